@@ -0,0 +1,240 @@
+package chaos
+
+import (
+	"atlas-compartment-transfer/settings"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInjected is returned by writes chaos fails on purpose
+var ErrInjected = errors.New("injected fault")
+
+const (
+	FaultDrop      = "drop"
+	FaultDuplicate = "duplicate"
+	FaultReorder   = "reorder"
+	FaultDelay     = "delay"
+	FaultFail      = "fail"
+
+	defaultMaxDelay = time.Second
+)
+
+// Faults are the probabilities of each fault befalling a message of a topic
+type Faults struct {
+	// Drop loses the message. Producers report it written, and consumers skip it.
+	Drop float64
+	// Duplicate writes, or handles, the message twice
+	Duplicate float64
+	// Reorder holds the message back until the next one of its topic has gone ahead, or the maximum delay has passed
+	Reorder float64
+	// Delay holds the message back for up to the maximum delay
+	Delay float64
+	// Fail returns ErrInjected instead of writing the message. Consumers ignore it.
+	Fail float64
+}
+
+// Config describes the faults injected into each topic, by the environment variable token of the topic
+type Config struct {
+	lock     sync.Mutex
+	disabled bool
+	rand     *rand.Rand
+	faults   map[string]Faults
+	tokens   map[string]struct{}
+	maxDelay time.Duration
+}
+
+// NewConfig creates a configuration which injects no faults until they are set for a topic
+func NewConfig(seed int64) *Config {
+	return &Config{
+		rand:     rand.New(rand.NewSource(seed)),
+		faults:   make(map[string]Faults),
+		tokens:   make(map[string]struct{}),
+		maxDelay: defaultMaxDelay,
+	}
+}
+
+// SetFaults sets the faults injected into the topic of the token
+func (c *Config) SetFaults(token string, f Faults) *Config {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.faults[token] = f
+	c.tokens[token] = struct{}{}
+	return c
+}
+
+// SetMaxDelay sets the longest a message is delayed, or held back to be reordered
+func (c *Config) SetMaxDelay(d time.Duration) *Config {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.maxDelay = d
+	return c
+}
+
+// Tokens are the tokens of the topics faults may be injected into, including those whose faults may be set by a reload
+func (c *Config) Tokens() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ts := make([]string, 0, len(c.tokens))
+	for t := range c.tokens {
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+// roll decides which faults befall a message of the topic of the token
+func (c *Config) roll(token string) map[string]bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	f, ok := c.faults[token]
+	if !ok || c.disabled {
+		return nil
+	}
+	return map[string]bool{
+		FaultDrop:      c.chance(f.Drop),
+		FaultDuplicate: c.chance(f.Duplicate),
+		FaultReorder:   c.chance(f.Reorder),
+		FaultDelay:     c.chance(f.Delay),
+		FaultFail:      c.chance(f.Fail),
+	}
+}
+
+func (c *Config) chance(p float64) bool {
+	return p > 0 && c.rand.Float64() < p
+}
+
+// delay picks how long a delayed message is held back
+func (c *Config) delay() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.maxDelay <= 0 {
+		return 0
+	}
+	return time.Duration(c.rand.Int63n(int64(c.maxDelay)))
+}
+
+// MaxDelay is the longest a message is delayed, or held back to be reordered
+func (c *Config) MaxDelay() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.maxDelay
+}
+
+// FromEnv reads the chaos configuration of a staging environment. Chaos is enabled by CHAOS_ENABLED, and the faults of a
+// topic are read from <token>_CHAOS, such as "drop=0.01,duplicate=0.05,delay=0.1". CHAOS_MAX_DELAY bounds delays, and
+// CHAOS_SEED makes the faults reproducible.
+func FromEnv(l logrus.FieldLogger) func(tokens ...string) (*Config, bool) {
+	return func(tokens ...string) (*Config, bool) {
+		if !enabled() {
+			return nil, false
+		}
+
+		seed := time.Now().UnixNano()
+		if v, ok := settings.Lookup("CHAOS_SEED"); ok {
+			s, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				l.Warnf("Invalid CHAOS_SEED [%s], using [%d].", v, seed)
+			} else {
+				seed = s
+			}
+		}
+		c := NewConfig(seed)
+		for _, token := range tokens {
+			c.tokens[token] = struct{}{}
+		}
+		read(l, c, tokens)
+		return c, true
+	}
+}
+
+// Reload re-reads the chaos configuration. Chaos which was enabled at startup can be switched off and on again, and its
+// faults changed, but chaos cannot be switched on for a service which started without it.
+func Reload(l logrus.FieldLogger) func(c *Config) func(tokens ...string) func() {
+	return func(c *Config) func(tokens ...string) func() {
+		return func(tokens ...string) func() {
+			return func() {
+				c.lock.Lock()
+				c.disabled = !enabled()
+				c.faults = make(map[string]Faults)
+				c.maxDelay = defaultMaxDelay
+				c.lock.Unlock()
+				if c.disabled {
+					l.Warnf("Chaos disabled.")
+					return
+				}
+				read(l, c, tokens)
+			}
+		}
+	}
+}
+
+func enabled() bool {
+	v, _ := settings.Lookup("CHAOS_ENABLED")
+	e, _ := strconv.ParseBool(v)
+	return e
+}
+
+// read reads the maximum delay, and the faults of each topic, into the configuration
+func read(l logrus.FieldLogger, c *Config, tokens []string) {
+	if v, ok := settings.Lookup("CHAOS_MAX_DELAY"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			l.Warnf("Invalid CHAOS_MAX_DELAY [%s], using [%s].", v, defaultMaxDelay)
+		} else {
+			c.SetMaxDelay(d)
+		}
+	}
+
+	for _, token := range tokens {
+		v, ok := settings.Lookup(token + "_CHAOS")
+		if !ok {
+			continue
+		}
+		f, err := ParseFaults(v)
+		if err != nil {
+			l.WithError(err).Warnf("Invalid [%s_CHAOS], injecting no faults into the topic.", token)
+			continue
+		}
+		c.SetFaults(token, f)
+		l.Warnf("Chaos enabled for [%s]: %s.", token, v)
+	}
+}
+
+// ParseFaults parses faults written as comma separated fault=probability pairs
+func ParseFaults(v string) (Faults, error) {
+	var f Faults
+	for _, pair := range strings.Split(v, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return Faults{}, fmt.Errorf("fault [%s] has no probability", pair)
+		}
+		p, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || p < 0 || p > 1 {
+			return Faults{}, fmt.Errorf("probability of fault [%s] is not between 0 and 1", pair)
+		}
+		switch strings.TrimSpace(name) {
+		case FaultDrop:
+			f.Drop = p
+		case FaultDuplicate:
+			f.Duplicate = p
+		case FaultReorder:
+			f.Reorder = p
+		case FaultDelay:
+			f.Delay = p
+		case FaultFail:
+			f.Fail = p
+		default:
+			return Faults{}, fmt.Errorf("fault [%s] is not known", name)
+		}
+	}
+	return f, nil
+}
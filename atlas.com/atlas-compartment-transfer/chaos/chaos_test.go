@@ -0,0 +1,164 @@
+package chaos_test
+
+import (
+	"atlas-compartment-transfer/chaos"
+	"atlas-compartment-transfer/test"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Chronicle20/atlas-kafka/producer"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+	"time"
+)
+
+const token = "EVENT_TOPIC_CHAOS"
+
+func logger() logrus.FieldLogger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return l
+}
+
+// writer creates a function producing messages with a value through the bus, decorated by chaos. Writes share one
+// decorated factory, as the producers of the service do, so that one may be held back behind another.
+func writer(bus *test.Bus, c *chaos.Config) func(value string) error {
+	pf := chaos.ProviderFactory(c)(bus.ProviderFactory)
+	return func(value string) error {
+		return pf(logger())(context.Background())(token)(producer.SingleMessageProvider(producer.CreateKey(0), value))
+	}
+}
+
+// values decodes the values of the messages produced through the bus
+func values(t *testing.T, bus *test.Bus) []string {
+	t.Helper()
+	vs := make([]string, 0)
+	for _, m := range bus.Messages(token) {
+		var v string
+		if err := json.Unmarshal(m.Value, &v); err != nil {
+			t.Fatalf("Unable to decode message value: %v", err)
+		}
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseFaults(t *testing.T) {
+	f, err := chaos.ParseFaults("drop=0.01, duplicate=0.05,reorder=0.1,delay=0.2,fail=1")
+	if err != nil {
+		t.Fatalf("Unable to parse faults: %v", err)
+	}
+	if f != (chaos.Faults{Drop: 0.01, Duplicate: 0.05, Reorder: 0.1, Delay: 0.2, Fail: 1}) {
+		t.Fatalf("Parsed unexpected faults [%+v].", f)
+	}
+
+	for _, v := range []string{"drop", "drop=2", "drop=x", "explode=0.5"} {
+		if _, err = chaos.ParseFaults(v); err == nil {
+			t.Errorf("Expected faults [%s] to be rejected.", v)
+		}
+	}
+}
+
+func TestProducerFaults(t *testing.T) {
+	t.Setenv(token, token)
+
+	bus := test.NewBus(logger())
+	err := writer(bus, chaos.NewConfig(1).SetFaults(token, chaos.Faults{Fail: 1}))("a")
+	if !errors.Is(err, chaos.ErrInjected) {
+		t.Fatalf("Expected injected failure, got [%v].", err)
+	}
+	err = writer(bus, chaos.NewConfig(1).SetFaults(token, chaos.Faults{Drop: 1}))("b")
+	if err != nil {
+		t.Fatalf("Expected dropped write to succeed, got [%v].", err)
+	}
+	if vs := values(t, bus); len(vs) != 0 {
+		t.Fatalf("Expected failed and dropped writes to produce nothing, got %v.", vs)
+	}
+
+	err = writer(bus, chaos.NewConfig(1).SetFaults(token, chaos.Faults{Duplicate: 1}))("c")
+	if err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	if vs := values(t, bus); !equal(vs, []string{"c", "c"}) {
+		t.Fatalf("Expected duplicated write, got %v.", vs)
+	}
+}
+
+func TestProducerReorder(t *testing.T) {
+	t.Setenv(token, token)
+
+	bus := test.NewBus(logger())
+	write := writer(bus, chaos.NewConfig(1).SetFaults(token, chaos.Faults{Reorder: 1}).SetMaxDelay(time.Hour))
+	for _, v := range []string{"a", "b"} {
+		if err := write(v); err != nil {
+			t.Fatalf("Unable to write: %v", err)
+		}
+	}
+	if vs := values(t, bus); !equal(vs, []string{"b", "a"}) {
+		t.Fatalf("Expected the first write to be held back behind the second, got %v.", vs)
+	}
+
+	// A message nothing follows is released once the maximum delay passes
+	bus = test.NewBus(logger())
+	write = writer(bus, chaos.NewConfig(1).SetFaults(token, chaos.Faults{Reorder: 1}).SetMaxDelay(10*time.Millisecond))
+	if err := write("c"); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(values(t, bus)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if vs := values(t, bus); !equal(vs, []string{"c"}) {
+		t.Fatalf("Expected the held back write to be released, got %v.", vs)
+	}
+}
+
+func TestHandlerFaults(t *testing.T) {
+	handled := make([]string, 0)
+	h := func(l logrus.FieldLogger, ctx context.Context, msg kafka.Message) (bool, error) {
+		handled = append(handled, string(msg.Value))
+		return true, nil
+	}
+	deliver := func(c *chaos.Config, vs ...string) {
+		ch := chaos.Handler(c)(token)(h)
+		for _, v := range vs {
+			_, _ = ch(logger(), context.Background(), kafka.Message{Topic: token, Value: []byte(v)})
+		}
+	}
+
+	deliver(chaos.NewConfig(1).SetFaults(token, chaos.Faults{Drop: 1}), "a")
+	if len(handled) != 0 {
+		t.Fatalf("Expected dropped message not to be handled, got %v.", handled)
+	}
+
+	deliver(chaos.NewConfig(1).SetFaults(token, chaos.Faults{Duplicate: 1}), "b")
+	if !equal(handled, []string{"b", "b"}) {
+		t.Fatalf("Expected duplicated message to be handled twice, got %v.", handled)
+	}
+
+	handled = handled[:0]
+	deliver(chaos.NewConfig(1).SetFaults(token, chaos.Faults{Reorder: 1}).SetMaxDelay(time.Hour), "c", "d")
+	if !equal(handled, []string{"d", "c"}) {
+		t.Fatalf("Expected the first message to be handled after the second, got %v.", handled)
+	}
+
+	handled = handled[:0]
+	deliver(chaos.NewConfig(1).SetFaults("OTHER_TOPIC", chaos.Faults{Drop: 1}), "e")
+	if !equal(handled, []string{"e"}) {
+		t.Fatalf("Expected message of a topic without faults to be handled, got %v.", handled)
+	}
+}
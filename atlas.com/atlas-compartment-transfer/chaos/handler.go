@@ -0,0 +1,77 @@
+package chaos
+
+import (
+	"context"
+	"github.com/Chronicle20/atlas-kafka/handler"
+	"github.com/Chronicle20/atlas-kafka/topic"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// RegisterHandler decorates a handler registration function so that messages of each topic suffer the faults configured
+// for it before they are handled. Consumers do not fail, so failure faults are ignored.
+func RegisterHandler(l logrus.FieldLogger) func(c *Config) func(rf func(topic string, handler handler.Handler) (string, error)) func(topic string, handler handler.Handler) (string, error) {
+	return func(c *Config) func(rf func(topic string, handler handler.Handler) (string, error)) func(topic string, handler handler.Handler) (string, error) {
+		return func(rf func(topic string, handler handler.Handler) (string, error)) func(topic string, handler handler.Handler) (string, error) {
+			tokens := make(map[string]string)
+			for _, token := range c.Tokens() {
+				t, err := topic.EnvProvider(l)(token)()
+				if err == nil {
+					tokens[t] = token
+				}
+			}
+			return func(topic string, h handler.Handler) (string, error) {
+				token, ok := tokens[topic]
+				if !ok {
+					return rf(topic, h)
+				}
+				return rf(topic, Handler(c)(token)(h))
+			}
+		}
+	}
+}
+
+// Handler decorates a handler so that messages of the topic of the token suffer the faults configured for it. Messages
+// which are dropped are reported handled.
+func Handler(c *Config) func(token string) func(h handler.Handler) handler.Handler {
+	return func(token string) func(h handler.Handler) handler.Handler {
+		return func(h handler.Handler) handler.Handler {
+			r := newReorderer()
+			return func(l logrus.FieldLogger, ctx context.Context, msg kafka.Message) (bool, error) {
+				faults := c.roll(token)
+				if faults == nil {
+					return h(l, ctx, msg)
+				}
+				if faults[FaultDrop] {
+					l.Warnf("Chaos dropped message from topic [%s] partition [%d] offset [%d].", msg.Topic, msg.Partition, msg.Offset)
+					return true, nil
+				}
+				if faults[FaultDelay] {
+					d := c.delay()
+					l.Warnf("Chaos delayed message from topic [%s] partition [%d] offset [%d] by [%s].", msg.Topic, msg.Partition, msg.Offset, d)
+					time.Sleep(d)
+				}
+				if faults[FaultReorder] {
+					held := r.hold(token, c.MaxDelay(), func() {
+						_, _ = h(l, ctx, msg)
+					})
+					if held {
+						l.Warnf("Chaos held back message from topic [%s] partition [%d] offset [%d] to reorder it.", msg.Topic, msg.Partition, msg.Offset)
+						return true, nil
+					}
+				}
+
+				ok, err := h(l, ctx, msg)
+				if faults[FaultDuplicate] {
+					l.Warnf("Chaos duplicated message from topic [%s] partition [%d] offset [%d].", msg.Topic, msg.Partition, msg.Offset)
+					ok, err = h(l, ctx, msg)
+				}
+				if release := r.take(token); release != nil {
+					release()
+				}
+				return ok, err
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package chaos
+
+import (
+	producer2 "atlas-compartment-transfer/kafka/producer"
+	"context"
+	"github.com/Chronicle20/atlas-kafka/producer"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// ProviderFactory decorates a producer factory so that writes to each topic suffer the faults configured for it. A write
+// which fails does not also drop, duplicate, reorder or delay its messages, and one which is dropped does nothing else.
+func ProviderFactory(c *Config) func(pf producer2.ProviderFactory) producer2.ProviderFactory {
+	return func(pf producer2.ProviderFactory) producer2.ProviderFactory {
+		r := newReorderer()
+		return func(l logrus.FieldLogger) func(ctx context.Context) func(token string) producer.MessageProducer {
+			return func(ctx context.Context) func(token string) producer.MessageProducer {
+				return func(token string) producer.MessageProducer {
+					mp := pf(l)(ctx)(token)
+					return func(provider model.Provider[[]kafka.Message]) error {
+						faults := c.roll(token)
+						if faults == nil {
+							return mp(provider)
+						}
+						if faults[FaultFail] {
+							l.Warnf("Chaos failed write to [%s].", token)
+							return ErrInjected
+						}
+						if faults[FaultDrop] {
+							l.Warnf("Chaos dropped write to [%s].", token)
+							return nil
+						}
+
+						ms, err := provider()
+						if err != nil {
+							return err
+						}
+						if faults[FaultDelay] {
+							d := c.delay()
+							l.Warnf("Chaos delayed write to [%s] by [%s].", token, d)
+							time.Sleep(d)
+						}
+						if faults[FaultReorder] {
+							held := r.hold(token, c.MaxDelay(), func() {
+								err := mp(copies(ms))
+								if err != nil {
+									l.WithError(err).Errorf("Unable to write reordered messages to [%s].", token)
+								}
+							})
+							if held {
+								l.Warnf("Chaos held back write to [%s] to reorder it.", token)
+								return nil
+							}
+						}
+
+						err = mp(copies(ms))
+						if err == nil && faults[FaultDuplicate] {
+							l.Warnf("Chaos duplicated write to [%s].", token)
+							err = mp(copies(ms))
+						}
+						if release := r.take(token); release != nil {
+							release()
+						}
+						return err
+					}
+				}
+			}
+		}
+	}
+}
+
+// copies provides copies of the messages, so that writing them again does not decorate the same headers twice
+func copies(ms []kafka.Message) model.Provider[[]kafka.Message] {
+	return func() ([]kafka.Message, error) {
+		cs := make([]kafka.Message, len(ms))
+		for i, m := range ms {
+			m.Headers = append([]kafka.Header(nil), m.Headers...)
+			cs[i] = m
+		}
+		return cs, nil
+	}
+}
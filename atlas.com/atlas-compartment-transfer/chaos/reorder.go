@@ -0,0 +1,58 @@
+package chaos
+
+import (
+	"sync"
+	"time"
+)
+
+// reorderer holds back a message per topic until the next message of the topic has gone ahead of it, or the hold limit
+// has passed
+type reorderer struct {
+	lock sync.Mutex
+	held map[string]*held
+}
+
+type held struct {
+	release func()
+	timer   *time.Timer
+}
+
+func newReorderer() *reorderer {
+	return &reorderer{held: make(map[string]*held)}
+}
+
+// hold holds back the release of a message, unless one is already held back for the topic
+func (r *reorderer) hold(token string, limit time.Duration, release func()) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.held[token]; ok {
+		return false
+	}
+	h := &held{release: release}
+	h.timer = time.AfterFunc(limit, func() {
+		r.lock.Lock()
+		current, ok := r.held[token]
+		if ok && current == h {
+			delete(r.held, token)
+		}
+		r.lock.Unlock()
+		if ok && current == h {
+			release()
+		}
+	})
+	r.held[token] = h
+	return true
+}
+
+// take returns the release of the message held back for the topic, if any, once another message has gone ahead of it
+func (r *reorderer) take(token string) func() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	h, ok := r.held[token]
+	if !ok {
+		return nil
+	}
+	delete(r.held, token)
+	h.timer.Stop()
+	return h.release
+}
@@ -0,0 +1,20 @@
+package character
+
+// Model is a character, as reported by the character service
+type Model struct {
+	id        uint32
+	accountId uint32
+	name      string
+}
+
+func (m Model) Id() uint32 {
+	return m.id
+}
+
+func (m Model) AccountId() uint32 {
+	return m.accountId
+}
+
+func (m Model) Name() string {
+	return m.name
+}
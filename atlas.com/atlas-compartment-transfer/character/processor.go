@@ -0,0 +1,36 @@
+package character
+
+import (
+	"atlas-compartment-transfer/rest"
+	"context"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Processor defines the interface for looking up characters in the character service
+type Processor interface {
+	ByIdProvider(characterId uint32) model.Provider[Model]
+}
+
+// ProcessorImpl implements the Processor interface
+type ProcessorImpl struct {
+	l   logrus.FieldLogger
+	ctx context.Context
+}
+
+// NewProcessor creates a new processor
+func NewProcessor(l logrus.FieldLogger, ctx context.Context) Processor {
+	return &ProcessorImpl{
+		l:   l,
+		ctx: ctx,
+	}
+}
+
+// ByIdProvider retrieves a character
+func (p *ProcessorImpl) ByIdProvider(characterId uint32) model.Provider[Model] {
+	url, err := characterUrl(characterId)
+	if err != nil {
+		return model.ErrorProvider[Model](err)
+	}
+	return rest.Provider[RestModel, Model](p.l, p.ctx)(requestById(url), Extract)
+}
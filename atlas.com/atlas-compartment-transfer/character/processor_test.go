@@ -0,0 +1,40 @@
+package character_test
+
+import (
+	"atlas-compartment-transfer/character"
+	"atlas-compartment-transfer/rest"
+	"atlas-compartment-transfer/test"
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+)
+
+func TestByIdProvider(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	te, err := tenant.Create(uuid.New(), "GMS", 83, 1)
+	if err != nil {
+		t.Fatalf("Unable to create tenant: %v", err)
+	}
+	p := character.NewProcessor(l, tenant.WithContext(context.Background(), te))
+
+	services := test.NewServices(t)
+	services.SetCharacter(1, 1000)
+
+	c, err := p.ByIdProvider(1)()
+	if err != nil {
+		t.Fatalf("Unable to retrieve character: %v", err)
+	}
+	if c.Id() != 1 || c.AccountId() != 1000 {
+		t.Fatalf("Retrieved unexpected character [%+v].", c)
+	}
+
+	_, err = p.ByIdProvider(2)()
+	if !errors.Is(err, rest.ErrNotFound) {
+		t.Errorf("Expected an unknown character to be not found, got [%v].", err)
+	}
+}
@@ -0,0 +1,24 @@
+package character
+
+import (
+	"atlas-compartment-transfer/rest"
+	"errors"
+	"fmt"
+)
+
+// ErrNotConfigured is returned when BASE_SERVICE_URL does not say where the character service is
+var ErrNotConfigured = errors.New("character service is not configured")
+
+const characterResource = "characters/%d"
+
+func characterUrl(characterId uint32) (string, error) {
+	baseUrl, ok := rest.BaseUrl()
+	if !ok {
+		return "", ErrNotConfigured
+	}
+	return baseUrl + fmt.Sprintf(characterResource, characterId), nil
+}
+
+func requestById(url string) rest.Request[RestModel] {
+	return rest.MakeGetRequest[RestModel](url)
+}
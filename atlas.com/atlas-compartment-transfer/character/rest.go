@@ -0,0 +1,36 @@
+package character
+
+import "strconv"
+
+// RestModel is the JSON:API resource for a character, as served by the character service
+type RestModel struct {
+	Id        uint32 `json:"-"`
+	AccountId uint32 `json:"accountId"`
+	Name      string `json:"name"`
+}
+
+func (r RestModel) GetName() string {
+	return "characters"
+}
+
+func (r RestModel) GetID() string {
+	return strconv.Itoa(int(r.Id))
+}
+
+func (r *RestModel) SetID(strId string) error {
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		return err
+	}
+	r.Id = uint32(id)
+	return nil
+}
+
+// Extract creates a character from its resource
+func Extract(rm RestModel) (Model, error) {
+	return Model{
+		id:        rm.Id,
+		accountId: rm.AccountId,
+		name:      rm.Name,
+	}, nil
+}
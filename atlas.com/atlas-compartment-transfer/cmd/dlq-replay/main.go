@@ -0,0 +1,111 @@
+package main
+
+import (
+	consumer2 "atlas-compartment-transfer/kafka/consumer"
+	"atlas-compartment-transfer/kafka/message/dlq"
+	dlq2 "atlas-compartment-transfer/kafka/producer/dlq"
+	"atlas-compartment-transfer/logger"
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/Chronicle20/atlas-kafka/topic"
+	"github.com/segmentio/kafka-go"
+)
+
+const serviceName = "atlas-compartment-transfer-dlq-replay"
+
+// dlq-replay reads a partition of the dead-letter topic and issues a REPLAY command for each matching entry,
+// so the service feeds it back through its normal handlers.
+func main() {
+	partition := flag.Int("partition", 0, "dead-letter topic partition to read")
+	from := flag.Int64("from", kafka.FirstOffset, "offset to start reading from (defaults to the first available offset)")
+	reason := flag.String("reason", "", "only replay entries dead-lettered for this reason")
+	source := flag.String("source", "", "only replay entries which originated from this topic")
+	dryRun := flag.Bool("dry-run", false, "log matching entries without replaying them")
+	flag.Parse()
+
+	l := logger.CreateLogger(serviceName)
+
+	dt, err := topic.EnvProvider(l)(dlq.EnvTopic)()
+	if err != nil {
+		l.WithError(err).Fatal("Unable to resolve dead-letter topic.")
+	}
+	ct, err := topic.EnvProvider(l)(dlq.EnvCommandTopic)()
+	if err != nil {
+		l.WithError(err).Fatal("Unable to resolve dead-letter command topic.")
+	}
+
+	ctx := context.Background()
+	brokers := consumer2.LookupBrokers()
+
+	conn, err := kafka.DialLeader(ctx, "tcp", brokers[0], dt, *partition)
+	if err != nil {
+		l.WithError(err).Fatalf("Unable to connect to partition [%d] of [%s].", *partition, dt)
+	}
+	first, last, err := conn.ReadOffsets()
+	_ = conn.Close()
+	if err != nil {
+		l.WithError(err).Fatalf("Unable to read offsets of partition [%d] of [%s].", *partition, dt)
+	}
+
+	start := *from
+	if start < first {
+		start = first
+	}
+	if start >= last {
+		l.Infof("No dead-letter entries to replay in partition [%d] of [%s].", *partition, dt)
+		return
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{Brokers: brokers, Topic: dt, Partition: *partition})
+	defer r.Close()
+	err = r.SetOffset(start)
+	if err != nil {
+		l.WithError(err).Fatalf("Unable to seek to offset [%d].", start)
+	}
+
+	w := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: ct, Balancer: &kafka.Hash{}}
+	defer w.Close()
+
+	replayed := 0
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			l.WithError(err).Fatal("Unable to read dead-letter entry.")
+		}
+
+		var e dlq.Entry
+		err = json.Unmarshal(m.Value, &e)
+		if err != nil {
+			l.WithError(err).Warnf("Skipping undecodable dead-letter entry at offset [%d].", m.Offset)
+		} else if (*reason == "" || e.Reason == *reason) && (*source == "" || e.SourceTopic == *source) {
+			l.Infof("Replaying entry at offset [%d] from topic [%s] offset [%d]. Reason [%s]: %s.", m.Offset, e.SourceTopic, e.Offset, e.Reason, e.Error)
+			if !*dryRun {
+				err = replay(ctx, w, e)
+				if err != nil {
+					l.WithError(err).Fatalf("Unable to issue replay command for offset [%d].", m.Offset)
+				}
+			}
+			replayed++
+		}
+
+		if m.Offset+1 >= last {
+			break
+		}
+	}
+	l.Infof("Replayed [%d] dead-letter entries from partition [%d] of [%s].", replayed, *partition, dt)
+}
+
+// replay issues the REPLAY command carrying the original headers, so the tenant and span of the source message are restored.
+func replay(ctx context.Context, w *kafka.Writer, e dlq.Entry) error {
+	ms, err := dlq2.ReplayCommandProvider(e)()
+	if err != nil {
+		return err
+	}
+	for i := range ms {
+		for _, h := range e.Headers {
+			ms[i].Headers = append(ms[i].Headers, kafka.Header{Key: h.Key, Value: []byte(h.Value)})
+		}
+	}
+	return w.WriteMessages(ctx, ms...)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"atlas-compartment-transfer/kafka/message/schema"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// schema-gen publishes the JSON Schema of every message. Schemas of existing versions are never rewritten; a message
+// whose shape changed must have its version bumped.
+func main() {
+	out := flag.String("out", "schemas", "directory to write schemas to")
+	flag.Parse()
+
+	err := os.MkdirAll(*out, 0o755)
+	if err != nil {
+		fail(err)
+	}
+
+	for _, m := range schema.Messages {
+		b, err := schema.Marshal(schema.Generate(m))
+		if err != nil {
+			fail(err)
+		}
+
+		path := filepath.Join(*out, m.FileName())
+		existing, err := os.ReadFile(path)
+		if err == nil {
+			if !bytes.Equal(existing, b) {
+				fail(fmt.Errorf("schema of [%s] version [%d] changed. Bump the version of the message", m.Name, m.Version))
+			}
+			continue
+		}
+		if !os.IsNotExist(err) {
+			fail(err)
+		}
+
+		err = os.WriteFile(path, b, 0o644)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+}
+
+func fail(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
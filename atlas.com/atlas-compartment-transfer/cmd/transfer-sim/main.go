@@ -0,0 +1,95 @@
+package main
+
+import (
+	"atlas-compartment-transfer/test/simulator"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// transfer-sim drives the service's saga, in process, against simulated compartment services, and reports how it held up
+func main() {
+	c := simulator.DefaultConfig()
+	fs := flag.NewFlagSet("transfer-sim", flag.ExitOnError)
+	fs.IntVar(&c.Transfers, "transfers", c.Transfers, "number of transfers to submit")
+	fs.Float64Var(&c.Rate, "rate", c.Rate, "transfers submitted per second, or 0 to submit them all at once")
+	fs.IntVar(&c.Workers, "workers", c.Workers, "number of messages handled at once")
+	fs.DurationVar(&c.AcceptLatency, "accept-latency", c.AcceptLatency, "mean time a compartment takes to accept an asset")
+	fs.DurationVar(&c.ReleaseLatency, "release-latency", c.ReleaseLatency, "mean time a compartment takes to release an asset")
+	fs.Float64Var(&c.ErrorRate, "error-rate", c.ErrorRate, "probability a compartment reports an error for a command")
+	fs.Float64Var(&c.DuplicateRate, "duplicate-rate", c.DuplicateRate, "probability a message is delivered twice")
+	fs.DurationVar(&c.Timeout, "timeout", c.Timeout, "how long to wait for transfers to finish")
+	fs.Int64Var(&c.Seed, "seed", c.Seed, "seed of the simulated errors, duplicates and latencies")
+	output := fs.String("o", "text", "output format, text or json")
+	mutexProfile := fs.String("mutexprofile", "", "write a profile of lock contention to the file")
+	verbose := fs.Bool("v", false, "log what the service logs")
+	_ = fs.Parse(os.Args[1:])
+
+	l := logrus.New()
+	l.SetLevel(logrus.WarnLevel)
+	if !*verbose {
+		l.SetOutput(io.Discard)
+	}
+	if *mutexProfile != "" {
+		runtime.SetMutexProfileFraction(1)
+	}
+
+	s, err := simulator.New(l, c)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "transfer-sim: %v\n", err)
+		os.Exit(1)
+	}
+	r, err := s.Run()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "transfer-sim: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *mutexProfile != "" {
+		err = writeMutexProfile(*mutexProfile)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "transfer-sim: unable to write mutex profile: %v\n", err)
+		}
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(r)
+	} else {
+		printReport(c, r)
+	}
+	if len(r.Violations) > 0 || r.Unfinished > 0 {
+		os.Exit(1)
+	}
+}
+
+func writeMutexProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return pprof.Lookup("mutex").WriteTo(f, 0)
+}
+
+func printReport(c simulator.Config, r simulator.Report) {
+	w := os.Stdout
+	_, _ = fmt.Fprintf(w, "Seed:         %d\n", c.Seed)
+	_, _ = fmt.Fprintf(w, "Transfers:    %d (%d completed, %d failed, %d unfinished)\n", r.Transfers, r.Completed, r.Failed, r.Unfinished)
+	_, _ = fmt.Fprintf(w, "Duplicates:   %d messages delivered twice\n", r.Duplicates)
+	_, _ = fmt.Fprintf(w, "Dead letters: %d\n", r.DeadLetters)
+	_, _ = fmt.Fprintf(w, "Elapsed:      %s\n", r.Elapsed.Round(time.Millisecond))
+	_, _ = fmt.Fprintf(w, "Throughput:   %.1f transfers/s\n", r.Throughput)
+	_, _ = fmt.Fprintf(w, "Latency:      p50 %s  p90 %s  p99 %s  max %s\n", r.P50.Round(time.Microsecond), r.P90.Round(time.Microsecond), r.P99.Round(time.Microsecond), r.Max.Round(time.Microsecond))
+	_, _ = fmt.Fprintf(w, "Violations:   %d\n", len(r.Violations))
+	for _, v := range r.Violations {
+		_, _ = fmt.Fprintf(w, "  %-14s %s  state %-10s at source %-5t at destination %t\n", v.Kind, v.TransactionId, v.State, v.AtSource, v.AtDestination)
+	}
+}
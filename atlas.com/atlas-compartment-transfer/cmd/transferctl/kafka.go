@@ -0,0 +1,407 @@
+package main
+
+import (
+	"atlas-compartment-transfer/correlation"
+	consumer2 "atlas-compartment-transfer/kafka/consumer"
+	compartment3 "atlas-compartment-transfer/kafka/message/cashshop/compartment"
+	compartment2 "atlas-compartment-transfer/kafka/message/character/compartment"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	compartment5 "atlas-compartment-transfer/kafka/message/currency/compartment"
+	"atlas-compartment-transfer/kafka/message/dlq"
+	"atlas-compartment-transfer/kafka/message/envelope"
+	"atlas-compartment-transfer/kafka/producer"
+	compartment4 "atlas-compartment-transfer/kafka/producer/compartment"
+	"atlas-compartment-transfer/transfer"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Chronicle20/atlas-kafka/topic"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"time"
+)
+
+// errTimeout is returned when a followed saga does not finish in time
+var errTimeout = errors.New("timed out waiting for the transfer to finish")
+
+const pollInterval = time.Second
+
+// registries are the versions of the messages of each topic, so raw messages are decoded as the service would
+var registries = map[string]*envelope.Registry{
+	compartment.EnvCommandTopicCompartmentTransfer: compartment.TransferCommandVersions,
+	compartment.EnvEventTopicStatus:                compartment.StatusEventVersions,
+	compartment2.EnvCommandTopic:                   compartment2.CommandVersions,
+	compartment2.EnvEventTopicStatus:               compartment2.StatusEventVersions,
+	compartment3.EnvCommandTopic:                   compartment3.CommandVersions,
+	compartment3.EnvEventTopicStatus:               compartment3.StatusEventVersions,
+	compartment5.EnvCommandTopic:                   compartment5.CommandVersions,
+	compartment5.EnvEventTopicStatus:               compartment5.StatusEventVersions,
+	dlq.EnvTopic:                                   nil,
+	dlq.EnvCommandTopic:                            nil,
+}
+
+func runSubmit(args []string) error {
+	fs, o := newFlagSet("submit", "")
+	transactionId := fs.String("transaction", "", "transaction id (defaults to a new id)")
+	accountId := fs.Uint("account", 0, "account id")
+	characterId := fs.Uint("character", 0, "character id")
+	assetId := fs.Uint("asset", 0, "asset id")
+	referenceId := fs.Uint("reference", 0, "reference id of the asset")
+	fromInventory := fs.String("from", compartment.InventoryTypeCharacter, "source inventory type, CHARACTER or CASH_SHOP")
+	fromCompartment := fs.String("from-compartment", uuid.Nil.String(), "source compartment id")
+	fromType := fs.Uint("from-type", 0, "source compartment type")
+	toInventory := fs.String("to", compartment.InventoryTypeCashShop, "destination inventory type, CHARACTER or CASH_SHOP")
+	toCompartment := fs.String("to-compartment", uuid.Nil.String(), "destination compartment id")
+	toType := fs.Uint("to-type", 0, "destination compartment type")
+	deliverAt := fs.String("deliver-at", "", "RFC 3339 time to deliver the transfer at (defaults to now)")
+	follow := fs.Bool("follow", true, "follow the saga until it finishes, unless the transfer is scheduled")
+	timeout := fs.Duration("timeout", 30*time.Second, "how long to follow the saga for")
+	if err := parse(fs, o, args); err != nil {
+		return err
+	}
+	t, err := o.createTenant()
+	if err != nil {
+		return err
+	}
+
+	cmd := compartment.TransferCommand{
+		AccountId:           uint32(*accountId),
+		CharacterId:         uint32(*characterId),
+		AssetId:             uint32(*assetId),
+		FromInventoryType:   *fromInventory,
+		FromCompartmentType: byte(*fromType),
+		ToInventoryType:     *toInventory,
+		ToCompartmentType:   byte(*toType),
+		ReferenceId:         uint32(*referenceId),
+	}
+	cmd.TransactionId, err = parseUuidFlag("transaction", *transactionId, uuid.New())
+	if err != nil {
+		return err
+	}
+	cmd.FromCompartmentId, err = parseUuidFlag("from-compartment", *fromCompartment, uuid.Nil)
+	if err != nil {
+		return err
+	}
+	cmd.ToCompartmentId, err = parseUuidFlag("to-compartment", *toCompartment, uuid.Nil)
+	if err != nil {
+		return err
+	}
+	if *deliverAt != "" {
+		at, err := time.Parse(time.RFC3339, *deliverAt)
+		if err != nil {
+			return fmt.Errorf("-deliver-at [%s] is not valid: %w", *deliverAt, err)
+		}
+		cmd.DeliverAt = &at
+	}
+
+	ctx, cancel := context.WithTimeout(tenant.WithContext(context.Background(), t), *timeout)
+	defer cancel()
+
+	// Mark the status topic before submitting, so the COMPLETED event cannot be missed
+	var statusTopic string
+	var offsets map[int]int64
+	if *follow {
+		statusTopic, err = resolveTopic(compartment.EnvEventTopicStatus)
+		if err != nil {
+			return err
+		}
+		offsets, err = lastOffsets(ctx, statusTopic)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = submit(correlation.WithCorrelationId(ctx, cmd.TransactionId.String()), cmd)
+	if err != nil {
+		return err
+	}
+	if cmd.DeliverAt != nil && cmd.DeliverAt.After(time.Now()) {
+		return printResult(o, cmd.TransactionId, string(transfer.StateScheduled), nil)
+	}
+	if !*follow {
+		return printResult(o, cmd.TransactionId, "SUBMITTED", nil)
+	}
+
+	state, event, err := followSaga(ctx, o, t, statusTopic, offsets, cmd.TransactionId)
+	if err != nil {
+		return err
+	}
+	err = printResult(o, cmd.TransactionId, state, event)
+	if err != nil {
+		return err
+	}
+	if state != string(transfer.StateCompleted) {
+		return fmt.Errorf("transfer [%s] finished in state [%s]", cmd.TransactionId, state)
+	}
+	return nil
+}
+
+func parseUuidFlag(name string, value string, fallback uuid.UUID) (uuid.UUID, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("-%s [%s] is not valid: %w", name, value, err)
+	}
+	return id, nil
+}
+
+// submit writes the command with the headers the service's own producers would set
+func submit(ctx context.Context, cmd compartment.TransferCommand) error {
+	ct, err := resolveTopic(compartment.EnvCommandTopicCompartmentTransfer)
+	if err != nil {
+		return err
+	}
+	ms, err := compartment4.TransferCommandProvider(cmd)()
+	if err != nil {
+		return err
+	}
+	for i := range ms {
+		for _, d := range producer.HeaderDecorators(ctx) {
+			hs, err := d()
+			if err != nil {
+				return err
+			}
+			for k, v := range hs {
+				ms[i].Headers = append(ms[i].Headers, kafka.Header{Key: k, Value: []byte(v)})
+			}
+		}
+	}
+
+	w := &kafka.Writer{Addr: kafka.TCP(consumer2.LookupBrokers()...), Topic: ct, Balancer: &kafka.Hash{}}
+	defer w.Close()
+	return w.WriteMessages(ctx, ms...)
+}
+
+// lastOffsets reads the offset the next message of each partition of the topic will be written at
+func lastOffsets(ctx context.Context, t string) (map[int]int64, error) {
+	brokers := consumer2.LookupBrokers()
+	conn, err := kafka.DialContext(ctx, "tcp", brokers[0])
+	if err != nil {
+		return nil, err
+	}
+	ps, err := conn.ReadPartitions(t)
+	_ = conn.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[int]int64)
+	for _, p := range ps {
+		pc, err := kafka.DialLeader(ctx, "tcp", brokers[0], t, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		last, err := pc.ReadLastOffset()
+		_ = pc.Close()
+		if err != nil {
+			return nil, err
+		}
+		offsets[p.ID] = last
+	}
+	return offsets, nil
+}
+
+// followSaga waits for the COMPLETED event of the transfer. The saga emits no event when it fails, so the state of the
+// transfer is also polled through the REST API.
+func followSaga(ctx context.Context, o *options, t tenant.Model, statusTopic string, offsets map[int]int64, transactionId uuid.UUID) (string, json.RawMessage, error) {
+	completed := make(chan json.RawMessage, 1)
+	decode := envelope.Decoder[compartment.StatusEvent[compartment.StatusEventCompletedBody]](compartment.StatusEventVersions)
+	for partition, offset := range offsets {
+		r := kafka.NewReader(kafka.ReaderConfig{Brokers: consumer2.LookupBrokers(), Topic: statusTopic, Partition: partition})
+		defer r.Close()
+		err := r.SetOffset(offset)
+		if err != nil {
+			return "", nil, err
+		}
+		go func(r *kafka.Reader) {
+			for {
+				m, err := r.ReadMessage(ctx)
+				if err != nil {
+					return
+				}
+				e, err := decode(m.Value)
+				if err != nil || e.Type != compartment.StatusEventTypeCompleted || e.Body.TransactionId != transactionId {
+					continue
+				}
+				select {
+				case completed <- m.Value:
+				default:
+				}
+				return
+			}
+		}(r)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-completed:
+			return string(transfer.StateCompleted), event, nil
+		case <-ticker.C:
+			rm, err := getTransfer(o.serviceUrl, t, transactionId)
+			if err != nil {
+				// The transfer may not have been journaled yet, or the REST API may be unreachable
+				continue
+			}
+			if transfer.State(rm.State).Terminal() && rm.State != string(transfer.StateCompleted) {
+				return rm.State, nil, nil
+			}
+		case <-ctx.Done():
+			return "", nil, errTimeout
+		}
+	}
+}
+
+func printResult(o *options, transactionId uuid.UUID, state string, event json.RawMessage) error {
+	if o.output == outputJson {
+		return json.NewEncoder(os.Stdout).Encode(struct {
+			TransactionId uuid.UUID       `json:"transactionId"`
+			State         string          `json:"state"`
+			Event         json.RawMessage `json:"event,omitempty"`
+		}{TransactionId: transactionId, State: state, Event: event})
+	}
+	fmt.Printf("Transfer [%s] %s.\n", transactionId, state)
+	return nil
+}
+
+func runDecode(args []string) error {
+	fs, o := newFlagSet("decode", "")
+	token := fs.String("topic", compartment.EnvCommandTopicCompartmentTransfer, "environment variable naming the topic to read")
+	partition := fs.Int("partition", 0, "partition to read")
+	from := fs.Int64("from", kafka.FirstOffset, "offset to start reading from (defaults to the first available offset)")
+	count := fs.Int("count", 0, "number of messages to decode (defaults to every message up to the end of the partition)")
+	if err := parse(fs, o, args); err != nil {
+		return err
+	}
+	registry, ok := registries[*token]
+	if !ok {
+		return fmt.Errorf("topic [%s] is not used by the service", *token)
+	}
+	t, err := resolveTopic(*token)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	brokers := consumer2.LookupBrokers()
+	conn, err := kafka.DialLeader(ctx, "tcp", brokers[0], t, *partition)
+	if err != nil {
+		return err
+	}
+	first, last, err := conn.ReadOffsets()
+	_ = conn.Close()
+	if err != nil {
+		return err
+	}
+	start := *from
+	if start < first {
+		start = first
+	}
+	if start >= last {
+		return nil
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{Brokers: brokers, Topic: t, Partition: *partition})
+	defer r.Close()
+	err = r.SetOffset(start)
+	if err != nil {
+		return err
+	}
+
+	for decoded := 0; *count == 0 || decoded < *count; decoded++ {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			return err
+		}
+		err = printMessage(o, registry, m)
+		if err != nil {
+			return err
+		}
+		if m.Offset+1 >= last {
+			break
+		}
+	}
+	return nil
+}
+
+// decodedMessage is a raw message, with its value upcast to the version the service works with
+type decodedMessage struct {
+	Topic     string            `json:"topic"`
+	Partition int               `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       string            `json:"key"`
+	Headers   map[string]string `json:"headers"`
+	Value     json.RawMessage   `json:"value"`
+	Error     string            `json:"error,omitempty"`
+}
+
+func printMessage(o *options, r *envelope.Registry, m kafka.Message) error {
+	dm := decodedMessage{
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Key:       string(m.Key),
+		Headers:   make(map[string]string),
+		Value:     m.Value,
+	}
+	for _, h := range m.Headers {
+		dm.Headers[h.Key] = string(h.Value)
+	}
+	if r != nil {
+		v, err := r.Upcast(m.Value)
+		if err != nil {
+			dm.Error = err.Error()
+		} else {
+			dm.Value = v
+		}
+	}
+	if !json.Valid(dm.Value) {
+		dm.Value, _ = json.Marshal(string(m.Value))
+		if dm.Error == "" {
+			dm.Error = "value is not JSON"
+		}
+	}
+
+	if o.output == outputJson {
+		return json.NewEncoder(os.Stdout).Encode(dm)
+	}
+	fmt.Printf("Offset %d, key [%s]\n", dm.Offset, dm.Key)
+	for k, v := range dm.Headers {
+		fmt.Printf("  %s: %s\n", k, v)
+	}
+	if dm.Error != "" {
+		fmt.Printf("  Unable to decode: %s\n", dm.Error)
+	}
+	var out bytes.Buffer
+	if json.Indent(&out, dm.Value, "  ", "  ") == nil {
+		fmt.Printf("  %s\n", out.String())
+	}
+	return nil
+}
+
+func printJson(body []byte) error {
+	var out bytes.Buffer
+	err := json.Indent(&out, body, "", "  ")
+	if err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
+
+// resolveTopic resolves a topic from its environment variable, as the service does
+func resolveTopic(token string) (string, error) {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return topic.EnvProvider(l)(token)()
+}
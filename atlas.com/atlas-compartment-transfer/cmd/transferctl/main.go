@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	outputText = "text"
+	outputJson = "json"
+
+	defaultServiceUrl = "http://localhost:8080"
+)
+
+// errUsage is returned for a command line which cannot be acted upon. The usage of the command has already been printed.
+var errUsage = errors.New("usage")
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{name: "submit", summary: "submit a TransferCommand and follow the saga until it finishes", run: runSubmit},
+	{name: "get", summary: "show the current state of a transfer", run: runGet},
+	{name: "journal", summary: "show the journal of a transfer", run: runJournal},
+	{name: "abort", summary: "stop a transfer which is in progress", run: runAbort},
+	{name: "replay", summary: "re-issue the pending command of a transfer which is in progress", run: runReplay},
+	{name: "scheduled", summary: "list the scheduled transfers of an account which have not started", run: runScheduled},
+	{name: "cancel", summary: "cancel a scheduled transfer of an account before it starts", run: runCancel},
+	{name: "decode", summary: "decode raw messages from a topic the service uses", run: runDecode},
+}
+
+// transferctl is the operator tool for the service. Transfers are submitted, and messages decoded, through the brokers
+// named by BOOTSTRAP_SERVERS. Transfers are queried, aborted, replayed and cancelled through the REST API.
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+		err := c.run(os.Args[2:])
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "transferctl %s: %v\n", c.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+func usage(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "Usage: transferctl <command> [flags]")
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
+	}
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, "Run transferctl <command> -h for the flags of a command.")
+}
+
+// options are the flags shared by every command
+type options struct {
+	output     string
+	serviceUrl string
+	tenantId   *string
+	region     *string
+	major      *uint
+	minor      *uint
+}
+
+func newFlagSet(name string, positional string) (*flag.FlagSet, *options) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: transferctl %s [flags] %s\n", name, positional)
+		fs.PrintDefaults()
+	}
+
+	o := &options{}
+	fs.StringVar(&o.output, "o", outputText, "output format, text or json")
+	fs.StringVar(&o.serviceUrl, "url", lookupEnv("TRANSFER_SERVICE_URL", defaultServiceUrl), "base URL of the service's REST API (or TRANSFER_SERVICE_URL)")
+	o.tenantId = fs.String("tenant", os.Getenv(tenant.ID), "id of the tenant (or "+tenant.ID+")")
+	o.region = fs.String("region", os.Getenv(tenant.Region), "region of the tenant (or "+tenant.Region+")")
+	o.major = fs.Uint("major", lookupUint(tenant.MajorVersion), "major version of the tenant (or "+tenant.MajorVersion+")")
+	o.minor = fs.Uint("minor", lookupUint(tenant.MinorVersion), "minor version of the tenant (or "+tenant.MinorVersion+")")
+	return fs, o
+}
+
+// parse parses the flags, and validates the output format
+func parse(fs *flag.FlagSet, o *options, args []string) error {
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if o.output != outputText && o.output != outputJson {
+		_, _ = fmt.Fprintf(fs.Output(), "Unknown output format [%s].\n", o.output)
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// createTenant creates the tenant named by the flags
+func (o *options) createTenant() (tenant.Model, error) {
+	id, err := uuid.Parse(*o.tenantId)
+	if err != nil {
+		return tenant.Model{}, fmt.Errorf("tenant id [%s] is not valid: %w", *o.tenantId, err)
+	}
+	return tenant.Create(id, *o.region, uint16(*o.major), uint16(*o.minor))
+}
+
+// transactionIdArg parses the single transaction id argument of a command
+func transactionIdArg(fs *flag.FlagSet) (uuid.UUID, error) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return uuid.Nil, errUsage
+	}
+	id, err := uuid.Parse(fs.Arg(0))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("transaction id [%s] is not valid: %w", fs.Arg(0), err)
+	}
+	return id, nil
+}
+
+func accountIdArg(fs *flag.FlagSet, n int) (uint32, error) {
+	if fs.NArg() != n {
+		fs.Usage()
+		return 0, errUsage
+	}
+	id, err := strconv.ParseUint(fs.Arg(0), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("account id [%s] is not valid: %w", fs.Arg(0), err)
+	}
+	return uint32(id), nil
+}
+
+func lookupEnv(key string, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+func lookupUint(key string) uint {
+	v, err := strconv.ParseUint(os.Getenv(key), 10, 16)
+	if err != nil {
+		return 0
+	}
+	return uint(v)
+}
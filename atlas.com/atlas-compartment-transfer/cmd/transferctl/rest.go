@@ -0,0 +1,254 @@
+package main
+
+import (
+	"atlas-compartment-transfer/transfer"
+	"errors"
+	"fmt"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/jtumidanski/api2go/jsonapi"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// errNotFound is returned for a transfer the service has no journal for
+	errNotFound = errors.New("transfer not found")
+	// errConflict is returned when acting upon a transfer which is not in a state the action allows, such as one which
+	// has already finished
+	errConflict = errors.New("transfer cannot be acted upon in its state")
+	// errNotScheduled is returned when cancelling a transfer which has already started
+	errNotScheduled = errors.New("transfer is not scheduled")
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func runGet(args []string) error {
+	fs, o := newFlagSet("get", "<transactionId>")
+	if err := parse(fs, o, args); err != nil {
+		return err
+	}
+	transactionId, err := transactionIdArg(fs)
+	if err != nil {
+		return err
+	}
+	t, err := o.createTenant()
+	if err != nil {
+		return err
+	}
+
+	body, err := request(o.serviceUrl, t, http.MethodGet, transferPath(transactionId))
+	if err != nil {
+		return err
+	}
+	return printTransfer(o, body)
+}
+
+func runJournal(args []string) error {
+	fs, o := newFlagSet("journal", "<transactionId>")
+	if err := parse(fs, o, args); err != nil {
+		return err
+	}
+	transactionId, err := transactionIdArg(fs)
+	if err != nil {
+		return err
+	}
+	t, err := o.createTenant()
+	if err != nil {
+		return err
+	}
+
+	body, err := request(o.serviceUrl, t, http.MethodGet, transferPath(transactionId)+"/journal")
+	if err != nil {
+		return err
+	}
+	if o.output == outputJson {
+		return printJson(body)
+	}
+
+	var es []transfer.JournalEntryRestModel
+	err = jsonapi.Unmarshal(body, &es)
+	if err != nil {
+		return err
+	}
+	for _, e := range es {
+		fmt.Printf("%s  %-16s %-10s %s\n", e.CreatedAt.Format(time.RFC3339), e.Kind, e.State, e.Payload)
+	}
+	return nil
+}
+
+func runAbort(args []string) error {
+	return runAction("abort", args)
+}
+
+func runReplay(args []string) error {
+	return runAction("replay", args)
+}
+
+// runAction posts an action upon a transfer which is in progress, and prints the transfer as it is afterward
+func runAction(action string, args []string) error {
+	fs, o := newFlagSet(action, "<transactionId>")
+	if err := parse(fs, o, args); err != nil {
+		return err
+	}
+	transactionId, err := transactionIdArg(fs)
+	if err != nil {
+		return err
+	}
+	t, err := o.createTenant()
+	if err != nil {
+		return err
+	}
+
+	body, err := request(o.serviceUrl, t, http.MethodPost, transferPath(transactionId)+"/"+action)
+	if err != nil {
+		return err
+	}
+	return printTransfer(o, body)
+}
+
+func runScheduled(args []string) error {
+	fs, o := newFlagSet("scheduled", "<accountId>")
+	if err := parse(fs, o, args); err != nil {
+		return err
+	}
+	accountId, err := accountIdArg(fs, 1)
+	if err != nil {
+		return err
+	}
+	t, err := o.createTenant()
+	if err != nil {
+		return err
+	}
+
+	body, err := request(o.serviceUrl, t, http.MethodGet, scheduledPath(accountId))
+	if err != nil {
+		return err
+	}
+	if o.output == outputJson {
+		return printJson(body)
+	}
+
+	var rms []transfer.RestModel
+	err = jsonapi.Unmarshal(body, &rms)
+	if err != nil {
+		return err
+	}
+	for _, rm := range rms {
+		fmt.Printf("%s  %s  %s -> %s  asset %d\n", rm.DeliverAt.Format(time.RFC3339), rm.Id, rm.FromInventoryType, rm.ToInventoryType, rm.AssetId)
+	}
+	return nil
+}
+
+func runCancel(args []string) error {
+	fs, o := newFlagSet("cancel", "<accountId> <transactionId>")
+	if err := parse(fs, o, args); err != nil {
+		return err
+	}
+	accountId, err := accountIdArg(fs, 2)
+	if err != nil {
+		return err
+	}
+	transactionId, err := uuid.Parse(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("transaction id [%s] is not valid: %w", fs.Arg(1), err)
+	}
+	t, err := o.createTenant()
+	if err != nil {
+		return err
+	}
+
+	body, err := request(o.serviceUrl, t, http.MethodPost, scheduledPath(accountId)+"/"+transactionId.String()+"/cancel")
+	if errors.Is(err, errConflict) {
+		return errNotScheduled
+	}
+	if err != nil {
+		return err
+	}
+	return printTransfer(o, body)
+}
+
+// getTransfer retrieves the current state of a transfer
+func getTransfer(serviceUrl string, t tenant.Model, transactionId uuid.UUID) (transfer.RestModel, error) {
+	var rm transfer.RestModel
+	body, err := request(serviceUrl, t, http.MethodGet, transferPath(transactionId))
+	if err != nil {
+		return rm, err
+	}
+	err = jsonapi.Unmarshal(body, &rm)
+	return rm, err
+}
+
+func transferPath(transactionId uuid.UUID) string {
+	return "/api/transfers/" + transactionId.String()
+}
+
+func scheduledPath(accountId uint32) string {
+	return "/api/accounts/" + strconv.FormatUint(uint64(accountId), 10) + "/scheduled-transfers"
+}
+
+// request calls the REST API on behalf of the tenant, returning the body of a successful response
+func request(serviceUrl string, t tenant.Model, method string, path string) ([]byte, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(serviceUrl, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(tenant.ID, t.Id().String())
+	req.Header.Set(tenant.Region, t.Region())
+	req.Header.Set(tenant.MajorVersion, strconv.Itoa(int(t.MajorVersion())))
+	req.Header.Set(tenant.MinorVersion, strconv.Itoa(int(t.MinorVersion())))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusNotFound:
+		return nil, errNotFound
+	case http.StatusConflict:
+		return nil, errConflict
+	}
+	return nil, fmt.Errorf("%s %s returned [%s]", method, path, resp.Status)
+}
+
+func printTransfer(o *options, body []byte) error {
+	if o.output == outputJson {
+		return printJson(body)
+	}
+
+	var rm transfer.RestModel
+	err := jsonapi.Unmarshal(body, &rm)
+	if err != nil {
+		return err
+	}
+	w := os.Stdout
+	_, _ = fmt.Fprintf(w, "Transaction:  %s\n", rm.Id)
+	_, _ = fmt.Fprintf(w, "State:        %s\n", rm.State)
+	_, _ = fmt.Fprintf(w, "Ordering:     %s\n", rm.Ordering)
+	_, _ = fmt.Fprintf(w, "Account:      %d\n", rm.AccountId)
+	_, _ = fmt.Fprintf(w, "Character:    %d\n", rm.CharacterId)
+	_, _ = fmt.Fprintf(w, "Asset:        %d (reference %d)\n", rm.AssetId, rm.ReferenceId)
+	_, _ = fmt.Fprintf(w, "From:         %s compartment %s type %d\n", rm.FromInventoryType, rm.FromCompartmentId, rm.FromCompartmentType)
+	_, _ = fmt.Fprintf(w, "To:           %s compartment %s type %d\n", rm.ToInventoryType, rm.ToCompartmentId, rm.ToCompartmentType)
+	if rm.DeliverAt != nil {
+		_, _ = fmt.Fprintf(w, "Deliver at:   %s\n", rm.DeliverAt.Format(time.RFC3339))
+	}
+	if rm.Fee != nil {
+		_, _ = fmt.Fprintf(w, "Fee:          %d charged to currency compartment %s\n", rm.Fee.Amount, rm.Fee.CompartmentId)
+	}
+	_, _ = fmt.Fprintf(w, "Created:      %s\n", rm.CreatedAt.Format(time.RFC3339))
+	_, _ = fmt.Fprintf(w, "Updated:      %s\n", rm.UpdatedAt.Format(time.RFC3339))
+	return nil
+}
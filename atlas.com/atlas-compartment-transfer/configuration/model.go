@@ -0,0 +1,119 @@
+package configuration
+
+import "time"
+
+const (
+	// OrderingAcceptFirst asks the destination to accept the asset before the source releases it
+	OrderingAcceptFirst = "ACCEPT_FIRST"
+	// OrderingReleaseFirst asks the source to release the asset before the destination accepts it
+	OrderingReleaseFirst = "RELEASE_FIRST"
+)
+
+// Route is a pair of inventory types a tenant may transfer assets between
+type Route struct {
+	from string
+	to   string
+}
+
+func (r Route) From() string {
+	return r.from
+}
+
+func (r Route) To() string {
+	return r.to
+}
+
+// Rate is how many transfers may be started in a period, as a token bucket holding up to burst transfers which is
+// refilled by count transfers every period
+type Rate struct {
+	count int
+	per   time.Duration
+	burst int
+}
+
+// Limited reports whether transfers are limited by the rate
+func (r Rate) Limited() bool {
+	return r.count > 0
+}
+
+func (r Rate) Count() int {
+	return r.count
+}
+
+func (r Rate) Per() time.Duration {
+	return r.per
+}
+
+// Burst is how many transfers may be started at once. It is the count when not set.
+func (r Rate) Burst() int {
+	if r.burst == 0 {
+		return r.count
+	}
+	return r.burst
+}
+
+// Model is the configuration of the transfers of a tenant. The zero value is the behaviour of a tenant which is not
+// configured: every supported pair of inventory types is enabled, steps never time out, transfers are not limited, and
+// the destination accepts the asset before the source releases it.
+type Model struct {
+	routes        []Route
+	stepTimeout   time.Duration
+	maxAttempts   int
+	ordering      string
+	accountRate   Rate
+	characterRate Rate
+	maxInFlight   int
+}
+
+// Routes are the pairs of inventory types transfers are enabled between. Every pair is enabled when there are none.
+func (m Model) Routes() []Route {
+	return m.routes
+}
+
+// Enabled reports whether the tenant may transfer assets from the one inventory type to the other
+func (m Model) Enabled(from string, to string) bool {
+	if len(m.routes) == 0 {
+		return true
+	}
+	for _, r := range m.routes {
+		if r.from == from && r.to == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StepTimeout is how long a step waits for a compartment to answer before its command is issued again. Steps never time
+// out when it is zero.
+func (m Model) StepTimeout() time.Duration {
+	return m.stepTimeout
+}
+
+// MaxAttempts is how many times the command of a step is issued before the transfer fails. Commands are issued until
+// answered when it is zero.
+func (m Model) MaxAttempts() int {
+	return m.maxAttempts
+}
+
+// Ordering is which of the compartments is asked to act first
+func (m Model) Ordering() string {
+	if m.ordering == "" {
+		return OrderingAcceptFirst
+	}
+	return m.ordering
+}
+
+// AccountRate is how often each account may start transfers
+func (m Model) AccountRate() Rate {
+	return m.accountRate
+}
+
+// CharacterRate is how often each character may start transfers
+func (m Model) CharacterRate() Rate {
+	return m.characterRate
+}
+
+// MaxInFlight is how many transfers of an account may be in progress at once. Accounts are not capped when it is zero.
+func (m Model) MaxInFlight() int {
+	return m.maxInFlight
+}
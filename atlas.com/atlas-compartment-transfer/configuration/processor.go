@@ -0,0 +1,80 @@
+package configuration
+
+import (
+	"atlas-compartment-transfer/rest"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrUnavailable is returned when the configuration service does not answer for a tenant it has never configured
+var ErrUnavailable = errors.New("tenant configuration is unavailable")
+
+// Processor defines the interface for resolving the transfer configuration of a tenant
+type Processor interface {
+	TenantProvider() model.Provider[Model]
+}
+
+// ProcessorImpl implements the Processor interface
+type ProcessorImpl struct {
+	l   logrus.FieldLogger
+	ctx context.Context
+	t   tenant.Model
+}
+
+// NewProcessor creates a new processor for the tenant of the context
+func NewProcessor(l logrus.FieldLogger, ctx context.Context) Processor {
+	return &ProcessorImpl{
+		l:   l,
+		ctx: ctx,
+		t:   tenant.MustFromContext(ctx),
+	}
+}
+
+// TenantProvider resolves the configuration of the tenant. The configuration file is preferred to the configuration
+// service, and tenants neither configures get the defaults. Should the configuration service not answer, the
+// configuration last retrieved from it is used. A tenant with none is not configured by guesswork, so ErrUnavailable is
+// returned, and the service is not asked again for RetryAfter.
+func (p *ProcessorImpl) TenantProvider() model.Provider[Model] {
+	return func() (Model, error) {
+		r := GetRegistry()
+		if m, ok := r.configured(p.t.Id()); ok {
+			return m, nil
+		}
+		last, found, fresh := r.cached(p.t.Id(), CacheTTL(p.l))
+		if fresh {
+			return last, nil
+		}
+
+		url, err := tenantUrl(p.t.Id())
+		if errors.Is(err, ErrNotConfigured) {
+			return r.defaults(), nil
+		}
+		if err, ok := r.failed(p.t.Id(), RetryAfter(p.l)); ok {
+			return p.unavailable(last, found, err)
+		}
+		m, err := rest.Provider[RestModel, Model](p.l, p.ctx)(requestById(url), Extract)()
+		if errors.Is(err, rest.ErrNotFound) {
+			m, err = r.defaults(), nil
+		}
+		if err != nil {
+			r.fail(p.t.Id(), err)
+			return p.unavailable(last, found, err)
+		}
+		r.store(p.t.Id(), m)
+		return m, nil
+	}
+}
+
+// unavailable resolves the configuration of the tenant while the configuration service does not answer
+func (p *ProcessorImpl) unavailable(last Model, found bool, err error) (Model, error) {
+	if found {
+		p.l.WithError(err).Warnf("Unable to retrieve configuration of tenant [%s]. Using the configuration last retrieved.", p.t.Id())
+		return last, nil
+	}
+	p.l.WithError(err).Errorf("Unable to retrieve configuration of tenant [%s].", p.t.Id())
+	return Model{}, fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
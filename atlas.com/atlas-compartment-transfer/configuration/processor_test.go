@@ -0,0 +1,140 @@
+package configuration_test
+
+import (
+	"atlas-compartment-transfer/configuration"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"atlas-compartment-transfer/test"
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func processor(t *testing.T, tenantId uuid.UUID) configuration.Processor {
+	t.Helper()
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	te, err := tenant.Create(tenantId, "GMS", 83, 1)
+	if err != nil {
+		t.Fatalf("Unable to create tenant: %v", err)
+	}
+	return configuration.NewProcessor(l, tenant.WithContext(context.Background(), te))
+}
+
+// useFile puts the configuration file in place for the duration of the test
+func useFile(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("Unable to write configuration: %v", err)
+	}
+	t.Setenv("TRANSFER_TENANT_CONFIG_FILE", path)
+	t.Cleanup(func() {
+		_ = os.Unsetenv("TRANSFER_TENANT_CONFIG_FILE")
+		_ = configuration.GetRegistry().Load()
+	})
+	err = configuration.GetRegistry().Load()
+	if err != nil {
+		t.Fatalf("Unable to load configuration: %v", err)
+	}
+}
+
+func TestTenantProviderPrefersFile(t *testing.T) {
+	configured := uuid.New()
+	useFile(t, `{"defaults":{"ordering":"RELEASE_FIRST"},"tenants":{"`+configured.String()+`":{"routes":[{"from":"CHARACTER","to":"CASH_SHOP"}],"timeouts":{"step":"30s"},"limits":{"maxAttempts":3}}}}`)
+	services := test.NewServices(t)
+	services.SetConfiguration(configured, map[string]interface{}{"ordering": configuration.OrderingReleaseFirst})
+
+	m, err := processor(t, configured).TenantProvider()()
+	if err != nil {
+		t.Fatalf("Unable to resolve configuration: %v", err)
+	}
+	if !m.Enabled(compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop) || m.Enabled(compartment.InventoryTypeCashShop, compartment.InventoryTypeCharacter) {
+		t.Errorf("Expected only the configured route to be enabled, got %v.", m.Routes())
+	}
+	if m.StepTimeout() != 30*time.Second || m.MaxAttempts() != 3 || m.Ordering() != configuration.OrderingAcceptFirst {
+		t.Errorf("Expected the file's configuration, got [%+v].", m)
+	}
+
+	m, err = processor(t, uuid.New()).TenantProvider()()
+	if err != nil {
+		t.Fatalf("Unable to resolve configuration: %v", err)
+	}
+	if m.Ordering() != configuration.OrderingReleaseFirst || !m.Enabled(compartment.InventoryTypeCashShop, compartment.InventoryTypeCharacter) {
+		t.Errorf("Expected a tenant the services do not configure to get the file's defaults, got [%+v].", m)
+	}
+}
+
+func TestTenantProviderFromService(t *testing.T) {
+	tenantId := uuid.New()
+	services := test.NewServices(t)
+	services.SetConfiguration(tenantId, map[string]interface{}{
+		"routes":   []map[string]interface{}{{"from": "CASH_SHOP", "to": "CHARACTER"}},
+		"ordering": configuration.OrderingReleaseFirst,
+	})
+
+	m, err := processor(t, tenantId).TenantProvider()()
+	if err != nil {
+		t.Fatalf("Unable to resolve configuration: %v", err)
+	}
+	if m.Ordering() != configuration.OrderingReleaseFirst || m.Enabled(compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop) {
+		t.Fatalf("Expected the service's configuration, got [%+v].", m)
+	}
+
+	// The configuration last retrieved is used while the service does not answer
+	t.Setenv("TRANSFER_TENANT_CONFIG_TTL", "0s")
+	services.SetFailing(true)
+	m, err = processor(t, tenantId).TenantProvider()()
+	if err != nil || m.Ordering() != configuration.OrderingReleaseFirst {
+		t.Errorf("Expected the configuration last retrieved, got [%+v] [%v].", m, err)
+	}
+
+	// A tenant never retrieved is not given the defaults
+	unknown := uuid.New()
+	_, err = processor(t, unknown).TenantProvider()()
+	if !errors.Is(err, configuration.ErrUnavailable) {
+		t.Errorf("Expected the configuration of a tenant never retrieved to be unavailable, got [%v].", err)
+	}
+
+	// The service is not asked again until the failure has aged
+	services.SetFailing(false)
+	services.SetConfiguration(unknown, map[string]interface{}{"ordering": configuration.OrderingReleaseFirst})
+	_, err = processor(t, unknown).TenantProvider()()
+	if !errors.Is(err, configuration.ErrUnavailable) {
+		t.Errorf("Expected the failure to be remembered, got [%v].", err)
+	}
+	t.Setenv("TRANSFER_TENANT_CONFIG_RETRY_AFTER", "0s")
+	m, err = processor(t, unknown).TenantProvider()()
+	if err != nil || m.Ordering() != configuration.OrderingReleaseFirst {
+		t.Errorf("Expected the service's configuration once the failure has aged, got [%+v] [%v].", m, err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	var tests = []struct {
+		name string
+		rm   configuration.RestModel
+	}{
+		{"unsupported route", configuration.RestModel{Routes: []configuration.RouteRestModel{{From: "STORAGE", To: "CHARACTER"}}}},
+		{"step timeout", configuration.RestModel{Timeouts: configuration.TimeoutsRestModel{Step: "soon"}}},
+		{"max attempts", configuration.RestModel{Limits: configuration.LimitsRestModel{MaxAttempts: -1}}},
+		{"ordering", configuration.RestModel{Ordering: "WHENEVER"}},
+		{"rate count", configuration.RestModel{Limits: configuration.LimitsRestModel{AccountRate: &configuration.RateRestModel{Per: "1m"}}}},
+		{"rate period", configuration.RestModel{Limits: configuration.LimitsRestModel{CharacterRate: &configuration.RateRestModel{Count: 5, Per: "daily"}}}},
+		{"max in flight", configuration.RestModel{Limits: configuration.LimitsRestModel{MaxInFlight: -1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := configuration.Extract(tt.rm); err == nil {
+				t.Errorf("Expected an invalid %s to be refused.", tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package configuration
+
+import (
+	"atlas-compartment-transfer/settings"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultCacheTTL = time.Minute
+const defaultRetryAfter = 10 * time.Second
+
+// File is the content of the configuration file. Tenants it does not configure are configured by the configuration
+// service, or failing that, by the defaults.
+type File struct {
+	Defaults *RestModel              `json:"defaults"`
+	Tenants  map[uuid.UUID]RestModel `json:"tenants"`
+}
+
+type fileConfig struct {
+	defaults Model
+	tenants  map[uuid.UUID]Model
+}
+
+type cached struct {
+	m         Model
+	fetchedAt time.Time
+}
+
+type failure struct {
+	err      error
+	failedAt time.Time
+}
+
+// Registry is a singleton that holds the transfer configuration of tenants. Configurations are read from the JSON file
+// named by TRANSFER_TENANT_CONFIG_FILE, which is re-read when the service is reloaded, and the configurations retrieved
+// from the configuration service are cached for TRANSFER_TENANT_CONFIG_TTL. A failure to retrieve a configuration is
+// remembered for TRANSFER_TENANT_CONFIG_RETRY_AFTER, so the service is not asked again on every command.
+type Registry struct {
+	lock      sync.Mutex
+	file      atomic.Pointer[fileConfig]
+	cacheLock sync.RWMutex
+	cache     map[uuid.UUID]cached
+	failures  map[uuid.UUID]failure
+}
+
+var registry *Registry
+var once sync.Once
+
+// GetRegistry returns the singleton instance of Registry
+func GetRegistry() *Registry {
+	once.Do(func() {
+		registry = &Registry{cache: make(map[uuid.UUID]cached), failures: make(map[uuid.UUID]failure)}
+		registry.file.Store(&fileConfig{tenants: make(map[uuid.UUID]Model)})
+	})
+	return registry
+}
+
+// Load reads the configuration file, and puts its configurations in place at once. Configurations cached from the
+// configuration service are retrieved again, but kept in case the service does not answer. When the file cannot be
+// read, or configures a tenant invalidly, the configurations in place are kept.
+func (r *Registry) Load() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	c := &fileConfig{tenants: make(map[uuid.UUID]Model)}
+	if path, ok := settings.Lookup("TRANSFER_TENANT_CONFIG_FILE"); ok && path != "" {
+		var err error
+		c, err = read(path)
+		if err != nil {
+			return err
+		}
+	}
+	r.file.Store(c)
+
+	r.cacheLock.Lock()
+	defer r.cacheLock.Unlock()
+	for id, c := range r.cache {
+		r.cache[id] = cached{m: c.m}
+	}
+	r.failures = make(map[uuid.UUID]failure)
+	return nil
+}
+
+func read(path string) (*fileConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a tenant configuration: %w", path, err)
+	}
+
+	c := &fileConfig{tenants: make(map[uuid.UUID]Model)}
+	if f.Defaults != nil {
+		c.defaults, err = Extract(*f.Defaults)
+		if err != nil {
+			return nil, fmt.Errorf("%s: defaults: %w", path, err)
+		}
+	}
+	for id, rm := range f.Tenants {
+		c.tenants[id], err = Extract(rm)
+		if err != nil {
+			return nil, fmt.Errorf("%s: tenant [%s]: %w", path, id, err)
+		}
+	}
+	return c, nil
+}
+
+// configured retrieves the configuration the file holds for the tenant, if any
+func (r *Registry) configured(tenantId uuid.UUID) (Model, bool) {
+	m, ok := r.file.Load().tenants[tenantId]
+	return m, ok
+}
+
+// defaults retrieves the configuration of tenants configured by neither the file nor the configuration service
+func (r *Registry) defaults() Model {
+	return r.file.Load().defaults
+}
+
+// cached retrieves the configuration last retrieved for the tenant, and whether it was retrieved within the age
+func (r *Registry) cached(tenantId uuid.UUID, age time.Duration) (Model, bool, bool) {
+	r.cacheLock.RLock()
+	defer r.cacheLock.RUnlock()
+	c, ok := r.cache[tenantId]
+	return c.m, ok, ok && time.Since(c.fetchedAt) <= age
+}
+
+func (r *Registry) store(tenantId uuid.UUID, m Model) {
+	r.cacheLock.Lock()
+	defer r.cacheLock.Unlock()
+	r.cache[tenantId] = cached{m: m, fetchedAt: time.Now()}
+	delete(r.failures, tenantId)
+}
+
+// failed retrieves the error the configuration service last answered for the tenant, when it answered within the age
+func (r *Registry) failed(tenantId uuid.UUID, age time.Duration) (error, bool) {
+	r.cacheLock.RLock()
+	defer r.cacheLock.RUnlock()
+	f, ok := r.failures[tenantId]
+	return f.err, ok && time.Since(f.failedAt) <= age
+}
+
+func (r *Registry) fail(tenantId uuid.UUID, err error) {
+	r.cacheLock.Lock()
+	defer r.cacheLock.Unlock()
+	r.failures[tenantId] = failure{err: err, failedAt: time.Now()}
+}
+
+// CacheTTL reads how long a configuration retrieved from the configuration service is used from
+// TRANSFER_TENANT_CONFIG_TTL
+func CacheTTL(l logrus.FieldLogger) time.Duration {
+	return duration(l, "TRANSFER_TENANT_CONFIG_TTL", defaultCacheTTL)
+}
+
+// RetryAfter reads how long the configuration service is not asked again for a tenant, once it has failed to answer,
+// from TRANSFER_TENANT_CONFIG_RETRY_AFTER
+func RetryAfter(l logrus.FieldLogger) time.Duration {
+	return duration(l, "TRANSFER_TENANT_CONFIG_RETRY_AFTER", defaultRetryAfter)
+}
+
+func duration(l logrus.FieldLogger, key string, def time.Duration) time.Duration {
+	v, ok := settings.Lookup(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		l.Warnf("Invalid %s [%s], using [%s].", key, v, def)
+		return def
+	}
+	return d
+}
+
+// Reload re-reads the configuration file, and logs how many tenants it configures
+func Reload(l logrus.FieldLogger) func() {
+	return func() {
+		err := GetRegistry().Load()
+		if err != nil {
+			l.WithError(err).Errorf("Unable to reload tenant configuration. Keeping the configuration in place.")
+			return
+		}
+		l.Infof("Reloaded tenant configuration. [%d] tenants are configured by file.", len(GetRegistry().file.Load().tenants))
+	}
+}
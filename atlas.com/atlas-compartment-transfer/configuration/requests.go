@@ -0,0 +1,25 @@
+package configuration
+
+import (
+	"atlas-compartment-transfer/rest"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+// ErrNotConfigured is returned when BASE_SERVICE_URL does not say where the configuration service is
+var ErrNotConfigured = errors.New("configuration service is not configured")
+
+const tenantResource = "configurations/tenants/%s/compartment-transfer"
+
+func tenantUrl(tenantId uuid.UUID) (string, error) {
+	baseUrl, ok := rest.BaseUrl()
+	if !ok {
+		return "", ErrNotConfigured
+	}
+	return baseUrl + fmt.Sprintf(tenantResource, tenantId), nil
+}
+
+func requestById(url string) rest.Request[RestModel] {
+	return rest.MakeGetRequest[RestModel](url)
+}
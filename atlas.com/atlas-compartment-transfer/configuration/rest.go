@@ -0,0 +1,126 @@
+package configuration
+
+import (
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"fmt"
+	"time"
+)
+
+// RestModel is the JSON:API resource for the transfer configuration of a tenant, as served by the configuration service.
+// The configuration file holds the same attributes.
+type RestModel struct {
+	Id       string            `json:"-"`
+	Routes   []RouteRestModel  `json:"routes,omitempty"`
+	Timeouts TimeoutsRestModel `json:"timeouts"`
+	Limits   LimitsRestModel   `json:"limits"`
+	Ordering string            `json:"ordering,omitempty"`
+}
+
+// RouteRestModel is a pair of inventory types transfers are enabled between
+type RouteRestModel struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// TimeoutsRestModel holds durations in the form accepted by time.ParseDuration, such as 30s
+type TimeoutsRestModel struct {
+	Step string `json:"step,omitempty"`
+}
+
+type LimitsRestModel struct {
+	MaxAttempts   int            `json:"maxAttempts,omitempty"`
+	AccountRate   *RateRestModel `json:"accountRate,omitempty"`
+	CharacterRate *RateRestModel `json:"characterRate,omitempty"`
+	MaxInFlight   int            `json:"maxInFlight,omitempty"`
+}
+
+// RateRestModel allows count transfers every period, such as 1m, and up to burst transfers at once
+type RateRestModel struct {
+	Count int    `json:"count"`
+	Per   string `json:"per"`
+	Burst int    `json:"burst,omitempty"`
+}
+
+func (r RestModel) GetName() string {
+	return "configurations"
+}
+
+func (r RestModel) GetID() string {
+	return r.Id
+}
+
+func (r *RestModel) SetID(strId string) error {
+	r.Id = strId
+	return nil
+}
+
+// Extract creates the configuration of a tenant from its resource, refusing values the saga cannot act upon
+func Extract(rm RestModel) (Model, error) {
+	routes := make([]Route, 0, len(rm.Routes))
+	for _, r := range rm.Routes {
+		if !supported(r.From) || !supported(r.To) {
+			return Model{}, fmt.Errorf("route from [%s] to [%s] is not between supported inventory types", r.From, r.To)
+		}
+		routes = append(routes, Route{from: r.From, to: r.To})
+	}
+
+	var stepTimeout time.Duration
+	if rm.Timeouts.Step != "" {
+		var err error
+		stepTimeout, err = time.ParseDuration(rm.Timeouts.Step)
+		if err != nil || stepTimeout < 0 {
+			return Model{}, fmt.Errorf("step timeout [%s] is not a duration", rm.Timeouts.Step)
+		}
+	}
+
+	if rm.Limits.MaxAttempts < 0 {
+		return Model{}, fmt.Errorf("max attempts [%d] is negative", rm.Limits.MaxAttempts)
+	}
+
+	accountRate, err := extractRate("account", rm.Limits.AccountRate)
+	if err != nil {
+		return Model{}, err
+	}
+	characterRate, err := extractRate("character", rm.Limits.CharacterRate)
+	if err != nil {
+		return Model{}, err
+	}
+	if rm.Limits.MaxInFlight < 0 {
+		return Model{}, fmt.Errorf("max in flight [%d] is negative", rm.Limits.MaxInFlight)
+	}
+
+	if rm.Ordering != "" && rm.Ordering != OrderingAcceptFirst && rm.Ordering != OrderingReleaseFirst {
+		return Model{}, fmt.Errorf("ordering [%s] is not [%s] or [%s]", rm.Ordering, OrderingAcceptFirst, OrderingReleaseFirst)
+	}
+
+	return Model{
+		routes:        routes,
+		stepTimeout:   stepTimeout,
+		maxAttempts:   rm.Limits.MaxAttempts,
+		ordering:      rm.Ordering,
+		accountRate:   accountRate,
+		characterRate: characterRate,
+		maxInFlight:   rm.Limits.MaxInFlight,
+	}, nil
+}
+
+func extractRate(name string, rm *RateRestModel) (Rate, error) {
+	if rm == nil {
+		return Rate{}, nil
+	}
+	if rm.Count <= 0 {
+		return Rate{}, fmt.Errorf("%s rate count [%d] is not positive", name, rm.Count)
+	}
+	per, err := time.ParseDuration(rm.Per)
+	if err != nil || per <= 0 {
+		return Rate{}, fmt.Errorf("%s rate period [%s] is not a positive duration", name, rm.Per)
+	}
+	if rm.Burst < 0 {
+		return Rate{}, fmt.Errorf("%s rate burst [%d] is negative", name, rm.Burst)
+	}
+	return Rate{count: rm.Count, per: per, burst: rm.Burst}, nil
+}
+
+func supported(inventoryType string) bool {
+	return inventoryType == compartment.InventoryTypeCharacter || inventoryType == compartment.InventoryTypeCashShop
+}
@@ -0,0 +1,53 @@
+package correlation
+
+import (
+	"context"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// MessageIdHeader identifies a produced message
+	MessageIdHeader = "MESSAGE_ID"
+	// CorrelationIdHeader identifies the saga a message was produced for
+	CorrelationIdHeader = "CORRELATION_ID"
+	// CausationIdHeader identifies the consumed message which caused a message to be produced
+	CausationIdHeader = "CAUSATION_ID"
+)
+
+type correlationKey struct{}
+
+type causationKey struct{}
+
+// WithCorrelationId creates a context whose produced messages are correlated to the saga
+func WithCorrelationId(ctx context.Context, correlationId string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, correlationId)
+}
+
+// CorrelationId is the id of the saga messages produced within the context belong to
+func CorrelationId(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationKey{}).(string)
+	return id, ok && id != ""
+}
+
+// WithCausationId creates a context whose produced messages were caused by the consumed message
+func WithCausationId(ctx context.Context, causationId string) context.Context {
+	return context.WithValue(ctx, causationKey{}, causationId)
+}
+
+// CausationId is the id of the consumed message being handled within the context
+func CausationId(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(causationKey{}).(string)
+	return id, ok && id != ""
+}
+
+// MessageId identifies a consumed message by its MESSAGE_ID header. Messages from producers which do not set the header
+// are identified by their topic, partition and offset.
+func MessageId(msg kafka.Message) string {
+	for _, h := range msg.Headers {
+		if h.Key == MessageIdHeader && len(h.Value) > 0 {
+			return string(h.Value)
+		}
+	}
+	return fmt.Sprintf("%s-%d-%d", msg.Topic, msg.Partition, msg.Offset)
+}
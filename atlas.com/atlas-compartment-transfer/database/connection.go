@@ -0,0 +1,75 @@
+package database
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"time"
+)
+
+const connectAttempts = 10
+
+type Migrator func(db *gorm.DB) error
+
+type Configuration struct {
+	migrators []Migrator
+}
+
+type Configurator func(c *Configuration)
+
+func SetMigrations(migrations ...Migrator) Configurator {
+	return func(c *Configuration) {
+		c.migrators = migrations
+	}
+}
+
+func Connect(l logrus.FieldLogger, configurators ...Configurator) *gorm.DB {
+	c := &Configuration{}
+	for _, configurator := range configurators {
+		configurator(c)
+	}
+
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
+		os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"), os.Getenv("DB_PORT"))
+
+	var db *gorm.DB
+	var err error
+	for attempt := 1; attempt <= connectAttempts; attempt++ {
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		if err == nil {
+			break
+		}
+		l.WithError(err).Warnf("Failed to connect to database. Attempt [%d] of [%d].", attempt, connectAttempts)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	if err != nil {
+		l.WithError(err).Fatal("Failed to connect to database.")
+	}
+
+	for _, m := range c.migrators {
+		err = m(db)
+		if err != nil {
+			l.WithError(err).Fatal("Failed to migrate database.")
+		}
+	}
+	return db
+}
+
+func Teardown(l logrus.FieldLogger) func(db *gorm.DB) func() {
+	return func(db *gorm.DB) func() {
+		return func() {
+			sqlDb, err := db.DB()
+			if err != nil {
+				l.WithError(err).Errorf("Unable to retrieve database connection.")
+				return
+			}
+			err = sqlDb.Close()
+			if err != nil {
+				l.WithError(err).Errorf("Unable to close database connection.")
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package dlq
+
+import (
+	"atlas-compartment-transfer/correlation"
+	"atlas-compartment-transfer/kafka/message/dlq"
+	"atlas-compartment-transfer/kafka/producer"
+	"atlas-compartment-transfer/transfer"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Chronicle20/atlas-kafka/handler"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Handler handles a decoded message, returning an error when the message cannot be processed
+type Handler[M any] func(l logrus.FieldLogger, ctx context.Context, m M) error
+
+// HandlerConfig describes how a Handler is adapted to the consumer
+type HandlerConfig[M any] struct {
+	handler             Handler[M]
+	deadLetterMalformed bool
+	producer            producer.ProviderFactory
+	decode              func(data []byte) (M, error)
+}
+
+// CommandConfig dead-letters messages which cannot be decoded, as well as those the handler fails to process
+func CommandConfig[M any](h Handler[M]) HandlerConfig[M] {
+	return HandlerConfig[M]{handler: h, deadLetterMalformed: true, producer: producer.ProviderImpl, decode: decode[M]}
+}
+
+// EventConfig dead-letters messages the handler fails to process. Event topics are shared with other services, so decoding failures are only logged
+func EventConfig[M any](h Handler[M]) HandlerConfig[M] {
+	return HandlerConfig[M]{handler: h, deadLetterMalformed: false, producer: producer.ProviderImpl, decode: decode[M]}
+}
+
+// SetProducer sets the producer factory dead-letter entries are emitted through
+func (c HandlerConfig[M]) SetProducer(pf producer.ProviderFactory) HandlerConfig[M] {
+	c.producer = pf
+	return c
+}
+
+// SetDecoder sets how messages are decoded, such as upcasting them from earlier versions
+func (c HandlerConfig[M]) SetDecoder(d func(data []byte) (M, error)) HandlerConfig[M] {
+	c.decode = d
+	return c
+}
+
+func decode[M any](data []byte) (M, error) {
+	var m M
+	err := json.Unmarshal(data, &m)
+	return m, err
+}
+
+// AdaptHandler adapts a Handler to the consumer, dead-lettering the original message on failure. Messages emitted while
+// handling are caused by the original message. A failure to handle a replayed message is also reported to the replay.
+func AdaptHandler[M any](config HandlerConfig[M]) handler.Handler {
+	return func(l logrus.FieldLogger, ctx context.Context, msg kafka.Message) (bool, error) {
+		ctx = correlation.WithCausationId(ctx, correlation.MessageId(msg))
+		m, err := config.decode(msg.Value)
+		if err != nil {
+			replayFailed(ctx, err)
+			if !config.deadLetterMalformed {
+				l.WithError(err).Warnf("Unable to decode message from topic [%s].", msg.Topic)
+				return true, nil
+			}
+			deadLetter(l, ctx, config.producer, msg, dlq.ReasonMalformed, err)
+			return true, nil
+		}
+
+		err = config.handler(l, ctx, m)
+		if err != nil {
+			replayFailed(ctx, err)
+			deadLetter(l, ctx, config.producer, msg, reason(err), err)
+		}
+		return true, nil
+	}
+}
+
+func deadLetter(l logrus.FieldLogger, ctx context.Context, pf producer.ProviderFactory, msg kafka.Message, reason string, cause error) {
+	err := newProcessor(l, ctx, pf).DeadLetterAndEmit(msg, reason, cause)
+	if err != nil {
+		l.WithError(err).Errorf("Unable to dead-letter message from topic [%s] partition [%d] offset [%d].", msg.Topic, msg.Partition, msg.Offset)
+	}
+}
+
+func reason(err error) string {
+	if errors.Is(err, transfer.ErrInvalidCommand) {
+		return dlq.ReasonInvalidCommand
+	}
+	if errors.Is(err, transfer.ErrUnknownTransaction) {
+		return dlq.ReasonUnknownTransaction
+	}
+	return dlq.ReasonProcessingFailed
+}
@@ -0,0 +1,79 @@
+package dlq_test
+
+import (
+	"atlas-compartment-transfer/dlq"
+	dlq2 "atlas-compartment-transfer/kafka/message/dlq"
+	"atlas-compartment-transfer/test"
+	"atlas-compartment-transfer/transfer"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+)
+
+type event struct {
+	Id int `json:"id"`
+}
+
+func TestAdaptHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		command bool
+		value   string
+		err     error
+		reasons []string
+	}{
+		{name: "handled message is acknowledged", value: `{"id":1}`, reasons: []string{}},
+		{name: "malformed command is dead-lettered", command: true, value: `{`, reasons: []string{dlq2.ReasonMalformed}},
+		{name: "malformed event is acknowledged", value: `{`, reasons: []string{}},
+		{name: "invalid command is dead-lettered", command: true, value: `{"id":1}`, err: transfer.ErrInvalidCommand, reasons: []string{dlq2.ReasonInvalidCommand}},
+		{name: "event of an unknown transaction is dead-lettered", value: `{"id":1}`, err: transfer.ErrUnknownTransaction, reasons: []string{dlq2.ReasonUnknownTransaction}},
+		{name: "message which fails is dead-lettered", value: `{"id":1}`, err: errors.New("failed"), reasons: []string{dlq2.ReasonProcessingFailed}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(dlq2.EnvTopic, dlq2.EnvTopic)
+			l := logrus.New()
+			l.SetOutput(io.Discard)
+			bus := test.NewBus(l)
+
+			var called bool
+			h := func(l logrus.FieldLogger, ctx context.Context, e event) error {
+				called = true
+				return tt.err
+			}
+			config := dlq.EventConfig[event](h)
+			if tt.command {
+				config = dlq.CommandConfig[event](h)
+			}
+
+			msg := kafka.Message{Topic: "source", Partition: 2, Offset: 7, Value: []byte(tt.value)}
+			handled, err := dlq.AdaptHandler(config.SetProducer(bus.ProviderFactory))(l, context.Background(), msg)
+			if !handled || err != nil {
+				t.Fatalf("Expected the message to be handled, got [%t] [%v].", handled, err)
+			}
+			if called != json.Valid(msg.Value) {
+				t.Errorf("Expected the handler to be called only for a message which decodes, got [%t].", called)
+			}
+
+			reasons := make([]string, 0)
+			for _, m := range bus.Messages(dlq2.EnvTopic) {
+				var e dlq2.Entry
+				if err := json.Unmarshal(m.Value, &e); err != nil {
+					t.Fatalf("Unable to decode dead-letter entry: %v", err)
+				}
+				if e.SourceTopic != msg.Topic || e.Partition != msg.Partition || e.Offset != msg.Offset || string(e.Payload) != tt.value {
+					t.Errorf("Expected the entry to carry the original message, got %+v.", e)
+				}
+				reasons = append(reasons, e.Reason)
+			}
+			if len(reasons) != len(tt.reasons) || (len(reasons) > 0 && reasons[0] != tt.reasons[0]) {
+				t.Errorf("Expected dead letters %v, got %v.", tt.reasons, reasons)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package dlq
+
+import (
+	"atlas-compartment-transfer/kafka/message"
+	"atlas-compartment-transfer/kafka/message/dlq"
+	"atlas-compartment-transfer/kafka/producer"
+	dlq2 "atlas-compartment-transfer/kafka/producer/dlq"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Chronicle20/atlas-kafka/consumer"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// ErrNoHandlers is returned when replaying an entry whose source topic has no registered handlers
+var ErrNoHandlers = errors.New("no handlers registered for source topic")
+
+// ErrReplayFailed is returned when a handler fails to process a replayed entry. The entry is dead-lettered again.
+var ErrReplayFailed = errors.New("replayed entry could not be processed")
+
+type replayKey struct{}
+
+// replay collects the failures of the handlers an entry is replayed through, which dead-letter rather than return them
+type replay struct {
+	lock sync.Mutex
+	errs []error
+}
+
+func withReplay(ctx context.Context) (context.Context, *replay) {
+	r := &replay{}
+	return context.WithValue(ctx, replayKey{}, r), r
+}
+
+// replayFailed records the failure of a handler, when the message it handles is being replayed
+func replayFailed(ctx context.Context, err error) {
+	r, ok := ctx.Value(replayKey{}).(*replay)
+	if !ok {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *replay) err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrReplayFailed, errors.Join(r.errs...))
+}
+
+// Processor defines the interface for the dead-letter processor
+type Processor interface {
+	DeadLetter(mb *message.Buffer) func(msg kafka.Message) func(reason string, cause error) error
+	DeadLetterAndEmit(msg kafka.Message, reason string, cause error) error
+	Replay(e dlq.Entry) error
+}
+
+// ProcessorImpl implements the Processor interface
+type ProcessorImpl struct {
+	l        logrus.FieldLogger
+	ctx      context.Context
+	producer producer.Provider
+}
+
+// NewProcessor creates a new processor
+func NewProcessor(l logrus.FieldLogger, ctx context.Context) Processor {
+	return newProcessor(l, ctx, producer.ProviderImpl)
+}
+
+func newProcessor(l logrus.FieldLogger, ctx context.Context, pf producer.ProviderFactory) Processor {
+	return &ProcessorImpl{
+		l:        l,
+		ctx:      ctx,
+		producer: pf(l)(ctx),
+	}
+}
+
+// DeadLetter buffers a dead-letter entry for the original message
+func (p *ProcessorImpl) DeadLetter(mb *message.Buffer) func(msg kafka.Message) func(reason string, cause error) error {
+	return func(msg kafka.Message) func(reason string, cause error) error {
+		return func(reason string, cause error) error {
+			p.l.WithError(cause).Warnf("Dead-lettering message from topic [%s] partition [%d] offset [%d]. Reason [%s].", msg.Topic, msg.Partition, msg.Offset, reason)
+			return mb.Put(dlq.EnvTopic, dlq2.EntryProvider(msg, reason, cause))
+		}
+	}
+}
+
+// DeadLetterAndEmit dead-letters the original message and emits the entry
+func (p *ProcessorImpl) DeadLetterAndEmit(msg kafka.Message, reason string, cause error) error {
+	return message.Emit(p.producer)(func(mb *message.Buffer) error {
+		return p.DeadLetter(mb)(msg)(reason, cause)
+	})
+}
+
+// Replay feeds a dead-letter entry back through the handlers registered for its source topic, returning an error when
+// any of them fails to process it
+func (p *ProcessorImpl) Replay(e dlq.Entry) error {
+	hs := GetRegistry().Get(e.SourceTopic)
+	if len(hs) == 0 {
+		p.l.Warnf("Unable to replay dead-letter entry. No handlers registered for topic [%s].", e.SourceTopic)
+		return ErrNoHandlers
+	}
+
+	msg := kafka.Message{
+		Topic:     e.SourceTopic,
+		Partition: e.Partition,
+		Offset:    e.Offset,
+		Key:       e.Key,
+		Value:     e.Payload,
+	}
+	for _, h := range e.Headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: h.Key, Value: []byte(h.Value)})
+	}
+
+	// Restore the span and tenant of the original message
+	ctx := consumer.SpanHeaderParser(p.ctx, msg.Headers)
+	ctx = consumer.TenantHeaderParser(ctx, msg.Headers)
+	ctx, r := withReplay(ctx)
+
+	p.l.Infof("Replaying dead-letter entry from topic [%s] partition [%d] offset [%d]. Original reason [%s].", e.SourceTopic, e.Partition, e.Offset, e.Reason)
+	for _, h := range hs {
+		_, err := h(p.l, ctx, msg)
+		if err != nil {
+			replayFailed(ctx, err)
+		}
+	}
+	return r.err()
+}
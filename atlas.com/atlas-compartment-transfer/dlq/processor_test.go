@@ -0,0 +1,63 @@
+package dlq_test
+
+import (
+	"atlas-compartment-transfer/dlq"
+	dlq2 "atlas-compartment-transfer/kafka/message/dlq"
+	"atlas-compartment-transfer/test"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		replay  error
+		reasons int
+	}{
+		{name: "replayed entry is handled", replay: nil},
+		{name: "failure of replayed entry is returned", err: errors.New("failed"), replay: dlq.ErrReplayFailed, reasons: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(dlq2.EnvTopic, dlq2.EnvTopic)
+			l := logrus.New()
+			l.SetOutput(io.Discard)
+			bus := test.NewBus(l)
+
+			// Each case replays from a topic of its own, as the registry is shared
+			topic := uuid.New().String()
+			var handled []int
+			h := func(l logrus.FieldLogger, ctx context.Context, e event) error {
+				handled = append(handled, e.Id)
+				return tt.err
+			}
+			dlq.GetRegistry().Add(topic, dlq.AdaptHandler(dlq.CommandConfig[event](h).SetProducer(bus.ProviderFactory)))
+
+			err := dlq.NewProcessor(l, context.Background()).Replay(dlq2.Entry{SourceTopic: topic, Payload: []byte(`{"id":3}`), Reason: dlq2.ReasonProcessingFailed})
+			if !errors.Is(err, tt.replay) || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Errorf("Expected replay to return [%v], got [%v].", tt.replay, err)
+			}
+			if len(handled) != 1 || handled[0] != 3 {
+				t.Errorf("Expected the entry to be handled once, got %v.", handled)
+			}
+			if n := len(bus.Messages(dlq2.EnvTopic)); n != tt.reasons {
+				t.Errorf("Expected [%d] dead letters, got [%d].", tt.reasons, n)
+			}
+		})
+	}
+
+	t.Run("entry of a topic without handlers is refused", func(t *testing.T) {
+		l := logrus.New()
+		l.SetOutput(io.Discard)
+		err := dlq.NewProcessor(l, context.Background()).Replay(dlq2.Entry{SourceTopic: uuid.New().String()})
+		if !errors.Is(err, dlq.ErrNoHandlers) {
+			t.Errorf("Expected [%v], got [%v].", dlq.ErrNoHandlers, err)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package dlq
+
+import (
+	"github.com/Chronicle20/atlas-kafka/handler"
+	"sync"
+)
+
+// Registry is a singleton that tracks the handlers registered per topic, so dead-lettered messages can be replayed through them
+type Registry struct {
+	handlers map[string][]handler.Handler
+	lock     sync.RWMutex
+}
+
+var registry *Registry
+var once sync.Once
+
+// GetRegistry returns the singleton instance of Registry
+func GetRegistry() *Registry {
+	once.Do(func() {
+		registry = &Registry{
+			handlers: make(map[string][]handler.Handler),
+			lock:     sync.RWMutex{},
+		}
+	})
+	return registry
+}
+
+// Add records a handler for the topic
+func (r *Registry) Add(topic string, h handler.Handler) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.handlers[topic] = append(r.handlers[topic], h)
+}
+
+// Get retrieves the handlers recorded for the topic
+func (r *Registry) Get(topic string) []handler.Handler {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append([]handler.Handler(nil), r.handlers[topic]...)
+}
+
+// RegisterHandler decorates a handler registration function so that every registered handler is also recorded for
+// replay. It is to be applied outside the decorators which gate or disrupt consumption, so that entries are replayed
+// through the handler alone.
+func RegisterHandler(rf func(topic string, handler handler.Handler) (string, error)) func(topic string, handler handler.Handler) (string, error) {
+	return func(topic string, h handler.Handler) (string, error) {
+		id, err := rf(topic, h)
+		if err != nil {
+			return id, err
+		}
+		GetRegistry().Add(topic, h)
+		return id, nil
+	}
+}
@@ -0,0 +1,191 @@
+package drain
+
+import (
+	"atlas-compartment-transfer/settings"
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-kafka/handler"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// ErrDraining is returned for messages delivered once the service has begun to drain, which are not handled
+var ErrDraining = errors.New("service is draining")
+
+const defaultTimeout = 10 * time.Second
+
+// Gate tracks the handlers which are running, and the background work which is started until shutdown, so shutdown can
+// let them finish before anything is torn down
+type Gate struct {
+	lock    sync.Mutex
+	closed  bool
+	running int
+	idle    chan struct{}
+	workers sync.WaitGroup
+}
+
+var gate *Gate
+var once sync.Once
+
+// GetGate returns the singleton instance of Gate
+func GetGate() *Gate {
+	once.Do(func() {
+		gate = NewGate()
+	})
+	return gate
+}
+
+var readGate *Gate
+var readOnce sync.Once
+
+// GetReadGate returns the singleton instance of the Gate of the REST requests which read transfers. It is closed after
+// the gate of the work which acts upon them, so reads are served while that work drains, but not once the journal they
+// read is closed.
+func GetReadGate() *Gate {
+	readOnce.Do(func() {
+		readGate = NewGate()
+	})
+	return readGate
+}
+
+// NewGate creates an open gate
+func NewGate() *Gate {
+	return &Gate{}
+}
+
+// enter records a handler as running, unless the gate is closed
+func (g *Gate) enter() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.closed {
+		return false
+	}
+	g.running++
+	return true
+}
+
+func (g *Gate) leave() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.running--
+	if g.running == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// Close stops the gate admitting handlers
+func (g *Gate) Close() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.closed = true
+}
+
+// WaitGroup tracks background work, such as periodic loops, which is to stop when the service drains. The work is to
+// stop once the context drain stops is done.
+func (g *Gate) WaitGroup() *sync.WaitGroup {
+	return &g.workers
+}
+
+// Running is the number of handlers which have not yet finished
+func (g *Gate) Running() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.running
+}
+
+// Wait waits for the running handlers and the background work to finish, or for the context to be done
+func (g *Gate) Wait(ctx context.Context) error {
+	g.lock.Lock()
+	idle := make(chan struct{})
+	if g.running == 0 {
+		close(idle)
+	} else {
+		if g.idle == nil {
+			g.idle = make(chan struct{})
+		}
+		idle = g.idle
+	}
+	g.lock.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		<-idle
+		g.workers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Handler decorates a handler so that it only runs while the gate is open, and is tracked until it finishes. Handlers
+// run detached from the cancellation of the consumer, so that stopping the consumers does not cut them off partway
+// through journaling or emitting. A message refused while draining is reported as not handled, so its offset is not
+// committed and it is delivered again once the partition is consumed elsewhere.
+func Handler(g *Gate) func(h handler.Handler) handler.Handler {
+	return func(h handler.Handler) handler.Handler {
+		return func(l logrus.FieldLogger, ctx context.Context, msg kafka.Message) (bool, error) {
+			if !g.enter() {
+				l.Warnf("Draining. Not handling message from topic [%s] partition [%d] offset [%d].", msg.Topic, msg.Partition, msg.Offset)
+				return false, ErrDraining
+			}
+			defer g.leave()
+			return h(l, context.WithoutCancel(ctx), msg)
+		}
+	}
+}
+
+// RegisterHandler decorates a handler registration function so that every registered handler passes through the gate
+func RegisterHandler(g *Gate) func(rf func(topic string, handler handler.Handler) (string, error)) func(topic string, handler handler.Handler) (string, error) {
+	return func(rf func(topic string, handler handler.Handler) (string, error)) func(topic string, handler handler.Handler) (string, error) {
+		return func(topic string, h handler.Handler) (string, error) {
+			return rf(topic, Handler(g)(h))
+		}
+	}
+}
+
+// Drain stops the consumers and the background work, closes the gate, and waits for the handlers and background work
+// already running to finish, for up to the timeout read from SHUTDOWN_DRAIN_TIMEOUT. It is to be run once termination is
+// requested, before the state store is flushed and the root context is cancelled.
+func Drain(l logrus.FieldLogger) func(g *Gate) func(stop context.CancelFunc) func() {
+	return func(g *Gate) func(stop context.CancelFunc) func() {
+		return func(stop context.CancelFunc) func() {
+			return func() {
+				timeout := Timeout(l)
+				l.Infof("Draining. Waiting up to [%s] for [%d] running handlers to finish.", timeout, g.Running())
+				stop()
+				g.Close()
+
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+				err := g.Wait(ctx)
+				if err != nil {
+					l.Warnf("Drain timed out with [%d] handlers still running. Their transfers will be resumed from the journal.", g.Running())
+					return
+				}
+				l.Infof("Drained.")
+			}
+		}
+	}
+}
+
+// Timeout reads how long to wait for running handlers to finish from SHUTDOWN_DRAIN_TIMEOUT
+func Timeout(l logrus.FieldLogger) time.Duration {
+	v, ok := settings.Lookup("SHUTDOWN_DRAIN_TIMEOUT")
+	if !ok {
+		return defaultTimeout
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		l.Warnf("Invalid SHUTDOWN_DRAIN_TIMEOUT [%s], using [%s].", v, defaultTimeout)
+		return defaultTimeout
+	}
+	return d
+}
@@ -0,0 +1,166 @@
+package drain_test
+
+import (
+	"atlas-compartment-transfer/drain"
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func logger() logrus.FieldLogger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return l
+}
+
+func TestDrainLetsRunningHandlerFinish(t *testing.T) {
+	g := drain.NewGate()
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	finished := make(chan error, 1)
+	h := drain.Handler(g)(func(l logrus.FieldLogger, ctx context.Context, msg kafka.Message) (bool, error) {
+		close(started)
+		<-proceed
+		finished <- ctx.Err()
+		return true, nil
+	})
+
+	consumerCtx, stopConsumers := context.WithCancel(context.Background())
+	go func() {
+		_, _ = h(logger(), consumerCtx, kafka.Message{})
+	}()
+	<-started
+
+	drained := make(chan struct{})
+	go func() {
+		drain.Drain(logger())(g)(stopConsumers)()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		t.Fatalf("Expected drain to wait for the running handler.")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if consumerCtx.Err() == nil {
+		t.Fatalf("Expected consumers to be stopped.")
+	}
+
+	close(proceed)
+	if err := <-finished; err != nil {
+		t.Fatalf("Expected the handler to run detached from the consumer, got [%v].", err)
+	}
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected drain to finish once the handler finished.")
+	}
+
+	handled, err := h(logger(), context.Background(), kafka.Message{})
+	if handled || !errors.Is(err, drain.ErrDraining) {
+		t.Fatalf("Expected message delivered while draining to be refused and left unhandled, got [%t] [%v].", handled, err)
+	}
+}
+
+func TestDrainStopsBackgroundWork(t *testing.T) {
+	g := drain.NewGate()
+	ctx, stop := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	proceed := make(chan struct{})
+	g.WaitGroup().Add(1)
+	go func() {
+		defer g.WaitGroup().Done()
+		<-ctx.Done()
+		close(stopped)
+		<-proceed
+	}()
+
+	drained := make(chan struct{})
+	go func() {
+		drain.Drain(logger())(g)(stop)()
+		close(drained)
+	}()
+
+	<-stopped
+	select {
+	case <-drained:
+		t.Fatalf("Expected drain to wait for the background work to finish.")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(proceed)
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected drain to finish once the background work finished.")
+	}
+}
+
+func TestDrainRefusesRequestsWhichAct(t *testing.T) {
+	g := drain.NewGate()
+	router := mux.NewRouter()
+	reads := drain.NewGate()
+	drain.InitResource(g, reads)(router, logger())
+	router.HandleFunc("/transfers", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}).Methods(http.MethodGet, http.MethodPost)
+	serve := func(method string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, "/transfers", nil))
+		return w.Code
+	}
+
+	if code := serve(http.MethodPost); code != http.StatusOK {
+		t.Fatalf("Expected request to be served before draining, got [%d].", code)
+	}
+	drain.Drain(logger())(g)(func() {})()
+	if code := serve(http.MethodPost); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected request which acts to be refused while draining, got [%d].", code)
+	}
+	if code := serve(http.MethodGet); code != http.StatusOK {
+		t.Errorf("Expected request which reads to be served while draining, got [%d].", code)
+	}
+
+	// Reads are refused once drained, before the journal they read is closed
+	drain.Drain(logger())(reads)(func() {})()
+	if code := serve(http.MethodGet); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected request which reads to be refused once reads are drained, got [%d].", code)
+	}
+}
+
+func TestDrainTimesOut(t *testing.T) {
+	t.Setenv("SHUTDOWN_DRAIN_TIMEOUT", "10ms")
+	g := drain.NewGate()
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	defer close(proceed)
+	h := drain.Handler(g)(func(l logrus.FieldLogger, ctx context.Context, msg kafka.Message) (bool, error) {
+		close(started)
+		<-proceed
+		return true, nil
+	})
+	go func() {
+		_, _ = h(logger(), context.Background(), kafka.Message{})
+	}()
+	<-started
+
+	drained := make(chan struct{})
+	go func() {
+		drain.Drain(logger())(g)(func() {})()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected drain to give up once the timeout passed.")
+	}
+	if g.Running() != 1 {
+		t.Fatalf("Expected the handler to still be running, got [%d].", g.Running())
+	}
+}
@@ -0,0 +1,30 @@
+package drain
+
+import (
+	"atlas-compartment-transfer/rest"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// InitResource passes the requests of the routes which act upon transfers through the gate, and those which read them
+// through the read gate, so they are refused once their gate is drained, and those already running are let finish.
+func InitResource(g *Gate, reads *Gate) rest.RouteInitializer {
+	return func(router *mux.Router, l logrus.FieldLogger) {
+		router.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				rg := g
+				if r.Method == http.MethodGet || r.Method == http.MethodHead {
+					rg = reads
+				}
+				if !rg.enter() {
+					l.Warnf("Draining. Not serving [%s] [%s].", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				defer rg.leave()
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+}
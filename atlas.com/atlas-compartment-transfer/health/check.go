@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Chronicle20/atlas-kafka/handler"
+	"github.com/Chronicle20/atlas-kafka/topic"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// DatabaseCheck pings the state store
+func DatabaseCheck(db *gorm.DB) Check {
+	return func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}
+}
+
+// TopicCheck connects to the brokers and ensures each of the topics has partitions to read from or write to
+func TopicCheck(brokers []string) func(topics model.Provider[[]string]) Check {
+	return func(topics model.Provider[[]string]) Check {
+		return func(ctx context.Context) error {
+			ts, err := topics()
+			if err != nil {
+				return err
+			}
+			if len(ts) == 0 {
+				return errors.New("no topics")
+			}
+
+			conn, err := dial(ctx, brokers)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			ps, err := conn.ReadPartitions(ts...)
+			if err != nil {
+				return err
+			}
+			found := make(map[string]bool)
+			for _, p := range ps {
+				found[p.Topic] = true
+			}
+			for _, t := range ts {
+				if !found[t] {
+					return fmt.Errorf("topic [%s] has no partitions", t)
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// dial connects to the first of the brokers which is reachable
+func dial(ctx context.Context, brokers []string) (*kafka.Conn, error) {
+	var err error
+	for _, b := range brokers {
+		var conn *kafka.Conn
+		conn, err = kafka.DialContext(ctx, "tcp", b)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	if err == nil {
+		err = errors.New("no brokers")
+	}
+	return nil, err
+}
+
+var consumedTopics = make(map[string]struct{})
+var consumedTopicsLock sync.RWMutex
+
+// ConsumedTopicsProvider provides the topics which have had a handler registered
+func ConsumedTopicsProvider() model.Provider[[]string] {
+	return func() ([]string, error) {
+		consumedTopicsLock.RLock()
+		defer consumedTopicsLock.RUnlock()
+		ts := make([]string, 0, len(consumedTopics))
+		for t := range consumedTopics {
+			ts = append(ts, t)
+		}
+		return ts, nil
+	}
+}
+
+// RegisterHandler decorates a handler registration function so that every topic with a registered handler is checked for readiness
+func RegisterHandler(rf func(topic string, handler handler.Handler) (string, error)) func(topic string, handler handler.Handler) (string, error) {
+	return func(topic string, h handler.Handler) (string, error) {
+		id, err := rf(topic, h)
+		if err != nil {
+			return id, err
+		}
+		consumedTopicsLock.Lock()
+		defer consumedTopicsLock.Unlock()
+		consumedTopics[topic] = struct{}{}
+		return id, nil
+	}
+}
+
+// EnvTopicsProvider resolves the topics configured for each of the environment variable tokens
+func EnvTopicsProvider(l logrus.FieldLogger) func(tokens ...string) model.Provider[[]string] {
+	return func(tokens ...string) model.Provider[[]string] {
+		return func() ([]string, error) {
+			ts := make([]string, 0, len(tokens))
+			for _, token := range tokens {
+				t, err := topic.EnvProvider(l)(token)()
+				if err != nil {
+					return nil, fmt.Errorf("topic [%s]: %w", token, err)
+				}
+				ts = append(ts, t)
+			}
+			return ts, nil
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrRecovering is reported until in-flight transfers have been resumed at startup
+	ErrRecovering = errors.New("recovering in-flight transfers")
+	// ErrDraining is reported once the service has been asked to terminate
+	ErrDraining = errors.New("draining")
+)
+
+// Check reports an error when a dependency of the service is unavailable
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Registry is a singleton that holds the checks which decide whether the service is ready to receive traffic
+type Registry struct {
+	checks    []namedCheck
+	recovered bool
+	draining  bool
+	lock      sync.RWMutex
+}
+
+var registry *Registry
+var once sync.Once
+
+// GetRegistry returns the singleton instance of Registry
+func GetRegistry() *Registry {
+	once.Do(func() {
+		registry = &Registry{
+			checks: make([]namedCheck, 0),
+			lock:   sync.RWMutex{},
+		}
+	})
+	return registry
+}
+
+// AddCheck records a check which must pass for the service to be ready
+func (r *Registry) AddCheck(name string, c Check) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.checks = append(r.checks, namedCheck{name: name, check: c})
+}
+
+// SetRecovered marks startup recovery as finished
+func (r *Registry) SetRecovered() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.recovered = true
+}
+
+// SetDraining marks the service as terminating, so it reports not-ready while the consumers stop
+func (r *Registry) SetDraining() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.draining = true
+}
+
+// Ready runs every check, returning the result of each by name. A nil result is a pass.
+func (r *Registry) Ready(ctx context.Context) map[string]error {
+	r.lock.RLock()
+	checks := append([]namedCheck(nil), r.checks...)
+	recovered := r.recovered
+	draining := r.draining
+	r.lock.RUnlock()
+
+	results := make(map[string]error)
+	results["recovery"] = nil
+	if !recovered {
+		results["recovery"] = ErrRecovering
+	}
+	results["lifecycle"] = nil
+	if draining {
+		results["lifecycle"] = ErrDraining
+	}
+	for _, c := range checks {
+		results[c.name] = c.check(ctx)
+	}
+	return results
+}
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"atlas-compartment-transfer/rest"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+const (
+	StatusUp   = "UP"
+	StatusDown = "DOWN"
+)
+
+type RestModel struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func InitResource() rest.RouteInitializer {
+	return func(router *mux.Router, l logrus.FieldLogger) {
+		router.HandleFunc("/healthz", handleLiveness(l)).Methods(http.MethodGet)
+		router.HandleFunc("/readyz", handleReadiness(l)).Methods(http.MethodGet)
+	}
+}
+
+// handleLiveness reports the service is up for as long as it is able to serve requests
+func handleLiveness(l logrus.FieldLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		write(l)(w)(http.StatusOK, RestModel{Status: StatusUp})
+	}
+}
+
+// handleReadiness reports the service is up when every readiness check passes
+func handleReadiness(l logrus.FieldLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(2)*time.Second)
+		defer cancel()
+
+		rm := RestModel{Status: StatusUp, Checks: make(map[string]string)}
+		for name, err := range GetRegistry().Ready(ctx) {
+			if err != nil {
+				rm.Status = StatusDown
+				rm.Checks[name] = StatusDown + ": " + err.Error()
+				continue
+			}
+			rm.Checks[name] = StatusUp
+		}
+
+		status := http.StatusOK
+		if rm.Status == StatusDown {
+			l.Debugf("Reporting not ready: %v.", rm.Checks)
+			status = http.StatusServiceUnavailable
+		}
+		write(l)(w)(status, rm)
+	}
+}
+
+func write(l logrus.FieldLogger) func(w http.ResponseWriter) func(status int, rm RestModel) {
+	return func(w http.ResponseWriter) func(status int, rm RestModel) {
+		return func(status int, rm RestModel) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			err := json.NewEncoder(w).Encode(rm)
+			if err != nil {
+				l.WithError(err).Errorf("Unable to write health response.")
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package health_test
+
+import (
+	"atlas-compartment-transfer/health"
+	"atlas-compartment-transfer/test"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// probe requests the path of the health resource, returning the status code and the response
+func probe(t *testing.T, router *mux.Router, path string) (int, health.RestModel) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var rm health.RestModel
+	err := json.NewDecoder(w.Body).Decode(&rm)
+	if err != nil {
+		t.Fatalf("Unable to decode [%s] response: %v", path, err)
+	}
+	return w.Code, rm
+}
+
+func TestReadiness(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	router := mux.NewRouter()
+	health.InitResource()(router, l)
+	db := test.Database(t)
+	health.GetRegistry().AddCheck("database", health.DatabaseCheck(db))
+
+	tests := []struct {
+		name   string
+		change func()
+		status int
+		down   string
+	}{
+		{name: "not ready while recovering", change: func() {}, status: http.StatusServiceUnavailable, down: "recovery"},
+		{name: "ready once recovered", change: health.GetRegistry().SetRecovered, status: http.StatusOK},
+		{name: "not ready once the database is lost", change: func() {
+			sqlDB, err := db.DB()
+			if err != nil {
+				t.Fatalf("Unable to retrieve database: %v", err)
+			}
+			_ = sqlDB.Close()
+		}, status: http.StatusServiceUnavailable, down: "database"},
+		{name: "not ready while draining", change: health.GetRegistry().SetDraining, status: http.StatusServiceUnavailable, down: "lifecycle"},
+	}
+
+	// Each case follows on from the last, as the registry is shared
+	for _, tt := range tests {
+		tt.change()
+		status, rm := probe(t, router, "/readyz")
+		if status != tt.status {
+			t.Errorf("%s: expected status [%d], got [%d] %v.", tt.name, tt.status, status, rm.Checks)
+		}
+		if tt.down != "" && (rm.Status != health.StatusDown || rm.Checks[tt.down] == health.StatusUp) {
+			t.Errorf("%s: expected check [%s] to be down, got %v.", tt.name, tt.down, rm.Checks)
+		}
+
+		// The service stays live whether or not it is ready
+		status, rm = probe(t, router, "/healthz")
+		if status != http.StatusOK || rm.Status != health.StatusUp {
+			t.Errorf("%s: expected to be live, got [%d] [%s].", tt.name, status, rm.Status)
+		}
+	}
+}
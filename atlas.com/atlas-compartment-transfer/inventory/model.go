@@ -0,0 +1,73 @@
+package inventory
+
+import (
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"github.com/google/uuid"
+)
+
+// Compartment is a compartment of a character's inventory, or of an account's cash shop inventory, as reported by the
+// service which owns it
+type Compartment struct {
+	id              uuid.UUID
+	inventoryType   string
+	compartmentType byte
+	capacity        uint32
+	assets          uint32
+}
+
+func (m Compartment) Id() uuid.UUID {
+	return m.id
+}
+
+func (m Compartment) InventoryType() string {
+	return m.inventoryType
+}
+
+func (m Compartment) Type() byte {
+	return m.compartmentType
+}
+
+func (m Compartment) Capacity() uint32 {
+	return m.capacity
+}
+
+// Assets is the number of assets the compartment holds
+func (m Compartment) Assets() uint32 {
+	return m.assets
+}
+
+// Free is the number of slots of the compartment which hold no asset
+func (m Compartment) Free() uint32 {
+	if m.assets >= m.capacity {
+		return 0
+	}
+	return m.capacity - m.assets
+}
+
+// Allows reports whether an asset of the template may be placed in the compartment. A character's compartments each hold
+// one type of item, named by the leading digit of the template id. The cash shop decides for itself what it holds.
+func (m Compartment) Allows(templateId uint32) bool {
+	if m.inventoryType != compartment.InventoryTypeCharacter {
+		return true
+	}
+	return templateId/1000000 == uint32(m.compartmentType)
+}
+
+// Asset is an asset held in a compartment
+type Asset struct {
+	id          uint32
+	templateId  uint32
+	referenceId uint32
+}
+
+func (m Asset) Id() uint32 {
+	return m.id
+}
+
+func (m Asset) TemplateId() uint32 {
+	return m.templateId
+}
+
+func (m Asset) ReferenceId() uint32 {
+	return m.referenceId
+}
@@ -0,0 +1,47 @@
+package inventory
+
+import (
+	"atlas-compartment-transfer/rest"
+	"context"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Processor defines the interface for looking up compartments in the character and cash shop inventory services
+type Processor interface {
+	CompartmentProvider(inventoryType string, ownerId uint32, compartmentId uuid.UUID) model.Provider[Compartment]
+	AssetProvider(inventoryType string, ownerId uint32, compartmentId uuid.UUID, assetId uint32) model.Provider[Asset]
+}
+
+// ProcessorImpl implements the Processor interface
+type ProcessorImpl struct {
+	l   logrus.FieldLogger
+	ctx context.Context
+}
+
+// NewProcessor creates a new processor
+func NewProcessor(l logrus.FieldLogger, ctx context.Context) Processor {
+	return &ProcessorImpl{
+		l:   l,
+		ctx: ctx,
+	}
+}
+
+// CompartmentProvider retrieves a compartment of the inventory type, owned by the character or account
+func (p *ProcessorImpl) CompartmentProvider(inventoryType string, ownerId uint32, compartmentId uuid.UUID) model.Provider[Compartment] {
+	url, err := compartmentUrl(inventoryType, ownerId, compartmentId)
+	if err != nil {
+		return model.ErrorProvider[Compartment](err)
+	}
+	return rest.Provider[CompartmentRestModel, Compartment](p.l, p.ctx)(requestCompartment(url), ExtractCompartment(inventoryType))
+}
+
+// AssetProvider retrieves an asset held in a compartment of the inventory type, owned by the character or account
+func (p *ProcessorImpl) AssetProvider(inventoryType string, ownerId uint32, compartmentId uuid.UUID, assetId uint32) model.Provider[Asset] {
+	url, err := compartmentUrl(inventoryType, ownerId, compartmentId)
+	if err != nil {
+		return model.ErrorProvider[Asset](err)
+	}
+	return rest.Provider[AssetRestModel, Asset](p.l, p.ctx)(requestAsset(url, assetId), ExtractAsset)
+}
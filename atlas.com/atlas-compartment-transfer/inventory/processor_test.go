@@ -0,0 +1,76 @@
+package inventory_test
+
+import (
+	"atlas-compartment-transfer/inventory"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"atlas-compartment-transfer/rest"
+	"atlas-compartment-transfer/test"
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+)
+
+func processor(t *testing.T) inventory.Processor {
+	t.Helper()
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	te, err := tenant.Create(uuid.New(), "GMS", 83, 1)
+	if err != nil {
+		t.Fatalf("Unable to create tenant: %v", err)
+	}
+	return inventory.NewProcessor(l, tenant.WithContext(context.Background(), te))
+}
+
+func TestCompartmentProvider(t *testing.T) {
+	services := test.NewServices(t)
+	compartmentId := uuid.New()
+	services.SetCompartment(compartment.InventoryTypeCharacter, 1, compartmentId, 2, 4, test.Asset{Id: 5, TemplateId: 2000000}, test.Asset{Id: 6, TemplateId: 2000001})
+
+	c, err := processor(t).CompartmentProvider(compartment.InventoryTypeCharacter, 1, compartmentId)()
+	if err != nil {
+		t.Fatalf("Unable to retrieve compartment: %v", err)
+	}
+	if c.Id() != compartmentId || c.Type() != 2 || c.Capacity() != 4 || c.Assets() != 2 || c.Free() != 2 {
+		t.Fatalf("Retrieved unexpected compartment [%+v].", c)
+	}
+	if !c.Allows(2000002) || c.Allows(1302000) {
+		t.Errorf("Expected a compartment of type [2] to hold only use items.")
+	}
+
+	_, err = processor(t).CompartmentProvider(compartment.InventoryTypeCashShop, 1, compartmentId)()
+	if !errors.Is(err, rest.ErrNotFound) {
+		t.Errorf("Expected a compartment of another inventory to be not found, got [%v].", err)
+	}
+}
+
+func TestAssetProvider(t *testing.T) {
+	services := test.NewServices(t)
+	compartmentId := uuid.New()
+	services.SetCompartment(compartment.InventoryTypeCashShop, 1000, compartmentId, 1, 50, test.Asset{Id: 5, TemplateId: 5000000, ReferenceId: 9})
+
+	a, err := processor(t).AssetProvider(compartment.InventoryTypeCashShop, 1000, compartmentId, 5)()
+	if err != nil {
+		t.Fatalf("Unable to retrieve asset: %v", err)
+	}
+	if a.Id() != 5 || a.TemplateId() != 5000000 || a.ReferenceId() != 9 {
+		t.Fatalf("Retrieved unexpected asset [%+v].", a)
+	}
+
+	services.SetFailing(true)
+	_, err = processor(t).AssetProvider(compartment.InventoryTypeCashShop, 1000, compartmentId, 5)()
+	if err == nil || errors.Is(err, rest.ErrNotFound) {
+		t.Errorf("Expected unavailable services to fail the request, got [%v].", err)
+	}
+}
+
+func TestProcessorRequiresBaseServiceUrl(t *testing.T) {
+	t.Setenv("BASE_SERVICE_URL", "")
+	_, err := processor(t).CompartmentProvider(compartment.InventoryTypeCharacter, 1, uuid.New())()
+	if !errors.Is(err, inventory.ErrNotConfigured) {
+		t.Errorf("Expected lookups to need BASE_SERVICE_URL, got [%v].", err)
+	}
+}
@@ -0,0 +1,42 @@
+package inventory
+
+import (
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"atlas-compartment-transfer/rest"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+// ErrNotConfigured is returned when BASE_SERVICE_URL does not say where the inventory services are
+var ErrNotConfigured = errors.New("inventory services are not configured")
+
+const (
+	characterCompartmentResource = "characters/%d/inventory/compartments/%s"
+	cashShopCompartmentResource  = "accounts/%d/cash-shop/inventory/compartments/%s"
+	assetResource                = "/assets/%d"
+)
+
+// compartmentUrl locates a compartment. Character compartments belong to the character, and cash shop compartments to
+// the account.
+func compartmentUrl(inventoryType string, ownerId uint32, compartmentId uuid.UUID) (string, error) {
+	baseUrl, ok := rest.BaseUrl()
+	if !ok {
+		return "", ErrNotConfigured
+	}
+	switch inventoryType {
+	case compartment.InventoryTypeCharacter:
+		return baseUrl + fmt.Sprintf(characterCompartmentResource, ownerId, compartmentId), nil
+	case compartment.InventoryTypeCashShop:
+		return baseUrl + fmt.Sprintf(cashShopCompartmentResource, ownerId, compartmentId), nil
+	}
+	return "", fmt.Errorf("unsupported inventory type [%s]", inventoryType)
+}
+
+func requestCompartment(url string) rest.Request[CompartmentRestModel] {
+	return rest.MakeGetRequest[CompartmentRestModel](url)
+}
+
+func requestAsset(url string, assetId uint32) rest.Request[AssetRestModel] {
+	return rest.MakeGetRequest[AssetRestModel](url + fmt.Sprintf(assetResource, assetId))
+}
@@ -0,0 +1,84 @@
+package inventory
+
+import (
+	"github.com/google/uuid"
+	"strconv"
+)
+
+// CompartmentRestModel is the JSON:API resource for a compartment, as served by the character and cash shop inventory services
+type CompartmentRestModel struct {
+	Id       uuid.UUID `json:"-"`
+	Type     byte      `json:"type"`
+	Capacity uint32    `json:"capacity"`
+	AssetIds []string  `json:"-"`
+}
+
+func (r CompartmentRestModel) GetName() string {
+	return "compartments"
+}
+
+func (r CompartmentRestModel) GetID() string {
+	return r.Id.String()
+}
+
+func (r *CompartmentRestModel) SetID(strId string) error {
+	id, err := uuid.Parse(strId)
+	if err != nil {
+		return err
+	}
+	r.Id = id
+	return nil
+}
+
+func (r *CompartmentRestModel) SetToManyReferenceIDs(name string, IDs []string) error {
+	if name == "assets" {
+		r.AssetIds = IDs
+	}
+	return nil
+}
+
+// AssetRestModel is the JSON:API resource for an asset held in a compartment
+type AssetRestModel struct {
+	Id          uint32 `json:"-"`
+	TemplateId  uint32 `json:"templateId"`
+	ReferenceId uint32 `json:"referenceId"`
+}
+
+func (r AssetRestModel) GetName() string {
+	return "assets"
+}
+
+func (r AssetRestModel) GetID() string {
+	return strconv.Itoa(int(r.Id))
+}
+
+func (r *AssetRestModel) SetID(strId string) error {
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		return err
+	}
+	r.Id = uint32(id)
+	return nil
+}
+
+// ExtractCompartment creates a compartment of the inventory type from its resource
+func ExtractCompartment(inventoryType string) func(rm CompartmentRestModel) (Compartment, error) {
+	return func(rm CompartmentRestModel) (Compartment, error) {
+		return Compartment{
+			id:              rm.Id,
+			inventoryType:   inventoryType,
+			compartmentType: rm.Type,
+			capacity:        rm.Capacity,
+			assets:          uint32(len(rm.AssetIds)),
+		}, nil
+	}
+}
+
+// ExtractAsset creates an asset from its resource
+func ExtractAsset(rm AssetRestModel) (Asset, error) {
+	return Asset{
+		id:          rm.Id,
+		templateId:  rm.TemplateId,
+		referenceId: rm.ReferenceId,
+	}, nil
+}
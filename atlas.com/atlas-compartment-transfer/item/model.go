@@ -0,0 +1,27 @@
+package item
+
+const (
+	// FlagUntradeable marks items which may not change hands
+	FlagUntradeable = "UNTRADEABLE"
+	// FlagAccountBound marks items which may only move between the characters of one account
+	FlagAccountBound = "ACCOUNT_BOUND"
+	// FlagQuest marks items given, and taken, by quests
+	FlagQuest = "QUEST"
+	// FlagCash marks items bought with cash
+	FlagCash = "CASH"
+)
+
+// Model is the metadata of an item template, as reported by the data service
+type Model struct {
+	templateId uint32
+	flags      []string
+}
+
+func (m Model) TemplateId() uint32 {
+	return m.templateId
+}
+
+// Flags are the flags the template carries
+func (m Model) Flags() []string {
+	return m.flags
+}
@@ -0,0 +1,36 @@
+package item
+
+import (
+	"atlas-compartment-transfer/rest"
+	"context"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Processor defines the interface for looking up item metadata in the data service
+type Processor interface {
+	ByTemplateIdProvider(templateId uint32) model.Provider[Model]
+}
+
+// ProcessorImpl implements the Processor interface
+type ProcessorImpl struct {
+	l   logrus.FieldLogger
+	ctx context.Context
+}
+
+// NewProcessor creates a new processor
+func NewProcessor(l logrus.FieldLogger, ctx context.Context) Processor {
+	return &ProcessorImpl{
+		l:   l,
+		ctx: ctx,
+	}
+}
+
+// ByTemplateIdProvider retrieves the metadata of an item template
+func (p *ProcessorImpl) ByTemplateIdProvider(templateId uint32) model.Provider[Model] {
+	url, err := itemUrl(templateId)
+	if err != nil {
+		return model.ErrorProvider[Model](err)
+	}
+	return rest.Provider[RestModel, Model](p.l, p.ctx)(requestById(url), Extract)
+}
@@ -0,0 +1,61 @@
+package item_test
+
+import (
+	"atlas-compartment-transfer/item"
+	"atlas-compartment-transfer/rest"
+	"atlas-compartment-transfer/test"
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"slices"
+	"testing"
+)
+
+func processor(t *testing.T) item.Processor {
+	t.Helper()
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	te, err := tenant.Create(uuid.New(), "GMS", 83, 1)
+	if err != nil {
+		t.Fatalf("Unable to create tenant: %v", err)
+	}
+	return item.NewProcessor(l, tenant.WithContext(context.Background(), te))
+}
+
+func TestByTemplateIdProvider(t *testing.T) {
+	services := test.NewServices(t)
+	services.SetItem(1302000)
+	services.SetItem(4031000, item.FlagQuest, item.FlagUntradeable)
+
+	i, err := processor(t).ByTemplateIdProvider(4031000)()
+	if err != nil {
+		t.Fatalf("Unable to retrieve item: %v", err)
+	}
+	if i.TemplateId() != 4031000 || len(i.Flags()) != 2 || !slices.Contains(i.Flags(), item.FlagQuest) || !slices.Contains(i.Flags(), item.FlagUntradeable) {
+		t.Fatalf("Retrieved unexpected item [%+v].", i)
+	}
+
+	i, err = processor(t).ByTemplateIdProvider(1302000)()
+	if err != nil {
+		t.Fatalf("Unable to retrieve item: %v", err)
+	}
+	if len(i.Flags()) != 0 {
+		t.Errorf("Expected an item without flags, got %v.", i.Flags())
+	}
+
+	_, err = processor(t).ByTemplateIdProvider(2000000)()
+	if !errors.Is(err, rest.ErrNotFound) {
+		t.Errorf("Expected an unknown item to be not found, got [%v].", err)
+	}
+}
+
+func TestProcessorRequiresBaseServiceUrl(t *testing.T) {
+	t.Setenv("BASE_SERVICE_URL", "")
+	_, err := processor(t).ByTemplateIdProvider(1302000)()
+	if !errors.Is(err, item.ErrNotConfigured) {
+		t.Errorf("Expected lookups to need BASE_SERVICE_URL, got [%v].", err)
+	}
+}
@@ -0,0 +1,24 @@
+package item
+
+import (
+	"atlas-compartment-transfer/rest"
+	"errors"
+	"fmt"
+)
+
+// ErrNotConfigured is returned when BASE_SERVICE_URL does not say where the data service is
+var ErrNotConfigured = errors.New("data service is not configured")
+
+const itemResource = "data/items/%d"
+
+func itemUrl(templateId uint32) (string, error) {
+	baseUrl, ok := rest.BaseUrl()
+	if !ok {
+		return "", ErrNotConfigured
+	}
+	return baseUrl + fmt.Sprintf(itemResource, templateId), nil
+}
+
+func requestById(url string) rest.Request[RestModel] {
+	return rest.MakeGetRequest[RestModel](url)
+}
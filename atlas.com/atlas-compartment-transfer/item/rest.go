@@ -0,0 +1,50 @@
+package item
+
+import "strconv"
+
+// RestModel is the JSON:API resource for the metadata of an item template, as served by the data service
+type RestModel struct {
+	Id              uint32 `json:"-"`
+	TradeBlock      bool   `json:"tradeBlock"`
+	AccountSharable bool   `json:"accountSharable"`
+	Quest           bool   `json:"quest"`
+	Cash            bool   `json:"cash"`
+}
+
+func (r RestModel) GetName() string {
+	return "items"
+}
+
+func (r RestModel) GetID() string {
+	return strconv.Itoa(int(r.Id))
+}
+
+func (r *RestModel) SetID(strId string) error {
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		return err
+	}
+	r.Id = uint32(id)
+	return nil
+}
+
+// Extract creates the metadata of an item template from its resource
+func Extract(rm RestModel) (Model, error) {
+	flags := make([]string, 0)
+	if rm.TradeBlock {
+		flags = append(flags, FlagUntradeable)
+	}
+	if rm.AccountSharable {
+		flags = append(flags, FlagAccountBound)
+	}
+	if rm.Quest {
+		flags = append(flags, FlagQuest)
+	}
+	if rm.Cash {
+		flags = append(flags, FlagCash)
+	}
+	return Model{
+		templateId: rm.Id,
+		flags:      flags,
+	}, nil
+}
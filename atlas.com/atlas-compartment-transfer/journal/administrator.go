@@ -0,0 +1,54 @@
+package journal
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ErrConflict is returned when entries are appended after a sequence which is no longer the end of the journal, because
+// another instance, or another handler, appended to it first
+var ErrConflict = errors.New("journal was appended to concurrently")
+
+// appendEntries writes the entries of a transfer to the journal, in order, as a single batch. Entries are numbered from
+// after the sequence the journal is expected to end at, and the sequence of the last is returned.
+func appendEntries(db *gorm.DB) func(after uint32, ms []Model) (uint32, error) {
+	return func(after uint32, ms []Model) (uint32, error) {
+		if len(ms) == 0 {
+			return after, nil
+		}
+		now := time.Now().UTC()
+		es := make([]Entity, 0, len(ms))
+		for i, m := range ms {
+			es = append(es, Entity{
+				TenantId:           m.Tenant().Id(),
+				TenantRegion:       m.Tenant().Region(),
+				TenantMajorVersion: m.Tenant().MajorVersion(),
+				TenantMinorVersion: m.Tenant().MinorVersion(),
+				TransactionId:      m.TransactionId(),
+				Sequence:           after + uint32(i) + 1,
+				Kind:               string(m.Kind()),
+				State:              m.State(),
+				AccountId:          m.AccountId(),
+				Payload:            string(m.Payload()),
+				CreatedAt:          now,
+			})
+			// Times are compared as they are stored, so they are kept in one zone
+			if !m.Deadline().IsZero() {
+				deadline := m.Deadline().UTC()
+				es[i].Deadline = &deadline
+			}
+		}
+		err := db.Create(&es).Error
+		if err == nil {
+			return after + uint32(len(es)), nil
+		}
+
+		// The unique sequence of each transfer's entries rejects the batch when the sequence was already taken
+		var taken int64
+		if db.Model(&Entity{}).Where(&Entity{TenantId: es[0].TenantId, TransactionId: es[0].TransactionId, Sequence: es[0].Sequence}).Count(&taken).Error == nil && taken > 0 {
+			return after, ErrConflict
+		}
+		return after, err
+	}
+}
@@ -0,0 +1,65 @@
+package journal
+
+import (
+	"encoding/json"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+func Migration(db *gorm.DB) error {
+	err := db.AutoMigrate(&Entity{})
+	if err != nil {
+		return err
+	}
+
+	// Number the entries journaled before entries carried a sequence, in the order they were written
+	err = db.Exec(`UPDATE transfer_journal SET sequence = (
+		SELECT COUNT(*) FROM transfer_journal j
+		WHERE j.tenant_id = transfer_journal.tenant_id AND j.transaction_id = transfer_journal.transaction_id AND j.id <= transfer_journal.id
+	) WHERE sequence = 0`).Error
+	if err != nil {
+		return err
+	}
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_transfer_journal_sequence ON transfer_journal (tenant_id, transaction_id, sequence)").Error
+}
+
+type Entity struct {
+	Id                 uint64     `gorm:"primaryKey;autoIncrement"`
+	TenantId           uuid.UUID  `gorm:"not null;type:uuid;index"`
+	TenantRegion       string     `gorm:"not null"`
+	TenantMajorVersion uint16     `gorm:"not null"`
+	TenantMinorVersion uint16     `gorm:"not null"`
+	TransactionId      uuid.UUID  `gorm:"not null;type:uuid;index"`
+	Sequence           uint32     `gorm:"not null;default:0"`
+	Kind               string     `gorm:"not null"`
+	State              string     `gorm:"index;index:idx_transfer_journal_deadline,priority:1"`
+	AccountId          uint32     `gorm:"not null;default:0;index"`
+	Payload            string     `gorm:"type:text"`
+	CreatedAt          time.Time  `gorm:"not null"`
+	Deadline           *time.Time `gorm:"index:idx_transfer_journal_deadline,priority:2"`
+}
+
+func (e Entity) TableName() string {
+	return "transfer_journal"
+}
+
+func Make(e Entity) (Model, error) {
+	t, err := tenant.Create(e.TenantId, e.TenantRegion, e.TenantMajorVersion, e.TenantMinorVersion)
+	if err != nil {
+		return Model{}, err
+	}
+	b := NewBuilder(t, e.TransactionId, Kind(e.Kind))
+	if e.Deadline != nil {
+		b.SetDeadline(*e.Deadline)
+	}
+	return b.
+		SetId(e.Id).
+		SetSequence(e.Sequence).
+		SetState(e.State).
+		SetAccountId(e.AccountId).
+		SetPayload(json.RawMessage(e.Payload)).
+		SetCreatedAt(e.CreatedAt).
+		Build(), nil
+}
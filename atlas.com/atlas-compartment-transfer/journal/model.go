@@ -0,0 +1,186 @@
+package journal
+
+import (
+	"encoding/json"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Kind identifies the saga transition an entry records
+type Kind string
+
+const (
+	KindCommandReceived Kind = "COMMAND_RECEIVED"
+	KindCommandEmitted  Kind = "COMMAND_EMITTED"
+	KindEventConsumed   Kind = "EVENT_CONSUMED"
+	KindStateChanged    Kind = "STATE_CHANGED"
+	KindSagaStarted     Kind = "SAGA_STARTED"
+)
+
+// CommandEmittedBody is the payload of a COMMAND_EMITTED entry. CorrelationId and CausationId are the headers the command
+// was emitted with. Commands re-issued on startup have no cause.
+type CommandEmittedBody struct {
+	Type          string `json:"type"`
+	InventoryType string `json:"inventoryType"`
+	CorrelationId string `json:"correlationId,omitempty"`
+	CausationId   string `json:"causationId,omitempty"`
+}
+
+// EventConsumedBody is the payload of an EVENT_CONSUMED entry. MessageId identifies the consumed event.
+type EventConsumedBody struct {
+	Type      string `json:"type"`
+	MessageId string `json:"messageId,omitempty"`
+}
+
+// StateChangedBody is the payload of a STATE_CHANGED entry. A rejected transfer records why, and which policy rule
+// denied it, if one did.
+type StateChangedBody struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason,omitempty"`
+	RuleId string `json:"ruleId,omitempty"`
+}
+
+// SagaStartedBody is the payload of a SAGA_STARTED entry. TraceContext carries the span headers of the saga's root span.
+type SagaStartedBody struct {
+	TraceContext map[string]string `json:"traceContext"`
+}
+
+// Model is a single, immutable entry in a transfer's journal
+type Model struct {
+	id            uint64
+	sequence      uint32
+	tenant        tenant.Model
+	transactionId uuid.UUID
+	kind          Kind
+	state         string
+	accountId     uint32
+	payload       json.RawMessage
+	createdAt     time.Time
+	deadline      time.Time
+}
+
+func (m Model) Id() uint64 {
+	return m.id
+}
+
+// Sequence numbers the entry within the journal of its transfer, from 1
+func (m Model) Sequence() uint32 {
+	return m.sequence
+}
+
+func (m Model) Tenant() tenant.Model {
+	return m.tenant
+}
+
+func (m Model) TransactionId() uuid.UUID {
+	return m.transactionId
+}
+
+func (m Model) Kind() Kind {
+	return m.kind
+}
+
+// State is the saga state entered, on STATE_CHANGED entries, or the state the saga waits in, on entries with a deadline
+func (m Model) State() string {
+	return m.state
+}
+
+// AccountId is the account which requested the transfer, and is only set on COMMAND_RECEIVED entries
+func (m Model) AccountId() uint32 {
+	return m.accountId
+}
+
+func (m Model) Payload() json.RawMessage {
+	return m.payload
+}
+
+func (m Model) CreatedAt() time.Time {
+	return m.createdAt
+}
+
+// Deadline is when the saga, waiting in the state of the entry, is to be acted upon should nothing be journaled after
+// the entry. It is only set on the last entry of a step which leaves the saga waiting.
+func (m Model) Deadline() time.Time {
+	return m.deadline
+}
+
+// Await creates a copy of the entry, marking the saga as waiting in the state until the deadline
+func (m Model) Await(state string, deadline time.Time) Model {
+	m.state = state
+	m.deadline = deadline
+	return m
+}
+
+// Builder constructs a Model
+type Builder struct {
+	id            uint64
+	sequence      uint32
+	tenant        tenant.Model
+	transactionId uuid.UUID
+	kind          Kind
+	state         string
+	accountId     uint32
+	payload       json.RawMessage
+	createdAt     time.Time
+	deadline      time.Time
+}
+
+func NewBuilder(t tenant.Model, transactionId uuid.UUID, kind Kind) *Builder {
+	return &Builder{
+		tenant:        t,
+		transactionId: transactionId,
+		kind:          kind,
+	}
+}
+
+func (b *Builder) SetId(id uint64) *Builder {
+	b.id = id
+	return b
+}
+
+func (b *Builder) SetSequence(sequence uint32) *Builder {
+	b.sequence = sequence
+	return b
+}
+
+func (b *Builder) SetState(state string) *Builder {
+	b.state = state
+	return b
+}
+
+func (b *Builder) SetAccountId(accountId uint32) *Builder {
+	b.accountId = accountId
+	return b
+}
+
+func (b *Builder) SetPayload(payload json.RawMessage) *Builder {
+	b.payload = payload
+	return b
+}
+
+func (b *Builder) SetCreatedAt(createdAt time.Time) *Builder {
+	b.createdAt = createdAt
+	return b
+}
+
+func (b *Builder) SetDeadline(deadline time.Time) *Builder {
+	b.deadline = deadline
+	return b
+}
+
+func (b *Builder) Build() Model {
+	return Model{
+		id:            b.id,
+		sequence:      b.sequence,
+		tenant:        b.tenant,
+		transactionId: b.transactionId,
+		kind:          b.kind,
+		state:         b.state,
+		accountId:     b.accountId,
+		payload:       b.payload,
+		createdAt:     b.createdAt,
+		deadline:      b.deadline,
+	}
+}
@@ -0,0 +1,93 @@
+package journal
+
+import (
+	"context"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Processor defines the interface for the journal processor
+type Processor interface {
+	ByTransactionIdProvider(transactionId uuid.UUID) model.Provider[[]Model]
+	Append(after uint32) func(entries ...Model) (uint32, error)
+	InFlightByAccountProvider(accountId uint32) func(terminal ...string) model.Provider[[]Model]
+	InFlightCountByAccountProvider(accountId uint32) func(started []string, terminal []string) model.Provider[int64]
+}
+
+// ProcessorImpl implements the Processor interface
+type ProcessorImpl struct {
+	l   logrus.FieldLogger
+	ctx context.Context
+	db  *gorm.DB
+	t   tenant.Model
+}
+
+// NewProcessor creates a new processor
+func NewProcessor(l logrus.FieldLogger, ctx context.Context, db *gorm.DB) Processor {
+	return &ProcessorImpl{
+		l:   l,
+		ctx: ctx,
+		db:  db,
+		t:   tenant.MustFromContext(ctx),
+	}
+}
+
+// ByTransactionIdProvider retrieves the journal of a transfer, oldest entry first
+func (p *ProcessorImpl) ByTransactionIdProvider(transactionId uuid.UUID) model.Provider[[]Model] {
+	return model.SliceMap(Make)(getByTransactionId(p.t.Id())(transactionId)(p.db.WithContext(p.ctx)))()
+}
+
+// Append adds the entries to the end of the journal of their transfer, which is expected to end at the sequence. Entries
+// are not added, and ErrConflict is returned, when the journal has since grown. It returns the sequence of the last entry.
+func (p *ProcessorImpl) Append(after uint32) func(entries ...Model) (uint32, error) {
+	return func(entries ...Model) (uint32, error) {
+		return appendEntries(p.db.WithContext(p.ctx))(after, entries)
+	}
+}
+
+// InFlightByAccountProvider retrieves the journals of the transfers the account requested which have not entered one of
+// the terminal states. Entries of all transfers are returned together, oldest first. Transfers journaled before entries
+// recorded their account are not retrieved.
+func (p *ProcessorImpl) InFlightByAccountProvider(accountId uint32) func(terminal ...string) model.Provider[[]Model] {
+	return func(terminal ...string) model.Provider[[]Model] {
+		return model.SliceMap(Make)(getInFlightByAccount(p.t.Id(), accountId, terminal)(p.db.WithContext(p.ctx)))()
+	}
+}
+
+// InFlightCountByAccountProvider counts the transfers the account requested which have entered one of the started states,
+// and not one of the terminal states. Transfers journaled before entries recorded their account are not counted.
+func (p *ProcessorImpl) InFlightCountByAccountProvider(accountId uint32) func(started []string, terminal []string) model.Provider[int64] {
+	return func(started []string, terminal []string) model.Provider[int64] {
+		return countInFlightByAccount(p.t.Id(), accountId, started, terminal)(p.db.WithContext(p.ctx))
+	}
+}
+
+// DueProvider retrieves the journals, across every tenant, of transfers which have waited in one of the states until their
+// deadline passed, and which nothing has been journaled for since. Entries of all transfers are returned together,
+// oldest first.
+func DueProvider(ctx context.Context, db *gorm.DB) func(now time.Time, states ...string) model.Provider[[]Model] {
+	return func(now time.Time, states ...string) model.Provider[[]Model] {
+		return model.SliceMap(Make)(getDue(now, states)(db.WithContext(ctx)))()
+	}
+}
+
+// StaleProvider retrieves the journals, across every tenant, of transfers which have not entered one of the terminal
+// states, and which nothing has been journaled for since their deadline passed, or, lacking a deadline, since the time
+// given. Entries of all transfers are returned together, oldest first.
+func StaleProvider(ctx context.Context, db *gorm.DB) func(now time.Time, before time.Time, terminal ...string) model.Provider[[]Model] {
+	return func(now time.Time, before time.Time, terminal ...string) model.Provider[[]Model] {
+		return model.SliceMap(Make)(getStale(now, before, terminal)(db.WithContext(ctx)))()
+	}
+}
+
+// InFlightCountProvider counts the transfers of each tenant which have entered one of the started states, and not one of
+// the terminal states
+func InFlightCountProvider(ctx context.Context, db *gorm.DB) func(started []string, terminal []string) model.Provider[map[uuid.UUID]int64] {
+	return func(started []string, terminal []string) model.Provider[map[uuid.UUID]int64] {
+		return countInFlight(started, terminal)(db.WithContext(ctx))
+	}
+}
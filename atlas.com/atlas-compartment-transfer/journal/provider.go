@@ -0,0 +1,110 @@
+package journal
+
+import (
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+func getByTransactionId(tenantId uuid.UUID) func(transactionId uuid.UUID) func(db *gorm.DB) model.Provider[[]Entity] {
+	return func(transactionId uuid.UUID) func(db *gorm.DB) model.Provider[[]Entity] {
+		return func(db *gorm.DB) model.Provider[[]Entity] {
+			var results []Entity
+			err := db.Where(&Entity{TenantId: tenantId, TransactionId: transactionId}).Order("sequence").Find(&results).Error
+			if err != nil {
+				return model.ErrorProvider[[]Entity](err)
+			}
+			return model.FixedProvider(results)
+		}
+	}
+}
+
+// getStale retrieves the journals, across every tenant, of transfers which have not entered one of the terminal states,
+// and whose last entry either has a deadline which has passed, or has none and was journaled before the time given
+func getStale(now time.Time, before time.Time, terminal []string) func(db *gorm.DB) model.Provider[[]Entity] {
+	return func(db *gorm.DB) model.Provider[[]Entity] {
+		var results []Entity
+		stale := db.Model(&Entity{}).Select("transaction_id").
+			Where("deadline <= ? OR (deadline IS NULL AND created_at <= ?)", now.UTC(), before.UTC()).
+			Where("NOT EXISTS (SELECT 1 FROM transfer_journal l WHERE l.tenant_id = transfer_journal.tenant_id AND l.transaction_id = transfer_journal.transaction_id AND l.sequence > transfer_journal.sequence)")
+		err := db.Where("transaction_id IN (?)", stale).
+			Where("NOT EXISTS (SELECT 1 FROM transfer_journal f WHERE f.tenant_id = transfer_journal.tenant_id AND f.transaction_id = transfer_journal.transaction_id AND f.kind = ? AND f.state IN ?)", string(KindStateChanged), terminal).
+			Order("id").Find(&results).Error
+		if err != nil {
+			return model.ErrorProvider[[]Entity](err)
+		}
+		return model.FixedProvider(results)
+	}
+}
+
+type tenantCount struct {
+	TenantId uuid.UUID
+	Count    int64
+}
+
+// countInFlight counts the transfers of each tenant which have entered one of the started states, and not one of the
+// terminal states
+func countInFlight(started []string, terminal []string) func(db *gorm.DB) model.Provider[map[uuid.UUID]int64] {
+	return func(db *gorm.DB) model.Provider[map[uuid.UUID]int64] {
+		var results []tenantCount
+		err := db.Model(&Entity{}).Select("tenant_id, COUNT(DISTINCT transaction_id) AS count").
+			Where("kind = ? AND state IN ?", string(KindStateChanged), started).
+			Where("NOT EXISTS (SELECT 1 FROM transfer_journal f WHERE f.tenant_id = transfer_journal.tenant_id AND f.transaction_id = transfer_journal.transaction_id AND f.kind = ? AND f.state IN ?)", string(KindStateChanged), terminal).
+			Group("tenant_id").Scan(&results).Error
+		if err != nil {
+			return model.ErrorProvider[map[uuid.UUID]int64](err)
+		}
+		counts := make(map[uuid.UUID]int64)
+		for _, r := range results {
+			counts[r.TenantId] = r.Count
+		}
+		return model.FixedProvider(counts)
+	}
+}
+
+// getDue retrieves the journals, across every tenant, of transfers whose last entry leaves them waiting in one of the
+// states, and whose deadline has passed
+func getDue(now time.Time, states []string) func(db *gorm.DB) model.Provider[[]Entity] {
+	return func(db *gorm.DB) model.Provider[[]Entity] {
+		var results []Entity
+		due := db.Model(&Entity{}).Select("transaction_id").
+			Where("state IN ? AND deadline <= ?", states, now.UTC()).
+			Where("NOT EXISTS (SELECT 1 FROM transfer_journal l WHERE l.tenant_id = transfer_journal.tenant_id AND l.transaction_id = transfer_journal.transaction_id AND l.sequence > transfer_journal.sequence)")
+		err := db.Where("transaction_id IN (?)", due).Order("id").Find(&results).Error
+		if err != nil {
+			return model.ErrorProvider[[]Entity](err)
+		}
+		return model.FixedProvider(results)
+	}
+}
+
+// getInFlightByAccount retrieves the journals of the transfers of an account of the tenant which have not entered one of
+// the terminal states
+func getInFlightByAccount(tenantId uuid.UUID, accountId uint32, terminal []string) func(db *gorm.DB) model.Provider[[]Entity] {
+	return func(db *gorm.DB) model.Provider[[]Entity] {
+		var results []Entity
+		requested := db.Model(&Entity{}).Select("transaction_id").Where("tenant_id = ? AND kind = ? AND account_id = ?", tenantId, string(KindCommandReceived), accountId)
+		finished := db.Model(&Entity{}).Select("transaction_id").Where("tenant_id = ? AND kind = ? AND state IN ?", tenantId, string(KindStateChanged), terminal)
+		err := db.Where("tenant_id = ? AND transaction_id IN (?) AND transaction_id NOT IN (?)", tenantId, requested, finished).Order("id").Find(&results).Error
+		if err != nil {
+			return model.ErrorProvider[[]Entity](err)
+		}
+		return model.FixedProvider(results)
+	}
+}
+
+// countInFlightByAccount counts the transfers of an account of the tenant which have entered one of the started states,
+// and not one of the terminal states
+func countInFlightByAccount(tenantId uuid.UUID, accountId uint32, started []string, terminal []string) func(db *gorm.DB) model.Provider[int64] {
+	return func(db *gorm.DB) model.Provider[int64] {
+		var count int64
+		begun := db.Model(&Entity{}).Select("transaction_id").Where("tenant_id = ? AND kind = ? AND state IN ?", tenantId, string(KindStateChanged), started)
+		finished := db.Model(&Entity{}).Select("transaction_id").Where("tenant_id = ? AND kind = ? AND state IN ?", tenantId, string(KindStateChanged), terminal)
+		err := db.Model(&Entity{}).Where("tenant_id = ? AND kind = ? AND account_id = ? AND transaction_id IN (?) AND transaction_id NOT IN (?)", tenantId, string(KindCommandReceived), accountId, begun, finished).Count(&count).Error
+		if err != nil {
+			return model.ErrorProvider[int64](err)
+		}
+		return model.FixedProvider(count)
+	}
+}
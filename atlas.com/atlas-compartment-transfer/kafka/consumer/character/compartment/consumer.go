@@ -1,13 +1,15 @@
 package compartment
 
 import (
+	"atlas-compartment-transfer/dlq"
 	consumer2 "atlas-compartment-transfer/kafka/consumer"
 	"atlas-compartment-transfer/kafka/message/character/compartment"
+	"atlas-compartment-transfer/kafka/message/envelope"
+	"atlas-compartment-transfer/kafka/producer"
 	"atlas-compartment-transfer/transfer"
 	"context"
 	"github.com/Chronicle20/atlas-kafka/consumer"
 	"github.com/Chronicle20/atlas-kafka/handler"
-	"github.com/Chronicle20/atlas-kafka/message"
 	"github.com/Chronicle20/atlas-kafka/topic"
 	"github.com/Chronicle20/atlas-model/model"
 	"github.com/sirupsen/logrus"
@@ -21,36 +23,46 @@ func InitConsumers(l logrus.FieldLogger) func(func(config consumer.Config, decor
 	}
 }
 
-func InitHandlers(l logrus.FieldLogger) func(rf func(topic string, handler handler.Handler) (string, error)) {
-	return func(rf func(topic string, handler handler.Handler) (string, error)) {
-		var t string
-		t, _ = topic.EnvProvider(l)(compartment.EnvEventTopicStatus)()
-		_, _ = rf(t, message.AdaptHandler(message.PersistentConfig(handleAcceptedEvent)))
-		_, _ = rf(t, message.AdaptHandler(message.PersistentConfig(handleReleasedEvent)))
-		_, _ = rf(t, message.AdaptHandler(message.PersistentConfig(handleErrorEvent)))
+func InitHandlers(l logrus.FieldLogger) func(pf producer.ProviderFactory) func(tf transfer.ProcessorFactory) func(rf func(topic string, handler handler.Handler) (string, error)) {
+	return func(pf producer.ProviderFactory) func(tf transfer.ProcessorFactory) func(rf func(topic string, handler handler.Handler) (string, error)) {
+		return func(tf transfer.ProcessorFactory) func(rf func(topic string, handler handler.Handler) (string, error)) {
+			return func(rf func(topic string, handler handler.Handler) (string, error)) {
+				var t string
+				t, _ = topic.EnvProvider(l)(compartment.EnvEventTopicStatus)()
+				_, _ = rf(t, dlq.AdaptHandler(dlq.EventConfig(handleAcceptedEvent(tf)).SetDecoder(envelope.Decoder[compartment.StatusEvent[compartment.AcceptedEventBody]](compartment.StatusEventVersions)).SetProducer(pf)))
+				_, _ = rf(t, dlq.AdaptHandler(dlq.EventConfig(handleReleasedEvent(tf)).SetDecoder(envelope.Decoder[compartment.StatusEvent[compartment.ReleasedEventBody]](compartment.StatusEventVersions)).SetProducer(pf)))
+				_, _ = rf(t, dlq.AdaptHandler(dlq.EventConfig(handleErrorEvent(tf)).SetDecoder(envelope.Decoder[compartment.StatusEvent[compartment.ErrorEventBody]](compartment.StatusEventVersions)).SetProducer(pf)))
+			}
+		}
 	}
 }
 
-func handleAcceptedEvent(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.AcceptedEventBody]) {
-	if e.Type != compartment.StatusEventTypeAccepted {
-		return
-	}
+func handleAcceptedEvent(tf transfer.ProcessorFactory) func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.AcceptedEventBody]) error {
+	return func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.AcceptedEventBody]) error {
+		if e.Type != compartment.StatusEventTypeAccepted {
+			return nil
+		}
 
-	_ = transfer.NewProcessor(l, ctx).HandleAcceptedAndEmit(e.Body.TransactionId)
+		return tf(l, ctx).HandleAcceptedAndEmit(e.Body.TransactionId)
+	}
 }
 
-func handleReleasedEvent(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.ReleasedEventBody]) {
-	if e.Type != compartment.StatusEventTypeReleased {
-		return
-	}
+func handleReleasedEvent(tf transfer.ProcessorFactory) func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.ReleasedEventBody]) error {
+	return func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.ReleasedEventBody]) error {
+		if e.Type != compartment.StatusEventTypeReleased {
+			return nil
+		}
 
-	_ = transfer.NewProcessor(l, ctx).HandleReleasedAndEmit(e.Body.TransactionId)
+		return tf(l, ctx).HandleReleasedAndEmit(e.Body.TransactionId)
+	}
 }
 
-func handleErrorEvent(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.ErrorEventBody]) {
-	if e.Type != compartment.StatusEventTypeError {
-		return
-	}
+func handleErrorEvent(tf transfer.ProcessorFactory) func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.ErrorEventBody]) error {
+	return func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.ErrorEventBody]) error {
+		if e.Type != compartment.StatusEventTypeError {
+			return nil
+		}
 
-	_ = transfer.NewProcessor(l, ctx).HandleErrorAndEmit(e.Body.TransactionId)
+		return tf(l, ctx).HandleErrorAndEmit(e.Body.TransactionId)
+	}
 }
@@ -1,13 +1,15 @@
 package compartment
 
 import (
+	"atlas-compartment-transfer/dlq"
 	consumer2 "atlas-compartment-transfer/kafka/consumer"
 	"atlas-compartment-transfer/kafka/message/compartment"
+	"atlas-compartment-transfer/kafka/message/envelope"
+	"atlas-compartment-transfer/kafka/producer"
 	"atlas-compartment-transfer/transfer"
 	"context"
 	"github.com/Chronicle20/atlas-kafka/consumer"
 	"github.com/Chronicle20/atlas-kafka/handler"
-	"github.com/Chronicle20/atlas-kafka/message"
 	"github.com/Chronicle20/atlas-kafka/topic"
 	"github.com/Chronicle20/atlas-model/model"
 	"github.com/sirupsen/logrus"
@@ -21,14 +23,20 @@ func InitConsumers(l logrus.FieldLogger) func(func(config consumer.Config, decor
 	}
 }
 
-func InitHandlers(l logrus.FieldLogger) func(rf func(topic string, handler handler.Handler) (string, error)) {
-	return func(rf func(topic string, handler handler.Handler) (string, error)) {
-		var t string
-		t, _ = topic.EnvProvider(l)(compartment.EnvCommandTopicCompartmentTransfer)()
-		_, _ = rf(t, message.AdaptHandler(message.PersistentConfig(handleTransferCommand)))
+func InitHandlers(l logrus.FieldLogger) func(pf producer.ProviderFactory) func(tf transfer.ProcessorFactory) func(rf func(topic string, handler handler.Handler) (string, error)) {
+	return func(pf producer.ProviderFactory) func(tf transfer.ProcessorFactory) func(rf func(topic string, handler handler.Handler) (string, error)) {
+		return func(tf transfer.ProcessorFactory) func(rf func(topic string, handler handler.Handler) (string, error)) {
+			return func(rf func(topic string, handler handler.Handler) (string, error)) {
+				var t string
+				t, _ = topic.EnvProvider(l)(compartment.EnvCommandTopicCompartmentTransfer)()
+				_, _ = rf(t, dlq.AdaptHandler(dlq.CommandConfig(handleTransferCommand(tf)).SetDecoder(envelope.Decoder[compartment.TransferCommand](compartment.TransferCommandVersions)).SetProducer(pf)))
+			}
+		}
 	}
 }
 
-func handleTransferCommand(l logrus.FieldLogger, ctx context.Context, e compartment.TransferCommand) {
-	_ = transfer.NewProcessor(l, ctx).ProcessAndEmit(e)
+func handleTransferCommand(tf transfer.ProcessorFactory) func(l logrus.FieldLogger, ctx context.Context, e compartment.TransferCommand) error {
+	return func(l logrus.FieldLogger, ctx context.Context, e compartment.TransferCommand) error {
+		return tf(l, ctx).ProcessAndEmit(e)
+	}
 }
@@ -0,0 +1,68 @@
+package compartment
+
+import (
+	"atlas-compartment-transfer/dlq"
+	consumer2 "atlas-compartment-transfer/kafka/consumer"
+	"atlas-compartment-transfer/kafka/message/currency/compartment"
+	"atlas-compartment-transfer/kafka/message/envelope"
+	"atlas-compartment-transfer/kafka/producer"
+	"atlas-compartment-transfer/transfer"
+	"context"
+	"github.com/Chronicle20/atlas-kafka/consumer"
+	"github.com/Chronicle20/atlas-kafka/handler"
+	"github.com/Chronicle20/atlas-kafka/topic"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/sirupsen/logrus"
+)
+
+func InitConsumers(l logrus.FieldLogger) func(func(config consumer.Config, decorators ...model.Decorator[consumer.Config])) func(consumerGroupId string) {
+	return func(rf func(config consumer.Config, decorators ...model.Decorator[consumer.Config])) func(consumerGroupId string) {
+		return func(consumerGroupId string) {
+			rf(consumer2.NewConfig(l)("currency_compartment_status_event")(compartment.EnvEventTopicStatus)(consumerGroupId), consumer.SetHeaderParsers(consumer.SpanHeaderParser, consumer.TenantHeaderParser))
+		}
+	}
+}
+
+func InitHandlers(l logrus.FieldLogger) func(pf producer.ProviderFactory) func(tf transfer.ProcessorFactory) func(rf func(topic string, handler handler.Handler) (string, error)) {
+	return func(pf producer.ProviderFactory) func(tf transfer.ProcessorFactory) func(rf func(topic string, handler handler.Handler) (string, error)) {
+		return func(tf transfer.ProcessorFactory) func(rf func(topic string, handler handler.Handler) (string, error)) {
+			return func(rf func(topic string, handler handler.Handler) (string, error)) {
+				var t string
+				t, _ = topic.EnvProvider(l)(compartment.EnvEventTopicStatus)()
+				_, _ = rf(t, dlq.AdaptHandler(dlq.EventConfig(handleDebitedEvent(tf)).SetDecoder(envelope.Decoder[compartment.StatusEvent[compartment.StatusEventDebitedBody]](compartment.StatusEventVersions)).SetProducer(pf)))
+				_, _ = rf(t, dlq.AdaptHandler(dlq.EventConfig(handleCreditedEvent(tf)).SetDecoder(envelope.Decoder[compartment.StatusEvent[compartment.StatusEventCreditedBody]](compartment.StatusEventVersions)).SetProducer(pf)))
+				_, _ = rf(t, dlq.AdaptHandler(dlq.EventConfig(handleErrorEvent(tf)).SetDecoder(envelope.Decoder[compartment.StatusEvent[compartment.StatusEventErrorBody]](compartment.StatusEventVersions)).SetProducer(pf)))
+			}
+		}
+	}
+}
+
+func handleDebitedEvent(tf transfer.ProcessorFactory) func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.StatusEventDebitedBody]) error {
+	return func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.StatusEventDebitedBody]) error {
+		if e.Type != compartment.StatusEventTypeDebited {
+			return nil
+		}
+
+		return tf(l, ctx).HandleDebitedAndEmit(e.Body.TransactionId)
+	}
+}
+
+func handleCreditedEvent(tf transfer.ProcessorFactory) func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.StatusEventCreditedBody]) error {
+	return func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.StatusEventCreditedBody]) error {
+		if e.Type != compartment.StatusEventTypeCredited {
+			return nil
+		}
+
+		return tf(l, ctx).HandleCreditedAndEmit(e.Body.TransactionId)
+	}
+}
+
+func handleErrorEvent(tf transfer.ProcessorFactory) func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.StatusEventErrorBody]) error {
+	return func(l logrus.FieldLogger, ctx context.Context, e compartment.StatusEvent[compartment.StatusEventErrorBody]) error {
+		if e.Type != compartment.StatusEventTypeError {
+			return nil
+		}
+
+		return tf(l, ctx).HandleErrorAndEmit(e.Body.TransactionId)
+	}
+}
@@ -0,0 +1,41 @@
+package dlq
+
+import (
+	"atlas-compartment-transfer/dlq"
+	consumer2 "atlas-compartment-transfer/kafka/consumer"
+	dlq2 "atlas-compartment-transfer/kafka/message/dlq"
+	"context"
+	"github.com/Chronicle20/atlas-kafka/consumer"
+	"github.com/Chronicle20/atlas-kafka/handler"
+	"github.com/Chronicle20/atlas-kafka/message"
+	"github.com/Chronicle20/atlas-kafka/topic"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/sirupsen/logrus"
+)
+
+func InitConsumers(l logrus.FieldLogger) func(func(config consumer.Config, decorators ...model.Decorator[consumer.Config])) func(consumerGroupId string) {
+	return func(rf func(config consumer.Config, decorators ...model.Decorator[consumer.Config])) func(consumerGroupId string) {
+		return func(consumerGroupId string) {
+			rf(consumer2.NewConfig(l)("compartment_transfer_dlq_command")(dlq2.EnvCommandTopic)(consumerGroupId), consumer.SetHeaderParsers(consumer.SpanHeaderParser, consumer.TenantHeaderParser))
+		}
+	}
+}
+
+func InitHandlers(l logrus.FieldLogger) func(rf func(topic string, handler handler.Handler) (string, error)) {
+	return func(rf func(topic string, handler handler.Handler) (string, error)) {
+		var t string
+		t, _ = topic.EnvProvider(l)(dlq2.EnvCommandTopic)()
+		_, _ = rf(t, message.AdaptHandler(message.PersistentConfig(handleReplayCommand)))
+	}
+}
+
+func handleReplayCommand(l logrus.FieldLogger, ctx context.Context, c dlq2.Command[dlq2.ReplayCommandBody]) {
+	if c.Type != dlq2.CommandReplay {
+		return
+	}
+
+	err := dlq.NewProcessor(l, ctx).Replay(c.Body.Entry)
+	if err != nil {
+		l.WithError(err).Errorf("Replay of dead-letter entry from topic [%s] partition [%d] offset [%d] failed.", c.Body.Entry.SourceTopic, c.Body.Entry.Partition, c.Body.Entry.Offset)
+	}
+}
@@ -9,6 +9,7 @@ const (
 )
 
 type Command[E any] struct {
+	Version         int    `json:"version"`
 	AccountId       uint32 `json:"accountId"`
 	CompartmentType byte   `json:"compartmentType"`
 	Type            string `json:"type"`
@@ -24,7 +25,7 @@ type AcceptCommandBody struct {
 type ReleaseCommandBody struct {
 	TransactionId uuid.UUID `json:"transactionId"`
 	CompartmentId uuid.UUID `json:"compartmentId"`
-	AssetId       uint32    `json:"assetId"`
+	AssetId       uint32    `json:"assetId" description:"Reference id of the asset. Named assetId on the wire for compatibility."`
 }
 
 const (
@@ -37,6 +38,7 @@ const (
 // StatusEvent represents a cash compartment status event
 // According to the requirements, it should always contain the compartmentId and type
 type StatusEvent[E any] struct {
+	Version         int       `json:"version"`
 	CompartmentId   uuid.UUID `json:"compartmentId"`
 	CompartmentType byte      `json:"compartmentType"`
 	Type            string    `json:"type"`
@@ -9,6 +9,7 @@ const (
 )
 
 type Command[E any] struct {
+	Version       int    `json:"version"`
 	CharacterId   uint32 `json:"characterId"`
 	InventoryType byte   `json:"inventoryType"`
 	Type          string `json:"type"`
@@ -22,7 +23,7 @@ type AcceptCommandBody struct {
 
 type ReleaseCommandBody struct {
 	TransactionId uuid.UUID `json:"transactionId"`
-	AssetId       uint32    `json:"assetId"`
+	AssetId       uint32    `json:"assetId" description:"Reference id of the asset. Named assetId on the wire for compatibility."`
 }
 
 const (
@@ -36,6 +37,7 @@ const (
 )
 
 type StatusEvent[E any] struct {
+	Version       int       `json:"version"`
 	CharacterId   uint32    `json:"characterId"`
 	CompartmentId uuid.UUID `json:"compartmentId"`
 	Type          string    `json:"type"`
@@ -0,0 +1,13 @@
+package compartment
+
+import "atlas-compartment-transfer/kafka/message/envelope"
+
+// CommandVersions converts compartment commands between versions. Version 2 added the version field.
+var CommandVersions = envelope.NewRegistry(2).
+	SetUpcaster(1, envelope.Unchanged).
+	SetDowncaster(1, envelope.Unchanged)
+
+// StatusEventVersions converts compartment status events between versions. Version 2 added the version field.
+var StatusEventVersions = envelope.NewRegistry(2).
+	SetUpcaster(1, envelope.Unchanged).
+	SetDowncaster(1, envelope.Unchanged)
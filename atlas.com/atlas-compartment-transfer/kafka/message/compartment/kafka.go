@@ -2,6 +2,7 @@ package compartment
 
 import (
 	"github.com/google/uuid"
+	"time"
 )
 
 const (
@@ -11,32 +12,53 @@ const (
 )
 
 type TransferCommand struct {
-	TransactionId       uuid.UUID `json:"transactionId"`
-	AccountId           uint32    `json:"accountId"`
-	CharacterId         uint32    `json:"characterId"`
-	AssetId             uint32    `json:"assetId"`
-	FromCompartmentId   uuid.UUID `json:"fromCompartmentId"`
-	FromCompartmentType byte      `json:"fromCompartmentType"`
-	FromInventoryType   string    `json:"fromInventoryType"`
-	ToCompartmentId     uuid.UUID `json:"toCompartmentId"`
-	ToCompartmentType   byte      `json:"toCompartmentType"`
-	ToInventoryType     string    `json:"toInventoryType"`
-	ReferenceId         uint32    `json:"referenceId"`
+	Version             int        `json:"version"`
+	TransactionId       uuid.UUID  `json:"transactionId"`
+	AccountId           uint32     `json:"accountId"`
+	CharacterId         uint32     `json:"characterId"`
+	AssetId             uint32     `json:"assetId"`
+	FromCompartmentId   uuid.UUID  `json:"fromCompartmentId"`
+	FromCompartmentType byte       `json:"fromCompartmentType"`
+	FromInventoryType   string     `json:"fromInventoryType"`
+	ToCompartmentId     uuid.UUID  `json:"toCompartmentId"`
+	ToCompartmentType   byte       `json:"toCompartmentType"`
+	ToInventoryType     string     `json:"toInventoryType"`
+	ReferenceId         uint32     `json:"referenceId"`
+	Fee                 *Fee       `json:"fee,omitempty"`
+	DeliverAt           *time.Time `json:"deliverAt,omitempty"`
+}
+
+// Fee is charged for a transfer, in the currency held by a currency compartment of the account
+type Fee struct {
+	Amount        uint32    `json:"amount"`
+	CompartmentId uuid.UUID `json:"compartmentId"`
 }
 
 const (
 	EnvEventTopicStatus      = "EVENT_TOPIC_COMPARTMENT_TRANSFER_STATUS"
 	StatusEventTypeCompleted = "COMPLETED"
+	StatusEventTypeRejected  = "REJECTED"
+
+	RejectedReasonCharacterNotOwned  = "CHARACTER_NOT_OWNED"
+	RejectedReasonAssetNotHeld       = "ASSET_NOT_HELD"
+	RejectedReasonNoCapacity         = "NO_CAPACITY"
+	RejectedReasonAssetNotAllowed    = "ASSET_NOT_ALLOWED"
+	RejectedReasonUnknownDestination = "UNKNOWN_DESTINATION"
+	RejectedReasonPolicyDenied       = "POLICY_DENIED"
+	RejectedReasonRouteNotEnabled    = "ROUTE_NOT_ENABLED"
+	RejectedReasonRateLimited        = "RATE_LIMITED"
 )
 
 // StatusEvent represents a compartment transfer status event
 type StatusEvent[E any] struct {
+	Version     int    `json:"version"`
 	CharacterId uint32 `json:"characterId"`
 	Type        string `json:"type"`
 	Body        E      `json:"body"`
 }
 
-// StatusEventCompletedBody represents the body of a COMPLETED status event
+// StatusEventCompletedBody represents the body of a COMPLETED status event. Fee is the fee the transfer was charged, if
+// any.
 type StatusEventCompletedBody struct {
 	TransactionId   uuid.UUID `json:"transactionId"`
 	AccountId       uint32    `json:"accountId"`
@@ -44,4 +66,15 @@ type StatusEventCompletedBody struct {
 	CompartmentId   uuid.UUID `json:"compartmentId"`
 	CompartmentType byte      `json:"compartmentType"`
 	InventoryType   string    `json:"inventoryType"`
+	Fee             *Fee      `json:"fee,omitempty"`
+}
+
+// StatusEventRejectedBody represents the body of a REJECTED status event, emitted for a transfer refused before any
+// compartment was asked to act. RuleId identifies the policy rule which denied a transfer rejected as POLICY_DENIED.
+type StatusEventRejectedBody struct {
+	TransactionId uuid.UUID `json:"transactionId"`
+	AccountId     uint32    `json:"accountId"`
+	AssetId       uint32    `json:"assetId"`
+	Reason        string    `json:"reason"`
+	RuleId        string    `json:"ruleId,omitempty"`
 }
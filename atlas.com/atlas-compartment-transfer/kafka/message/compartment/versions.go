@@ -0,0 +1,93 @@
+package compartment
+
+import (
+	"atlas-compartment-transfer/kafka/message/envelope"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// TransferCommandVersions converts transfer commands between versions. Version 2 added the version field, version 3
+// added the fee, and version 4 added the time the transfer is to be delivered at.
+var TransferCommandVersions = envelope.NewRegistry(4).
+	SetUpcaster(1, envelope.Unchanged).
+	SetDowncaster(1, envelope.Unchanged).
+	SetUpcaster(2, envelope.Unchanged).
+	SetDowncaster(2, withoutFee).
+	SetUpcaster(3, envelope.Unchanged).
+	SetDowncaster(3, withoutDeliverAt)
+
+// StatusEventVersions converts transfer status events between versions. Version 2 added the version field, version 3
+// added the id of the policy rule which rejected a transfer, and version 4 added the fee a completed transfer was charged.
+var StatusEventVersions = envelope.NewRegistry(4).
+	SetUpcaster(1, envelope.Unchanged).
+	SetDowncaster(1, envelope.Unchanged).
+	SetUpcaster(2, envelope.Unchanged).
+	SetDowncaster(2, withoutRuleId).
+	SetUpcaster(3, envelope.Unchanged).
+	SetDowncaster(3, withoutFeeCharged)
+
+// withoutFee removes the fee from a transfer command, which consumers of version 2 do not know. A command charging a fee
+// cannot be downcast, as consumers of version 2 would move the asset for free.
+func withoutFee(fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	if raw, ok := fields["fee"]; ok {
+		var fee *Fee
+		err := json.Unmarshal(raw, &fee)
+		if err != nil {
+			return nil, err
+		}
+		if fee != nil && fee.Amount > 0 {
+			return nil, fmt.Errorf("%w: transfer command charges a fee", envelope.ErrNotDowncastable)
+		}
+	}
+	delete(fields, "fee")
+	return fields, nil
+}
+
+// withoutDeliverAt removes the delivery time from a transfer command, which consumers of version 3 do not know. A
+// command with a delivery time cannot be downcast, as consumers of version 3 would start the transfer at once.
+func withoutDeliverAt(fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	if raw, ok := fields["deliverAt"]; ok {
+		var deliverAt *time.Time
+		err := json.Unmarshal(raw, &deliverAt)
+		if err != nil {
+			return nil, err
+		}
+		if deliverAt != nil && !deliverAt.IsZero() {
+			return nil, fmt.Errorf("%w: transfer command is scheduled", envelope.ErrNotDowncastable)
+		}
+	}
+	delete(fields, "deliverAt")
+	return fields, nil
+}
+
+// withoutRuleId removes the rule id from the body of a REJECTED status event, which consumers of version 2 do not know
+func withoutRuleId(fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	return withoutBodyField(fields, "ruleId")
+}
+
+// withoutFeeCharged removes the fee from the body of a COMPLETED status event, which consumers of version 3 do not know
+func withoutFeeCharged(fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	return withoutBodyField(fields, "fee")
+}
+
+func withoutBodyField(fields map[string]json.RawMessage, name string) (map[string]json.RawMessage, error) {
+	raw, ok := fields["body"]
+	if !ok {
+		return fields, nil
+	}
+	var body map[string]json.RawMessage
+	err := json.Unmarshal(raw, &body)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok = body[name]; !ok {
+		return fields, nil
+	}
+	delete(body, name)
+	fields["body"], err = json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,50 @@
+package compartment
+
+import (
+	"atlas-compartment-transfer/kafka/message/envelope"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestTransferCommandDowncast(t *testing.T) {
+	encode := func(cmd TransferCommand) []byte {
+		cmd.Version = TransferCommandVersions.Current()
+		b, err := json.Marshal(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	free := encode(TransferCommand{TransactionId: uuid.New(), Fee: &Fee{Amount: 0, CompartmentId: uuid.New()}})
+	out, err := TransferCommandVersions.Downcast(free, 2)
+	if err != nil {
+		t.Fatalf("Unable to downcast a command without a fee to [2]: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(out, &fields); err != nil || fields["fee"] != nil {
+		t.Errorf("Expected the fee to be removed, got %s.", out)
+	}
+
+	paid := encode(TransferCommand{TransactionId: uuid.New(), Fee: &Fee{Amount: 100, CompartmentId: uuid.New()}})
+	if _, err = TransferCommandVersions.Downcast(paid, 3); err != nil {
+		t.Errorf("Unable to downcast a command charging a fee to [3]: %v", err)
+	}
+	for _, to := range []int{1, 2} {
+		if _, err = TransferCommandVersions.Downcast(paid, to); !errors.Is(err, envelope.ErrNotDowncastable) {
+			t.Errorf("Expected a command charging a fee not to be downcast to [%d], got %v.", to, err)
+		}
+	}
+
+	deliverAt := time.Now().Add(time.Hour)
+	scheduled := encode(TransferCommand{TransactionId: uuid.New(), DeliverAt: &deliverAt})
+	if _, err = TransferCommandVersions.Downcast(scheduled, 3); !errors.Is(err, envelope.ErrNotDowncastable) {
+		t.Errorf("Expected a scheduled command not to be downcast to [3], got %v.", err)
+	}
+	if _, err = TransferCommandVersions.Downcast(encode(TransferCommand{TransactionId: uuid.New()}), 3); err != nil {
+		t.Errorf("Unable to downcast a command without a delivery time to [3]: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package compartment
+
+import "github.com/google/uuid"
+
+const (
+	EnvCommandTopic = "COMMAND_TOPIC_CURRENCY_COMPARTMENT"
+	CommandDebit    = "DEBIT"
+	CommandCredit   = "CREDIT"
+)
+
+// Command represents a command to the currency compartment of an account, which holds the currency fees are charged in
+type Command[E any] struct {
+	Version       int       `json:"version"`
+	AccountId     uint32    `json:"accountId"`
+	CharacterId   uint32    `json:"characterId"`
+	CompartmentId uuid.UUID `json:"compartmentId"`
+	Type          string    `json:"type"`
+	Body          E         `json:"body"`
+}
+
+type DebitCommandBody struct {
+	TransactionId uuid.UUID `json:"transactionId"`
+	Amount        uint32    `json:"amount"`
+}
+
+type CreditCommandBody struct {
+	TransactionId uuid.UUID `json:"transactionId"`
+	Amount        uint32    `json:"amount"`
+}
+
+const (
+	EnvEventTopicStatus     = "EVENT_TOPIC_CURRENCY_COMPARTMENT_STATUS"
+	StatusEventTypeDebited  = "DEBITED"
+	StatusEventTypeCredited = "CREDITED"
+	StatusEventTypeError    = "ERROR"
+)
+
+// StatusEvent represents a currency compartment status event
+type StatusEvent[E any] struct {
+	Version       int       `json:"version"`
+	AccountId     uint32    `json:"accountId"`
+	CompartmentId uuid.UUID `json:"compartmentId"`
+	Type          string    `json:"type"`
+	Body          E         `json:"body"`
+}
+
+type StatusEventDebitedBody struct {
+	TransactionId uuid.UUID `json:"transactionId"`
+	Amount        uint32    `json:"amount"`
+}
+
+type StatusEventCreditedBody struct {
+	TransactionId uuid.UUID `json:"transactionId"`
+	Amount        uint32    `json:"amount"`
+}
+
+type StatusEventErrorBody struct {
+	ErrorCode     string    `json:"errorCode"`
+	TransactionId uuid.UUID `json:"transactionId"`
+}
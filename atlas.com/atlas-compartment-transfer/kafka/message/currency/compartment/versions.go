@@ -0,0 +1,11 @@
+package compartment
+
+import "atlas-compartment-transfer/kafka/message/envelope"
+
+// CommandVersions converts currency compartment commands between versions. The commands were introduced at version 2,
+// the first to carry the version field, so there are no earlier versions to convert.
+var CommandVersions = envelope.NewRegistry(2)
+
+// StatusEventVersions converts currency compartment status events between versions. The events were introduced at
+// version 2, the first to carry the version field, so there are no earlier versions to convert.
+var StatusEventVersions = envelope.NewRegistry(2)
@@ -0,0 +1,77 @@
+package dlq
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+const (
+	EnvTopic                 = "DLQ_TOPIC_COMPARTMENT_TRANSFER"
+	ReasonMalformed          = "MALFORMED"
+	ReasonInvalidCommand     = "INVALID_COMMAND"
+	ReasonUnknownTransaction = "UNKNOWN_TRANSACTION"
+	ReasonProcessingFailed   = "PROCESSING_FAILED"
+)
+
+// Entry represents a message which could not be processed, as written to the dead-letter topic
+type Entry struct {
+	SourceTopic string    `json:"sourceTopic"`
+	Partition   int       `json:"partition"`
+	Offset      int64     `json:"offset"`
+	Key         []byte    `json:"key"`
+	Payload     []byte    `json:"payload"`
+	Headers     Headers   `json:"headers"`
+	Reason      string    `json:"reason"`
+	Error       string    `json:"error"`
+	FailedAt    time.Time `json:"failedAt"`
+}
+
+// Header is a header of the original message
+type Header struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Headers are the headers of the original message, in the order they were written. Kafka allows a key to repeat, so
+// they are kept as a list rather than keyed.
+type Headers []Header
+
+// UnmarshalJSON reads headers written as a list, as well as those written as an object by version 1 of the entry
+func (h *Headers) UnmarshalJSON(data []byte) error {
+	var hs []Header
+	if err := json.Unmarshal(data, &hs); err == nil {
+		*h = hs
+		return nil
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	hs = make([]Header, 0, len(m))
+	for k, v := range m {
+		hs = append(hs, Header{Key: k, Value: v})
+	}
+	sort.Slice(hs, func(i, j int) bool {
+		return hs[i].Key < hs[j].Key
+	})
+	*h = hs
+	return nil
+}
+
+const (
+	EnvCommandTopic = "COMMAND_TOPIC_COMPARTMENT_TRANSFER_DLQ"
+	CommandReplay   = "REPLAY"
+)
+
+// Command represents a dead-letter command
+type Command[E any] struct {
+	Type string `json:"type"`
+	Body E      `json:"body"`
+}
+
+// ReplayCommandBody carries the dead-letter entry to feed back through the normal handlers
+type ReplayCommandBody struct {
+	Entry Entry `json:"entry"`
+}
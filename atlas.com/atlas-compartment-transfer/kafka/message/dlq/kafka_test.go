@@ -0,0 +1,37 @@
+package dlq_test
+
+import (
+	"atlas-compartment-transfer/kafka/message/dlq"
+	"encoding/json"
+	"testing"
+)
+
+func TestEntryHeaders(t *testing.T) {
+	t.Run("repeated keys are kept in order", func(t *testing.T) {
+		e := dlq.Entry{Headers: dlq.Headers{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}, {Key: "a", Value: "3"}}}
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Fatalf("Unable to encode entry: %v", err)
+		}
+		var got dlq.Entry
+		err = json.Unmarshal(b, &got)
+		if err != nil {
+			t.Fatalf("Unable to decode entry: %v", err)
+		}
+		if len(got.Headers) != 3 || got.Headers[0] != e.Headers[0] || got.Headers[1] != e.Headers[1] || got.Headers[2] != e.Headers[2] {
+			t.Errorf("Expected headers %v, got %v.", e.Headers, got.Headers)
+		}
+	})
+
+	t.Run("headers of version 1 are read", func(t *testing.T) {
+		var got dlq.Entry
+		err := json.Unmarshal([]byte(`{"headers":{"b":"2","a":"1"}}`), &got)
+		if err != nil {
+			t.Fatalf("Unable to decode entry: %v", err)
+		}
+		expected := dlq.Headers{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}
+		if len(got.Headers) != 2 || got.Headers[0] != expected[0] || got.Headers[1] != expected[1] {
+			t.Errorf("Expected headers %v, got %v.", expected, got.Headers)
+		}
+	})
+}
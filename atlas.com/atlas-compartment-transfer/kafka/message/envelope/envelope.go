@@ -0,0 +1,193 @@
+package envelope
+
+import (
+	"atlas-compartment-transfer/settings"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/segmentio/kafka-go"
+	"strconv"
+)
+
+const (
+	// VersionKey is the envelope field carrying the version of a message
+	VersionKey = "version"
+	// LegacyVersion is the version of messages which predate the version field
+	LegacyVersion = 1
+)
+
+// ErrUnknownVersion is returned for a message whose version cannot be converted to, or from, the current version
+var ErrUnknownVersion = errors.New("unknown message version")
+
+// ErrNotDowncastable is returned for a message carrying a field which an earlier version cannot express, and without
+// which the message would ask for something else
+var ErrNotDowncastable = errors.New("message cannot be written at an earlier version")
+
+// Converter reshapes the fields of a message between adjacent versions
+type Converter func(fields map[string]json.RawMessage) (map[string]json.RawMessage, error)
+
+// Unchanged is the Converter between versions which differ only in their version field
+func Unchanged(fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	return fields, nil
+}
+
+// Registry holds the conversions between the versions of a family of messages
+type Registry struct {
+	current     int
+	upcasters   map[int]Converter
+	downcasters map[int]Converter
+}
+
+// NewRegistry creates a registry for messages whose in-memory type is at the current version
+func NewRegistry(current int) *Registry {
+	return &Registry{
+		current:     current,
+		upcasters:   make(map[int]Converter),
+		downcasters: make(map[int]Converter),
+	}
+}
+
+// SetUpcaster sets the conversion from a version to the next
+func (r *Registry) SetUpcaster(from int, c Converter) *Registry {
+	r.upcasters[from] = c
+	return r
+}
+
+// SetDowncaster sets the conversion from the next version to a version
+func (r *Registry) SetDowncaster(to int, c Converter) *Registry {
+	r.downcasters[to] = c
+	return r
+}
+
+// Current is the version of the in-memory type of the messages
+func (r *Registry) Current() int {
+	return r.current
+}
+
+// Upcast converts a message written at any known version to the current version
+func (r *Registry) Upcast(data []byte) ([]byte, error) {
+	fields, version, err := read(data)
+	if err != nil {
+		return nil, err
+	}
+	if version < LegacyVersion || version > r.current {
+		return nil, fmt.Errorf("%w: [%d]", ErrUnknownVersion, version)
+	}
+	for ; version < r.current; version++ {
+		c, ok := r.upcasters[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: no upcaster from [%d]", ErrUnknownVersion, version)
+		}
+		fields, err = c(fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return write(fields, version)
+}
+
+// Downcast converts a message of the current in-memory type to an earlier version
+func (r *Registry) Downcast(data []byte, to int) ([]byte, error) {
+	if to < LegacyVersion || to > r.current {
+		return nil, fmt.Errorf("%w: [%d]", ErrUnknownVersion, to)
+	}
+	fields, _, err := read(data)
+	if err != nil {
+		return nil, err
+	}
+	version := r.current
+	for version > to {
+		version--
+		c, ok := r.downcasters[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: no downcaster to [%d]", ErrUnknownVersion, version)
+		}
+		fields, err = c(fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return write(fields, version)
+}
+
+func read(data []byte) (map[string]json.RawMessage, int, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, 0, err
+	}
+	version := 0
+	if v, ok := fields[VersionKey]; ok {
+		err = json.Unmarshal(v, &version)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	// Producers which declare the field without setting it are writing the legacy version
+	if version == 0 {
+		version = LegacyVersion
+	}
+	return fields, version, nil
+}
+
+// write renders the fields at the version. Legacy messages carry no version field.
+func write(fields map[string]json.RawMessage, version int) ([]byte, error) {
+	delete(fields, VersionKey)
+	if version > LegacyVersion {
+		fields[VersionKey] = json.RawMessage(strconv.Itoa(version))
+	}
+	return json.Marshal(fields)
+}
+
+// Decoder decodes a message written at any known version into its current in-memory type
+func Decoder[M any](r *Registry) func(data []byte) (M, error) {
+	return func(data []byte) (M, error) {
+		var m M
+		b, err := r.Upcast(data)
+		if err != nil {
+			return m, err
+		}
+		err = json.Unmarshal(b, &m)
+		return m, err
+	}
+}
+
+// MessageProvider creates a provider for a single message, written at the version configured for the topic token
+func MessageProvider(r *Registry) func(token string) func(key []byte, value interface{}) model.Provider[[]kafka.Message] {
+	return func(token string) func(key []byte, value interface{}) model.Provider[[]kafka.Message] {
+		return func(key []byte, value interface{}) model.Provider[[]kafka.Message] {
+			return func() ([]kafka.Message, error) {
+				b, err := json.Marshal(value)
+				if err != nil {
+					return nil, err
+				}
+				version, err := ConfiguredVersion(r)(token)
+				if err != nil {
+					return nil, err
+				}
+				b, err = r.Downcast(b, version)
+				if err != nil {
+					return nil, err
+				}
+				return []kafka.Message{{Key: key, Value: b}}, nil
+			}
+		}
+	}
+}
+
+// ConfiguredVersion reads the version messages are written to a topic at from <token>_VERSION, defaulting to the
+// current version. Pinning an earlier version lets consumers which have not been upgraded keep reading the topic.
+func ConfiguredVersion(r *Registry) func(token string) (int, error) {
+	return func(token string) (int, error) {
+		v, ok := settings.Lookup(token + "_VERSION")
+		if !ok {
+			return r.current, nil
+		}
+		version, err := strconv.Atoi(v)
+		if err != nil || version < LegacyVersion || version > r.current {
+			return 0, fmt.Errorf("%w: [%s_VERSION] is [%s]", ErrUnknownVersion, token, v)
+		}
+		return version, nil
+	}
+}
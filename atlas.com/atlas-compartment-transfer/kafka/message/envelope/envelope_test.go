@@ -0,0 +1,103 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type message struct {
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+}
+
+// testRegistry renamed the field "label" to "name" in version 2, and added "color" in version 3
+func testRegistry() *Registry {
+	return NewRegistry(3).
+		SetUpcaster(1, func(fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+			fields["name"] = fields["label"]
+			delete(fields, "label")
+			return fields, nil
+		}).
+		SetDowncaster(1, func(fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+			fields["label"] = fields["name"]
+			delete(fields, "name")
+			return fields, nil
+		}).
+		SetUpcaster(2, Unchanged).
+		SetDowncaster(2, Unchanged)
+}
+
+func TestDecoderUpcastsEarlierVersions(t *testing.T) {
+	d := Decoder[message](testRegistry())
+	for _, data := range []string{
+		`{"type":"A","label":"x"}`,
+		`{"version":0,"type":"A","label":"x"}`,
+		`{"version":2,"type":"A","name":"x"}`,
+		`{"version":3,"type":"A","name":"x"}`,
+	} {
+		m, err := d([]byte(data))
+		if err != nil {
+			t.Fatalf("Unable to decode [%s]: %v", data, err)
+		}
+		if m.Version != 3 || m.Name != "x" {
+			t.Errorf("Decoded [%s] as %+v.", data, m)
+		}
+	}
+}
+
+func TestDecoderRejectsUnknownVersions(t *testing.T) {
+	d := Decoder[message](testRegistry())
+	for _, data := range []string{
+		`{"version":4,"type":"A","name":"x"}`,
+		`{"version":-1,"type":"A","label":"x"}`,
+	} {
+		_, err := d([]byte(data))
+		if !errors.Is(err, ErrUnknownVersion) {
+			t.Errorf("Expected [%s] to be rejected as an unknown version, got %v.", data, err)
+		}
+	}
+}
+
+func TestDowncast(t *testing.T) {
+	r := testRegistry()
+	b, err := json.Marshal(message{Version: 3, Type: "A", Name: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for to, expected := range map[int]string{
+		1: `{"label":"x","type":"A"}`,
+		2: `{"name":"x","type":"A","version":2}`,
+		3: `{"name":"x","type":"A","version":3}`,
+	} {
+		out, err := r.Downcast(b, to)
+		if err != nil {
+			t.Fatalf("Unable to downcast to [%d]: %v", to, err)
+		}
+		if string(out) != expected {
+			t.Errorf("Downcast to [%d] is %s, expected %s.", to, out, expected)
+		}
+	}
+}
+
+func TestConfiguredVersion(t *testing.T) {
+	r := testRegistry()
+	v, err := ConfiguredVersion(r)("TEST_TOPIC")
+	if err != nil || v != 3 {
+		t.Errorf("Expected the current version when unset, got [%d] %v.", v, err)
+	}
+
+	t.Setenv("TEST_TOPIC_VERSION", "2")
+	v, err = ConfiguredVersion(r)("TEST_TOPIC")
+	if err != nil || v != 2 {
+		t.Errorf("Expected the pinned version, got [%d] %v.", v, err)
+	}
+
+	t.Setenv("TEST_TOPIC_VERSION", "4")
+	_, err = ConfiguredVersion(r)("TEST_TOPIC")
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Expected an unknown pinned version to be rejected, got %v.", err)
+	}
+}
@@ -30,6 +30,15 @@ func (b *Buffer) Put(t string, p model.Provider[[]kafka.Message]) error {
 	return nil
 }
 
+// PutAll appends the messages held by another buffer
+func (b *Buffer) PutAll(o *Buffer) {
+	for t, ms := range o.GetAll() {
+		b.mu.Lock()
+		b.buffer[t] = append(b.buffer[t], ms...)
+		b.mu.Unlock()
+	}
+}
+
 func (b *Buffer) GetAll() map[string][]kafka.Message {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -0,0 +1,54 @@
+package schema
+
+import (
+	compartment3 "atlas-compartment-transfer/kafka/message/cashshop/compartment"
+	compartment2 "atlas-compartment-transfer/kafka/message/character/compartment"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	compartment4 "atlas-compartment-transfer/kafka/message/currency/compartment"
+	"atlas-compartment-transfer/kafka/message/dlq"
+	"fmt"
+)
+
+//go:generate go run ../../../cmd/schema-gen -out ../../../schemas
+
+// Message describes a command or event exchanged with other services. The version must be bumped whenever the shape of
+// the value changes, so the published schema of each version never changes.
+type Message struct {
+	Name    string
+	Version int
+	Type    string
+	Value   interface{}
+}
+
+// FileName is the name the schema of the message is published under
+func (m Message) FileName() string {
+	return fmt.Sprintf("%s.v%d.json", m.Name, m.Version)
+}
+
+// Messages are every command and event the service produces or consumes
+var Messages = []Message{
+	{Name: "compartment-transfer.command.transfer", Version: 4, Value: compartment.TransferCommand{}},
+	{Name: "compartment-transfer.event.completed", Version: 4, Type: compartment.StatusEventTypeCompleted, Value: compartment.StatusEvent[compartment.StatusEventCompletedBody]{}},
+	{Name: "compartment-transfer.event.rejected", Version: 4, Type: compartment.StatusEventTypeRejected, Value: compartment.StatusEvent[compartment.StatusEventRejectedBody]{}},
+
+	{Name: "compartment.command.accept", Version: 2, Type: compartment2.CommandAccept, Value: compartment2.Command[compartment2.AcceptCommandBody]{}},
+	{Name: "compartment.command.release", Version: 2, Type: compartment2.CommandRelease, Value: compartment2.Command[compartment2.ReleaseCommandBody]{}},
+	{Name: "compartment.event.accepted", Version: 2, Type: compartment2.StatusEventTypeAccepted, Value: compartment2.StatusEvent[compartment2.AcceptedEventBody]{}},
+	{Name: "compartment.event.released", Version: 2, Type: compartment2.StatusEventTypeReleased, Value: compartment2.StatusEvent[compartment2.ReleasedEventBody]{}},
+	{Name: "compartment.event.error", Version: 2, Type: compartment2.StatusEventTypeError, Value: compartment2.StatusEvent[compartment2.ErrorEventBody]{}},
+
+	{Name: "cash-compartment.command.accept", Version: 2, Type: compartment3.CommandAccept, Value: compartment3.Command[compartment3.AcceptCommandBody]{}},
+	{Name: "cash-compartment.command.release", Version: 2, Type: compartment3.CommandRelease, Value: compartment3.Command[compartment3.ReleaseCommandBody]{}},
+	{Name: "cash-compartment.event.accepted", Version: 2, Type: compartment3.StatusEventTypeAccepted, Value: compartment3.StatusEvent[compartment3.StatusEventAcceptedBody]{}},
+	{Name: "cash-compartment.event.released", Version: 2, Type: compartment3.StatusEventTypeReleased, Value: compartment3.StatusEvent[compartment3.StatusEventReleasedBody]{}},
+	{Name: "cash-compartment.event.error", Version: 2, Type: compartment3.StatusEventTypeError, Value: compartment3.StatusEvent[compartment3.StatusEventErrorBody]{}},
+
+	{Name: "currency-compartment.command.debit", Version: 2, Type: compartment4.CommandDebit, Value: compartment4.Command[compartment4.DebitCommandBody]{}},
+	{Name: "currency-compartment.command.credit", Version: 2, Type: compartment4.CommandCredit, Value: compartment4.Command[compartment4.CreditCommandBody]{}},
+	{Name: "currency-compartment.event.debited", Version: 2, Type: compartment4.StatusEventTypeDebited, Value: compartment4.StatusEvent[compartment4.StatusEventDebitedBody]{}},
+	{Name: "currency-compartment.event.credited", Version: 2, Type: compartment4.StatusEventTypeCredited, Value: compartment4.StatusEvent[compartment4.StatusEventCreditedBody]{}},
+	{Name: "currency-compartment.event.error", Version: 2, Type: compartment4.StatusEventTypeError, Value: compartment4.StatusEvent[compartment4.StatusEventErrorBody]{}},
+
+	{Name: "compartment-transfer.dlq.entry", Version: 2, Value: dlq.Entry{}},
+	{Name: "compartment-transfer.dlq.command.replay", Version: 2, Type: dlq.CommandReplay, Value: dlq.Command[dlq.ReplayCommandBody]{}},
+}
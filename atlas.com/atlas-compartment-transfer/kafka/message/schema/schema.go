@@ -0,0 +1,130 @@
+package schema
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema document
+type Schema map[string]interface{}
+
+var (
+	uuidType          = reflect.TypeOf(uuid.UUID{})
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Generate creates the JSON Schema of a message, from the fields and json tags of its Go type
+func Generate(m Message) Schema {
+	s := generate(reflect.TypeOf(m.Value))
+	s["$schema"] = draft
+	s["$id"] = m.FileName()
+	s["title"] = m.Name
+	s["x-version"] = m.Version
+	if m.Type != "" {
+		props := s["properties"].(map[string]interface{})
+		if p, ok := props["type"].(Schema); ok {
+			p["const"] = m.Type
+		}
+	}
+	return s
+}
+
+// Marshal renders a schema as it is published
+func Marshal(s Schema) ([]byte, error) {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func generate(t reflect.Type) Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == uuidType:
+		return Schema{"type": "string", "format": "uuid"}
+	case t == timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case t.Implements(textMarshalerType):
+		return Schema{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Uint8:
+		return Schema{"type": "integer", "minimum": 0, "maximum": 255}
+	case reflect.Uint16:
+		return Schema{"type": "integer", "minimum": 0, "maximum": 65535}
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer", "minimum": 0}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "contentEncoding": "base64"}
+		}
+		return Schema{"type": "array", "items": generate(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": generate(t.Elem())}
+	case reflect.Struct:
+		return generateObject(t)
+	}
+	panic(fmt.Sprintf("unsupported type [%s] in message schema", t))
+}
+
+func generateObject(t reflect.Type) Schema {
+	props := make(map[string]interface{})
+	required := make([]string, 0)
+	addFields(t, props, &required)
+	s := Schema{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func addFields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addFields(f.Type, props, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := generate(f.Type)
+		if d, ok := f.Tag.Lookup("description"); ok {
+			fs["description"] = d
+		}
+		props[name] = fs
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package schema
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const published = "../../../schemas"
+
+// TestPublishedSchemas fails when the shape of a message no longer matches the schema published for its version
+func TestPublishedSchemas(t *testing.T) {
+	for _, m := range Messages {
+		t.Run(m.FileName(), func(t *testing.T) {
+			b, err := Marshal(Generate(m))
+			if err != nil {
+				t.Fatalf("Unable to generate schema: %v", err)
+			}
+			golden, err := os.ReadFile(filepath.Join(published, m.FileName()))
+			if os.IsNotExist(err) {
+				t.Fatalf("No schema published for [%s] version [%d]. Run go generate ./kafka/message/schema.", m.Name, m.Version)
+			}
+			if err != nil {
+				t.Fatalf("Unable to read published schema: %v", err)
+			}
+			if !bytes.Equal(golden, b) {
+				t.Fatalf("Message [%s] no longer matches its published schema. Bump its version and run go generate ./kafka/message/schema.\nPublished:\n%s\nGenerated:\n%s", m.Name, golden, b)
+			}
+		})
+	}
+}
+
+func TestMessageVersionsAreUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, m := range Messages {
+		if seen[m.FileName()] {
+			t.Errorf("Message [%s] version [%d] is registered more than once.", m.Name, m.Version)
+		}
+		seen[m.FileName()] = true
+	}
+}
@@ -2,6 +2,7 @@ package compartment
 
 import (
 	"atlas-compartment-transfer/kafka/message/cashshop/compartment"
+	"atlas-compartment-transfer/kafka/message/envelope"
 	"github.com/Chronicle20/atlas-kafka/producer"
 	"github.com/Chronicle20/atlas-model/model"
 	"github.com/google/uuid"
@@ -20,7 +21,7 @@ func AcceptCommandProvider(accountId uint32, compartmentId uuid.UUID, compartmen
 			ReferenceId:   referenceId,
 		},
 	}
-	return producer.SingleMessageProvider(key, value)
+	return envelope.MessageProvider(compartment.CommandVersions)(compartment.EnvCommandTopic)(key, value)
 }
 
 func ReleaseCommandProvider(accountId uint32, compartmentId uuid.UUID, compartmentType byte, transactionId uuid.UUID, referenceId uint32) model.Provider[[]kafka.Message] {
@@ -35,5 +36,5 @@ func ReleaseCommandProvider(accountId uint32, compartmentId uuid.UUID, compartme
 			AssetId:       referenceId,
 		},
 	}
-	return producer.SingleMessageProvider(key, value)
+	return envelope.MessageProvider(compartment.CommandVersions)(compartment.EnvCommandTopic)(key, value)
 }
@@ -2,6 +2,7 @@ package compartment
 
 import (
 	"atlas-compartment-transfer/kafka/message/character/compartment"
+	"atlas-compartment-transfer/kafka/message/envelope"
 	"github.com/Chronicle20/atlas-kafka/producer"
 	"github.com/Chronicle20/atlas-model/model"
 	"github.com/google/uuid"
@@ -19,7 +20,7 @@ func AcceptCommandProvider(characterId uint32, compartmentType byte, transaction
 			ReferenceId:   referenceId,
 		},
 	}
-	return producer.SingleMessageProvider(key, value)
+	return envelope.MessageProvider(compartment.CommandVersions)(compartment.EnvCommandTopic)(key, value)
 }
 
 func ReleaseCommandProvider(characterId uint32, compartmentType byte, transactionId uuid.UUID, referenceId uint32) model.Provider[[]kafka.Message] {
@@ -33,5 +34,5 @@ func ReleaseCommandProvider(characterId uint32, compartmentType byte, transactio
 			AssetId:       referenceId,
 		},
 	}
-	return producer.SingleMessageProvider(key, value)
+	return envelope.MessageProvider(compartment.CommandVersions)(compartment.EnvCommandTopic)(key, value)
 }
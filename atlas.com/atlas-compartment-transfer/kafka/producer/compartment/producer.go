@@ -2,14 +2,15 @@ package compartment
 
 import (
 	"atlas-compartment-transfer/kafka/message/compartment"
+	"atlas-compartment-transfer/kafka/message/envelope"
 	"github.com/Chronicle20/atlas-kafka/producer"
 	"github.com/Chronicle20/atlas-model/model"
 	"github.com/google/uuid"
 	"github.com/segmentio/kafka-go"
 )
 
-// CompletedStatusEventProvider creates a provider for a COMPLETED status event
-func CompletedStatusEventProvider(characterId uint32, transactionId uuid.UUID, accountId uint32, assetId uint32, compartmentId uuid.UUID, compartmentType byte, inventoryType string) model.Provider[[]kafka.Message] {
+// CompletedStatusEventProvider creates a provider for a COMPLETED status event. The fee is left out when nil.
+func CompletedStatusEventProvider(characterId uint32, transactionId uuid.UUID, accountId uint32, assetId uint32, compartmentId uuid.UUID, compartmentType byte, inventoryType string, fee *compartment.Fee) model.Provider[[]kafka.Message] {
 	key := producer.CreateKey(int(characterId))
 	value := &compartment.StatusEvent[compartment.StatusEventCompletedBody]{
 		CharacterId: characterId,
@@ -21,7 +22,31 @@ func CompletedStatusEventProvider(characterId uint32, transactionId uuid.UUID, a
 			CompartmentId:   compartmentId,
 			CompartmentType: compartmentType,
 			InventoryType:   inventoryType,
+			Fee:             fee,
 		},
 	}
-	return producer.SingleMessageProvider(key, value)
+	return envelope.MessageProvider(compartment.StatusEventVersions)(compartment.EnvEventTopicStatus)(key, value)
+}
+
+// RejectedStatusEventProvider creates a provider for a REJECTED status event
+func RejectedStatusEventProvider(characterId uint32, transactionId uuid.UUID, accountId uint32, assetId uint32, reason string, ruleId string) model.Provider[[]kafka.Message] {
+	key := producer.CreateKey(int(characterId))
+	value := &compartment.StatusEvent[compartment.StatusEventRejectedBody]{
+		CharacterId: characterId,
+		Type:        compartment.StatusEventTypeRejected,
+		Body: compartment.StatusEventRejectedBody{
+			TransactionId: transactionId,
+			AccountId:     accountId,
+			AssetId:       assetId,
+			Reason:        reason,
+			RuleId:        ruleId,
+		},
+	}
+	return envelope.MessageProvider(compartment.StatusEventVersions)(compartment.EnvEventTopicStatus)(key, value)
+}
+
+// TransferCommandProvider creates a provider for a command to transfer an asset between compartments
+func TransferCommandProvider(cmd compartment.TransferCommand) model.Provider[[]kafka.Message] {
+	key := producer.CreateKey(int(cmd.CharacterId))
+	return envelope.MessageProvider(compartment.TransferCommandVersions)(compartment.EnvCommandTopicCompartmentTransfer)(key, &cmd)
 }
@@ -0,0 +1,40 @@
+package compartment
+
+import (
+	"atlas-compartment-transfer/kafka/message/currency/compartment"
+	"atlas-compartment-transfer/kafka/message/envelope"
+	"github.com/Chronicle20/atlas-kafka/producer"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+)
+
+func DebitCommandProvider(accountId uint32, characterId uint32, compartmentId uuid.UUID, transactionId uuid.UUID, amount uint32) model.Provider[[]kafka.Message] {
+	key := producer.CreateKey(int(accountId))
+	value := &compartment.Command[compartment.DebitCommandBody]{
+		AccountId:     accountId,
+		CharacterId:   characterId,
+		CompartmentId: compartmentId,
+		Type:          compartment.CommandDebit,
+		Body: compartment.DebitCommandBody{
+			TransactionId: transactionId,
+			Amount:        amount,
+		},
+	}
+	return envelope.MessageProvider(compartment.CommandVersions)(compartment.EnvCommandTopic)(key, value)
+}
+
+func CreditCommandProvider(accountId uint32, characterId uint32, compartmentId uuid.UUID, transactionId uuid.UUID, amount uint32) model.Provider[[]kafka.Message] {
+	key := producer.CreateKey(int(accountId))
+	value := &compartment.Command[compartment.CreditCommandBody]{
+		AccountId:     accountId,
+		CharacterId:   characterId,
+		CompartmentId: compartmentId,
+		Type:          compartment.CommandCredit,
+		Body: compartment.CreditCommandBody{
+			TransactionId: transactionId,
+			Amount:        amount,
+		},
+	}
+	return envelope.MessageProvider(compartment.CommandVersions)(compartment.EnvCommandTopic)(key, value)
+}
@@ -0,0 +1,40 @@
+package dlq
+
+import (
+	"atlas-compartment-transfer/kafka/message/dlq"
+	"github.com/Chronicle20/atlas-kafka/producer"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/segmentio/kafka-go"
+	"time"
+)
+
+// EntryProvider creates a provider for a dead-letter entry wrapping the original message
+func EntryProvider(msg kafka.Message, reason string, cause error) model.Provider[[]kafka.Message] {
+	headers := make(dlq.Headers, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, dlq.Header{Key: h.Key, Value: string(h.Value)})
+	}
+	value := &dlq.Entry{
+		SourceTopic: msg.Topic,
+		Partition:   msg.Partition,
+		Offset:      msg.Offset,
+		Key:         msg.Key,
+		Payload:     msg.Value,
+		Headers:     headers,
+		Reason:      reason,
+		Error:       cause.Error(),
+		FailedAt:    time.Now(),
+	}
+	return producer.SingleMessageProvider(msg.Key, value)
+}
+
+// ReplayCommandProvider creates a provider for a REPLAY command of the given dead-letter entry
+func ReplayCommandProvider(e dlq.Entry) model.Provider[[]kafka.Message] {
+	value := &dlq.Command[dlq.ReplayCommandBody]{
+		Type: dlq.CommandReplay,
+		Body: dlq.ReplayCommandBody{
+			Entry: e,
+		},
+	}
+	return producer.SingleMessageProvider(e.Key, value)
+}
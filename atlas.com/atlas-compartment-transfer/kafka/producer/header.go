@@ -0,0 +1,48 @@
+package producer
+
+import (
+	"atlas-compartment-transfer/correlation"
+	"context"
+	"github.com/Chronicle20/atlas-kafka/producer"
+	"github.com/google/uuid"
+)
+
+// MessageIdHeaderDecorator stamps each message with an id of its own, which the messages it causes refer to
+func MessageIdHeaderDecorator() producer.HeaderDecorator {
+	return func() (map[string]string, error) {
+		return map[string]string{correlation.MessageIdHeader: uuid.New().String()}, nil
+	}
+}
+
+// CorrelationHeaderDecorator stamps messages with the id of the saga of the context
+func CorrelationHeaderDecorator(ctx context.Context) producer.HeaderDecorator {
+	return func() (map[string]string, error) {
+		headers := make(map[string]string)
+		if id, ok := correlation.CorrelationId(ctx); ok {
+			headers[correlation.CorrelationIdHeader] = id
+		}
+		return headers, nil
+	}
+}
+
+// CausationHeaderDecorator stamps messages with the id of the consumed message of the context
+func CausationHeaderDecorator(ctx context.Context) producer.HeaderDecorator {
+	return func() (map[string]string, error) {
+		headers := make(map[string]string)
+		if id, ok := correlation.CausationId(ctx); ok {
+			headers[correlation.CausationIdHeader] = id
+		}
+		return headers, nil
+	}
+}
+
+// HeaderDecorators are the decorators every emitted message passes through
+func HeaderDecorators(ctx context.Context) []producer.HeaderDecorator {
+	return []producer.HeaderDecorator{
+		producer.SpanHeaderDecorator(ctx),
+		producer.TenantHeaderDecorator(ctx),
+		MessageIdHeaderDecorator(),
+		CorrelationHeaderDecorator(ctx),
+		CausationHeaderDecorator(ctx),
+	}
+}
@@ -1,20 +1,37 @@
 package producer
 
 import (
+	"atlas-compartment-transfer/metrics"
 	"context"
 	"github.com/Chronicle20/atlas-kafka/producer"
 	"github.com/Chronicle20/atlas-kafka/topic"
+	"github.com/Chronicle20/atlas-model/model"
+	tenant "github.com/Chronicle20/atlas-tenant"
+	"github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
 )
 
 type Provider func(token string) producer.MessageProducer
 
+// ProviderFactory creates the producers messages are emitted through for a context. ProviderImpl emits to Kafka.
+type ProviderFactory func(l logrus.FieldLogger) func(ctx context.Context) func(token string) producer.MessageProducer
+
 func ProviderImpl(l logrus.FieldLogger) func(ctx context.Context) func(token string) producer.MessageProducer {
 	return func(ctx context.Context) func(token string) producer.MessageProducer {
-		sd := producer.SpanHeaderDecorator(ctx)
-		td := producer.TenantHeaderDecorator(ctx)
+		decorators := HeaderDecorators(ctx)
+		tenantId := ""
+		if t, err := tenant.FromContext(ctx)(); err == nil {
+			tenantId = t.Id().String()
+		}
 		return func(token string) producer.MessageProducer {
-			return producer.Produce(l)(producer.WriterProvider(topic.EnvProvider(l)(token)))(sd, td)
+			mp := producer.Produce(l)(producer.WriterProvider(topic.EnvProvider(l)(token)))(decorators...)
+			return func(provider model.Provider[[]kafka.Message]) error {
+				err := mp(provider)
+				if err != nil {
+					metrics.ProducerErrors.WithLabelValues(tenantId, token).Inc()
+				}
+				return err
+			}
 		}
 	}
 }
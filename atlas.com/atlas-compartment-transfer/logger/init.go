@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"atlas-compartment-transfer/settings"
 	"github.com/sirupsen/logrus"
 	"go.elastic.co/ecslogrus"
 	"os"
@@ -11,12 +12,23 @@ func CreateLogger(serviceName string) *logrus.Logger {
 	l.SetOutput(os.Stdout)
 	l.AddHook(newHook(serviceName))
 	l.SetFormatter(&ecslogrus.Formatter{})
-	if val, ok := os.LookupEnv("LOG_LEVEL"); ok {
+	setLevel(l)
+	return l
+}
+
+// Reload applies the LOG_LEVEL setting to the logger. An unset or invalid level leaves the level in place.
+func Reload(l *logrus.Logger) func() {
+	return func() {
+		setLevel(l)
+	}
+}
+
+func setLevel(l *logrus.Logger) {
+	if val, ok := settings.Lookup("LOG_LEVEL"); ok {
 		if level, err := logrus.ParseLevel(val); err == nil {
 			l.SetLevel(level)
 		}
 	}
-	return l
 }
 
 type ExtraFieldHook struct {
@@ -1,36 +1,150 @@
 package main
 
 import (
+	"atlas-compartment-transfer/chaos"
+	"atlas-compartment-transfer/configuration"
+	"atlas-compartment-transfer/database"
+	"atlas-compartment-transfer/dlq"
+	"atlas-compartment-transfer/drain"
+	"atlas-compartment-transfer/health"
+	"atlas-compartment-transfer/journal"
+	consumer2 "atlas-compartment-transfer/kafka/consumer"
 	csCompartment "atlas-compartment-transfer/kafka/consumer/cashshop/compartment"
 	cCompartment "atlas-compartment-transfer/kafka/consumer/character/compartment"
 	"atlas-compartment-transfer/kafka/consumer/compartment"
+	cuCompartment "atlas-compartment-transfer/kafka/consumer/currency/compartment"
+	dlqConsumer "atlas-compartment-transfer/kafka/consumer/dlq"
+	compartment3 "atlas-compartment-transfer/kafka/message/cashshop/compartment"
+	compartment2 "atlas-compartment-transfer/kafka/message/character/compartment"
+	compartment4 "atlas-compartment-transfer/kafka/message/compartment"
+	compartment5 "atlas-compartment-transfer/kafka/message/currency/compartment"
+	dlq2 "atlas-compartment-transfer/kafka/message/dlq"
+	"atlas-compartment-transfer/kafka/producer"
 	"atlas-compartment-transfer/logger"
+	"atlas-compartment-transfer/metrics"
+	"atlas-compartment-transfer/policy"
+	"atlas-compartment-transfer/ratelimit"
+	"atlas-compartment-transfer/rest"
 	"atlas-compartment-transfer/service"
+	"atlas-compartment-transfer/settings"
 	"atlas-compartment-transfer/tracing"
+	"atlas-compartment-transfer/transfer"
+	"context"
 	"github.com/Chronicle20/atlas-kafka/consumer"
 )
 
 const serviceName = "atlas-compartment-transfer"
 const consumerGroupId = "Compartment Transfer Service"
 
+// producedTopics are the topics the service emits to, which must be reachable for it to be ready
+var producedTopics = []string{
+	compartment2.EnvCommandTopic,
+	compartment3.EnvCommandTopic,
+	compartment5.EnvCommandTopic,
+	compartment4.EnvEventTopicStatus,
+	dlq2.EnvTopic,
+}
+
+// consumedTopics are the topics the service consumes from
+var consumedTopics = []string{
+	compartment4.EnvCommandTopicCompartmentTransfer,
+	compartment2.EnvEventTopicStatus,
+	compartment3.EnvEventTopicStatus,
+	compartment5.EnvEventTopicStatus,
+	dlq2.EnvCommandTopic,
+}
+
+func GetServer() rest.Server {
+	return rest.NewServerInformation("", "/api")
+}
+
 func main() {
+	// Settings are read before anything else, as the logger is configured from them
+	_, serr := settings.GetRegistry().Load()
 	l := logger.CreateLogger(serviceName)
+	if serr != nil {
+		l.WithError(serr).Fatal("Unable to read settings.")
+	}
 	l.Infoln("Starting main service.")
 
+	if err := policy.GetRegistry().Load(); err != nil {
+		l.WithError(err).Fatal("Unable to read transfer policy.")
+	}
+	if err := configuration.GetRegistry().Load(); err != nil {
+		l.WithError(err).Fatal("Unable to read tenant configuration.")
+	}
+
 	tdm := service.GetTeardownManager()
+	tdm.ReloadFunc(settings.Reload(l))
+	tdm.ReloadFunc(logger.Reload(l))
+	tdm.ReloadFunc(tracing.Reload(l))
+	tdm.ReloadFunc(policy.Reload(l))
+	tdm.ReloadFunc(configuration.Reload(l))
 
 	tc, err := tracing.InitTracer(l)(serviceName)
 	if err != nil {
 		l.WithError(err).Fatal("Unable to initialize tracer.")
 	}
 
-	cmf := consumer.GetManager().AddConsumer(l, tdm.Context(), tdm.WaitGroup())
+	// Probes are served from the outset, so the service is live but not ready while it connects and recovers
+	tdm.TerminateFunc(health.GetRegistry().SetDraining)
+	rest.CreateManagementService(l, tdm.Context(), tdm.WaitGroup(), metrics.InitResource(), health.InitResource())
+
+	db := database.Connect(l, database.SetMigrations(journal.Migration, ratelimit.Migration))
+	brokers := consumer2.LookupBrokers()
+	health.GetRegistry().AddCheck("database", health.DatabaseCheck(db))
+	health.GetRegistry().AddCheck("consumers", health.TopicCheck(brokers)(health.ConsumedTopicsProvider()))
+	health.GetRegistry().AddCheck("producer", health.TopicCheck(brokers)(health.EnvTopicsProvider(l)(producedTopics...)))
+
+	// Resume the transfers instances left in flight before consuming
+	_, err = transfer.Recover(l, tdm.Context(), db)(transfer.NewProcessorFactory(db, producer.ProviderImpl, transfer.GetTransactionCache()))
+	if err != nil {
+		l.WithError(err).Fatal("Unable to recover in-flight transfers.")
+	}
+	health.GetRegistry().SetRecovered()
+
+	// Consumers and the loops which advance transfers stop first on termination, so work already running can finish before
+	// the journal is closed and anything is torn down
+	workCtx, stopWork := context.WithCancel(tdm.Context())
+	tdm.TerminateFunc(drain.Drain(l)(drain.GetGate())(stopWork))
+	// Reads of the journal are served while transfers drain, and refused before it is closed
+	tdm.TerminateFunc(drain.Drain(l)(drain.GetReadGate())(func() {}))
+	tdm.TerminateFunc(database.Teardown(l)(db))
+	cmf := consumer.GetManager().AddConsumer(l, workCtx, tdm.WaitGroup())
 	compartment.InitConsumers(l)(cmf)(consumerGroupId)
 	csCompartment.InitConsumers(l)(cmf)(consumerGroupId)
 	cCompartment.InitConsumers(l)(cmf)(consumerGroupId)
-	compartment.InitHandlers(l)(consumer.GetManager().RegisterHandler)
-	csCompartment.InitHandlers(l)(consumer.GetManager().RegisterHandler)
-	cCompartment.InitHandlers(l)(consumer.GetManager().RegisterHandler)
+	cuCompartment.InitConsumers(l)(cmf)(consumerGroupId)
+	dlqConsumer.InitConsumers(l)(cmf)(consumerGroupId)
+	rf := drain.RegisterHandler(drain.GetGate())(health.RegisterHandler(consumer.GetManager().RegisterHandler))
+	pf := producer.ProviderFactory(producer.ProviderImpl)
+	// Staging environments may inject faults, to prove the saga survives them
+	if c, ok := chaos.FromEnv(l)(append(producedTopics, consumedTopics...)...); ok {
+		l.Warnln("Chaos mode enabled.")
+		tdm.ReloadFunc(chaos.Reload(l)(c)(append(producedTopics, consumedTopics...)...))
+		rf = chaos.RegisterHandler(l)(c)(rf)
+		pf = chaos.ProviderFactory(c)(pf)
+	}
+	// Dead-lettered messages are replayed through their handlers alone, so neither draining nor chaos refuses them
+	rf = dlq.RegisterHandler(rf)
+	// Transfers may be advanced by other instances, so the cache forgets those it has not touched for a while
+	transfer.StartEviction(l, tdm.Context(), tdm.WaitGroup())(transfer.GetTransactionCache())
+	// Rate limit buckets which have refilled limit no more than new ones, so they are removed from the database
+	ratelimit.StartEviction(l, workCtx, drain.GetGate().WaitGroup())(db, ratelimit.GetLimiter())
+	tf := transfer.NewProcessorFactory(db, pf, transfer.GetTransactionCache())
+	// Steps a compartment has not answered within the tenant's step timeout are issued again, or fail the transfer
+	transfer.StartTimeouts(l, workCtx, drain.GetGate().WaitGroup())(db, tf)
+	// Scheduled transfers are started once they are due, by whichever instance reaches them first
+	transfer.StartSchedules(l, workCtx, drain.GetGate().WaitGroup())(db, tf)
+	// Transfers in flight are gauged from the journal, as any instance may advance them
+	transfer.StartInFlightGauge(l, workCtx, drain.GetGate().WaitGroup())(db)
+	compartment.InitHandlers(l)(pf)(tf)(rf)
+	csCompartment.InitHandlers(l)(pf)(tf)(rf)
+	cCompartment.InitHandlers(l)(pf)(tf)(rf)
+	cuCompartment.InitHandlers(l)(pf)(tf)(rf)
+	dlqConsumer.InitHandlers(l)(drain.RegisterHandler(drain.GetGate())(health.RegisterHandler(consumer.GetManager().RegisterHandler)))
+
+	rest.CreateService(l, tdm.Context(), tdm.WaitGroup(), GetServer().GetPrefix(), drain.InitResource(drain.GetGate(), drain.GetReadGate()), transfer.InitResource(GetServer())(db))
 
 	tdm.TeardownFunc(tracing.Teardown(l)(tc))
 
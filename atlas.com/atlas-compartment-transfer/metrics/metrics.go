@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	namespace = "atlas"
+	subsystem = "compartment_transfer"
+)
+
+const (
+	OutcomeCompleted = "completed"
+	OutcomeFailed    = "failed"
+	OutcomeRejected  = "rejected"
+	OutcomeAborted   = "aborted"
+	OutcomeCancelled = "cancelled"
+)
+
+const (
+	ActionReissued = "reissued"
+	ActionFailed   = "failed"
+	ActionRefunded = "refunded"
+)
+
+const (
+	ActionScheduled = "scheduled"
+	ActionStarted   = "started"
+	ActionCancelled = "cancelled"
+)
+
+const (
+	LimitAccountRate   = "account_rate"
+	LimitCharacterRate = "character_rate"
+	LimitInFlight      = "in_flight"
+)
+
+// stepBuckets spans a fast, local round trip through to a compartment service which is struggling
+var stepBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Transfers counts finished transfers, by tenant, outcome and inventory type pair
+var Transfers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "transfers_total",
+	Help:      "Number of transfers which finished, by outcome and inventory type pair.",
+}, []string{"tenant", "outcome", "from_inventory_type", "to_inventory_type"})
+
+// InFlightTransfers gauges the transfers waiting on a compartment, by tenant, as the journal counts them
+var InFlightTransfers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "in_flight_transfers",
+	Help:      "Number of transfers waiting on a compartment.",
+}, []string{"tenant"})
+
+// AcceptStepDuration observes the time between emitting ACCEPT and consuming ACCEPTED, by tenant and destination inventory type
+var AcceptStepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "accept_step_duration_seconds",
+	Help:      "Time taken by the destination compartment to accept an asset.",
+	Buckets:   stepBuckets,
+}, []string{"tenant", "inventory_type"})
+
+// ReleaseStepDuration observes the time between emitting RELEASE and consuming RELEASED, by tenant and source inventory type
+var ReleaseStepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "release_step_duration_seconds",
+	Help:      "Time taken by the source compartment to release an asset.",
+	Buckets:   stepBuckets,
+}, []string{"tenant", "inventory_type"})
+
+// ProducerErrors counts failures to emit messages, by tenant and topic token
+var ProducerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "producer_errors_total",
+	Help:      "Number of failures to emit messages.",
+}, []string{"tenant", "topic"})
+
+// UnknownTransactionEvents counts compartment status events for transactions which are not in progress, by tenant and event type
+var UnknownTransactionEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "unknown_transaction_events_total",
+	Help:      "Number of compartment status events for transactions which are not in progress.",
+}, []string{"tenant", "event_type"})
+
+// RecoveredTransfers counts in-flight transfers resumed at startup, by tenant and the state they were resumed in
+var RecoveredTransfers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "recovered_transfers_total",
+	Help:      "Number of in-flight transfers resumed at startup.",
+}, []string{"tenant", "state"})
+
+// JournalConflicts counts steps which found their transfer advanced by another instance, and were retried from the journal, by tenant
+var JournalConflicts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "journal_conflicts_total",
+	Help:      "Number of steps which found their transfer advanced by another instance.",
+}, []string{"tenant"})
+
+// Rejections counts transfer commands refused before any compartment was asked to act, by tenant and reason
+var Rejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "rejections_total",
+	Help:      "Number of transfer commands refused before any compartment was asked to act.",
+}, []string{"tenant", "reason"})
+
+// RateLimited counts transfer commands refused as their account or character exceeded one of its tenant's limits, by
+// tenant and the limit exceeded
+var RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "rate_limited_total",
+	Help:      "Number of transfer commands refused as their account or character exceeded a limit.",
+}, []string{"tenant", "limit"})
+
+// StepTimeouts counts steps which waited longer than their tenant's step timeout, by tenant, the state they timed out
+// in, and whether their command was issued again or the transfer failed
+var StepTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "step_timeouts_total",
+	Help:      "Number of steps which waited longer than their tenant's step timeout.",
+}, []string{"tenant", "state", "action"})
+
+// ScheduledTransfers counts transfers to be delivered later, by tenant, and whether they were scheduled, started once
+// they were due, or cancelled before they started
+var ScheduledTransfers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: subsystem,
+	Name:      "scheduled_transfers_total",
+	Help:      "Number of transfers to be delivered later which were scheduled, started or cancelled.",
+}, []string{"tenant", "action"})
@@ -0,0 +1,15 @@
+package metrics
+
+import (
+	"atlas-compartment-transfer/rest"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+func InitResource() rest.RouteInitializer {
+	return func(router *mux.Router, l logrus.FieldLogger) {
+		router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	}
+}
@@ -0,0 +1,76 @@
+package policy
+
+import "slices"
+
+const (
+	// EffectAllow lets the transfers a rule matches go ahead
+	EffectAllow = "ALLOW"
+	// EffectDeny rejects the transfers a rule matches
+	EffectDeny = "DENY"
+)
+
+// Rule matches transfers by the template and flags of the item moved, and by the inventory types it moves between. A
+// zero valued field matches anything.
+type Rule struct {
+	Id                string   `json:"id"`
+	Effect            string   `json:"effect"`
+	MinTemplateId     uint32   `json:"minTemplateId,omitempty"`
+	MaxTemplateId     uint32   `json:"maxTemplateId,omitempty"`
+	Flags             []string `json:"flags,omitempty"`
+	FromInventoryType string   `json:"fromInventoryType,omitempty"`
+	ToInventoryType   string   `json:"toInventoryType,omitempty"`
+}
+
+// Subject is a transfer as the rules see it
+type Subject struct {
+	TemplateId        uint32
+	Flags             []string
+	FromInventoryType string
+	ToInventoryType   string
+}
+
+// Matches reports whether the rule applies to the transfer. A rule with flags applies only to items carrying all of them.
+func (r Rule) Matches(s Subject) bool {
+	if s.TemplateId < r.MinTemplateId {
+		return false
+	}
+	if r.MaxTemplateId != 0 && s.TemplateId > r.MaxTemplateId {
+		return false
+	}
+	if r.FromInventoryType != "" && r.FromInventoryType != s.FromInventoryType {
+		return false
+	}
+	if r.ToInventoryType != "" && r.ToInventoryType != s.ToInventoryType {
+		return false
+	}
+	for _, f := range r.Flags {
+		if !slices.Contains(s.Flags, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// NeedsFlags reports whether any of the rules match on item flags, in which case the item metadata must be known to
+// evaluate them
+func NeedsFlags(rules []Rule) bool {
+	for _, r := range rules {
+		if len(r.Flags) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate finds the first of the rules which applies to the transfer. The transfer is denied when that rule denies it,
+// and allowed when it allows it, or when no rule applies.
+func Evaluate(rules []Rule) func(s Subject) (Rule, bool) {
+	return func(s Subject) (Rule, bool) {
+		for _, r := range rules {
+			if r.Matches(s) {
+				return r, r.Effect == EffectDeny
+			}
+		}
+		return Rule{}, false
+	}
+}
@@ -0,0 +1,87 @@
+package policy_test
+
+import (
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"atlas-compartment-transfer/policy"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	rules := []policy.Rule{
+		{Id: "pets-anywhere", Effect: policy.EffectAllow, MinTemplateId: 5000000, MaxTemplateId: 5000999},
+		{Id: "bound-stay", Effect: policy.EffectDeny, Flags: []string{"ACCOUNT_BOUND"}, FromInventoryType: compartment.InventoryTypeCharacter},
+		{Id: "no-quest-to-cash", Effect: policy.EffectDeny, Flags: []string{"QUEST"}, ToInventoryType: compartment.InventoryTypeCashShop},
+	}
+	var tests = []struct {
+		name    string
+		subject policy.Subject
+		ruleId  string
+		denied  bool
+	}{
+		{"unflagged", policy.Subject{TemplateId: 2000000, FromInventoryType: compartment.InventoryTypeCharacter, ToInventoryType: compartment.InventoryTypeCashShop}, "", false},
+		{"bound", policy.Subject{TemplateId: 1302000, Flags: []string{"CASH", "ACCOUNT_BOUND"}, FromInventoryType: compartment.InventoryTypeCharacter, ToInventoryType: compartment.InventoryTypeCashShop}, "bound-stay", true},
+		{"bound from cash shop", policy.Subject{TemplateId: 1302000, Flags: []string{"ACCOUNT_BOUND"}, FromInventoryType: compartment.InventoryTypeCashShop, ToInventoryType: compartment.InventoryTypeCharacter}, "", false},
+		{"bound pet", policy.Subject{TemplateId: 5000010, Flags: []string{"ACCOUNT_BOUND"}, FromInventoryType: compartment.InventoryTypeCharacter, ToInventoryType: compartment.InventoryTypeCashShop}, "pets-anywhere", false},
+		{"quest", policy.Subject{TemplateId: 4031000, Flags: []string{"QUEST"}, FromInventoryType: compartment.InventoryTypeCharacter, ToInventoryType: compartment.InventoryTypeCashShop}, "no-quest-to-cash", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, denied := policy.Evaluate(rules)(tt.subject)
+			if r.Id != tt.ruleId || denied != tt.denied {
+				t.Errorf("Expected rule [%s] denied [%t], got rule [%s] denied [%t].", tt.ruleId, tt.denied, r.Id, denied)
+			}
+		})
+	}
+}
+
+func writePolicy(t *testing.T, path string, content string) {
+	t.Helper()
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("Unable to write policy: %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tenantId := uuid.New()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	t.Setenv("TRANSFER_POLICY_FILE", path)
+	t.Cleanup(func() {
+		_ = os.Unsetenv("TRANSFER_POLICY_FILE")
+		_ = policy.GetRegistry().Load()
+	})
+
+	writePolicy(t, path, `{"rules":[{"id":"shared","effect":"DENY","flags":["UNTRADEABLE"]}],"tenants":{"`+tenantId.String()+`":[{"id":"own","effect":"ALLOW","minTemplateId":1000000}]}}`)
+	err := policy.GetRegistry().Load()
+	if err != nil {
+		t.Fatalf("Unable to load policy: %v", err)
+	}
+	rules := policy.GetRegistry().RulesFor(tenantId)
+	if len(rules) != 2 || rules[0].Id != "own" || rules[1].Id != "shared" {
+		t.Fatalf("Expected the tenant's rules ahead of the shared rules, got %v.", rules)
+	}
+	rules = policy.GetRegistry().RulesFor(uuid.New())
+	if len(rules) != 1 || rules[0].Id != "shared" {
+		t.Fatalf("Expected other tenants to have only the shared rules, got %v.", rules)
+	}
+	if !policy.NeedsFlags(rules) {
+		t.Errorf("Expected a rule on flags to need item metadata.")
+	}
+
+	// An invalid policy leaves the rules in place
+	writePolicy(t, path, `{"rules":[{"id":"shared","effect":"MAYBE"}]}`)
+	if err = policy.GetRegistry().Load(); err == nil {
+		t.Fatalf("Expected a rule with an unknown effect to be refused.")
+	}
+	if rules = policy.GetRegistry().RulesFor(tenantId); len(rules) != 2 {
+		t.Fatalf("Expected the rules in place to be kept, got %v.", rules)
+	}
+
+	writePolicy(t, path, `{"rules":[{"id":"shared","effect":"DENY","minTemplateId":2,"maxTemplateId":1}]}`)
+	if err = policy.GetRegistry().Load(); err == nil {
+		t.Fatalf("Expected a rule matching no template to be refused.")
+	}
+}
@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"atlas-compartment-transfer/settings"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Config is the content of the policy file. The rules of a tenant are evaluated before the rules every tenant shares.
+type Config struct {
+	Rules   []Rule               `json:"rules"`
+	Tenants map[uuid.UUID][]Rule `json:"tenants"`
+}
+
+// Registry is a singleton that holds the transfer policy, read from the JSON file named by TRANSFER_POLICY_FILE. The file
+// is re-read when the service is reloaded. Every transfer is allowed when no file is named.
+type Registry struct {
+	lock   sync.Mutex
+	config atomic.Pointer[Config]
+}
+
+var registry *Registry
+var once sync.Once
+
+// GetRegistry returns the singleton instance of Registry
+func GetRegistry() *Registry {
+	once.Do(func() {
+		registry = &Registry{}
+		registry.config.Store(&Config{})
+	})
+	return registry
+}
+
+// RulesFor retrieves the rules which apply to transfers of the tenant, in the order they are evaluated
+func (r *Registry) RulesFor(tenantId uuid.UUID) []Rule {
+	c := r.config.Load()
+	rules := make([]Rule, 0, len(c.Tenants[tenantId])+len(c.Rules))
+	rules = append(rules, c.Tenants[tenantId]...)
+	return append(rules, c.Rules...)
+}
+
+// Load reads the policy file, and puts its rules in place at once. When the file cannot be read, or holds an invalid
+// rule, the rules in place are kept.
+func (r *Registry) Load() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	c := &Config{}
+	if path, ok := settings.Lookup("TRANSFER_POLICY_FILE"); ok && path != "" {
+		var err error
+		c, err = read(path)
+		if err != nil {
+			return err
+		}
+	}
+	r.config.Store(c)
+	return nil
+}
+
+func read(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a transfer policy: %w", path, err)
+	}
+	err = validate(c.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for id, rules := range c.Tenants {
+		err = validate(rules)
+		if err != nil {
+			return nil, fmt.Errorf("%s: tenant [%s]: %w", path, id, err)
+		}
+	}
+	return c, nil
+}
+
+func validate(rules []Rule) error {
+	for i, r := range rules {
+		if r.Id == "" {
+			return fmt.Errorf("rule [%d] has no id", i)
+		}
+		if r.Effect != EffectAllow && r.Effect != EffectDeny {
+			return fmt.Errorf("rule [%s] has effect [%s], not [%s] or [%s]", r.Id, r.Effect, EffectAllow, EffectDeny)
+		}
+		if r.MaxTemplateId != 0 && r.MaxTemplateId < r.MinTemplateId {
+			return fmt.Errorf("rule [%s] has a template range [%d, %d] which matches nothing", r.Id, r.MinTemplateId, r.MaxTemplateId)
+		}
+	}
+	return nil
+}
+
+// Reload re-reads the policy file, and logs how many rules are in place
+func Reload(l logrus.FieldLogger) func() {
+	return func() {
+		err := GetRegistry().Load()
+		if err != nil {
+			l.WithError(err).Errorf("Unable to reload transfer policy. Keeping the rules in place.")
+			return
+		}
+		c := GetRegistry().config.Load()
+		l.Infof("Reloaded transfer policy. [%d] shared rules, and rules for [%d] tenants, are in place.", len(c.Rules), len(c.Tenants))
+	}
+}
@@ -0,0 +1,27 @@
+package ratelimit
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+func Migration(db *gorm.DB) error {
+	return db.AutoMigrate(&Entity{})
+}
+
+// Entity is the token bucket of an account or character of a tenant. Its version grows with each take, so a take based
+// on a bucket another instance has since taken from is refused.
+type Entity struct {
+	TenantId uuid.UUID `gorm:"primaryKey;type:uuid"`
+	Scope    string    `gorm:"primaryKey"`
+	OwnerId  uint32    `gorm:"primaryKey;autoIncrement:false"`
+	Tokens   float64   `gorm:"not null"`
+	Last     time.Time `gorm:"not null"`
+	FullAt   time.Time `gorm:"not null;index"`
+	Version  uint32    `gorm:"not null;default:0"`
+}
+
+func (e Entity) TableName() string {
+	return "rate_limit_buckets"
+}
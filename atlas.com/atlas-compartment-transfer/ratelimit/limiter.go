@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"atlas-compartment-transfer/configuration"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+const evictionInterval = time.Minute
+
+// Scope is what a bucket limits the transfers of
+type Scope string
+
+const (
+	ScopeAccount   Scope = "account"
+	ScopeCharacter Scope = "character"
+)
+
+// Limit is the rate the transfers of an account or character are limited to
+type Limit struct {
+	Scope Scope
+	Id    uint32
+	Rate  configuration.Rate
+}
+
+// ErrContended is returned when the buckets of a transfer were taken from by other instances each time it was taken from
+// them
+var ErrContended = errors.New("rate limit buckets are contended")
+
+// errTaken is returned when a bucket was taken from, or created, by another instance since it was read
+var errTaken = errors.New("rate limit bucket was taken from elsewhere")
+
+const maxAttempts = 5
+
+// refill adds the tokens the bucket has earned since it was last taken from, up to the burst of the rate
+func refill(e *Entity, now time.Time, rate configuration.Rate) {
+	elapsed := now.Sub(e.Last)
+	if elapsed > 0 {
+		e.Tokens += elapsed.Seconds() * float64(rate.Count()) / rate.Per().Seconds()
+		e.Last = now
+	}
+	if e.Tokens > float64(rate.Burst()) {
+		e.Tokens = float64(rate.Burst())
+	}
+}
+
+// fullAt is when the bucket will have refilled under the rate
+func fullAt(e Entity, rate configuration.Rate) time.Time {
+	missing := float64(rate.Burst()) - e.Tokens
+	return e.Last.Add(time.Duration(missing / float64(rate.Count()) * float64(rate.Per())))
+}
+
+// Limiter takes transfers from a token bucket for each account and character of each tenant which has started a
+// transfer. Buckets are held in the database, so every instance limits transfers alike.
+type Limiter struct {
+	now func() time.Time
+}
+
+var limiter *Limiter
+var once sync.Once
+
+// GetLimiter returns the singleton instance of Limiter
+func GetLimiter() *Limiter {
+	once.Do(func() {
+		limiter = NewLimiter(time.Now)
+	})
+	return limiter
+}
+
+// NewLimiter creates a limiter apart from the singleton, which reads the time from the clock
+func NewLimiter(now func() time.Time) *Limiter {
+	return &Limiter{now: now}
+}
+
+// Allow takes a token from the bucket of each limit, but only when every one of them holds a token, so a transfer which
+// is refused does not count against the limits it was within. It returns the scope of the first limit which is exceeded.
+// Limits which are not limited are ignored. Should another instance take from the buckets meanwhile, the transfer is
+// taken from them again, and ErrContended is returned when it cannot be.
+func (l *Limiter) Allow(db *gorm.DB) func(tenantId uuid.UUID, limits ...Limit) (Scope, bool, error) {
+	return func(tenantId uuid.UUID, limits ...Limit) (Scope, bool, error) {
+		for i := 0; i < maxAttempts; i++ {
+			scope, ok, err := l.allow(db, tenantId, limits)
+			if !errors.Is(err, errTaken) {
+				return scope, ok, err
+			}
+		}
+		return "", false, ErrContended
+	}
+}
+
+// take is a bucket a transfer is to be taken from, with the rate it refills at
+type take struct {
+	e     Entity
+	rate  configuration.Rate
+	found bool
+}
+
+func (l *Limiter) allow(db *gorm.DB, tenantId uuid.UUID, limits []Limit) (Scope, bool, error) {
+	now := l.now().UTC()
+	var refused Scope
+	err := db.Transaction(func(tx *gorm.DB) error {
+		takes := make([]take, 0, len(limits))
+		for _, lim := range limits {
+			if !lim.Rate.Limited() {
+				continue
+			}
+			e, found, err := getBucket(tenantId, lim.Scope, lim.Id)(tx)
+			if err != nil {
+				return err
+			}
+			if !found {
+				e = Entity{TenantId: tenantId, Scope: string(lim.Scope), OwnerId: lim.Id, Tokens: float64(lim.Rate.Burst()), Last: now}
+			}
+			refill(&e, now, lim.Rate)
+			if e.Tokens < 1 {
+				refused = lim.Scope
+				return nil
+			}
+			takes = append(takes, take{e: e, rate: lim.Rate, found: found})
+		}
+		for _, t := range takes {
+			t.e.Tokens--
+			t.e.FullAt = fullAt(t.e, t.rate)
+			err := putBucket(t.e, t.found)(tx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", false, err
+	}
+	if refused != "" {
+		return refused, false, nil
+	}
+	return "", true, nil
+}
+
+// Evict removes the buckets which have refilled, as they limit no more than a new bucket would, and returns how many
+// were removed
+func (l *Limiter) Evict(db *gorm.DB) (int, error) {
+	return deleteFull(l.now().UTC())(db)
+}
+
+// StartEviction periodically evicts the buckets which have refilled from the database, until the context is done
+func StartEviction(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup) func(db *gorm.DB, limiter *Limiter) {
+	return func(db *gorm.DB, limiter *Limiter) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(evictionInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					n, err := limiter.Evict(db.WithContext(ctx))
+					if err != nil {
+						l.WithError(err).Errorf("Unable to evict rate limit buckets.")
+						continue
+					}
+					if n > 0 {
+						l.Debugf("Evicted [%d] rate limit buckets.", n)
+					}
+				}
+			}
+		}()
+	}
+}
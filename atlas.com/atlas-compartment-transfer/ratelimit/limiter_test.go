@@ -0,0 +1,148 @@
+package ratelimit_test
+
+import (
+	"atlas-compartment-transfer/configuration"
+	"atlas-compartment-transfer/ratelimit"
+	"atlas-compartment-transfer/test"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func rate(t *testing.T, count int, per string, burst int) configuration.Rate {
+	t.Helper()
+	m, err := configuration.Extract(configuration.RestModel{Limits: configuration.LimitsRestModel{
+		AccountRate: &configuration.RateRestModel{Count: count, Per: per, Burst: burst},
+	}})
+	if err != nil {
+		t.Fatalf("Unable to extract rate: %v", err)
+	}
+	return m.AccountRate()
+}
+
+// allow takes a transfer from the limits through the limiter, failing the test when the buckets cannot be taken from
+func allow(t *testing.T, l *ratelimit.Limiter, db *gorm.DB, tenantId uuid.UUID, limits ...ratelimit.Limit) (ratelimit.Scope, bool) {
+	t.Helper()
+	scope, ok, err := l.Allow(db)(tenantId, limits...)
+	if err != nil {
+		t.Fatalf("Unable to take from the rate limits: %v", err)
+	}
+	return scope, ok
+}
+
+// evict evicts the refilled buckets through the limiter, failing the test when they cannot be
+func evict(t *testing.T, l *ratelimit.Limiter, db *gorm.DB) int {
+	t.Helper()
+	n, err := l.Evict(db)
+	if err != nil {
+		t.Fatalf("Unable to evict rate limit buckets: %v", err)
+	}
+	return n
+}
+
+func TestAllow(t *testing.T) {
+	c := &clock{now: time.Now()}
+	l := ratelimit.NewLimiter(c.Now)
+	db := test.Database(t, ratelimit.Migration)
+	tenantId := uuid.New()
+	account := ratelimit.Limit{Scope: ratelimit.ScopeAccount, Id: 1000, Rate: rate(t, 2, "1m", 3)}
+
+	for i := 0; i < 3; i++ {
+		if _, ok := allow(t, l, db, tenantId, account); !ok {
+			t.Fatalf("Expected transfer [%d] of the burst to be allowed.", i+1)
+		}
+	}
+	if scope, ok := allow(t, l, db, tenantId, account); ok || scope != ratelimit.ScopeAccount {
+		t.Fatalf("Expected a transfer beyond the burst to exceed the account limit, got [%s] [%t].", scope, ok)
+	}
+	if _, ok := allow(t, l, db, uuid.New(), account); !ok {
+		t.Fatalf("Expected the account of another tenant to be limited apart.")
+	}
+
+	c.now = c.now.Add(30 * time.Second)
+	if _, ok := allow(t, l, db, tenantId, account); !ok {
+		t.Fatalf("Expected a token to be refilled after half of the period.")
+	}
+	if _, ok := allow(t, l, db, tenantId, account); ok {
+		t.Fatalf("Expected only one token to be refilled after half of the period.")
+	}
+}
+
+func TestAllowTakesFromEveryLimitOrNone(t *testing.T) {
+	c := &clock{now: time.Now()}
+	l := ratelimit.NewLimiter(c.Now)
+	db := test.Database(t, ratelimit.Migration)
+	tenantId := uuid.New()
+	account := ratelimit.Limit{Scope: ratelimit.ScopeAccount, Id: 1000, Rate: rate(t, 2, "1m", 0)}
+	character := ratelimit.Limit{Scope: ratelimit.ScopeCharacter, Id: 1, Rate: rate(t, 1, "1m", 0)}
+	other := ratelimit.Limit{Scope: ratelimit.ScopeCharacter, Id: 2, Rate: rate(t, 1, "1m", 0)}
+
+	if _, ok := allow(t, l, db, tenantId, account, character); !ok {
+		t.Fatalf("Expected the first transfer to be allowed.")
+	}
+	if scope, ok := allow(t, l, db, tenantId, account, character); ok || scope != ratelimit.ScopeCharacter {
+		t.Fatalf("Expected the second transfer to exceed the character limit, got [%s] [%t].", scope, ok)
+	}
+	// The refused transfer took no token from the account, so another character of it may still transfer
+	if _, ok := allow(t, l, db, tenantId, account, other); !ok {
+		t.Fatalf("Expected a transfer of another character of the account to be allowed.")
+	}
+	if scope, ok := allow(t, l, db, tenantId, account, ratelimit.Limit{Scope: ratelimit.ScopeCharacter, Id: 3, Rate: rate(t, 1, "1m", 0)}); ok || scope != ratelimit.ScopeAccount {
+		t.Fatalf("Expected a third transfer to exceed the account limit, got [%s] [%t].", scope, ok)
+	}
+	if _, ok := allow(t, l, db, tenantId, ratelimit.Limit{Scope: ratelimit.ScopeAccount, Id: 1000}); !ok {
+		t.Fatalf("Expected a limit without a rate to allow every transfer.")
+	}
+}
+
+func TestEvict(t *testing.T) {
+	c := &clock{now: time.Now()}
+	l := ratelimit.NewLimiter(c.Now)
+	db := test.Database(t, ratelimit.Migration)
+	tenantId := uuid.New()
+	account := ratelimit.Limit{Scope: ratelimit.ScopeAccount, Id: 1000, Rate: rate(t, 1, "1m", 0)}
+
+	allow(t, l, db, tenantId, account)
+	if n := evict(t, l, db); n != 0 {
+		t.Fatalf("Expected an empty bucket to be kept, evicted [%d].", n)
+	}
+	c.now = c.now.Add(time.Minute)
+	if n := evict(t, l, db); n != 1 {
+		t.Fatalf("Expected a refilled bucket to be evicted, evicted [%d].", n)
+	}
+	if _, ok := allow(t, l, db, tenantId, account); !ok {
+		t.Fatalf("Expected the account to be allowed once its bucket was evicted.")
+	}
+}
+
+func TestAllowIsSharedAcrossLimiters(t *testing.T) {
+	c := &clock{now: time.Now()}
+	db := test.Database(t, ratelimit.Migration)
+	first := ratelimit.NewLimiter(c.Now)
+	second := ratelimit.NewLimiter(c.Now)
+	tenantId := uuid.New()
+	account := ratelimit.Limit{Scope: ratelimit.ScopeAccount, Id: 1000, Rate: rate(t, 2, "1m", 0)}
+
+	// Each instance has its own limiter, and the buckets they share are held by the database
+	if _, ok := allow(t, first, db, tenantId, account); !ok {
+		t.Fatalf("Expected the first transfer to be allowed.")
+	}
+	if _, ok := allow(t, second, db, tenantId, account); !ok {
+		t.Fatalf("Expected the second transfer to be allowed by another instance.")
+	}
+	if scope, ok := allow(t, first, db, tenantId, account); ok || scope != ratelimit.ScopeAccount {
+		t.Fatalf("Expected a third transfer to exceed the account limit across instances, got [%s] [%t].", scope, ok)
+	}
+	if _, ok := allow(t, second, db, tenantId, account); ok {
+		t.Fatalf("Expected another instance to refuse a transfer beyond the account limit.")
+	}
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// getBucket retrieves the bucket of the account or character of the tenant, reporting whether there is one
+func getBucket(tenantId uuid.UUID, scope Scope, ownerId uint32) func(db *gorm.DB) (Entity, bool, error) {
+	return func(db *gorm.DB) (Entity, bool, error) {
+		var e Entity
+		err := db.Where("tenant_id = ? AND scope = ? AND owner_id = ?", tenantId, string(scope), ownerId).First(&e).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Entity{}, false, nil
+		}
+		if err != nil {
+			return Entity{}, false, err
+		}
+		return e, true, nil
+	}
+}
+
+// putBucket writes the bucket as it is after a take. A bucket which was read is only written when it has not been taken
+// from since, and one which was not is only created when it still does not exist. errTaken is returned otherwise.
+func putBucket(e Entity, found bool) func(db *gorm.DB) error {
+	return func(db *gorm.DB) error {
+		if !found {
+			res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&e)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return errTaken
+			}
+			return nil
+		}
+		res := db.Model(&Entity{}).
+			Where("tenant_id = ? AND scope = ? AND owner_id = ? AND version = ?", e.TenantId, e.Scope, e.OwnerId, e.Version).
+			Updates(map[string]interface{}{"tokens": e.Tokens, "last": e.Last, "full_at": e.FullAt, "version": e.Version + 1})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errTaken
+		}
+		return nil
+	}
+}
+
+// deleteFull removes the buckets which have refilled by the time given, returning how many were removed
+func deleteFull(now time.Time) func(db *gorm.DB) (int, error) {
+	return func(db *gorm.DB) (int, error) {
+		res := db.Where("full_at <= ?", now).Delete(&Entity{})
+		return int(res.RowsAffected), res.Error
+	}
+}
@@ -0,0 +1,134 @@
+package rest
+
+import (
+	"context"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jtumidanski/api2go/jsonapi"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+type HandlerDependency struct {
+	l   logrus.FieldLogger
+	ctx context.Context
+	db  *gorm.DB
+}
+
+func (h HandlerDependency) Logger() logrus.FieldLogger {
+	return h.l
+}
+
+func (h HandlerDependency) Context() context.Context {
+	return h.ctx
+}
+
+func (h HandlerDependency) DB() *gorm.DB {
+	return h.db
+}
+
+type HandlerContext struct {
+	si jsonapi.ServerInformation
+}
+
+func (h HandlerContext) ServerInformation() jsonapi.ServerInformation {
+	return h.si
+}
+
+type GetHandler func(d *HandlerDependency, c *HandlerContext) http.HandlerFunc
+
+// RegisterHandler wraps a handler with a span, and a context carrying the tenant named by the request headers
+func RegisterHandler(l logrus.FieldLogger) func(db *gorm.DB) func(si jsonapi.ServerInformation) func(handlerName string, handler GetHandler) http.HandlerFunc {
+	return func(db *gorm.DB) func(si jsonapi.ServerInformation) func(handlerName string, handler GetHandler) http.HandlerFunc {
+		return func(si jsonapi.ServerInformation) func(handlerName string, handler GetHandler) http.HandlerFunc {
+			return func(handlerName string, handler GetHandler) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					wireContext, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+					span := opentracing.StartSpan(handlerName, ext.RPCServerOption(wireContext))
+					defer span.Finish()
+
+					fl := l.WithFields(logrus.Fields{"originator": handlerName, "type": "rest_handler"})
+					t, err := ParseTenant(r.Header)
+					if err != nil {
+						fl.WithError(err).Errorf("Unable to identify tenant of request.")
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					ctx := tenant.WithContext(opentracing.ContextWithSpan(r.Context(), span), t)
+					handler(&HandlerDependency{l: fl, ctx: ctx, db: db}, &HandlerContext{si: si})(w, r)
+				}
+			}
+		}
+	}
+}
+
+// ParseTenant creates the tenant identified by the request headers
+func ParseTenant(h http.Header) (tenant.Model, error) {
+	id, err := uuid.Parse(h.Get(tenant.ID))
+	if err != nil {
+		return tenant.Model{}, err
+	}
+	majorVersion, err := strconv.ParseUint(h.Get(tenant.MajorVersion), 10, 16)
+	if err != nil {
+		return tenant.Model{}, err
+	}
+	minorVersion, err := strconv.ParseUint(h.Get(tenant.MinorVersion), 10, 16)
+	if err != nil {
+		return tenant.Model{}, err
+	}
+	return tenant.Create(id, h.Get(tenant.Region), uint16(majorVersion), uint16(minorVersion))
+}
+
+type TransactionIdHandler func(transactionId uuid.UUID) http.HandlerFunc
+
+func ParseTransactionId(l logrus.FieldLogger, next TransactionIdHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		transactionId, err := uuid.Parse(mux.Vars(r)["transactionId"])
+		if err != nil {
+			l.WithError(err).Errorf("Unable to properly parse transactionId from path.")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		next(transactionId)(w, r)
+	}
+}
+
+type AccountIdHandler func(accountId uint32) http.HandlerFunc
+
+func ParseAccountId(l logrus.FieldLogger, next AccountIdHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountId, err := strconv.ParseUint(mux.Vars(r)["accountId"], 10, 32)
+		if err != nil {
+			l.WithError(err).Errorf("Unable to properly parse accountId from path.")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		next(uint32(accountId))(w, r)
+	}
+}
+
+// MarshalResponse writes the JSON:API document for a resource, or slice of resources
+func MarshalResponse[A any](l logrus.FieldLogger) func(w http.ResponseWriter) func(si jsonapi.ServerInformation) func(data A) {
+	return func(w http.ResponseWriter) func(si jsonapi.ServerInformation) func(data A) {
+		return func(si jsonapi.ServerInformation) func(data A) {
+			return func(data A) {
+				res, err := jsonapi.MarshalWithURLs(data, si)
+				if err != nil {
+					l.WithError(err).Errorf("Unable to marshal models.")
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				_, err = w.Write(res)
+				if err != nil {
+					l.WithError(err).Errorf("Unable to write response.")
+				}
+			}
+		}
+	}
+}
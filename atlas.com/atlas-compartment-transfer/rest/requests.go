@@ -0,0 +1,91 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/jtumidanski/api2go/jsonapi"
+	"github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the requested resource does not exist
+var ErrNotFound = errors.New("resource not found")
+
+const requestTimeout = 5 * time.Second
+
+var client = &http.Client{Timeout: requestTimeout}
+
+// Request retrieves a resource from the REST API of another service
+type Request[A any] func(l logrus.FieldLogger, ctx context.Context) (A, error)
+
+// BaseUrl is the root the REST APIs of other services are reached under, read from BASE_SERVICE_URL. Nothing is
+// requested of other services when it is not set.
+func BaseUrl() (string, bool) {
+	v, ok := os.LookupEnv("BASE_SERVICE_URL")
+	if !ok || v == "" {
+		return "", false
+	}
+	return strings.TrimSuffix(v, "/") + "/", true
+}
+
+// MakeGetRequest creates a request for the JSON:API resource at the url, made on behalf of the tenant of the context
+func MakeGetRequest[A any](url string) Request[A] {
+	return func(l logrus.FieldLogger, ctx context.Context) (A, error) {
+		var result A
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return result, err
+		}
+		t := tenant.MustFromContext(ctx)
+		req.Header.Set(tenant.ID, t.Id().String())
+		req.Header.Set(tenant.Region, t.Region())
+		req.Header.Set(tenant.MajorVersion, strconv.Itoa(int(t.MajorVersion())))
+		req.Header.Set(tenant.MinorVersion, strconv.Itoa(int(t.MinorVersion())))
+		if span := opentracing.SpanFromContext(ctx); span != nil {
+			_ = opentracing.GlobalTracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+		}
+
+		l.Debugf("Issuing [%s] request to [%s].", http.MethodGet, url)
+		resp, err := client.Do(req)
+		if err != nil {
+			return result, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return result, err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = jsonapi.Unmarshal(body, &result)
+			return result, err
+		case http.StatusNotFound:
+			return result, ErrNotFound
+		}
+		return result, fmt.Errorf("[%s] request to [%s] returned [%s]", http.MethodGet, url, resp.Status)
+	}
+}
+
+// Provider adapts a request to a provider of the model its resource is transformed into
+func Provider[A any, M any](l logrus.FieldLogger, ctx context.Context) func(r Request[A], transform func(A) (M, error)) model.Provider[M] {
+	return func(r Request[A], transform func(A) (M, error)) model.Provider[M] {
+		return func() (M, error) {
+			rm, err := r(l, ctx)
+			if err != nil {
+				var m M
+				return m, err
+			}
+			return transform(rm)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPort           = "8080"
+	defaultManagementPort = "9090"
+)
+
+type RouteInitializer func(*mux.Router, logrus.FieldLogger)
+
+// Server describes where the service is reachable, for the links of JSON:API documents
+type Server struct {
+	baseUrl string
+	prefix  string
+}
+
+func (s Server) GetBaseURL() string {
+	return s.baseUrl
+}
+
+func (s Server) GetPrefix() string {
+	return s.prefix
+}
+
+func NewServerInformation(baseUrl string, prefix string) Server {
+	return Server{baseUrl: baseUrl, prefix: prefix}
+}
+
+// CreateService starts the REST server, and stops it when the context is cancelled
+func CreateService(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup, basePath string, initializers ...RouteInitializer) {
+	router := mux.NewRouter().PathPrefix(basePath).Subrouter().StrictSlash(true)
+	router.Use(commonHeader)
+	for _, initializer := range initializers {
+		initializer(router, l)
+	}
+	serve(l, ctx, wg, lookupPort("REST_PORT", defaultPort), router)
+}
+
+// CreateManagementService starts the operational server, for metrics and probes, on a port of its own so scrapes stay off the API
+func CreateManagementService(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup, initializers ...RouteInitializer) {
+	router := mux.NewRouter()
+	for _, initializer := range initializers {
+		initializer(router, l)
+	}
+	serve(l, ctx, wg, lookupPort("MANAGEMENT_PORT", defaultManagementPort), router)
+}
+
+func lookupPort(key string, fallback string) string {
+	port, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	return port
+}
+
+func serve(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup, port string, handler http.Handler) {
+	hs := &http.Server{
+		Addr:         ":" + port,
+		Handler:      handler,
+		ErrorLog:     nil,
+		ReadTimeout:  time.Duration(5) * time.Second,
+		WriteTimeout: time.Duration(10) * time.Second,
+		IdleTimeout:  time.Duration(120) * time.Second,
+	}
+
+	l.Infof("Starting server on %s", hs.Addr)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := hs.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.WithError(err).Errorf("Error while serving.")
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		l.Infof("Shutting down server on %s", hs.Addr)
+		sctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+		defer cancel()
+		err := hs.Shutdown(sctx)
+		if err != nil {
+			l.WithError(err).Errorf("Error shutting down HTTP service.")
+		}
+	}()
+}
+
+func commonHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		next.ServeHTTP(w, r)
+	})
+}
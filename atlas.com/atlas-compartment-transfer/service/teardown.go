@@ -9,11 +9,16 @@ import (
 )
 
 type Manager struct {
-	termChan  chan os.Signal
-	doneChan  chan struct{}
-	waitGroup *sync.WaitGroup
-	context   context.Context
-	cancel    context.CancelFunc
+	termChan    chan os.Signal
+	termFuncs   []func()
+	termLock    sync.Mutex
+	reloadChan  chan os.Signal
+	reloadFuncs []func()
+	reloadLock  sync.Mutex
+	doneChan    chan struct{}
+	waitGroup   *sync.WaitGroup
+	context     context.Context
+	cancel      context.CancelFunc
 }
 
 var manager *Manager
@@ -24,14 +29,17 @@ func GetTeardownManager() *Manager {
 		ctx, cancel := context.WithCancel(context.Background())
 
 		manager = &Manager{
-			termChan:  make(chan os.Signal),
-			doneChan:  make(chan struct{}),
-			waitGroup: &sync.WaitGroup{},
-			context:   ctx,
-			cancel:    cancel,
+			termChan:   make(chan os.Signal),
+			reloadChan: make(chan os.Signal, 1),
+			doneChan:   make(chan struct{}),
+			waitGroup:  &sync.WaitGroup{},
+			context:    ctx,
+			cancel:     cancel,
 		}
 
-		signal.Notify(manager.termChan, os.Interrupt, os.Kill, syscall.SIGTERM, syscall.SIGHUP)
+		signal.Notify(manager.termChan, os.Interrupt, os.Kill, syscall.SIGTERM)
+		signal.Notify(manager.reloadChan, syscall.SIGHUP)
+		go manager.reload()
 	})
 	return manager
 }
@@ -45,8 +53,45 @@ func (m *Manager) TeardownFunc(f func()) {
 	}()
 }
 
+// TerminateFunc registers a function to run as soon as termination is requested, before the root context is cancelled
+// and anything is torn down. Functions run one after another, in the order they were registered.
+func (m *Manager) TerminateFunc(f func()) {
+	m.termLock.Lock()
+	defer m.termLock.Unlock()
+	m.termFuncs = append(m.termFuncs, f)
+}
+
+// ReloadFunc registers a function to run whenever a reload is requested with SIGHUP. Functions run one after another, in
+// the order they were registered.
+func (m *Manager) ReloadFunc(f func()) {
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+	m.reloadFuncs = append(m.reloadFuncs, f)
+}
+
+func (m *Manager) reload() {
+	for {
+		select {
+		case <-m.context.Done():
+			signal.Stop(m.reloadChan)
+			return
+		case <-m.reloadChan:
+			m.reloadLock.Lock()
+			for _, f := range m.reloadFuncs {
+				f()
+			}
+			m.reloadLock.Unlock()
+		}
+	}
+}
+
 func (m *Manager) Wait() {
 	<-m.termChan
+	m.termLock.Lock()
+	for _, f := range m.termFuncs {
+		f()
+	}
+	m.termLock.Unlock()
 	close(m.doneChan)
 	m.cancel()
 	m.waitGroup.Wait()
@@ -0,0 +1,146 @@
+package settings
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry is a singleton that holds the runtime-tunable settings of the service. Settings are read from the environment,
+// overridden by the KEY=VALUE lines of the file named by SETTINGS_FILE, which is re-read when the service is reloaded.
+type Registry struct {
+	lock      sync.Mutex
+	overrides atomic.Pointer[map[string]string]
+}
+
+// Change is a setting whose effective value differs after a reload
+type Change struct {
+	Key  string
+	From string
+	To   string
+}
+
+var registry *Registry
+var once sync.Once
+
+// GetRegistry returns the singleton instance of Registry
+func GetRegistry() *Registry {
+	once.Do(func() {
+		registry = &Registry{}
+		registry.overrides.Store(&map[string]string{})
+	})
+	return registry
+}
+
+// Lookup retrieves the effective value of a setting, preferring the settings file to the environment
+func Lookup(key string) (string, bool) {
+	return GetRegistry().Lookup(key)
+}
+
+// Lookup retrieves the effective value of a setting, preferring the settings file to the environment
+func (r *Registry) Lookup(key string) (string, bool) {
+	if v, ok := (*r.overrides.Load())[key]; ok {
+		return v, true
+	}
+	return os.LookupEnv(key)
+}
+
+// Load reads the settings file, and puts its settings in place at once. It returns the settings whose effective value
+// changed. When the file cannot be read, the settings in place are kept.
+func (r *Registry) Load() ([]Change, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	overrides := make(map[string]string)
+	if path, ok := os.LookupEnv("SETTINGS_FILE"); ok && path != "" {
+		var err error
+		overrides, err = read(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	previous := *r.overrides.Load()
+	keys := make(map[string]struct{})
+	for k := range previous {
+		keys[k] = struct{}{}
+	}
+	for k := range overrides {
+		keys[k] = struct{}{}
+	}
+	changes := make([]Change, 0)
+	for k := range keys {
+		from := effective(previous, k)
+		to := effective(overrides, k)
+		if from != to {
+			changes = append(changes, Change{Key: k, From: from, To: to})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	r.overrides.Store(&overrides)
+	return changes, nil
+}
+
+func effective(overrides map[string]string, key string) string {
+	if v, ok := overrides[key]; ok {
+		return v
+	}
+	return os.Getenv(key)
+}
+
+// read parses a file of KEY=VALUE lines. Blank lines and lines starting with # are ignored.
+func read(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("%s:%d is not a KEY=VALUE setting", path, n)
+		}
+		values[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return values, s.Err()
+}
+
+// Reload re-reads the settings file, and logs the settings which changed. Secrets are not logged.
+func Reload(l logrus.FieldLogger) func() {
+	return func() {
+		changes, err := GetRegistry().Load()
+		if err != nil {
+			l.WithError(err).Errorf("Unable to reload settings. Keeping the settings in place.")
+			return
+		}
+		if len(changes) == 0 {
+			l.Infof("Reloaded settings. Nothing changed.")
+			return
+		}
+		for _, c := range changes {
+			l.Infof("Reloaded setting [%s] from [%s] to [%s].", c.Key, redact(c.Key, c.From), redact(c.Key, c.To))
+		}
+	}
+}
+
+func redact(key string, value string) string {
+	for _, s := range []string{"PASSWORD", "SECRET", "TOKEN"} {
+		if strings.Contains(key, s) && value != "" {
+			return "***"
+		}
+	}
+	return value
+}
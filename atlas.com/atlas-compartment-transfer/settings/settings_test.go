@@ -0,0 +1,70 @@
+package settings_test
+
+import (
+	"atlas-compartment-transfer/settings"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSettings(t *testing.T, path string, content string) {
+	t.Helper()
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("Unable to write settings: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings")
+	t.Setenv("SETTINGS_FILE", path)
+	t.Setenv("LOG_LEVEL", "info")
+	t.Setenv("TRACE_SAMPLE_RATIO", "1")
+	t.Cleanup(func() {
+		_ = os.Unsetenv("SETTINGS_FILE")
+		_, _ = settings.GetRegistry().Load()
+	})
+
+	writeSettings(t, path, "# tuned for the incident\nLOG_LEVEL = debug\n\nSHUTDOWN_DRAIN_TIMEOUT=30s\n")
+	changes, err := settings.GetRegistry().Load()
+	if err != nil {
+		t.Fatalf("Unable to load settings: %v", err)
+	}
+	expected := []settings.Change{
+		{Key: "LOG_LEVEL", From: "info", To: "debug"},
+		{Key: "SHUTDOWN_DRAIN_TIMEOUT", From: "", To: "30s"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected changes %v, got %v.", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("Expected changes %v, got %v.", expected, changes)
+		}
+	}
+	if v, _ := settings.Lookup("LOG_LEVEL"); v != "debug" {
+		t.Fatalf("Expected the settings file to override the environment, got [%s].", v)
+	}
+	if v, _ := settings.Lookup("TRACE_SAMPLE_RATIO"); v != "1" {
+		t.Fatalf("Expected settings missing from the file to fall back to the environment, got [%s].", v)
+	}
+
+	// Removing a setting from the file restores the environment's value
+	writeSettings(t, path, "SHUTDOWN_DRAIN_TIMEOUT=30s\n")
+	changes, err = settings.GetRegistry().Load()
+	if err != nil {
+		t.Fatalf("Unable to load settings: %v", err)
+	}
+	if len(changes) != 1 || changes[0] != (settings.Change{Key: "LOG_LEVEL", From: "debug", To: "info"}) {
+		t.Fatalf("Expected LOG_LEVEL to revert to the environment, got %v.", changes)
+	}
+
+	// A file which cannot be parsed leaves the settings in place
+	writeSettings(t, path, "SHUTDOWN_DRAIN_TIMEOUT\n")
+	if _, err = settings.GetRegistry().Load(); err == nil {
+		t.Fatalf("Expected malformed settings to be rejected.")
+	}
+	if v, _ := settings.Lookup("SHUTDOWN_DRAIN_TIMEOUT"); v != "30s" {
+		t.Fatalf("Expected settings to be kept, got [%s].", v)
+	}
+}
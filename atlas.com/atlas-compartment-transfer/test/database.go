@@ -0,0 +1,41 @@
+package test
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+)
+
+// Database creates an in-memory SQLite database private to the test, and applies the migrations
+func Database(t *testing.T, migrations ...func(db *gorm.DB) error) *gorm.DB {
+	t.Helper()
+	db, err := OpenDatabase(migrations...)
+	if err != nil {
+		t.Fatalf("Unable to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		sqlDB, err := db.DB()
+		if err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// OpenDatabase creates a private, in-memory SQLite database, and applies the migrations. The caller closes it.
+func OpenDatabase(migrations ...func(db *gorm.DB) error) (*gorm.DB, error) {
+	dsn := "file:" + uuid.New().String() + "?mode=memory&cache=shared"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range migrations {
+		err = m(db)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
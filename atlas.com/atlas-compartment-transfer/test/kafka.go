@@ -0,0 +1,143 @@
+package test
+
+import (
+	producer2 "atlas-compartment-transfer/kafka/producer"
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-kafka/consumer"
+	"github.com/Chronicle20/atlas-kafka/handler"
+	"github.com/Chronicle20/atlas-kafka/producer"
+	"github.com/Chronicle20/atlas-kafka/topic"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"sync"
+)
+
+// ErrUndelivered is returned when dispatching does not settle, such as when handlers keep producing to each other
+var ErrUndelivered = errors.New("messages remain undelivered")
+
+const maxDeliveries = 1000
+
+// Bus is an in-memory stand-in for the Kafka brokers. Messages produced through it are queued until dispatched to the
+// handlers registered for their topic, and every message is kept so tests can inspect what was emitted. Topics are
+// resolved from their environment variable tokens, as they are in the service.
+type Bus struct {
+	l        logrus.FieldLogger
+	lock     sync.Mutex
+	queue    []kafka.Message
+	produced map[string][]kafka.Message
+	handlers map[string][]handler.Handler
+}
+
+// NewBus creates an empty bus
+func NewBus(l logrus.FieldLogger) *Bus {
+	return &Bus{
+		l:        l,
+		queue:    make([]kafka.Message, 0),
+		produced: make(map[string][]kafka.Message),
+		handlers: make(map[string][]handler.Handler),
+	}
+}
+
+// ProviderFactory emits onto the bus, decorating messages with the headers the Kafka producer would
+func (b *Bus) ProviderFactory(l logrus.FieldLogger) func(ctx context.Context) func(token string) producer.MessageProducer {
+	return func(ctx context.Context) func(token string) producer.MessageProducer {
+		decorators := producer2.HeaderDecorators(ctx)
+		return func(token string) producer.MessageProducer {
+			return func(provider model.Provider[[]kafka.Message]) error {
+				t, err := topic.EnvProvider(l)(token)()
+				if err != nil {
+					return err
+				}
+				ms, err := provider()
+				if err != nil {
+					return err
+				}
+				for i := range ms {
+					for _, d := range decorators {
+						hs, err := d()
+						if err != nil {
+							return err
+						}
+						for k, v := range hs {
+							ms[i].Headers = append(ms[i].Headers, kafka.Header{Key: k, Value: []byte(v)})
+						}
+					}
+				}
+				b.put(t, ms)
+				return nil
+			}
+		}
+	}
+}
+
+func (b *Bus) put(t string, ms []kafka.Message) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, m := range ms {
+		m.Topic = t
+		m.Partition = 0
+		m.Offset = int64(len(b.produced[t]))
+		b.produced[t] = append(b.produced[t], m)
+		b.queue = append(b.queue, m)
+	}
+}
+
+// Publish puts messages onto the topic of the token on behalf of another service, within the tenant of the context
+func (b *Bus) Publish(ctx context.Context, token string, provider model.Provider[[]kafka.Message]) error {
+	return b.ProviderFactory(b.l)(ctx)(token)(provider)
+}
+
+// RegisterHandler stands in for the consumer manager, recording the handler for the topic
+func (b *Bus) RegisterHandler(topic string, h handler.Handler) (string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.handlers[topic] = append(b.handlers[topic], h)
+	return strconv.Itoa(len(b.handlers[topic])) + "-" + uuid.New().String(), nil
+}
+
+// Dispatch delivers queued messages to the handlers registered for their topic, in the order they were produced, until
+// the queue is empty. Headers are parsed into the handler's context as the consumer would. Messages for topics without
+// handlers are dropped from the queue, but are still kept.
+func (b *Bus) Dispatch() error {
+	for i := 0; i < maxDeliveries; i++ {
+		m, hs, ok := b.next()
+		if !ok {
+			return nil
+		}
+		ctx := consumer.SpanHeaderParser(context.Background(), m.Headers)
+		ctx = consumer.TenantHeaderParser(ctx, m.Headers)
+		for _, h := range hs {
+			_, err := h(b.l, ctx, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return ErrUndelivered
+}
+
+func (b *Bus) next() (kafka.Message, []handler.Handler, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.queue) == 0 {
+		return kafka.Message{}, nil, false
+	}
+	m := b.queue[0]
+	b.queue = b.queue[1:]
+	return m, append([]handler.Handler(nil), b.handlers[m.Topic]...), true
+}
+
+// Messages returns every message produced to the topic of the token, oldest first
+func (b *Bus) Messages(token string) []kafka.Message {
+	t, err := topic.EnvProvider(b.l)(token)()
+	if err != nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]kafka.Message(nil), b.produced[t]...)
+}
@@ -0,0 +1,125 @@
+package test
+
+import (
+	"atlas-compartment-transfer/item"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const servicesPrefix = "/api/"
+
+// Asset is an asset held in a compartment the services stand in for
+type Asset struct {
+	Id          uint32
+	TemplateId  uint32
+	ReferenceId uint32
+}
+
+// Services is a local stand-in for the REST APIs of the character, configuration, data, and character and cash shop
+// inventory, services. It serves the characters, tenant configurations, items and compartments it is given, and points BASE_SERVICE_URL at itself for the duration of the test.
+type Services struct {
+	lock      sync.Mutex
+	resources map[string]interface{}
+	failing   bool
+}
+
+// NewServices starts a stand-in which serves nothing until characters, configurations, items and compartments are added
+func NewServices(t *testing.T) *Services {
+	t.Helper()
+	s := &Services{resources: make(map[string]interface{})}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	t.Setenv("BASE_SERVICE_URL", server.URL+servicesPrefix)
+	return s
+}
+
+// SetCompartment serves a compartment of the inventory type, and the assets it holds. Character compartments are served
+// for the character, and cash shop compartments for the account.
+func (s *Services) SetCompartment(inventoryType string, ownerId uint32, compartmentId uuid.UUID, compartmentType byte, capacity uint32, assets ...Asset) {
+	path := fmt.Sprintf("characters/%d/inventory/compartments/%s", ownerId, compartmentId)
+	if inventoryType == compartment.InventoryTypeCashShop {
+		path = fmt.Sprintf("accounts/%d/cash-shop/inventory/compartments/%s", ownerId, compartmentId)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	refs := make([]interface{}, 0, len(assets))
+	for _, a := range assets {
+		id := strconv.Itoa(int(a.Id))
+		refs = append(refs, map[string]interface{}{"type": "assets", "id": id})
+		s.resources[path+"/assets/"+id] = document("assets", id, map[string]interface{}{"templateId": a.TemplateId, "referenceId": a.ReferenceId}, nil)
+	}
+	s.resources[path] = document("compartments", compartmentId.String(), map[string]interface{}{"type": compartmentType, "capacity": capacity}, map[string]interface{}{
+		"assets": map[string]interface{}{"data": refs},
+	})
+}
+
+// SetCharacter serves a character belonging to the account
+func (s *Services) SetCharacter(characterId uint32, accountId uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id := strconv.Itoa(int(characterId))
+	s.resources["characters/"+id] = document("characters", id, map[string]interface{}{"accountId": accountId, "name": "Character" + id}, nil)
+}
+
+// SetConfiguration serves the attributes of the tenant's transfer configuration
+func (s *Services) SetConfiguration(tenantId uuid.UUID, attributes map[string]interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.resources["configurations/tenants/"+tenantId.String()+"/compartment-transfer"] = document("configurations", tenantId.String(), attributes, nil)
+}
+
+// SetItem serves the metadata of an item template carrying the flags
+func (s *Services) SetItem(templateId uint32, flags ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id := strconv.Itoa(int(templateId))
+	s.resources["data/items/"+id] = document("items", id, map[string]interface{}{
+		"tradeBlock":      slices.Contains(flags, item.FlagUntradeable),
+		"accountSharable": slices.Contains(flags, item.FlagAccountBound),
+		"quest":           slices.Contains(flags, item.FlagQuest),
+		"cash":            slices.Contains(flags, item.FlagCash),
+	}, nil)
+}
+
+// SetFailing makes every request fail, as though the services were unavailable
+func (s *Services) SetFailing(failing bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failing = failing
+}
+
+func (s *Services) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	doc, ok := s.resources[strings.TrimPrefix(r.URL.Path, servicesPrefix)]
+	failing := s.failing
+	s.lock.Unlock()
+
+	if failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if !ok || r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	_ = json.NewEncoder(w).Encode(doc)
+}
+
+func document(resourceType string, id string, attributes map[string]interface{}, relationships map[string]interface{}) map[string]interface{} {
+	data := map[string]interface{}{"type": resourceType, "id": id, "attributes": attributes}
+	if relationships != nil {
+		data["relationships"] = relationships
+	}
+	return map[string]interface{}{"data": data}
+}
@@ -0,0 +1,616 @@
+package simulator
+
+import (
+	"atlas-compartment-transfer/chaos"
+	"atlas-compartment-transfer/correlation"
+	"atlas-compartment-transfer/journal"
+	csCompartment "atlas-compartment-transfer/kafka/consumer/cashshop/compartment"
+	cCompartment "atlas-compartment-transfer/kafka/consumer/character/compartment"
+	tCompartment "atlas-compartment-transfer/kafka/consumer/compartment"
+	compartment3 "atlas-compartment-transfer/kafka/message/cashshop/compartment"
+	compartment2 "atlas-compartment-transfer/kafka/message/character/compartment"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"atlas-compartment-transfer/kafka/message/dlq"
+	"atlas-compartment-transfer/kafka/message/envelope"
+	producer2 "atlas-compartment-transfer/kafka/producer"
+	compartment4 "atlas-compartment-transfer/kafka/producer/compartment"
+	"atlas-compartment-transfer/ratelimit"
+	"atlas-compartment-transfer/test"
+	"atlas-compartment-transfer/transfer"
+	"context"
+	"encoding/json"
+	"github.com/Chronicle20/atlas-kafka/consumer"
+	"github.com/Chronicle20/atlas-kafka/handler"
+	"github.com/Chronicle20/atlas-kafka/producer"
+	"github.com/Chronicle20/atlas-kafka/topic"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ViolationDuplicated    = "DUPLICATED"
+	ViolationLost          = "LOST"
+	ViolationStuck         = "STUCK"
+	ViolationStateMismatch = "STATE_MISMATCH"
+
+	simulatedErrorCode = "SIMULATED"
+)
+
+// Config describes the load driven through the service, and how the simulated compartment services behave
+type Config struct {
+	// Transfers is the number of transfers submitted
+	Transfers int
+	// Rate is the number of transfers submitted per second. Zero submits them as fast as they are handled.
+	Rate float64
+	// Workers is the number of messages the service handles at once, as its consumers of several partitions would
+	Workers int
+	// AcceptLatency and ReleaseLatency are the mean time a compartment takes to act upon a command
+	AcceptLatency  time.Duration
+	ReleaseLatency time.Duration
+	// ErrorRate is the probability a compartment reports an error instead of acting upon a command
+	ErrorRate float64
+	// DuplicateRate is the probability a message is delivered twice
+	DuplicateRate float64
+	// Chaos injects faults into the topics the service produces to and consumes from, as its staging chaos mode does
+	Chaos *chaos.Config
+	// Timeout bounds how long to wait for every transfer to finish
+	Timeout time.Duration
+	Seed    int64
+}
+
+// DefaultConfig is a modest, error-free load
+func DefaultConfig() Config {
+	return Config{
+		Transfers:      1000,
+		Workers:        8,
+		AcceptLatency:  5 * time.Millisecond,
+		ReleaseLatency: 5 * time.Millisecond,
+		Timeout:        time.Minute,
+		Seed:           time.Now().UnixNano(),
+	}
+}
+
+// Violation is a transfer whose asset did not end up where the state of its saga says it should be
+type Violation struct {
+	TransactionId uuid.UUID `json:"transactionId"`
+	Kind          string    `json:"kind"`
+	State         string    `json:"state"`
+	AtSource      bool      `json:"atSource"`
+	AtDestination bool      `json:"atDestination"`
+}
+
+// Report summarizes a simulation
+type Report struct {
+	Transfers   int           `json:"transfers"`
+	Completed   int           `json:"completed"`
+	Failed      int           `json:"failed"`
+	Unfinished  int           `json:"unfinished"`
+	DeadLetters int           `json:"deadLetters"`
+	Duplicates  int           `json:"duplicates"`
+	Elapsed     time.Duration `json:"elapsed"`
+	Throughput  float64       `json:"throughput"`
+	P50         time.Duration `json:"p50"`
+	P90         time.Duration `json:"p90"`
+	P99         time.Duration `json:"p99"`
+	Max         time.Duration `json:"max"`
+	Violations  []Violation   `json:"violations"`
+}
+
+// record tracks a transfer, and the asset it moves, as the simulated compartments see it
+type record struct {
+	submittedAt   time.Time
+	finishedAt    time.Time
+	outcome       string
+	atSource      bool
+	atDestination bool
+	accepted      string
+	released      string
+}
+
+// Simulator stands in for the brokers and for the character and cash shop compartment services. It drives the service's
+// real handlers and processor, over an in-memory database, with as many messages in flight at once as it has workers.
+type Simulator struct {
+	l         logrus.FieldLogger
+	config    Config
+	ctx       context.Context
+	workers   chan struct{}
+	inflight  atomic.Int64
+	lock      sync.Mutex
+	rand      *rand.Rand
+	handlers  map[string][]handler.Handler
+	transfers map[uuid.UUID]*record
+	dead      int
+	dupes     int
+}
+
+// New creates a simulator. Topics without an environment variable are named after their token.
+func New(l logrus.FieldLogger, config Config) (*Simulator, error) {
+	for _, token := range []string{
+		compartment.EnvCommandTopicCompartmentTransfer,
+		compartment.EnvEventTopicStatus,
+		compartment2.EnvCommandTopic,
+		compartment2.EnvEventTopicStatus,
+		compartment3.EnvCommandTopic,
+		compartment3.EnvEventTopicStatus,
+		dlq.EnvTopic,
+	} {
+		if _, ok := os.LookupEnv(token); !ok {
+			_ = os.Setenv(token, token)
+		}
+	}
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+
+	t, err := tenant.Create(uuid.New(), "GMS", 83, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &Simulator{
+		l:         l,
+		config:    config,
+		ctx:       tenant.WithContext(context.Background(), t),
+		workers:   make(chan struct{}, config.Workers),
+		rand:      rand.New(rand.NewSource(config.Seed)),
+		handlers:  make(map[string][]handler.Handler),
+		transfers: make(map[uuid.UUID]*record),
+	}, nil
+}
+
+// Run submits the configured transfers, waits for them to finish, and checks where every asset ended up
+func (s *Simulator) Run() (Report, error) {
+	db, err := test.OpenDatabase(journal.Migration, ratelimit.Migration)
+	if err != nil {
+		return Report{}, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return Report{}, err
+	}
+	defer sqlDB.Close()
+	// SQLite serializes writers. A single connection queues them, rather than failing them as locked.
+	sqlDB.SetMaxOpenConns(1)
+
+	pf := producer2.ProviderFactory(s.ProviderFactory)
+	rf := s.RegisterHandler
+	if s.config.Chaos != nil {
+		pf = chaos.ProviderFactory(s.config.Chaos)(pf)
+		rf = s.countHandlers(chaos.RegisterHandler(s.l)(s.config.Chaos)(rf))
+	}
+	tf := transfer.NewProcessorFactory(db, pf, transfer.NewTransactionCache())
+	tCompartment.InitHandlers(s.l)(pf)(tf)(rf)
+	cCompartment.InitHandlers(s.l)(pf)(tf)(rf)
+	csCompartment.InitHandlers(s.l)(pf)(tf)(rf)
+
+	start := time.Now()
+	err = s.submit()
+	if err != nil {
+		return Report{}, err
+	}
+	if !s.settle() {
+		s.l.Warnf("Timed out waiting for transfers to finish.")
+	}
+	return s.report(tf, time.Since(start)), nil
+}
+
+// settle waits until no message has been in flight for long enough that none can still be held back by chaos, or the
+// timeout passes
+func (s *Simulator) settle() bool {
+	quiet := time.Duration(0)
+	if s.config.Chaos != nil {
+		quiet = s.config.Chaos.MaxDelay() + 100*time.Millisecond
+	}
+	deadline := time.After(s.config.Timeout)
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+
+	var idleSince time.Time
+	for {
+		select {
+		case <-deadline:
+			return false
+		case <-ticker.C:
+			if s.inflight.Load() > 0 {
+				idleSince = time.Time{}
+				continue
+			}
+			if idleSince.IsZero() {
+				idleSince = time.Now()
+			}
+			if time.Since(idleSince) >= quiet {
+				return true
+			}
+		}
+	}
+}
+
+// submit publishes the transfer commands, alternating the direction of the transfers
+func (s *Simulator) submit() error {
+	var pace <-chan time.Time
+	if s.config.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / s.config.Rate))
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+
+	for i := 0; i < s.config.Transfers; i++ {
+		if pace != nil {
+			<-pace
+		}
+		from, to := compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop
+		if i%2 == 1 {
+			from, to = to, from
+		}
+		cmd := compartment.TransferCommand{
+			TransactionId:       uuid.New(),
+			AccountId:           uint32(1000 + i),
+			CharacterId:         uint32(1 + i),
+			AssetId:             uint32(i),
+			FromCompartmentId:   uuid.New(),
+			FromCompartmentType: 1,
+			FromInventoryType:   from,
+			ToCompartmentId:     uuid.New(),
+			ToCompartmentType:   1,
+			ToInventoryType:     to,
+			ReferenceId:         uint32(i),
+		}
+
+		s.lock.Lock()
+		s.transfers[cmd.TransactionId] = &record{submittedAt: time.Now(), atSource: true}
+		s.lock.Unlock()
+
+		err := s.ProviderFactory(s.l)(s.ctx)(compartment.EnvCommandTopicCompartmentTransfer)(compartment4.TransferCommandProvider(cmd))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProviderFactory routes messages the service emits. Commands go to the simulated compartments, and everything else is
+// delivered to the handlers registered for its topic. Messages are decorated with the headers the Kafka producer would set.
+func (s *Simulator) ProviderFactory(l logrus.FieldLogger) func(ctx context.Context) func(token string) producer.MessageProducer {
+	return func(ctx context.Context) func(token string) producer.MessageProducer {
+		decorators := producer2.HeaderDecorators(ctx)
+		return func(token string) producer.MessageProducer {
+			return func(provider model.Provider[[]kafka.Message]) error {
+				t, err := topic.EnvProvider(l)(token)()
+				if err != nil {
+					return err
+				}
+				ms, err := provider()
+				if err != nil {
+					return err
+				}
+				for i := range ms {
+					for _, d := range decorators {
+						hs, err := d()
+						if err != nil {
+							return err
+						}
+						for k, v := range hs {
+							ms[i].Headers = append(ms[i].Headers, kafka.Header{Key: k, Value: []byte(v)})
+						}
+					}
+					ms[i].Topic = t
+					s.route(token, ms[i])
+				}
+				return nil
+			}
+		}
+	}
+}
+
+// RegisterHandler stands in for the consumer manager, recording the handler for the topic
+func (s *Simulator) RegisterHandler(topic string, h handler.Handler) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers[topic] = append(s.handlers[topic], h)
+	return uuid.New().String(), nil
+}
+
+func (s *Simulator) route(token string, m kafka.Message) {
+	switch token {
+	case compartment.EnvEventTopicStatus:
+		s.completed(m)
+	case dlq.EnvTopic:
+		s.lock.Lock()
+		s.dead++
+		s.lock.Unlock()
+	case compartment2.EnvCommandTopic:
+		s.duplicated(func() { s.spawn(func() { s.characterCompartment(m) }) })
+	case compartment3.EnvCommandTopic:
+		s.duplicated(func() { s.spawn(func() { s.cashShopCompartment(m) }) })
+	default:
+		s.duplicated(func() { s.spawn(func() { s.deliver(token, m) }) })
+	}
+}
+
+// duplicated runs a delivery, and runs it again with the configured probability
+func (s *Simulator) duplicated(f func()) {
+	f()
+	if s.chance(s.config.DuplicateRate) {
+		s.lock.Lock()
+		s.dupes++
+		s.lock.Unlock()
+		f()
+	}
+}
+
+func (s *Simulator) spawn(f func()) {
+	s.inflight.Add(1)
+	go func() {
+		defer s.inflight.Add(-1)
+		f()
+	}()
+}
+
+// countHandlers decorates a handler registration function so that handlers count as in flight while they run, even when
+// chaos runs them after their delivery has returned
+func (s *Simulator) countHandlers(rf func(topic string, handler handler.Handler) (string, error)) func(topic string, handler handler.Handler) (string, error) {
+	return func(topic string, h handler.Handler) (string, error) {
+		return rf(topic, func(l logrus.FieldLogger, ctx context.Context, msg kafka.Message) (bool, error) {
+			s.inflight.Add(1)
+			defer s.inflight.Add(-1)
+			return h(l, ctx, msg)
+		})
+	}
+}
+
+// deliver runs the handlers registered for the topic, parsing the headers into their context as the consumer would
+func (s *Simulator) deliver(token string, m kafka.Message) {
+	s.workers <- struct{}{}
+	defer func() { <-s.workers }()
+
+	s.lock.Lock()
+	hs := append([]handler.Handler(nil), s.handlers[m.Topic]...)
+	s.lock.Unlock()
+
+	ctx := consumer.SpanHeaderParser(context.Background(), m.Headers)
+	ctx = consumer.TenantHeaderParser(ctx, m.Headers)
+	for _, h := range hs {
+		_, _ = h(s.l, ctx, m)
+	}
+
+	// The saga emits nothing when it fails, so a transfer has finished once its error event was handled
+	if token == compartment2.EnvEventTopicStatus || token == compartment3.EnvEventTopicStatus {
+		var e struct {
+			Type string `json:"type"`
+			Body struct {
+				TransactionId uuid.UUID `json:"transactionId"`
+			} `json:"body"`
+		}
+		if json.Unmarshal(m.Value, &e) == nil && e.Type == compartment2.StatusEventTypeError {
+			s.finish(e.Body.TransactionId, string(transfer.StateFailed))
+		}
+	}
+}
+
+func (s *Simulator) completed(m kafka.Message) {
+	e, err := envelope.Decoder[compartment.StatusEvent[compartment.StatusEventCompletedBody]](compartment.StatusEventVersions)(m.Value)
+	if err != nil || e.Type != compartment.StatusEventTypeCompleted {
+		return
+	}
+	s.finish(e.Body.TransactionId, string(transfer.StateCompleted))
+}
+
+func (s *Simulator) finish(transactionId uuid.UUID, outcome string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, ok := s.transfers[transactionId]
+	if !ok || r.outcome != "" {
+		return
+	}
+	r.outcome = outcome
+	r.finishedAt = time.Now()
+}
+
+// act applies a compartment command to the asset of the transfer. Compartments are idempotent by transaction id, so a
+// repeated command reports the status it reported the first time.
+func (s *Simulator) act(accept bool, transactionId uuid.UUID) (string, bool) {
+	latency := s.config.ReleaseLatency
+	if accept {
+		latency = s.config.AcceptLatency
+	}
+	time.Sleep(s.jitter(latency))
+	failed := s.chance(s.config.ErrorRate)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, ok := s.transfers[transactionId]
+	if !ok {
+		return "", false
+	}
+	if accept {
+		if r.accepted == "" {
+			r.accepted = compartment2.StatusEventTypeAccepted
+			if failed {
+				r.accepted = compartment2.StatusEventTypeError
+			} else {
+				r.atDestination = true
+			}
+		}
+		return r.accepted, true
+	}
+	if r.released == "" {
+		r.released = compartment2.StatusEventTypeReleased
+		if failed {
+			r.released = compartment2.StatusEventTypeError
+		} else {
+			r.atSource = false
+		}
+	}
+	return r.released, true
+}
+
+func (s *Simulator) characterCompartment(m kafka.Message) {
+	var c compartment2.Command[commandBody]
+	err := decodeCommand(compartment2.CommandVersions, m, &c)
+	if err != nil {
+		return
+	}
+	eventType, ok := s.act(c.Type == compartment2.CommandAccept, c.Body.TransactionId)
+	if !ok {
+		return
+	}
+
+	e := compartment2.StatusEvent[compartment2.ErrorEventBody]{
+		CharacterId: c.CharacterId,
+		Type:        eventType,
+		Body:        compartment2.ErrorEventBody{TransactionId: c.Body.TransactionId},
+	}
+	if eventType == compartment2.StatusEventTypeError {
+		e.Body.ErrorCode = simulatedErrorCode
+	}
+	s.reply(m, compartment2.EnvEventTopicStatus, e)
+}
+
+func (s *Simulator) cashShopCompartment(m kafka.Message) {
+	var c compartment3.Command[commandBody]
+	err := decodeCommand(compartment3.CommandVersions, m, &c)
+	if err != nil {
+		return
+	}
+	eventType, ok := s.act(c.Type == compartment3.CommandAccept, c.Body.TransactionId)
+	if !ok {
+		return
+	}
+
+	e := compartment3.StatusEvent[compartment3.StatusEventErrorBody]{
+		CompartmentType: c.CompartmentType,
+		Type:            eventType,
+		Body:            compartment3.StatusEventErrorBody{TransactionId: c.Body.TransactionId},
+	}
+	if eventType == compartment3.StatusEventTypeError {
+		e.Body.ErrorCode = simulatedErrorCode
+	}
+	s.reply(m, compartment3.EnvEventTopicStatus, e)
+}
+
+// commandBody is the part of an accept or release command body the simulated compartments act upon
+type commandBody struct {
+	TransactionId uuid.UUID `json:"transactionId"`
+}
+
+// decodeCommand upcasts a compartment command, and decodes it
+func decodeCommand(r *envelope.Registry, m kafka.Message, v interface{}) error {
+	b, err := r.Upcast(m.Value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// reply publishes a status event on behalf of a compartment, within the tenant of the command, and caused by it
+func (s *Simulator) reply(m kafka.Message, token string, value interface{}) {
+	ctx := consumer.TenantHeaderParser(context.Background(), m.Headers)
+	ctx = correlation.WithCausationId(ctx, correlation.MessageId(m))
+	err := s.ProviderFactory(s.l)(ctx)(token)(producer.SingleMessageProvider(m.Key, value))
+	if err != nil {
+		s.l.WithError(err).Errorf("Unable to publish simulated status event.")
+	}
+}
+
+func (s *Simulator) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rand.Float64() < p
+}
+
+// jitter spreads a latency evenly between half and one and a half times its mean
+func (s *Simulator) jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return d/2 + time.Duration(s.rand.Float64()*float64(d))
+}
+
+// report checks the state of every saga against where its asset ended up
+func (s *Simulator) report(tf transfer.ProcessorFactory, elapsed time.Duration) Report {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r := Report{
+		Transfers:   len(s.transfers),
+		DeadLetters: s.dead,
+		Duplicates:  s.dupes,
+		Elapsed:     elapsed,
+		Violations:  make([]Violation, 0),
+	}
+	latencies := make([]time.Duration, 0, len(s.transfers))
+	for transactionId, t := range s.transfers {
+		switch t.outcome {
+		case string(transfer.StateCompleted):
+			r.Completed++
+		case string(transfer.StateFailed):
+			r.Failed++
+		default:
+			r.Unfinished++
+		}
+		if t.outcome != "" {
+			latencies = append(latencies, t.finishedAt.Sub(t.submittedAt))
+		}
+
+		state := ""
+		if m, err := tf(s.l, s.ctx).ByTransactionIdProvider(transactionId)(); err == nil {
+			state = string(m.State())
+		}
+		if kind := violation(transfer.State(state), t); kind != "" {
+			r.Violations = append(r.Violations, Violation{TransactionId: transactionId, Kind: kind, State: state, AtSource: t.atSource, AtDestination: t.atDestination})
+		}
+	}
+
+	if elapsed > 0 {
+		r.Throughput = float64(len(latencies)) / elapsed.Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.P50 = percentile(latencies, 50)
+	r.P90 = percentile(latencies, 90)
+	r.P99 = percentile(latencies, 99)
+	r.Max = percentile(latencies, 100)
+	return r
+}
+
+// violation reports how the asset of a transfer is misplaced, given the state of its saga. A completed transfer's asset
+// is only at the destination; any other finished transfer's asset is only at the source.
+func violation(state transfer.State, t *record) string {
+	switch {
+	case t.atSource && t.atDestination:
+		return ViolationDuplicated
+	case !t.atSource && !t.atDestination:
+		return ViolationLost
+	case !state.Terminal():
+		return ViolationStuck
+	case state == transfer.StateCompleted && !t.atDestination:
+		return ViolationStateMismatch
+	case state != transfer.StateCompleted && !t.atSource:
+		return ViolationStateMismatch
+	}
+	return ""
+}
+
+// percentile is the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (p*len(sorted)+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,85 @@
+package simulator_test
+
+import (
+	"atlas-compartment-transfer/chaos"
+	compartment3 "atlas-compartment-transfer/kafka/message/cashshop/compartment"
+	compartment2 "atlas-compartment-transfer/kafka/message/character/compartment"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"atlas-compartment-transfer/test/simulator"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+	"time"
+)
+
+func run(t *testing.T, c simulator.Config) simulator.Report {
+	t.Helper()
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	s, err := simulator.New(l, c)
+	if err != nil {
+		t.Fatalf("Unable to create simulator: %v", err)
+	}
+	r, err := s.Run()
+	if err != nil {
+		t.Fatalf("Unable to run simulation: %v", err)
+	}
+	for _, v := range r.Violations {
+		t.Errorf("Transfer [%s] violated [%s] in state [%s].", v.TransactionId, v.Kind, v.State)
+	}
+	return r
+}
+
+func TestSimulatedTransfersComplete(t *testing.T) {
+	c := simulator.DefaultConfig()
+	c.Transfers = 50
+	c.AcceptLatency = time.Millisecond
+	c.ReleaseLatency = time.Millisecond
+	c.DuplicateRate = 0.2
+	c.Timeout = 30 * time.Second
+	c.Seed = 1
+
+	r := run(t, c)
+	if r.Completed != c.Transfers {
+		t.Fatalf("Expected [%d] transfers to complete, got [%d] (%d failed, %d unfinished).", c.Transfers, r.Completed, r.Failed, r.Unfinished)
+	}
+	if r.Duplicates == 0 {
+		t.Fatalf("Expected messages to be delivered twice.")
+	}
+}
+
+func TestSimulatedTransfersFail(t *testing.T) {
+	c := simulator.DefaultConfig()
+	c.Transfers = 20
+	c.AcceptLatency = time.Millisecond
+	c.ReleaseLatency = time.Millisecond
+	c.ErrorRate = 1
+	c.Timeout = 30 * time.Second
+	c.Seed = 1
+
+	r := run(t, c)
+	if r.Failed != c.Transfers {
+		t.Fatalf("Expected [%d] transfers to fail, got [%d] (%d completed, %d unfinished).", c.Transfers, r.Failed, r.Completed, r.Unfinished)
+	}
+}
+
+func TestSimulatedTransfersSurviveChaos(t *testing.T) {
+	c := simulator.DefaultConfig()
+	c.Transfers = 50
+	c.AcceptLatency = time.Millisecond
+	c.ReleaseLatency = time.Millisecond
+	c.Timeout = 30 * time.Second
+	c.Seed = 1
+	faults := chaos.Faults{Duplicate: 0.2, Reorder: 0.2, Delay: 0.2}
+	c.Chaos = chaos.NewConfig(c.Seed).SetMaxDelay(20*time.Millisecond).
+		SetFaults(compartment.EnvCommandTopicCompartmentTransfer, faults).
+		SetFaults(compartment2.EnvCommandTopic, faults).
+		SetFaults(compartment2.EnvEventTopicStatus, faults).
+		SetFaults(compartment3.EnvCommandTopic, faults).
+		SetFaults(compartment3.EnvEventTopicStatus, faults)
+
+	r := run(t, c)
+	if r.Completed != c.Transfers {
+		t.Fatalf("Expected [%d] transfers to complete, got [%d] (%d failed, %d unfinished).", c.Transfers, r.Completed, r.Failed, r.Unfinished)
+	}
+}
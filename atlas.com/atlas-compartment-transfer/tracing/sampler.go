@@ -0,0 +1,40 @@
+package tracing
+
+import (
+	"github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"sync/atomic"
+)
+
+// ratioSampler samples new traces at a ratio which can be changed while the tracer runs
+type ratioSampler struct {
+	current atomic.Pointer[sdktrace.Sampler]
+}
+
+var sampler = newRatioSampler(defaultSampleRatio)
+
+func newRatioSampler(ratio float64) *ratioSampler {
+	s := &ratioSampler{}
+	s.set(ratio)
+	return s
+}
+
+func (s *ratioSampler) set(ratio float64) {
+	rs := sdktrace.TraceIDRatioBased(ratio)
+	s.current.Store(&rs)
+}
+
+func (s *ratioSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return (*s.current.Load()).ShouldSample(p)
+}
+
+func (s *ratioSampler) Description() string {
+	return (*s.current.Load()).Description()
+}
+
+// Reload applies the TRACE_SAMPLE_RATIO setting to the tracer
+func Reload(l logrus.FieldLogger) func() {
+	return func() {
+		sampler.set(sampleRatio(l))
+	}
+}
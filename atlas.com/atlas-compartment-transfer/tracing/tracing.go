@@ -1,29 +1,106 @@
 package tracing
 
 import (
+	"atlas-compartment-transfer/settings"
+	"context"
 	"fmt"
 	"github.com/opentracing/opentracing-go"
 	"github.com/sirupsen/logrus"
-	"github.com/uber/jaeger-client-go/config"
+	"go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel"
+	otBridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"os"
+	"strconv"
+	"time"
 )
 
+const defaultSampleRatio = 1.0
+
+// InitTracer installs an OpenTelemetry tracer which exports spans over OTLP. It is bridged to the global opentracing
+// tracer, and propagates the Jaeger uber-trace-id header alongside W3C trace context, so spans continue to join up with
+// the services which still propagate Jaeger headers.
 func InitTracer(l logrus.FieldLogger) func(serviceName string) (io.Closer, error) {
 	return func(serviceName string) (io.Closer, error) {
-		jaegerHostPort := os.Getenv("JAEGER_HOST_PORT")
-		cfg := &config.Configuration{
-			ServiceName: serviceName,
-			Sampler:     &config.SamplerConfig{Type: "const", Param: 1},
-			Reporter:    &config.ReporterConfig{LogSpans: true, LocalAgentHostPort: jaegerHostPort},
+		ctx := context.Background()
+		sampler.set(sampleRatio(l))
+
+		opts := make([]otlptracegrpc.Option, 0)
+		if _, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_ENDPOINT"); !ok {
+			opts = append(opts, otlptracegrpc.WithEndpoint(os.Getenv("JAEGER_HOST_PORT")), otlptracegrpc.WithInsecure())
 		}
-		tracer, closer, err := cfg.NewTracer(config.Logger(LogrusAdapter{logger: l}))
+		exporter, err := otlptracegrpc.New(ctx, opts...)
 		if err != nil {
 			return nil, err
 		}
-		opentracing.SetGlobalTracer(tracer)
-		return closer, nil
+
+		res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+		if err != nil {
+			return nil, err
+		}
+
+		tp := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(res),
+			sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		)
+		Install(l)(serviceName)(tp)
+		return closer{tp: tp}, nil
+	}
+}
+
+// Install bridges the tracer provider to the global opentracing tracer, and sets the propagators spans are carried
+// across messages by
+func Install(l logrus.FieldLogger) func(serviceName string) func(tp trace.TracerProvider) {
+	return func(serviceName string) func(tp trace.TracerProvider) {
+		return func(tp trace.TracerProvider) {
+			propagator := propagation.NewCompositeTextMapPropagator(jaeger.Jaeger{}, propagation.TraceContext{}, propagation.Baggage{})
+			bt, wtp := otBridge.NewTracerPair(tp.Tracer(serviceName))
+			bt.SetTextMapPropagator(propagator)
+			bt.SetWarningHandler(func(msg string) {
+				l.Debugf("Tracing bridge: %s", msg)
+			})
+
+			otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+				l.WithError(err).Warnf("Tracing error.")
+			}))
+			otel.SetTextMapPropagator(propagator)
+			otel.SetTracerProvider(wtp)
+			opentracing.SetGlobalTracer(bt)
+		}
+	}
+}
+
+// sampleRatio reads the fraction of new traces to sample from TRACE_SAMPLE_RATIO. Spans continuing a trace follow the
+// sampling decision of their parent.
+func sampleRatio(l logrus.FieldLogger) float64 {
+	v, ok := settings.Lookup("TRACE_SAMPLE_RATIO")
+	if !ok {
+		return defaultSampleRatio
 	}
+	r, err := strconv.ParseFloat(v, 64)
+	if err != nil || r < 0 || r > 1 {
+		l.Warnf("Invalid TRACE_SAMPLE_RATIO [%s], sampling every trace.", v)
+		return defaultSampleRatio
+	}
+	return r
+}
+
+// closer flushes and stops the tracer provider
+type closer struct {
+	tp *sdktrace.TracerProvider
+}
+
+func (c closer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	defer cancel()
+	return c.tp.Shutdown(ctx)
 }
 
 func Teardown(l logrus.FieldLogger) func(tc io.Closer) func() {
@@ -37,18 +114,6 @@ func Teardown(l logrus.FieldLogger) func(tc io.Closer) func() {
 	}
 }
 
-type LogrusAdapter struct {
-	logger logrus.FieldLogger
-}
-
-func (l LogrusAdapter) Error(msg string) {
-	l.logger.Error(msg)
-}
-
-func (l LogrusAdapter) Infof(msg string, args ...interface{}) {
-	l.logger.Infof(msg, args)
-}
-
 func StartSpan(l logrus.FieldLogger, name string, opts ...opentracing.StartSpanOption) (logrus.FieldLogger, opentracing.Span) {
 	span := opentracing.StartSpan(name, opts...)
 	sl := l.WithField("span.id", fmt.Sprintf("%v", span))
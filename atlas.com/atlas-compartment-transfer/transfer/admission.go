@@ -0,0 +1,54 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/configuration"
+	"atlas-compartment-transfer/journal"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"atlas-compartment-transfer/metrics"
+	"atlas-compartment-transfer/ratelimit"
+	"fmt"
+)
+
+// admit verifies the tenant has enabled transfers between the command's inventory types, and that the account has not
+// reached the tenant's cap on transfers in progress, which are counted from the journal. It returns a Rejection when the
+// transfer is refused, and any other error when the journal could not be read.
+func (p *ProcessorImpl) admit(cmd compartment.TransferCommand, cfg configuration.Model) error {
+	if !cfg.Enabled(cmd.FromInventoryType, cmd.ToInventoryType) {
+		return Rejection{Reason: compartment.RejectedReasonRouteNotEnabled, Detail: fmt.Sprintf("transfers from [%s] to [%s] are not enabled for the tenant", cmd.FromInventoryType, cmd.ToInventoryType)}
+	}
+
+	if cfg.MaxInFlight() > 0 {
+		n, err := journal.NewProcessor(p.l, p.ctx, p.db).InFlightCountByAccountProvider(cmd.AccountId)(startedStates(), terminalStates())()
+		if err != nil {
+			return err
+		}
+		if n >= int64(cfg.MaxInFlight()) {
+			metrics.RateLimited.WithLabelValues(p.t.Id().String(), metrics.LimitInFlight).Inc()
+			return Rejection{Reason: compartment.RejectedReasonRateLimited, Detail: fmt.Sprintf("account [%d] has [%d] of [%d] transfers in progress", cmd.AccountId, n, cfg.MaxInFlight())}
+		}
+	}
+	return nil
+}
+
+// throttle takes a transfer from the tenant's rates for the account and the character, which every instance shares. It
+// is the last check a transfer passes, so transfers refused otherwise do not use up the rates. It returns a Rejection
+// when either rate is exceeded, and the error when the rates cannot be taken from.
+func (p *ProcessorImpl) throttle(cmd compartment.TransferCommand, cfg configuration.Model) error {
+	tenantId := p.t.Id().String()
+	scope, ok, err := ratelimit.GetLimiter().Allow(p.db.WithContext(p.ctx))(p.t.Id(),
+		ratelimit.Limit{Scope: ratelimit.ScopeAccount, Id: cmd.AccountId, Rate: cfg.AccountRate()},
+		ratelimit.Limit{Scope: ratelimit.ScopeCharacter, Id: cmd.CharacterId, Rate: cfg.CharacterRate()},
+	)
+	if err != nil {
+		return err
+	}
+	if !ok && scope == ratelimit.ScopeAccount {
+		metrics.RateLimited.WithLabelValues(tenantId, metrics.LimitAccountRate).Inc()
+		return Rejection{Reason: compartment.RejectedReasonRateLimited, Detail: fmt.Sprintf("account [%d] exceeded [%d] transfers every [%s]", cmd.AccountId, cfg.AccountRate().Count(), cfg.AccountRate().Per())}
+	}
+	if !ok {
+		metrics.RateLimited.WithLabelValues(tenantId, metrics.LimitCharacterRate).Inc()
+		return Rejection{Reason: compartment.RejectedReasonRateLimited, Detail: fmt.Sprintf("character [%d] exceeded [%d] transfers every [%s]", cmd.CharacterId, cfg.CharacterRate().Count(), cfg.CharacterRate().Per())}
+	}
+	return nil
+}
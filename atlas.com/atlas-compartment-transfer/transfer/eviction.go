@@ -0,0 +1,51 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/settings"
+	"context"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL  = 5 * time.Minute
+	evictionInterval = 30 * time.Second
+)
+
+// StartEviction periodically evicts transfers which have not been touched for TRANSFER_CACHE_TTL from the cache, until the
+// context is done. Another instance may have advanced them since, so they are read afresh from the journal when next needed.
+func StartEviction(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup) func(cache *TransactionCache) {
+	return func(cache *TransactionCache) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(evictionInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if n := cache.Evict(CacheTTL(l)); n > 0 {
+						l.Debugf("Evicted [%d] compartment transfers from the cache.", n)
+					}
+				}
+			}
+		}()
+	}
+}
+
+// CacheTTL reads how long a transfer is cached without being touched from TRANSFER_CACHE_TTL
+func CacheTTL(l logrus.FieldLogger) time.Duration {
+	v, ok := settings.Lookup("TRANSFER_CACHE_TTL")
+	if !ok {
+		return defaultCacheTTL
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		l.Warnf("Invalid TRANSFER_CACHE_TTL [%s], using [%s].", v, defaultCacheTTL)
+		return defaultCacheTTL
+	}
+	return d
+}
@@ -0,0 +1,155 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/configuration"
+	"atlas-compartment-transfer/journal"
+	"atlas-compartment-transfer/kafka/message"
+	compartment2 "atlas-compartment-transfer/kafka/message/character/compartment"
+	compartment7 "atlas-compartment-transfer/kafka/message/currency/compartment"
+	compartment8 "atlas-compartment-transfer/kafka/producer/currency/compartment"
+	"atlas-compartment-transfer/metrics"
+	"github.com/google/uuid"
+	"time"
+)
+
+// createChargeStep creates a step function for asking the currency compartment to debit the fee of a transfer
+func (p *ProcessorImpl) createChargeStep(m Model) TransactionStep {
+	return func(mb *message.Buffer) error {
+		p.l.Debugf("Charging fee of [%d] to currency compartment [%s] for transfer [%s].", m.FeeAmount(), m.FeeCompartmentId(), m.TransactionId())
+		return mb.Put(compartment7.EnvCommandTopic, compartment8.DebitCommandProvider(m.AccountId(), m.CharacterId(), m.FeeCompartmentId(), m.TransactionId(), m.FeeAmount()))
+	}
+}
+
+// createRefundStep creates a step function for asking the currency compartment to credit the fee of a transfer back
+func (p *ProcessorImpl) createRefundStep(m Model) TransactionStep {
+	return func(mb *message.Buffer) error {
+		p.l.Debugf("Refunding fee of [%d] to currency compartment [%s] for transfer [%s].", m.FeeAmount(), m.FeeCompartmentId(), m.TransactionId())
+		return mb.Put(compartment7.EnvCommandTopic, compartment8.CreditCommandProvider(m.AccountId(), m.CharacterId(), m.FeeCompartmentId(), m.TransactionId(), m.FeeAmount()))
+	}
+}
+
+// HandleDebited handles the debited status event, starting to move the asset now its fee is charged
+func (p *ProcessorImpl) HandleDebited(mb *message.Buffer) func(transactionId uuid.UUID) error {
+	return func(transactionId uuid.UUID) error {
+		tp := p.withLogger(TransactionDecorator(transactionId))
+		tp.l.Debugf("Fee charged for transfer. TransferId: [%s]", transactionId)
+
+		info, exists, err := tp.expectTransferInfo(transactionId, StateCharging)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return tp.unknownTransaction(transactionId, compartment7.StatusEventTypeDebited)
+		}
+		tp = tp.withLogger(InfoDecorator(info))
+		if info.State != StateCharging {
+			tp.l.Debugf("Ignoring debit of transfer [%s] in state [%s].", transactionId, info.State)
+			return nil
+		}
+
+		next, commandType, inventoryType := StateAccepting, compartment2.CommandAccept, info.ToInventoryType
+		if info.Ordering == configuration.OrderingReleaseFirst {
+			next, commandType, inventoryType = StateReleasing, compartment2.CommandRelease, info.FromInventoryType
+		}
+		staged, err := tp.stage(info.Start)
+		if err != nil {
+			return err
+		}
+		entries, err := tp.awaiting(next,
+			eventConsumedEntry(p.t, transactionId, compartment7.StatusEventTypeDebited, causationId(p.ctx)),
+			stateChangedEntry(p.t, transactionId, info.State, next),
+			commandEmittedEntry(p.t, transactionId, commandType, inventoryType, causationId(p.ctx)),
+		)
+		if err != nil {
+			return err
+		}
+		sequence, err := tp.record(info.Sequence, entries...)
+		if err != nil {
+			return tp.journalFailed(transactionId, err)
+		}
+		mb.PutAll(staged)
+		info.State = next
+		info.Sequence = sequence
+		info.StepStartedAt = time.Now()
+		p.cache.Store(transactionId, info)
+		return nil
+	}
+}
+
+// HandleDebitedAndEmit handles the debited status event and emits messages
+func (p *ProcessorImpl) HandleDebitedAndEmit(transactionId uuid.UUID) error {
+	return p.emitInSaga(SpanDebited, transactionId, func(sp *ProcessorImpl) func(mb *message.Buffer) error {
+		return func(mb *message.Buffer) error {
+			return sp.HandleDebited(mb)(transactionId)
+		}
+	})
+}
+
+// HandleCredited handles the credited status event, failing the transfer now its fee is refunded
+func (p *ProcessorImpl) HandleCredited(mb *message.Buffer) func(transactionId uuid.UUID) error {
+	return func(transactionId uuid.UUID) error {
+		tp := p.withLogger(TransactionDecorator(transactionId))
+		tp.l.Debugf("Fee refunded for transfer. TransferId: [%s]", transactionId)
+
+		info, exists, err := tp.expectTransferInfo(transactionId, StateRefunding)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return tp.unknownTransaction(transactionId, compartment7.StatusEventTypeCredited)
+		}
+		tp = tp.withLogger(InfoDecorator(info))
+		if info.State != StateRefunding {
+			tp.l.Debugf("Ignoring credit of transfer [%s] in state [%s].", transactionId, info.State)
+			return nil
+		}
+
+		_, err = tp.record(info.Sequence,
+			eventConsumedEntry(p.t, transactionId, compartment7.StatusEventTypeCredited, causationId(p.ctx)),
+			stateChangedEntry(p.t, transactionId, info.State, StateFailed),
+		)
+		if err != nil {
+			return tp.journalFailed(transactionId, err)
+		}
+
+		p.cache.Delete(transactionId)
+		tp.finished(info, metrics.OutcomeFailed)
+		return nil
+	}
+}
+
+// HandleCreditedAndEmit handles the credited status event and emits messages
+func (p *ProcessorImpl) HandleCreditedAndEmit(transactionId uuid.UUID) error {
+	return p.emitInSaga(SpanCredited, transactionId, func(sp *ProcessorImpl) func(mb *message.Buffer) error {
+		return func(mb *message.Buffer) error {
+			return sp.HandleCredited(mb)(transactionId)
+		}
+	})
+}
+
+// refund asks the currency compartment to credit back the fee of a transfer whose asset could not be moved. The entries
+// given are journaled ahead of the state change, which records the reason for the refund, if any.
+func (p *ProcessorImpl) refund(mb *message.Buffer, transactionId uuid.UUID, info TransferInfo, reason string, entries ...journal.Model) error {
+	entries, err := p.awaiting(StateRefunding, append(entries,
+		refundingEntry(p.t, transactionId, info.State, reason),
+		commandEmittedEntry(p.t, transactionId, compartment7.CommandCredit, "", causationId(p.ctx)),
+	)...)
+	if err != nil {
+		return err
+	}
+	staged, err := p.stage(info.Refund)
+	if err != nil {
+		return err
+	}
+	sequence, err := p.record(info.Sequence, entries...)
+	if err != nil {
+		return p.journalFailed(transactionId, err)
+	}
+	mb.PutAll(staged)
+	p.l.Warnf("Compartment transfer [%s] could not be moved in state [%s]. Refunding its fee of [%d].", transactionId, info.State, info.FeeAmount)
+	info.State = StateRefunding
+	info.Sequence = sequence
+	info.StepStartedAt = time.Now()
+	p.cache.Store(transactionId, info)
+	return nil
+}
@@ -0,0 +1,48 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/journal"
+	"atlas-compartment-transfer/metrics"
+	"context"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+const inFlightInterval = 30 * time.Second
+
+// StartInFlightGauge periodically gauges the transfers in flight from the journal, until the context is done
+func StartInFlightGauge(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			GaugeInFlight(l, ctx, db)
+			ticker := time.NewTicker(inFlightInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					GaugeInFlight(l, ctx, db)
+				}
+			}
+		}()
+	}
+}
+
+// GaugeInFlight sets the gauge of transfers in flight of each tenant to the count the journal holds. Transfers are
+// advanced by whichever instance consumes their events, so every instance gauges the transfers of every instance.
+func GaugeInFlight(l logrus.FieldLogger, ctx context.Context, db *gorm.DB) {
+	counts, err := journal.InFlightCountProvider(ctx, db)(startedStates(), terminalStates())()
+	if err != nil {
+		l.WithError(err).Errorf("Unable to count the in-flight compartment transfers.")
+		return
+	}
+	metrics.InFlightTransfers.Reset()
+	for tenantId, count := range counts {
+		metrics.InFlightTransfers.WithLabelValues(tenantId.String()).Set(float64(count))
+	}
+}
@@ -0,0 +1,152 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/configuration"
+	"atlas-compartment-transfer/correlation"
+	"atlas-compartment-transfer/journal"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+)
+
+// ErrIncompleteJournal is returned when a journal cannot be folded into a transfer because it does not begin with the command
+var ErrIncompleteJournal = errors.New("journal does not begin with a received command")
+
+func commandReceivedEntry(t tenant.Model, cmd compartment.TransferCommand) journal.Model {
+	payload, _ := json.Marshal(cmd)
+	return journal.NewBuilder(t, cmd.TransactionId, journal.KindCommandReceived).SetAccountId(cmd.AccountId).SetPayload(payload).Build()
+}
+
+func commandEmittedEntry(t tenant.Model, transactionId uuid.UUID, commandType string, inventoryType string, causationId string) journal.Model {
+	payload, _ := json.Marshal(journal.CommandEmittedBody{Type: commandType, InventoryType: inventoryType, CorrelationId: transactionId.String(), CausationId: causationId})
+	return journal.NewBuilder(t, transactionId, journal.KindCommandEmitted).SetPayload(payload).Build()
+}
+
+func eventConsumedEntry(t tenant.Model, transactionId uuid.UUID, eventType string, messageId string) journal.Model {
+	payload, _ := json.Marshal(journal.EventConsumedBody{Type: eventType, MessageId: messageId})
+	return journal.NewBuilder(t, transactionId, journal.KindEventConsumed).SetPayload(payload).Build()
+}
+
+func stateChangedEntry(t tenant.Model, transactionId uuid.UUID, from State, to State) journal.Model {
+	payload, _ := json.Marshal(journal.StateChangedBody{From: string(from), To: string(to)})
+	return journal.NewBuilder(t, transactionId, journal.KindStateChanged).SetState(string(to)).SetPayload(payload).Build()
+}
+
+func rejectedEntry(t tenant.Model, transactionId uuid.UUID, from State, reason string, ruleId string) journal.Model {
+	payload, _ := json.Marshal(journal.StateChangedBody{From: string(from), To: string(StateRejected), Reason: reason, RuleId: ruleId})
+	return journal.NewBuilder(t, transactionId, journal.KindStateChanged).SetState(string(StateRejected)).SetPayload(payload).Build()
+}
+
+func timedOutEntry(t tenant.Model, transactionId uuid.UUID, from State) journal.Model {
+	payload, _ := json.Marshal(journal.StateChangedBody{From: string(from), To: string(StateFailed), Reason: ReasonTimedOut})
+	return journal.NewBuilder(t, transactionId, journal.KindStateChanged).SetState(string(StateFailed)).SetPayload(payload).Build()
+}
+
+func refundingEntry(t tenant.Model, transactionId uuid.UUID, from State, reason string) journal.Model {
+	payload, _ := json.Marshal(journal.StateChangedBody{From: string(from), To: string(StateRefunding), Reason: reason})
+	return journal.NewBuilder(t, transactionId, journal.KindStateChanged).SetState(string(StateRefunding)).SetPayload(payload).Build()
+}
+
+func sagaStartedEntry(t tenant.Model, transactionId uuid.UUID, traceContext map[string]string) journal.Model {
+	payload, _ := json.Marshal(journal.SagaStartedBody{TraceContext: traceContext})
+	return journal.NewBuilder(t, transactionId, journal.KindSagaStarted).SetPayload(payload).Build()
+}
+
+// causationId is the id of the message being handled, if any
+func causationId(ctx context.Context) string {
+	id, _ := correlation.CausationId(ctx)
+	return id
+}
+
+// Replay rebuilds a transfer from its journal, oldest entry first, returning the state of the transfer after each entry
+// was applied
+func Replay(entries []journal.Model) ([]Model, error) {
+	if len(entries) == 0 || entries[0].Kind() != journal.KindCommandReceived {
+		return nil, ErrIncompleteJournal
+	}
+
+	var cmd compartment.TransferCommand
+	err := json.Unmarshal(entries[0].Payload(), &cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	b := NewBuilder(entries[0].Tenant(), cmd.TransactionId).
+		SetAccountId(cmd.AccountId).
+		SetCharacterId(cmd.CharacterId).
+		SetAssetId(cmd.AssetId).
+		SetReferenceId(cmd.ReferenceId).
+		SetFrom(cmd.FromCompartmentId, cmd.FromCompartmentType, cmd.FromInventoryType).
+		SetTo(cmd.ToCompartmentId, cmd.ToCompartmentType, cmd.ToInventoryType).
+		SetCreatedAt(entries[0].CreatedAt())
+	if cmd.Fee != nil {
+		b.SetFee(cmd.Fee.Amount, cmd.Fee.CompartmentId)
+	}
+	if cmd.DeliverAt != nil {
+		b.SetDeliverAt(*cmd.DeliverAt)
+	}
+
+	// The first state the saga entered once it started, and charged its fee, shows which compartment it asked to act first. The command of each step is issued
+	// again when the step times out, so each issue after the state last changed is an attempt.
+	ordered := false
+	attempts := 0
+	results := make([]Model, 0, len(entries))
+	for _, e := range entries {
+		if e.Kind() == journal.KindStateChanged {
+			if !ordered && State(e.State()) != StateScheduled && State(e.State()) != StateCharging {
+				if State(e.State()) == StateReleasing {
+					b.SetOrdering(configuration.OrderingReleaseFirst)
+				}
+				ordered = true
+			}
+			attempts = 0
+			b.SetState(State(e.State()))
+		}
+		if e.Kind() == journal.KindCommandEmitted {
+			attempts++
+		}
+		b.SetAttempts(attempts)
+		if e.Kind() == journal.KindSagaStarted {
+			var body journal.SagaStartedBody
+			if json.Unmarshal(e.Payload(), &body) == nil {
+				b.SetTraceContext(body.TraceContext)
+			}
+		}
+		b.SetSequence(e.Sequence())
+		b.SetUpdatedAt(e.CreatedAt())
+		results = append(results, b.Build())
+	}
+	return results, nil
+}
+
+// Fold derives the current state of a transfer from its journal, oldest entry first. The transfer was last updated,
+// and its timeout clock last restarted, by the final entry.
+func Fold(entries []journal.Model) (Model, error) {
+	ms, err := Replay(entries)
+	if err != nil {
+		return Model{}, err
+	}
+	return ms[len(ms)-1], nil
+}
+
+// groupByTransaction splits interleaved journal entries into one journal per transfer, preserving the order in which the
+// transfers were received
+func groupByTransaction(entries []journal.Model) [][]journal.Model {
+	var order []uuid.UUID
+	journals := make(map[uuid.UUID][]journal.Model)
+	for _, e := range entries {
+		if _, ok := journals[e.TransactionId()]; !ok {
+			order = append(order, e.TransactionId())
+		}
+		journals[e.TransactionId()] = append(journals[e.TransactionId()], e)
+	}
+
+	results := make([][]journal.Model, 0, len(order))
+	for _, id := range order {
+		results = append(results, journals[id])
+	}
+	return results
+}
@@ -0,0 +1,74 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// TenantDecorator adds the tenant to every line logged
+func TenantDecorator(t tenant.Model) model.Decorator[logrus.FieldLogger] {
+	return func(l logrus.FieldLogger) logrus.FieldLogger {
+		return l.WithField("tenant.id", t.Id().String())
+	}
+}
+
+// TransactionDecorator adds the transaction id to every line logged, for transfers whose details are not yet known
+func TransactionDecorator(transactionId uuid.UUID) model.Decorator[logrus.FieldLogger] {
+	return func(l logrus.FieldLogger) logrus.FieldLogger {
+		return l.WithField("transaction.id", transactionId.String())
+	}
+}
+
+// CommandDecorator adds the fields identifying the transfer a command requests
+func CommandDecorator(cmd compartment.TransferCommand) model.Decorator[logrus.FieldLogger] {
+	return func(l logrus.FieldLogger) logrus.FieldLogger {
+		return l.WithFields(logrus.Fields{
+			"transaction.id":               cmd.TransactionId.String(),
+			"character.id":                 cmd.CharacterId,
+			"account.id":                   cmd.AccountId,
+			"transfer.from_inventory_type": cmd.FromInventoryType,
+			"transfer.to_inventory_type":   cmd.ToInventoryType,
+		})
+	}
+}
+
+// ModelDecorator adds the fields identifying the transfer, and the state of its saga
+func ModelDecorator(m Model) model.Decorator[logrus.FieldLogger] {
+	return func(l logrus.FieldLogger) logrus.FieldLogger {
+		return l.WithFields(logrus.Fields{
+			"transaction.id":               m.TransactionId().String(),
+			"character.id":                 m.CharacterId(),
+			"account.id":                   m.AccountId(),
+			"transfer.from_inventory_type": m.FromInventoryType(),
+			"transfer.to_inventory_type":   m.ToInventoryType(),
+			"transfer.state":               string(m.State()),
+		})
+	}
+}
+
+// InfoDecorator adds the fields identifying a transfer in progress, and the state of its saga
+func InfoDecorator(info TransferInfo) model.Decorator[logrus.FieldLogger] {
+	return func(l logrus.FieldLogger) logrus.FieldLogger {
+		return l.WithFields(logrus.Fields{
+			"character.id":                 info.CharacterId,
+			"account.id":                   info.AccountId,
+			"transfer.from_inventory_type": info.FromInventoryType,
+			"transfer.to_inventory_type":   info.ToInventoryType,
+			"transfer.state":               string(info.State),
+		})
+	}
+}
+
+// withLogger creates a processor which logs through the decorated logger
+func (p *ProcessorImpl) withLogger(decorators ...model.Decorator[logrus.FieldLogger]) *ProcessorImpl {
+	l := p.l
+	for _, d := range decorators {
+		l = d(l)
+	}
+	c := *p
+	c.l = l
+	return &c
+}
@@ -0,0 +1,315 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/configuration"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"time"
+)
+
+// State identifies how far a transfer saga has progressed
+type State string
+
+const (
+	// StateScheduled indicates the transfer is to be delivered later, and has not started
+	StateScheduled State = "SCHEDULED"
+	// StateCharging indicates the currency compartment has been asked to debit the transfer's fee
+	StateCharging State = "CHARGING"
+	// StateAccepting indicates the destination compartment has been asked to accept the asset
+	StateAccepting State = "ACCEPTING"
+	// StateReleasing indicates the source compartment has been asked to release the asset
+	StateReleasing State = "RELEASING"
+	// StateRefunding indicates the asset could not be moved, and the currency compartment has been asked to credit the fee
+	// it was charged back
+	StateRefunding State = "REFUNDING"
+	// StateCompleted indicates the asset has been moved
+	StateCompleted State = "COMPLETED"
+	// StateFailed indicates a compartment reported an error
+	StateFailed State = "FAILED"
+	// StateAborted indicates an operator stopped the saga before it finished
+	StateAborted State = "ABORTED"
+	// StateRejected indicates the transfer was refused before any compartment was asked to act
+	StateRejected State = "REJECTED"
+	// StateCancelled indicates the transfer was cancelled before it was due to start
+	StateCancelled State = "CANCELLED"
+)
+
+// Terminal reports whether the saga has nothing left to do in this state
+func (s State) Terminal() bool {
+	return s == StateCompleted || s == StateFailed || s == StateAborted || s == StateRejected || s == StateCancelled
+}
+
+// terminalStates are the states the journals of finished transfers hold, for finding the transfers still in flight
+func terminalStates() []string {
+	return []string{string(StateCompleted), string(StateFailed), string(StateAborted), string(StateRejected), string(StateCancelled)}
+}
+
+// startedStates are the states the journals of transfers which have asked a compartment to act hold, for telling them
+// apart from scheduled transfers
+func startedStates() []string {
+	return []string{string(StateCharging), string(StateAccepting), string(StateReleasing), string(StateRefunding)}
+}
+
+// Model is the persisted state of a transfer saga
+type Model struct {
+	tenant              tenant.Model
+	transactionId       uuid.UUID
+	accountId           uint32
+	characterId         uint32
+	assetId             uint32
+	referenceId         uint32
+	fromCompartmentId   uuid.UUID
+	fromCompartmentType byte
+	fromInventoryType   string
+	toCompartmentId     uuid.UUID
+	toCompartmentType   byte
+	toInventoryType     string
+	feeAmount           uint32
+	feeCompartmentId    uuid.UUID
+	deliverAt           time.Time
+	state               State
+	ordering            string
+	attempts            int
+	traceContext        map[string]string
+	sequence            uint32
+	createdAt           time.Time
+	updatedAt           time.Time
+}
+
+func (m Model) Tenant() tenant.Model {
+	return m.tenant
+}
+
+func (m Model) TransactionId() uuid.UUID {
+	return m.transactionId
+}
+
+func (m Model) AccountId() uint32 {
+	return m.accountId
+}
+
+func (m Model) CharacterId() uint32 {
+	return m.characterId
+}
+
+func (m Model) AssetId() uint32 {
+	return m.assetId
+}
+
+func (m Model) ReferenceId() uint32 {
+	return m.referenceId
+}
+
+func (m Model) FromCompartmentId() uuid.UUID {
+	return m.fromCompartmentId
+}
+
+func (m Model) FromCompartmentType() byte {
+	return m.fromCompartmentType
+}
+
+func (m Model) FromInventoryType() string {
+	return m.fromInventoryType
+}
+
+func (m Model) ToCompartmentId() uuid.UUID {
+	return m.toCompartmentId
+}
+
+func (m Model) ToCompartmentType() byte {
+	return m.toCompartmentType
+}
+
+func (m Model) ToInventoryType() string {
+	return m.toInventoryType
+}
+
+// FeeAmount is the fee charged for the transfer. No fee is charged when it is zero.
+func (m Model) FeeAmount() uint32 {
+	return m.feeAmount
+}
+
+// FeeCompartmentId is the currency compartment the fee is charged to
+func (m Model) FeeCompartmentId() uuid.UUID {
+	return m.feeCompartmentId
+}
+
+// DeliverAt is the time the transfer is to start at. The transfer starts once it is received when it is zero.
+func (m Model) DeliverAt() time.Time {
+	return m.deliverAt
+}
+
+func (m Model) State() State {
+	return m.state
+}
+
+// Ordering is which of the compartments the saga asked to act first
+func (m Model) Ordering() string {
+	return m.ordering
+}
+
+// Attempts is how many times the command of the pending step has been issued
+func (m Model) Attempts() int {
+	return m.attempts
+}
+
+// TraceContext is the span headers of the saga's root span, which the spans of each step are children of
+func (m Model) TraceContext() map[string]string {
+	return m.traceContext
+}
+
+// Sequence is the sequence of the last journal entry the transfer was derived from
+func (m Model) Sequence() uint32 {
+	return m.sequence
+}
+
+func (m Model) CreatedAt() time.Time {
+	return m.createdAt
+}
+
+// UpdatedAt is when the saga last made progress, and is the start of its timeout clock
+func (m Model) UpdatedAt() time.Time {
+	return m.updatedAt
+}
+
+// Builder constructs a Model
+type Builder struct {
+	tenant              tenant.Model
+	transactionId       uuid.UUID
+	accountId           uint32
+	characterId         uint32
+	assetId             uint32
+	referenceId         uint32
+	fromCompartmentId   uuid.UUID
+	fromCompartmentType byte
+	fromInventoryType   string
+	toCompartmentId     uuid.UUID
+	toCompartmentType   byte
+	toInventoryType     string
+	feeAmount           uint32
+	feeCompartmentId    uuid.UUID
+	deliverAt           time.Time
+	state               State
+	ordering            string
+	attempts            int
+	traceContext        map[string]string
+	sequence            uint32
+	createdAt           time.Time
+	updatedAt           time.Time
+}
+
+func NewBuilder(t tenant.Model, transactionId uuid.UUID) *Builder {
+	return &Builder{
+		tenant:        t,
+		transactionId: transactionId,
+		state:         StateAccepting,
+		ordering:      configuration.OrderingAcceptFirst,
+	}
+}
+
+func (b *Builder) SetAccountId(accountId uint32) *Builder {
+	b.accountId = accountId
+	return b
+}
+
+func (b *Builder) SetCharacterId(characterId uint32) *Builder {
+	b.characterId = characterId
+	return b
+}
+
+func (b *Builder) SetAssetId(assetId uint32) *Builder {
+	b.assetId = assetId
+	return b
+}
+
+func (b *Builder) SetReferenceId(referenceId uint32) *Builder {
+	b.referenceId = referenceId
+	return b
+}
+
+func (b *Builder) SetFrom(compartmentId uuid.UUID, compartmentType byte, inventoryType string) *Builder {
+	b.fromCompartmentId = compartmentId
+	b.fromCompartmentType = compartmentType
+	b.fromInventoryType = inventoryType
+	return b
+}
+
+func (b *Builder) SetTo(compartmentId uuid.UUID, compartmentType byte, inventoryType string) *Builder {
+	b.toCompartmentId = compartmentId
+	b.toCompartmentType = compartmentType
+	b.toInventoryType = inventoryType
+	return b
+}
+
+func (b *Builder) SetFee(amount uint32, compartmentId uuid.UUID) *Builder {
+	b.feeAmount = amount
+	b.feeCompartmentId = compartmentId
+	return b
+}
+
+func (b *Builder) SetDeliverAt(deliverAt time.Time) *Builder {
+	b.deliverAt = deliverAt
+	return b
+}
+
+func (b *Builder) SetState(state State) *Builder {
+	b.state = state
+	return b
+}
+
+func (b *Builder) SetOrdering(ordering string) *Builder {
+	b.ordering = ordering
+	return b
+}
+
+func (b *Builder) SetAttempts(attempts int) *Builder {
+	b.attempts = attempts
+	return b
+}
+
+func (b *Builder) SetTraceContext(traceContext map[string]string) *Builder {
+	b.traceContext = traceContext
+	return b
+}
+
+func (b *Builder) SetSequence(sequence uint32) *Builder {
+	b.sequence = sequence
+	return b
+}
+
+func (b *Builder) SetCreatedAt(createdAt time.Time) *Builder {
+	b.createdAt = createdAt
+	return b
+}
+
+func (b *Builder) SetUpdatedAt(updatedAt time.Time) *Builder {
+	b.updatedAt = updatedAt
+	return b
+}
+
+func (b *Builder) Build() Model {
+	return Model{
+		tenant:              b.tenant,
+		transactionId:       b.transactionId,
+		accountId:           b.accountId,
+		characterId:         b.characterId,
+		assetId:             b.assetId,
+		referenceId:         b.referenceId,
+		fromCompartmentId:   b.fromCompartmentId,
+		fromCompartmentType: b.fromCompartmentType,
+		fromInventoryType:   b.fromInventoryType,
+		toCompartmentId:     b.toCompartmentId,
+		toCompartmentType:   b.toCompartmentType,
+		toInventoryType:     b.toInventoryType,
+		feeAmount:           b.feeAmount,
+		feeCompartmentId:    b.feeCompartmentId,
+		deliverAt:           b.deliverAt,
+		state:               b.state,
+		ordering:            b.ordering,
+		attempts:            b.attempts,
+		traceContext:        b.traceContext,
+		sequence:            b.sequence,
+		createdAt:           b.createdAt,
+		updatedAt:           b.updatedAt,
+	}
+}
@@ -0,0 +1,124 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/character"
+	"atlas-compartment-transfer/inventory"
+	"atlas-compartment-transfer/item"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"atlas-compartment-transfer/policy"
+	"atlas-compartment-transfer/rest"
+	"errors"
+	"fmt"
+)
+
+// ErrChecksUnavailable is returned when the character or inventory services cannot say whether a transfer would pass
+// its checks. The transfer is not started unchecked, so the command is retried or dead-lettered.
+var ErrChecksUnavailable = errors.New("transfer cannot be checked")
+
+// ErrPolicyUnavailable is returned when the transfer policy cannot be evaluated, as the item metadata it needs could not
+// be retrieved, or the asset it is evaluated against could not.
+var ErrPolicyUnavailable = errors.New("transfer policy cannot be evaluated")
+
+// Rejection is returned when a transfer is refused before any compartment is asked to act. Its reason is reported in the
+// REJECTED status event.
+type Rejection struct {
+	Reason string
+	RuleId string
+	Detail string
+}
+
+func (r Rejection) Error() string {
+	return fmt.Sprintf("transfer rejected [%s]: %s", r.Reason, r.Detail)
+}
+
+// preflight verifies the character belongs to the account, and the source holds the asset, then asks the inventory
+// services whether the destination has room for the asset, and allows assets of its type, and finally evaluates the
+// transfer policy of the tenant. It returns a Rejection when a check fails, and an error wrapping ErrChecksUnavailable
+// when the services could not say. Nothing is checked when BASE_SERVICE_URL is not set. Should the tenant have a policy,
+// any error wraps ErrPolicyUnavailable.
+func (p *ProcessorImpl) preflight(cmd compartment.TransferCommand) error {
+	// The policy is evaluated against the asset, so a transfer the tenant has rules for is not started unchecked
+	rules := policy.GetRegistry().RulesFor(p.t.Id())
+	unavailable := func(err error) error {
+		if len(rules) == 0 {
+			return fmt.Errorf("%w: %w", ErrChecksUnavailable, err)
+		}
+		return fmt.Errorf("%w: %w: %w", ErrPolicyUnavailable, ErrChecksUnavailable, err)
+	}
+
+	if _, ok := rest.BaseUrl(); !ok {
+		if len(rules) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%w: BASE_SERVICE_URL is not set", ErrPolicyUnavailable)
+	}
+
+	c, err := character.NewProcessor(p.l, p.ctx).ByIdProvider(cmd.CharacterId)()
+	if errors.Is(err, rest.ErrNotFound) {
+		return Rejection{Reason: compartment.RejectedReasonCharacterNotOwned, Detail: fmt.Sprintf("character [%d] does not exist", cmd.CharacterId)}
+	}
+	if err != nil {
+		return unavailable(err)
+	}
+	if c.AccountId() != cmd.AccountId {
+		return Rejection{Reason: compartment.RejectedReasonCharacterNotOwned, Detail: fmt.Sprintf("character [%d] belongs to account [%d], not [%d]", cmd.CharacterId, c.AccountId(), cmd.AccountId)}
+	}
+
+	ip := inventory.NewProcessor(p.l, p.ctx)
+	asset, err := ip.AssetProvider(cmd.FromInventoryType, owner(cmd, cmd.FromInventoryType), cmd.FromCompartmentId, cmd.AssetId)()
+	if errors.Is(err, rest.ErrNotFound) {
+		return Rejection{Reason: compartment.RejectedReasonAssetNotHeld, Detail: fmt.Sprintf("[%s] compartment [%s] does not hold asset [%d]", cmd.FromInventoryType, cmd.FromCompartmentId, cmd.AssetId)}
+	}
+	if err != nil {
+		return unavailable(err)
+	}
+	if asset.ReferenceId() != cmd.ReferenceId {
+		return Rejection{Reason: compartment.RejectedReasonAssetNotHeld, Detail: fmt.Sprintf("asset [%d] of [%s] compartment [%s] has reference [%d], not [%d]", cmd.AssetId, cmd.FromInventoryType, cmd.FromCompartmentId, asset.ReferenceId(), cmd.ReferenceId)}
+	}
+
+	to, err := ip.CompartmentProvider(cmd.ToInventoryType, owner(cmd, cmd.ToInventoryType), cmd.ToCompartmentId)()
+	if errors.Is(err, rest.ErrNotFound) {
+		return Rejection{Reason: compartment.RejectedReasonUnknownDestination, Detail: fmt.Sprintf("[%s] compartment [%s] does not exist", cmd.ToInventoryType, cmd.ToCompartmentId)}
+	}
+	if err != nil {
+		return unavailable(err)
+	}
+	if to.Free() == 0 {
+		return Rejection{Reason: compartment.RejectedReasonNoCapacity, Detail: fmt.Sprintf("[%s] compartment [%s] holds [%d] of [%d] assets", cmd.ToInventoryType, cmd.ToCompartmentId, to.Assets(), to.Capacity())}
+	}
+	if !to.Allows(asset.TemplateId()) {
+		return Rejection{Reason: compartment.RejectedReasonAssetNotAllowed, Detail: fmt.Sprintf("[%s] compartment [%s] of type [%d] does not hold template [%d]", cmd.ToInventoryType, cmd.ToCompartmentId, to.Type(), asset.TemplateId())}
+	}
+	return p.evaluatePolicy(cmd, rules, asset.TemplateId())
+}
+
+// evaluatePolicy rejects the transfer when the first of the rules to apply to it denies it. The item metadata is only
+// retrieved when a rule matches on flags.
+func (p *ProcessorImpl) evaluatePolicy(cmd compartment.TransferCommand, rules []policy.Rule, templateId uint32) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	s := policy.Subject{TemplateId: templateId, FromInventoryType: cmd.FromInventoryType, ToInventoryType: cmd.ToInventoryType}
+	if policy.NeedsFlags(rules) {
+		i, err := item.NewProcessor(p.l, p.ctx).ByTemplateIdProvider(templateId)()
+		if err != nil {
+			return fmt.Errorf("%w: unable to retrieve item [%d]: %w", ErrPolicyUnavailable, templateId, err)
+		}
+		s.Flags = i.Flags()
+	}
+
+	if r, denied := policy.Evaluate(rules)(s); denied {
+		return Rejection{Reason: compartment.RejectedReasonPolicyDenied, RuleId: r.Id, Detail: fmt.Sprintf("rule [%s] denies moving template [%d] from [%s] to [%s]", r.Id, templateId, cmd.FromInventoryType, cmd.ToInventoryType)}
+	}
+	return nil
+}
+
+// owner identifies who owns the compartments of the inventory type. Characters own their inventories, and accounts own
+// their cash shop inventories.
+func owner(cmd compartment.TransferCommand, inventoryType string) uint32 {
+	if inventoryType == compartment.InventoryTypeCashShop {
+		return cmd.AccountId
+	}
+	return cmd.CharacterId
+}
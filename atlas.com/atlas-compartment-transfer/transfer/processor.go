@@ -1,18 +1,39 @@
 package transfer
 
 import (
+	"atlas-compartment-transfer/configuration"
+	"atlas-compartment-transfer/journal"
 	"atlas-compartment-transfer/kafka/message"
 	compartment4 "atlas-compartment-transfer/kafka/message/cashshop/compartment"
 	compartment2 "atlas-compartment-transfer/kafka/message/character/compartment"
 	"atlas-compartment-transfer/kafka/message/compartment"
+	compartment7 "atlas-compartment-transfer/kafka/message/currency/compartment"
 	"atlas-compartment-transfer/kafka/producer"
 	compartment5 "atlas-compartment-transfer/kafka/producer/cashshop/compartment"
 	compartment3 "atlas-compartment-transfer/kafka/producer/character/compartment"
 	compartment6 "atlas-compartment-transfer/kafka/producer/compartment"
+	"atlas-compartment-transfer/metrics"
 	"context"
+	"errors"
+	"fmt"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/Chronicle20/atlas-tenant"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidCommand is returned when a transfer command cannot be acted upon
+	ErrInvalidCommand = errors.New("invalid transfer command")
+	// ErrUnknownTransaction is returned when a status event references a transaction which is not in progress
+	ErrUnknownTransaction = errors.New("unknown transaction")
+	// ErrNotAbortable is returned when aborting a transfer which a compartment has already moved the asset for
+	ErrNotAbortable = errors.New("transfer cannot be aborted")
+	// ErrNotScheduled is returned when cancelling a transfer which is not waiting to be delivered
+	ErrNotScheduled = errors.New("transfer is not scheduled")
 )
 
 // TransactionStep represents the next step in the transfer saga
@@ -20,21 +41,41 @@ type TransactionStep func(mb *message.Buffer) error
 
 // TransferInfo holds information about a transfer
 type TransferInfo struct {
-	Step              TransactionStep
+	// Step is the step taken once the compartment asked first has answered
+	Step TransactionStep
+	// Start is the first step of moving the asset, taken once the fee is charged
+	Start TransactionStep
+	// Refund credits the fee back, should the asset not be moved
+	Refund            TransactionStep
+	State             State
+	Ordering          string
 	CharacterId       uint32
 	AccountId         uint32
 	AssetId           uint32
+	FromInventoryType string
 	ToCompartmentId   uuid.UUID
 	ToCompartmentType byte
 	ToInventoryType   string
+	FeeAmount         uint32
+	FeeCompartmentId  uuid.UUID
+	StepStartedAt     time.Time
+	TraceContext      map[string]string
+	// Sequence is the last journal entry of the transfer this instance knows of. Appending after it claims the next step.
+	Sequence uint32
 }
 
-// TransactionCache is a singleton that holds the transaction cache
+// TransactionCache is a singleton that holds the transaction cache. It is a cache of the journal, which is shared by every
+// instance of the service, so an entry may be stale when another instance has since advanced the transfer.
 type TransactionCache struct {
-	txCache   map[uuid.UUID]TransferInfo
+	txCache   map[uuid.UUID]cacheEntry
 	cacheLock sync.RWMutex
 }
 
+type cacheEntry struct {
+	info     TransferInfo
+	storedAt time.Time
+}
+
 // instance is the singleton instance of TransactionCache
 var instance *TransactionCache
 var once sync.Once
@@ -42,27 +83,32 @@ var once sync.Once
 // GetTransactionCache returns the singleton instance of TransactionCache
 func GetTransactionCache() *TransactionCache {
 	once.Do(func() {
-		instance = &TransactionCache{
-			txCache:   make(map[uuid.UUID]TransferInfo),
-			cacheLock: sync.RWMutex{},
-		}
+		instance = NewTransactionCache()
 	})
 	return instance
 }
 
+// NewTransactionCache creates a cache apart from the singleton, for processors which must not share state
+func NewTransactionCache() *TransactionCache {
+	return &TransactionCache{
+		txCache:   make(map[uuid.UUID]cacheEntry),
+		cacheLock: sync.RWMutex{},
+	}
+}
+
 // Store stores transfer information in the cache
 func (tc *TransactionCache) Store(transactionId uuid.UUID, info TransferInfo) {
 	tc.cacheLock.Lock()
 	defer tc.cacheLock.Unlock()
-	tc.txCache[transactionId] = info
+	tc.txCache[transactionId] = cacheEntry{info: info, storedAt: time.Now()}
 }
 
 // Get retrieves transfer information from the cache
 func (tc *TransactionCache) Get(transactionId uuid.UUID) (TransferInfo, bool) {
 	tc.cacheLock.RLock()
 	defer tc.cacheLock.RUnlock()
-	info, exists := tc.txCache[transactionId]
-	return info, exists
+	e, exists := tc.txCache[transactionId]
+	return e.info, exists
 }
 
 // Delete removes a transaction step from the cache
@@ -72,194 +118,807 @@ func (tc *TransactionCache) Delete(transactionId uuid.UUID) {
 	delete(tc.txCache, transactionId)
 }
 
+// Evict removes the transfers which have not been stored for longer than the age, returning how many were removed. They
+// are read from the journal should they have any further events.
+func (tc *TransactionCache) Evict(age time.Duration) int {
+	tc.cacheLock.Lock()
+	defer tc.cacheLock.Unlock()
+	evicted := 0
+	for id, e := range tc.txCache {
+		if time.Since(e.storedAt) > age {
+			delete(tc.txCache, id)
+			evicted++
+		}
+	}
+	return evicted
+}
+
 // Processor defines the interface for the transfer processor
 type Processor interface {
 	Process(mb *message.Buffer) func(cmd compartment.TransferCommand) error
 	ProcessAndEmit(cmd compartment.TransferCommand) error
+	Resume(mb *message.Buffer) func(m Model) error
+	ResumeAndEmit(m Model) error
+	ByTransactionIdProvider(transactionId uuid.UUID) model.Provider[Model]
 	HandleAccepted(mb *message.Buffer) func(transactionId uuid.UUID) error
 	HandleAcceptedAndEmit(transactionId uuid.UUID) error
 	HandleReleased(mb *message.Buffer) func(transactionId uuid.UUID) error
 	HandleReleasedAndEmit(transactionId uuid.UUID) error
+	HandleDebited(mb *message.Buffer) func(transactionId uuid.UUID) error
+	HandleDebitedAndEmit(transactionId uuid.UUID) error
+	HandleCredited(mb *message.Buffer) func(transactionId uuid.UUID) error
+	HandleCreditedAndEmit(transactionId uuid.UUID) error
 	HandleError(mb *message.Buffer) func(transactionId uuid.UUID) error
 	HandleErrorAndEmit(transactionId uuid.UUID) error
+	Abort(mb *message.Buffer) func(transactionId uuid.UUID) error
+	AbortAndEmit(transactionId uuid.UUID) error
+	TimeOut(mb *message.Buffer) func(m Model) error
+	TimeOutAndEmit(m Model) error
+	StartScheduled(mb *message.Buffer) func(m Model) error
+	StartScheduledAndEmit(m Model) error
+	Cancel(mb *message.Buffer) func(accountId uint32, transactionId uuid.UUID) error
+	CancelAndEmit(accountId uint32, transactionId uuid.UUID) error
+	ScheduledByAccountIdProvider(accountId uint32) model.Provider[[]Model]
 }
 
 // ProcessorImpl implements the Processor interface
 type ProcessorImpl struct {
 	l        logrus.FieldLogger
 	ctx      context.Context
+	db       *gorm.DB
+	t        tenant.Model
+	pf       producer.ProviderFactory
 	producer producer.Provider
+	cache    *TransactionCache
 }
 
-// NewProcessor creates a new processor
-func NewProcessor(l logrus.FieldLogger, ctx context.Context) Processor {
-	return &ProcessorImpl{
-		l:        l,
-		ctx:      ctx,
-		producer: producer.ProviderImpl(l)(ctx),
+// ProcessorFactory creates a processor for the tenant of the context
+type ProcessorFactory func(l logrus.FieldLogger, ctx context.Context) Processor
+
+// NewProcessorFactory creates processors which emit through the producer factory and share the transaction cache
+func NewProcessorFactory(db *gorm.DB, pf producer.ProviderFactory, cache *TransactionCache) ProcessorFactory {
+	return func(l logrus.FieldLogger, ctx context.Context) Processor {
+		t := tenant.MustFromContext(ctx)
+		return &ProcessorImpl{
+			l:        TenantDecorator(t)(l),
+			ctx:      ctx,
+			db:       db,
+			t:        t,
+			pf:       pf,
+			producer: pf(l)(ctx),
+			cache:    cache,
+		}
 	}
 }
 
+// NewProcessor creates a new processor, which emits to Kafka and shares the singleton transaction cache
+func NewProcessor(l logrus.FieldLogger, ctx context.Context, db *gorm.DB) Processor {
+	return NewProcessorFactory(db, producer.ProviderImpl, GetTransactionCache())(l, ctx)
+}
+
 // Process handles the transfer command
 func (p *ProcessorImpl) Process(mb *message.Buffer) func(cmd compartment.TransferCommand) error {
 	return func(cmd compartment.TransferCommand) error {
-		p.l.Debugf("Initiating compartment transfer [%s] for character [%d].", cmd.TransactionId, cmd.CharacterId)
-
-		// Step 1: Handle FromInventoryType
-		var info TransferInfo
-		nextStep := p.createReleaseStep(cmd)
-
-		if cmd.ToInventoryType == compartment.InventoryTypeCharacter {
-			p.l.Debugf("Informing [%s] inventory to receive that [%d] via transfer [%s].", cmd.ToInventoryType, cmd.AssetId, cmd.TransactionId)
-			_ = mb.Put(compartment2.EnvCommandTopic, compartment3.AcceptCommandProvider(cmd.CharacterId, cmd.ToCompartmentType, cmd.TransactionId, cmd.ReferenceId))
-
-			info = TransferInfo{
-				Step:              nextStep,
-				CharacterId:       cmd.CharacterId,
-				AccountId:         cmd.AccountId,
-				AssetId:           cmd.AssetId,
-				ToCompartmentId:   cmd.ToCompartmentId,
-				ToCompartmentType: cmd.ToCompartmentType,
-				ToInventoryType:   cmd.ToInventoryType,
-			}
-
-		} else if cmd.ToInventoryType == compartment.InventoryTypeCashShop {
-			p.l.Debugf("Informing [%s] inventory to receive that [%d] via transfer [%s].", cmd.ToInventoryType, cmd.AssetId, cmd.TransactionId)
-			_ = mb.Put(compartment4.EnvCommandTopic, compartment5.AcceptCommandProvider(cmd.AccountId, cmd.ToCompartmentId, cmd.ToCompartmentType, cmd.TransactionId, cmd.ReferenceId))
-
-			info = TransferInfo{
-				Step:              nextStep,
-				CharacterId:       cmd.CharacterId,
-				AccountId:         cmd.AccountId,
-				AssetId:           cmd.ReferenceId,
-				ToCompartmentId:   cmd.ToCompartmentId,
-				ToCompartmentType: cmd.ToCompartmentType,
-				ToInventoryType:   cmd.ToInventoryType,
-			}
-		}
-
-		// Store transaction and transfer info in cache
-		GetTransactionCache().Store(cmd.TransactionId, info)
-		return nil
+		tp := p.withLogger(CommandDecorator(cmd))
+		tp.l.Debugf("Initiating compartment transfer [%s] for character [%d].", cmd.TransactionId, cmd.CharacterId)
+
+		err := validate(cmd)
+		if err != nil {
+			tp.l.WithError(err).Warnf("Rejecting compartment transfer [%s].", cmd.TransactionId)
+			metrics.Transfers.WithLabelValues(p.t.Id().String(), metrics.OutcomeRejected, cmd.FromInventoryType, cmd.ToInventoryType).Inc()
+			return err
+		}
+
+		// The tenant's configuration is resolved afresh for each command, so a change applies to the transfers started after it
+		cfg, err := configuration.NewProcessor(tp.l, p.ctx).TenantProvider()()
+		if err != nil {
+			tp.l.WithError(err).Errorf("Unable to resolve the configuration of compartment transfer [%s].", cmd.TransactionId)
+			return err
+		}
+
+		// A repeated command is not checked again, as the transfer it started may have since changed the compartments
+		if _, err = tp.ByTransactionIdProvider(cmd.TransactionId)(); err == nil {
+			tp.l.Infof("Compartment transfer [%s] was already received. Ignoring the repeated command.", cmd.TransactionId)
+			return nil
+		}
+
+		// Journal the saga so it can be resumed should the service stop before it finishes
+		entries := []journal.Model{commandReceivedEntry(p.t, cmd)}
+		if tc := traceContext(p.ctx); tc != nil {
+			entries = append(entries, sagaStartedEntry(p.t, cmd.TransactionId, tc))
+		}
+
+		// Transfers to be delivered later are checked once they are due, as that is when the compartments must allow them
+		if cmd.DeliverAt != nil && cmd.DeliverAt.After(time.Now()) {
+			err = tp.schedule(mb, cmd, cfg, entries...)
+		} else {
+			err = tp.start(mb, cmd, tp.newBuilder(cmd, cfg).SetTraceContext(traceContext(p.ctx)), cfg, 0, "", entries...)
+		}
+		if errors.Is(err, journal.ErrConflict) {
+			tp.l.Infof("Compartment transfer [%s] was already received. Ignoring the repeated command.", cmd.TransactionId)
+			return nil
+		}
+		return err
+	}
+}
+
+// newBuilder creates a builder for the transfer of the command, in the ordering of the tenant's configuration
+func (p *ProcessorImpl) newBuilder(cmd compartment.TransferCommand, cfg configuration.Model) *Builder {
+	b := NewBuilder(p.t, cmd.TransactionId).
+		SetAccountId(cmd.AccountId).
+		SetCharacterId(cmd.CharacterId).
+		SetAssetId(cmd.AssetId).
+		SetReferenceId(cmd.ReferenceId).
+		SetFrom(cmd.FromCompartmentId, cmd.FromCompartmentType, cmd.FromInventoryType).
+		SetTo(cmd.ToCompartmentId, cmd.ToCompartmentType, cmd.ToInventoryType).
+		SetOrdering(cfg.Ordering())
+	if cmd.Fee != nil {
+		b.SetFee(cmd.Fee.Amount, cmd.Fee.CompartmentId)
+	}
+	if cmd.DeliverAt != nil {
+		b.SetDeliverAt(*cmd.DeliverAt)
+	}
+	return b
+}
+
+// start admits the transfer of the command and takes the first step of its saga, or rejects it. The entries given are
+// journaled ahead of those of the step, after the sequence the journal is expected to end at, and the saga leaves the
+// state it was in. It returns journal.ErrConflict when the journal has since grown.
+func (p *ProcessorImpl) start(mb *message.Buffer, cmd compartment.TransferCommand, b *Builder, cfg configuration.Model, after uint32, from State, entries ...journal.Model) error {
+	first, firstCommand, firstInventoryType, step := p.firstStep(b.Build())
+	m := b.SetState(first).Build()
+	tp := p.withLogger(ModelDecorator(m))
+
+	// Refuse transfers over the tenant's limits, transfers it has not enabled, and transfers the compartments are known
+	// to refuse, rather than waiting for an error
+	var rejection Rejection
+	err := tp.admit(cmd, cfg)
+	if err != nil && !errors.As(err, &rejection) {
+		tp.l.WithError(err).Errorf("Unable to count the transfers in progress of account [%d]. Not starting compartment transfer [%s].", cmd.AccountId, cmd.TransactionId)
+		return err
+	}
+	if err == nil {
+		err = tp.preflight(cmd)
+		if errors.Is(err, ErrPolicyUnavailable) {
+			tp.l.WithError(err).Errorf("Unable to check compartment transfer [%s] against the transfer policy. Not starting it.", cmd.TransactionId)
+			return err
+		}
+		if err != nil && !errors.As(err, &rejection) {
+			tp.l.WithError(err).Errorf("Unable to check compartment transfer [%s] before starting it. Not starting it.", cmd.TransactionId)
+			return err
+		}
+	}
+	// Scheduled transfers were taken from the rates as they were accepted
+	if rejection.Reason == "" && from != StateScheduled {
+		err = tp.throttle(cmd, cfg)
+		if err != nil && !errors.As(err, &rejection) {
+			tp.l.WithError(err).Errorf("Unable to take compartment transfer [%s] from the rate limits. Not starting it.", cmd.TransactionId)
+			return err
+		}
+	}
+	if rejection.Reason != "" {
+		return tp.reject(mb, cmd, rejection, after, from, entries...)
+	}
+
+	// Step 1: Charge the fee, or ask the destination to accept the asset, or the source to release it
+	staged, err := tp.stage(step)
+	if err != nil {
+		return err
 	}
+	stepEntries, err := tp.awaiting(first,
+		stateChangedEntry(p.t, cmd.TransactionId, from, first),
+		commandEmittedEntry(p.t, cmd.TransactionId, firstCommand, firstInventoryType, causationId(p.ctx)),
+	)
+	if err != nil {
+		return err
+	}
+	sequence, err := tp.record(after, append(entries, stepEntries...)...)
+	if err != nil {
+		if !errors.Is(err, journal.ErrConflict) {
+			tp.l.WithError(err).Errorf("Unable to journal compartment transfer [%s].", cmd.TransactionId)
+		}
+		return err
+	}
+	mb.PutAll(staged)
+
+	// Store transaction and transfer info in cache
+	info := tp.createTransferInfo(m)
+	info.Sequence = sequence
+	p.cache.Store(cmd.TransactionId, info)
+	return nil
 }
 
-// ProcessAndEmit handles the transfer command and emits messages
+// reject journals the transfer of the command as refused, after the sequence the journal is expected to end at, and
+// tells the character why. The entries given are journaled ahead of the rejection. It returns journal.ErrConflict when
+// the journal has since grown.
+func (p *ProcessorImpl) reject(mb *message.Buffer, cmd compartment.TransferCommand, rejection Rejection, after uint32, from State, entries ...journal.Model) error {
+	staged, err := p.stage(func(mb *message.Buffer) error {
+		return mb.Put(compartment.EnvEventTopicStatus, compartment6.RejectedStatusEventProvider(cmd.CharacterId, cmd.TransactionId, cmd.AccountId, cmd.AssetId, rejection.Reason, rejection.RuleId))
+	})
+	if err != nil {
+		return err
+	}
+	entries = append(entries, rejectedEntry(p.t, cmd.TransactionId, from, rejection.Reason, rejection.RuleId))
+	_, err = p.record(after, entries...)
+	if err != nil {
+		if !errors.Is(err, journal.ErrConflict) {
+			p.l.WithError(err).Errorf("Unable to journal compartment transfer [%s].", cmd.TransactionId)
+		}
+		return err
+	}
+	mb.PutAll(staged)
+
+	p.l.WithError(rejection).Warnf("Rejecting compartment transfer [%s].", cmd.TransactionId)
+	tenantId := p.t.Id().String()
+	metrics.Transfers.WithLabelValues(tenantId, metrics.OutcomeRejected, cmd.FromInventoryType, cmd.ToInventoryType).Inc()
+	metrics.Rejections.WithLabelValues(tenantId, rejection.Reason).Inc()
+	return nil
+}
+
+// validate ensures the command describes a transfer the saga is able to perform
+func validate(cmd compartment.TransferCommand) error {
+	if cmd.TransactionId == uuid.Nil {
+		return fmt.Errorf("%w: missing transaction id", ErrInvalidCommand)
+	}
+	if !validInventoryType(cmd.FromInventoryType) {
+		return fmt.Errorf("%w: unsupported source inventory type [%s]", ErrInvalidCommand, cmd.FromInventoryType)
+	}
+	if !validInventoryType(cmd.ToInventoryType) {
+		return fmt.Errorf("%w: unsupported destination inventory type [%s]", ErrInvalidCommand, cmd.ToInventoryType)
+	}
+	if cmd.Fee != nil && cmd.Fee.Amount > 0 && cmd.Fee.CompartmentId == uuid.Nil {
+		return fmt.Errorf("%w: missing fee compartment id", ErrInvalidCommand)
+	}
+	return nil
+}
+
+func validInventoryType(inventoryType string) bool {
+	return inventoryType == compartment.InventoryTypeCharacter || inventoryType == compartment.InventoryTypeCashShop
+}
+
+// ProcessAndEmit handles the transfer command and emits messages, within a new root span for the transfer's saga
 func (p *ProcessorImpl) ProcessAndEmit(cmd compartment.TransferCommand) error {
-	return message.Emit(p.producer)(func(mb *message.Buffer) error {
-		return p.Process(mb)(cmd)
+	sp, span := p.startSaga(cmd.TransactionId, cmd.FromInventoryType, cmd.ToInventoryType)
+	err := message.Emit(sp.producer)(func(mb *message.Buffer) error {
+		return sp.Process(mb)(cmd)
 	})
+	finishSpan(span, err)
+	return err
+}
+
+// Resume re-issues the pending command of a persisted, in-flight transfer. Downstream commands are idempotent by
+// transaction id, so repeating one which was already acted upon is safe.
+func (p *ProcessorImpl) Resume(mb *message.Buffer) func(m Model) error {
+	return func(m Model) error {
+		tp := p.withLogger(ModelDecorator(m))
+		tp.l.Debugf("Resuming compartment transfer [%s] in state [%s].", m.TransactionId(), m.State())
+
+		var step TransactionStep
+		var entry journal.Model
+		switch m.State() {
+		case StateCharging:
+			step = tp.createChargeStep(m)
+			entry = commandEmittedEntry(p.t, m.TransactionId(), compartment7.CommandDebit, "", causationId(p.ctx))
+		case StateRefunding:
+			step = tp.createRefundStep(m)
+			entry = commandEmittedEntry(p.t, m.TransactionId(), compartment7.CommandCredit, "", causationId(p.ctx))
+		case StateAccepting:
+			step = tp.createAcceptStep(m)
+			entry = commandEmittedEntry(p.t, m.TransactionId(), compartment2.CommandAccept, m.ToInventoryType(), causationId(p.ctx))
+		case StateReleasing:
+			step = tp.createReleaseStep(m)
+			entry = commandEmittedEntry(p.t, m.TransactionId(), compartment2.CommandRelease, m.FromInventoryType(), causationId(p.ctx))
+		default:
+			return nil
+		}
+
+		// Journaling the re-issued command restarts the timeout clock. Should another instance have advanced the transfer
+		// since it was read, that instance owns it, and nothing is re-issued.
+		staged, err := tp.stage(step)
+		if err != nil {
+			return err
+		}
+		entries, err := tp.awaiting(m.State(), entry)
+		if err != nil {
+			return err
+		}
+		sequence, err := tp.record(m.Sequence(), entries...)
+		if errors.Is(err, journal.ErrConflict) {
+			tp.l.Debugf("Compartment transfer [%s] was advanced elsewhere. Not resuming it.", m.TransactionId())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mb.PutAll(staged)
+
+		info := tp.createTransferInfo(m)
+		info.Sequence = sequence
+		p.cache.Store(m.TransactionId(), info)
+		return nil
+	}
+}
+
+// ResumeAndEmit resumes a persisted, in-flight transfer and emits messages
+func (p *ProcessorImpl) ResumeAndEmit(m Model) error {
+	sp, span := p.continueSaga(SpanResume, m.TransactionId(), p.createTransferInfo(m))
+	err := message.Emit(sp.producer)(func(mb *message.Buffer) error {
+		return sp.Resume(mb)(m)
+	})
+	finishSpan(span, err)
+	return err
+}
+
+// createTransferInfo creates the cached transfer information for a saga awaiting its next event
+func (p *ProcessorImpl) createTransferInfo(m Model) TransferInfo {
+	info := TransferInfo{
+		Step:              p.createReleaseStep(m),
+		State:             m.State(),
+		Ordering:          m.Ordering(),
+		CharacterId:       m.CharacterId(),
+		AccountId:         m.AccountId(),
+		AssetId:           m.AssetId(),
+		FromInventoryType: m.FromInventoryType(),
+		ToCompartmentId:   m.ToCompartmentId(),
+		ToCompartmentType: m.ToCompartmentType(),
+		ToInventoryType:   m.ToInventoryType(),
+		FeeAmount:         m.FeeAmount(),
+		FeeCompartmentId:  m.FeeCompartmentId(),
+		StepStartedAt:     time.Now(),
+		TraceContext:      m.TraceContext(),
+		Sequence:          m.Sequence(),
+	}
+	if m.Ordering() == configuration.OrderingReleaseFirst {
+		info.Step = p.createAcceptStep(m)
+	}
+	_, _, _, info.Start = p.moveStep(m)
+	info.Refund = p.createRefundStep(m)
+	if m.ToInventoryType() == compartment.InventoryTypeCashShop {
+		info.AssetId = m.ReferenceId()
+	}
+	return info
+}
+
+// firstStep is the first step of the saga, which charges the fee when there is one, or otherwise starts moving the
+// asset. It returns the state the saga enters, and the command and inventory type the step issues.
+func (p *ProcessorImpl) firstStep(m Model) (State, string, string, TransactionStep) {
+	if m.FeeAmount() > 0 {
+		return StateCharging, compartment7.CommandDebit, "", p.createChargeStep(m)
+	}
+	return p.moveStep(m)
+}
+
+// moveStep is the first step of moving the asset, which asks the compartment the ordering puts first to act
+func (p *ProcessorImpl) moveStep(m Model) (State, string, string, TransactionStep) {
+	if m.Ordering() == configuration.OrderingReleaseFirst {
+		return StateReleasing, compartment2.CommandRelease, m.FromInventoryType(), p.createReleaseStep(m)
+	}
+	return StateAccepting, compartment2.CommandAccept, m.ToInventoryType(), p.createAcceptStep(m)
+}
+
+// firstMoveState is the state of a transfer in the ordering, while the compartment asked first to move the asset has not
+// yet answered
+func firstMoveState(ordering string) State {
+	if ordering == configuration.OrderingReleaseFirst {
+		return StateReleasing
+	}
+	return StateAccepting
+}
+
+// createAcceptStep creates a step function for asking the destination to accept an asset
+func (p *ProcessorImpl) createAcceptStep(m Model) TransactionStep {
+	return func(mb *message.Buffer) error {
+		p.l.Debugf("Informing [%s] inventory to receive that [%d] via transfer [%s].", m.ToInventoryType(), m.AssetId(), m.TransactionId())
+		if m.ToInventoryType() == compartment.InventoryTypeCharacter {
+			return mb.Put(compartment2.EnvCommandTopic, compartment3.AcceptCommandProvider(m.CharacterId(), m.ToCompartmentType(), m.TransactionId(), m.ReferenceId()))
+		} else if m.ToInventoryType() == compartment.InventoryTypeCashShop {
+			return mb.Put(compartment4.EnvCommandTopic, compartment5.AcceptCommandProvider(m.AccountId(), m.ToCompartmentId(), m.ToCompartmentType(), m.TransactionId(), m.ReferenceId()))
+		}
+		return nil
+	}
 }
 
 // createReleaseStep creates a step function for releasing an asset
-func (p *ProcessorImpl) createReleaseStep(cmd compartment.TransferCommand) TransactionStep {
+func (p *ProcessorImpl) createReleaseStep(m Model) TransactionStep {
 	return func(mb *message.Buffer) error {
-		if cmd.FromInventoryType == compartment.InventoryTypeCharacter {
-			_ = mb.Put(compartment2.EnvCommandTopic, compartment3.ReleaseCommandProvider(cmd.CharacterId, cmd.FromCompartmentType, cmd.TransactionId, cmd.ReferenceId))
-		} else if cmd.FromInventoryType == compartment.InventoryTypeCashShop {
-			_ = mb.Put(compartment4.EnvCommandTopic, compartment5.ReleaseCommandProvider(cmd.AccountId, cmd.FromCompartmentId, cmd.FromCompartmentType, cmd.TransactionId, cmd.ReferenceId))
+		if m.FromInventoryType() == compartment.InventoryTypeCharacter {
+			return mb.Put(compartment2.EnvCommandTopic, compartment3.ReleaseCommandProvider(m.CharacterId(), m.FromCompartmentType(), m.TransactionId(), m.ReferenceId()))
+		} else if m.FromInventoryType() == compartment.InventoryTypeCashShop {
+			return mb.Put(compartment4.EnvCommandTopic, compartment5.ReleaseCommandProvider(m.AccountId(), m.FromCompartmentId(), m.FromCompartmentType(), m.TransactionId(), m.ReferenceId()))
 		}
 		return nil
 	}
 }
 
+// stage builds the messages of a step before the step is journaled, so a message which cannot be built, such as one its
+// topic is pinned to a version too early for, is not journaled as emitted. The messages are to be put into the buffer of
+// the saga once the step is journaled.
+func (p *ProcessorImpl) stage(step TransactionStep) (*message.Buffer, error) {
+	sb := message.NewBuffer()
+	err := step(sb)
+	if err != nil {
+		p.l.WithError(err).Errorf("Unable to build the messages of the next step.")
+		return nil, err
+	}
+	return sb, nil
+}
+
+// record appends entries to the journal of the transfer, after the last entry this instance knows of. It returns
+// journal.ErrConflict when the transfer has since been advanced elsewhere.
+func (p *ProcessorImpl) record(after uint32, entries ...journal.Model) (uint32, error) {
+	return journal.NewProcessor(p.l, p.ctx, p.db).Append(after)(entries...)
+}
+
+// awaiting marks the last of the entries of a step as leaving the transfer waiting in the state, until the step times
+// out under the tenant's step timeout as it is now. Steps of tenants which set no step timeout are not marked. A step is
+// not taken without its timeout, so it fails when the tenant's configuration cannot be retrieved.
+func (p *ProcessorImpl) awaiting(state State, entries ...journal.Model) ([]journal.Model, error) {
+	if len(entries) == 0 {
+		return entries, nil
+	}
+	cfg, err := configuration.NewProcessor(p.l, p.ctx).TenantProvider()()
+	if err != nil {
+		p.l.WithError(err).Errorf("Unable to retrieve the step timeout. Not moving compartment transfer [%s] to state [%s].", entries[0].TransactionId(), state)
+		return nil, err
+	}
+	if cfg.StepTimeout() == 0 {
+		return entries, nil
+	}
+	last := len(entries) - 1
+	entries[last] = entries[last].Await(string(state), time.Now().Add(cfg.StepTimeout()))
+	return entries, nil
+}
+
+// journalFailed reports a failure to journal the state of a transfer. A conflict is not reported, as the step is
+// retried from the journal.
+func (p *ProcessorImpl) journalFailed(transactionId uuid.UUID, err error) error {
+	if !errors.Is(err, journal.ErrConflict) {
+		p.l.WithError(err).Errorf("Unable to journal state of transfer [%s].", transactionId)
+	}
+	return err
+}
+
+// unknownTransaction reports a status event for a transaction which is not in progress. The status topics are shared
+// with the sagas of other services, so an event for a transaction the tenant never journaled is skipped. An event for a
+// transfer which has finished, been aborted or not yet started is ErrUnknownTransaction.
+func (p *ProcessorImpl) unknownTransaction(transactionId uuid.UUID, eventType string) error {
+	metrics.UnknownTransactionEvents.WithLabelValues(p.t.Id().String(), eventType).Inc()
+	entries, err := journal.NewProcessor(p.l, p.ctx, p.db).ByTransactionIdProvider(transactionId)()
+	if err != nil {
+		p.l.WithError(err).Errorf("Unable to read the journal of transaction [%s].", transactionId)
+		return err
+	}
+	if len(entries) == 0 {
+		p.l.Debugf("Transaction [%s] was not journaled. Skipping [%s] status event of another saga.", transactionId, eventType)
+		return nil
+	}
+	p.l.Warnf("No transfer in progress for transaction [%s].", transactionId)
+	return ErrUnknownTransaction
+}
+
+// finished counts a transfer which reached a terminal state
+func (p *ProcessorImpl) finished(info TransferInfo, outcome string) {
+	metrics.Transfers.WithLabelValues(p.t.Id().String(), outcome, info.FromInventoryType, info.ToInventoryType).Inc()
+}
+
+// ByTransactionIdProvider rebuilds the current state of a transfer by folding its journal
+func (p *ProcessorImpl) ByTransactionIdProvider(transactionId uuid.UUID) model.Provider[Model] {
+	return model.Map(Fold)(journal.NewProcessor(p.l, p.ctx, p.db).ByTransactionIdProvider(transactionId))
+}
+
+// getTransferInfo retrieves transfer information from the cache, falling back to the journal. A transfer which is not
+// in progress does not exist, and an error is returned only when the journal cannot be read.
+func (p *ProcessorImpl) getTransferInfo(transactionId uuid.UUID) (TransferInfo, bool, error) {
+	if info, exists := p.cache.Get(transactionId); exists {
+		return info, true, nil
+	}
+
+	m, err := p.ByTransactionIdProvider(transactionId)()
+	if errors.Is(err, ErrIncompleteJournal) {
+		return TransferInfo{}, false, nil
+	}
+	if err != nil {
+		p.l.WithError(err).Errorf("Unable to read the journal of transfer [%s].", transactionId)
+		return TransferInfo{}, false, err
+	}
+	// Scheduled transfers have not started, so no compartment has been asked to act for them
+	if m.State().Terminal() || m.State() == StateScheduled {
+		return TransferInfo{}, false, nil
+	}
+	info := p.createTransferInfo(m)
+	info.StepStartedAt = m.UpdatedAt()
+	p.cache.Store(transactionId, info)
+	return info, true, nil
+}
+
+// expectTransferInfo retrieves transfer information as getTransferInfo does. A cached transfer which is not in the state
+// expected is read from the journal once more, as another instance may have since advanced it.
+func (p *ProcessorImpl) expectTransferInfo(transactionId uuid.UUID, expected State) (TransferInfo, bool, error) {
+	info, exists, err := p.getTransferInfo(transactionId)
+	if err != nil || !exists || info.State == expected {
+		return info, exists, err
+	}
+	p.cache.Delete(transactionId)
+	return p.getTransferInfo(transactionId)
+}
+
 // HandleAccepted handles the accepted status event
 func (p *ProcessorImpl) HandleAccepted(mb *message.Buffer) func(transactionId uuid.UUID) error {
 	return func(transactionId uuid.UUID) error {
-		p.l.Debugf("Target compartment accepted transfer. Removing from original inventory. TransferId: [%s]", transactionId)
+		tp := p.withLogger(TransactionDecorator(transactionId))
+		tp.l.Debugf("Target compartment accepted transfer. TransferId: [%s]", transactionId)
 
 		// Get transfer info from cache
-		info, exists := GetTransactionCache().Get(transactionId)
+		info, exists, err := tp.expectTransferInfo(transactionId, StateAccepting)
+		if err != nil {
+			return err
+		}
 
 		if !exists {
-			p.l.Warn("No transfer info found for transaction")
+			return tp.unknownTransaction(transactionId, compartment2.StatusEventTypeAccepted)
+		}
+		tp = tp.withLogger(InfoDecorator(info))
+		if info.State != StateAccepting {
+			tp.l.Debugf("Ignoring acceptance of transfer [%s] in state [%s].", transactionId, info.State)
 			return nil
 		}
+		metrics.AcceptStepDuration.WithLabelValues(p.t.Id().String(), info.ToInventoryType).Observe(time.Since(info.StepStartedAt).Seconds())
 
-		// Execute next step
-		err := info.Step(mb)
-		if err != nil {
-			p.l.WithError(err).Error("Failed to execute next step")
-			return err
+		if info.Ordering == configuration.OrderingReleaseFirst {
+			return tp.complete(mb, transactionId, info, compartment2.StatusEventTypeAccepted)
 		}
+		return tp.advance(mb, transactionId, info, compartment2.StatusEventTypeAccepted)
+	}
+}
 
-		// Note: We no longer delete the transaction from the cache here
-		// so that HandleReleased can access the transfer info
+// advance asks the compartment the saga did not ask first to act, now the first has answered
+func (p *ProcessorImpl) advance(mb *message.Buffer, transactionId uuid.UUID, info TransferInfo, eventType string) error {
+	next, commandType, inventoryType := StateReleasing, compartment2.CommandRelease, info.FromInventoryType
+	if info.Ordering == configuration.OrderingReleaseFirst {
+		next, commandType, inventoryType = StateAccepting, compartment2.CommandAccept, info.ToInventoryType
+	}
 
-		return nil
+	// Execute next step
+	staged, err := p.stage(info.Step)
+	if err != nil {
+		return err
+	}
+	entries, err := p.awaiting(next,
+		eventConsumedEntry(p.t, transactionId, eventType, causationId(p.ctx)),
+		stateChangedEntry(p.t, transactionId, info.State, next),
+		commandEmittedEntry(p.t, transactionId, commandType, inventoryType, causationId(p.ctx)),
+	)
+	if err != nil {
+		return err
+	}
+	sequence, err := p.record(info.Sequence, entries...)
+	if err != nil {
+		return p.journalFailed(transactionId, err)
+	}
+	mb.PutAll(staged)
+	info.State = next
+	info.Sequence = sequence
+	info.StepStartedAt = time.Now()
+	p.cache.Store(transactionId, info)
+
+	// Note: We no longer delete the transaction from the cache here
+	// so that the last answer can access the transfer info
+
+	return nil
+}
+
+// complete finishes the transfer, now the compartment the saga asked last has answered
+func (p *ProcessorImpl) complete(mb *message.Buffer, transactionId uuid.UUID, info TransferInfo, eventType string) error {
+	// Emit completed status event, with the fee the transfer was charged
+	var fee *compartment.Fee
+	if info.FeeAmount > 0 {
+		fee = &compartment.Fee{Amount: info.FeeAmount, CompartmentId: info.FeeCompartmentId}
+	}
+	staged, err := p.stage(func(mb *message.Buffer) error {
+		return mb.Put(compartment.EnvEventTopicStatus, compartment6.CompletedStatusEventProvider(
+			info.CharacterId,
+			transactionId,
+			info.AccountId,
+			info.AssetId,
+			info.ToCompartmentId,
+			info.ToCompartmentType,
+			info.ToInventoryType,
+			fee,
+		))
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.record(info.Sequence,
+		eventConsumedEntry(p.t, transactionId, eventType, causationId(p.ctx)),
+		commandEmittedEntry(p.t, transactionId, compartment.StatusEventTypeCompleted, info.ToInventoryType, causationId(p.ctx)),
+		stateChangedEntry(p.t, transactionId, info.State, StateCompleted),
+	)
+	if err != nil {
+		return p.journalFailed(transactionId, err)
 	}
+	mb.PutAll(staged)
+
+	// Remove transaction from cache
+	p.cache.Delete(transactionId)
+	p.finished(info, metrics.OutcomeCompleted)
+
+	return nil
+}
+
+// emitInSaga runs a step of the transfer's saga within a span of the saga, and emits the messages it buffers. Steps for
+// transfers which are not in progress run in the span of their message. A step which finds the transfer was advanced
+// elsewhere since it was cached is run once more, from the journal.
+func (p *ProcessorImpl) emitInSaga(name string, transactionId uuid.UUID, step func(sp *ProcessorImpl) func(mb *message.Buffer) error) error {
+	err := p.emitStep(name, transactionId, step)
+	if !errors.Is(err, journal.ErrConflict) {
+		return err
+	}
+	p.l.Debugf("Transfer [%s] was advanced elsewhere. Retrying [%s] from the journal.", transactionId, name)
+	metrics.JournalConflicts.WithLabelValues(p.t.Id().String()).Inc()
+	p.cache.Delete(transactionId)
+	return p.emitStep(name, transactionId, step)
+}
+
+func (p *ProcessorImpl) emitStep(name string, transactionId uuid.UUID, step func(sp *ProcessorImpl) func(mb *message.Buffer) error) error {
+	info, exists, err := p.getTransferInfo(transactionId)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return message.Emit(p.producer)(step(p))
+	}
+
+	sp, span := p.continueSaga(name, transactionId, info)
+	err = message.Emit(sp.producer)(step(sp))
+	finishSpan(span, err)
+	return err
 }
 
 // HandleAcceptedAndEmit handles the accepted status event and emits messages
 func (p *ProcessorImpl) HandleAcceptedAndEmit(transactionId uuid.UUID) error {
-	return message.Emit(p.producer)(func(mb *message.Buffer) error {
-		return p.HandleAccepted(mb)(transactionId)
+	return p.emitInSaga(SpanAccepted, transactionId, func(sp *ProcessorImpl) func(mb *message.Buffer) error {
+		return func(mb *message.Buffer) error {
+			return sp.HandleAccepted(mb)(transactionId)
+		}
 	})
 }
 
 // HandleReleased handles the released status event
 func (p *ProcessorImpl) HandleReleased(mb *message.Buffer) func(transactionId uuid.UUID) error {
 	return func(transactionId uuid.UUID) error {
-		p.l.Debugf("Asset released from original inventory. Transfer completed. TransferId: [%s]", transactionId)
+		tp := p.withLogger(TransactionDecorator(transactionId))
+		tp.l.Debugf("Asset released from original inventory. TransferId: [%s]", transactionId)
 
 		// Get transfer info from cache
-		info, exists := GetTransactionCache().Get(transactionId)
+		info, exists, err := tp.expectTransferInfo(transactionId, StateReleasing)
+		if err != nil {
+			return err
+		}
 
 		if !exists {
-			p.l.Warn("No transfer info found for transaction")
+			return tp.unknownTransaction(transactionId, compartment2.StatusEventTypeReleased)
+		}
+		tp = tp.withLogger(InfoDecorator(info))
+		if info.State != StateReleasing {
+			tp.l.Debugf("Ignoring release of transfer [%s] in state [%s].", transactionId, info.State)
 			return nil
 		}
+		metrics.ReleaseStepDuration.WithLabelValues(p.t.Id().String(), info.FromInventoryType).Observe(time.Since(info.StepStartedAt).Seconds())
 
-		// Emit completed status event
-		_ = mb.Put(compartment.EnvEventTopicStatus, compartment6.CompletedStatusEventProvider(
-			info.CharacterId,
-			transactionId,
-			info.AccountId,
-			info.AssetId,
-			info.ToCompartmentId,
-			info.ToCompartmentType,
-			info.ToInventoryType,
-		))
-
-		// Remove transaction from cache
-		GetTransactionCache().Delete(transactionId)
-
-		return nil
+		if info.Ordering == configuration.OrderingReleaseFirst {
+			return tp.advance(mb, transactionId, info, compartment2.StatusEventTypeReleased)
+		}
+		return tp.complete(mb, transactionId, info, compartment2.StatusEventTypeReleased)
 	}
 }
 
 // HandleReleasedAndEmit handles the released status event and emits messages
 func (p *ProcessorImpl) HandleReleasedAndEmit(transactionId uuid.UUID) error {
-	return message.Emit(p.producer)(func(mb *message.Buffer) error {
-		return p.HandleReleased(mb)(transactionId)
+	return p.emitInSaga(SpanReleased, transactionId, func(sp *ProcessorImpl) func(mb *message.Buffer) error {
+		return func(mb *message.Buffer) error {
+			return sp.HandleReleased(mb)(transactionId)
+		}
 	})
 }
 
 // HandleError handles the error status event
 func (p *ProcessorImpl) HandleError(mb *message.Buffer) func(transactionId uuid.UUID) error {
 	return func(transactionId uuid.UUID) error {
-		p.l.Debugf("Transfer failed. TransferId: [%s]", transactionId)
+		tp := p.withLogger(TransactionDecorator(transactionId))
+		tp.l.Debugf("Transfer failed. TransferId: [%s]", transactionId)
 
 		// Get transfer info from cache
-		_, exists := GetTransactionCache().Get(transactionId)
+		info, exists, err := tp.getTransferInfo(transactionId)
+		if err != nil {
+			return err
+		}
 
-		// If no transfer info exists, just return
+		// If no transfer info exists, there is nothing to unwind
 		if !exists {
-			p.l.Warn("No transfer info found for transaction")
-			return nil
+			return tp.unknownTransaction(transactionId, compartment2.StatusEventTypeError)
 		}
+		tp = tp.withLogger(InfoDecorator(info))
 
-		// Remove transaction from cache
-		GetTransactionCache().Delete(transactionId)
+		// The fee is credited back should the asset not be moved once it was charged
+		consumed := eventConsumedEntry(p.t, transactionId, compartment2.StatusEventTypeError, causationId(p.ctx))
+		if info.FeeAmount > 0 && (info.State == StateAccepting || info.State == StateReleasing) {
+			return tp.refund(mb, transactionId, info, "", consumed)
+		}
+		if info.State == StateRefunding {
+			tp.l.Errorf("Unable to refund the fee of [%d] charged for compartment transfer [%s].", info.FeeAmount, transactionId)
+		}
+
+		_, err = tp.record(info.Sequence,
+			consumed,
+			stateChangedEntry(p.t, transactionId, info.State, StateFailed),
+		)
+		if err != nil {
+			return tp.journalFailed(transactionId, err)
+		}
 
-		// TODO: issue saga failed event
+		// Remove transaction from cache
+		p.cache.Delete(transactionId)
+		tp.finished(info, metrics.OutcomeFailed)
 		return nil
 	}
 }
 
 // HandleErrorAndEmit handles the error status event and emits messages
 func (p *ProcessorImpl) HandleErrorAndEmit(transactionId uuid.UUID) error {
-	return message.Emit(p.producer)(func(mb *message.Buffer) error {
-		return p.HandleError(mb)(transactionId)
+	return p.emitInSaga(SpanError, transactionId, func(sp *ProcessorImpl) func(mb *message.Buffer) error {
+		return func(mb *message.Buffer) error {
+			return sp.HandleError(mb)(transactionId)
+		}
+	})
+}
+
+// ReasonAborted is journaled for the refund of the fee of a transfer which was aborted
+const ReasonAborted = "ABORTED"
+
+// Abort stops a transfer whose compartment asked first has not yet answered, so status events compartments emit for the
+// transfer afterwards are for an unknown transaction. Once the first compartment has answered, the asset has moved
+// into, or out of, one compartment alone, and aborting would leave it in both or in neither. A transfer whose fee is
+// being charged is not aborted either, as the currency compartment may yet debit it, and one whose fee was charged is
+// refunded.
+func (p *ProcessorImpl) Abort(mb *message.Buffer) func(transactionId uuid.UUID) error {
+	return func(transactionId uuid.UUID) error {
+		tp := p.withLogger(TransactionDecorator(transactionId))
+
+		info, exists, err := tp.getTransferInfo(transactionId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUnknownTransaction
+		}
+		tp = tp.withLogger(InfoDecorator(info))
+		if info.State != firstMoveState(info.Ordering) {
+			tp.l.Warnf("Refusing to abort compartment transfer [%s] in state [%s].", transactionId, info.State)
+			return ErrNotAbortable
+		}
+		tp.l.Infof("Aborting compartment transfer [%s] in state [%s].", transactionId, info.State)
+		if info.FeeAmount > 0 {
+			return tp.refund(mb, transactionId, info, ReasonAborted)
+		}
+
+		_, err = tp.record(info.Sequence, stateChangedEntry(p.t, transactionId, info.State, StateAborted))
+		if err != nil {
+			return tp.journalFailed(transactionId, err)
+		}
+
+		p.cache.Delete(transactionId)
+		tp.finished(info, metrics.OutcomeAborted)
+		return nil
+	}
+}
+
+// AbortAndEmit aborts a transfer which is in progress and emits messages
+func (p *ProcessorImpl) AbortAndEmit(transactionId uuid.UUID) error {
+	return p.emitInSaga(SpanAbort, transactionId, func(sp *ProcessorImpl) func(mb *message.Buffer) error {
+		return func(mb *message.Buffer) error {
+			return sp.Abort(mb)(transactionId)
+		}
 	})
 }
@@ -0,0 +1,68 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/journal"
+	"atlas-compartment-transfer/metrics"
+	"atlas-compartment-transfer/settings"
+	"context"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+const defaultStaleAfter = 5 * time.Minute
+
+// Recover resumes the transfers which were left in flight when an instance stopped. It is to be run before the consumers
+// start. Every instance recovers at startup, so a transfer is only taken to be left behind once its step deadline has
+// passed, or, when the tenant sets no step timeout, once nothing has been journaled for it for RECOVERY_STALE_AFTER.
+// Transfers another instance is waiting on are left to it, and should two instances resume the same transfer, the
+// journal decides which one does. Scheduled transfers are left to start once they are due.
+func Recover(l logrus.FieldLogger, ctx context.Context, db *gorm.DB) func(tf ProcessorFactory) (int, error) {
+	return func(tf ProcessorFactory) (int, error) {
+		now := time.Now()
+		entries, err := journal.StaleProvider(ctx, db)(now, now.Add(-StaleAfter(l)), terminalStates()...)()
+		if err != nil {
+			return 0, err
+		}
+
+		journals := groupByTransaction(entries)
+		recovered := 0
+		for _, j := range journals {
+			m, err := Fold(j)
+			if err != nil {
+				TransactionDecorator(j[0].TransactionId())(l).WithError(err).Errorf("Unable to rebuild compartment transfer [%s] from its journal.", j[0].TransactionId())
+				continue
+			}
+			if m.State() == StateScheduled {
+				continue
+			}
+
+			tctx := tenant.WithContext(ctx, m.Tenant())
+			err = tf(l, tctx).ResumeAndEmit(m)
+			if err != nil {
+				ModelDecorator(m)(l).WithError(err).Errorf("Unable to resume compartment transfer [%s] for tenant [%s].", m.TransactionId(), m.Tenant().Id())
+				continue
+			}
+			metrics.RecoveredTransfers.WithLabelValues(m.Tenant().Id().String(), string(m.State())).Inc()
+			recovered++
+		}
+		l.Infof("Recovered [%d] of [%d] stale in-flight compartment transfers.", recovered, len(journals))
+		return recovered, nil
+	}
+}
+
+// StaleAfter reads how long a transfer without a step deadline goes without a journal entry before it is recovered from
+// RECOVERY_STALE_AFTER
+func StaleAfter(l logrus.FieldLogger) time.Duration {
+	v, ok := settings.Lookup("RECOVERY_STALE_AFTER")
+	if !ok {
+		return defaultStaleAfter
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		l.Warnf("Invalid RECOVERY_STALE_AFTER [%s], using [%s].", v, defaultStaleAfter)
+		return defaultStaleAfter
+	}
+	return d
+}
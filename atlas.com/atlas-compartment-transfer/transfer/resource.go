@@ -0,0 +1,188 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/journal"
+	"atlas-compartment-transfer/rest"
+	"errors"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jtumidanski/api2go/jsonapi"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+func InitResource(si jsonapi.ServerInformation) func(db *gorm.DB) rest.RouteInitializer {
+	return func(db *gorm.DB) rest.RouteInitializer {
+		return func(router *mux.Router, l logrus.FieldLogger) {
+			register := rest.RegisterHandler(l)(db)(si)
+			r := router.PathPrefix("/transfers").Subrouter()
+			r.HandleFunc("/{transactionId}", register("get_transfer", handleGetTransfer)).Methods(http.MethodGet)
+			r.HandleFunc("/{transactionId}/journal", register("get_transfer_journal", handleGetTransferJournal)).Methods(http.MethodGet)
+			r.HandleFunc("/{transactionId}/abort", register("abort_transfer", handleAbortTransfer)).Methods(http.MethodPost)
+			r.HandleFunc("/{transactionId}/replay", register("replay_transfer", handleReplayTransfer)).Methods(http.MethodPost)
+			a := router.PathPrefix("/accounts/{accountId}/scheduled-transfers").Subrouter()
+			a.HandleFunc("", register("get_scheduled_transfers", handleGetScheduledTransfers)).Methods(http.MethodGet)
+			a.HandleFunc("/{transactionId}/cancel", register("cancel_scheduled_transfer", handleCancelScheduledTransfer)).Methods(http.MethodPost)
+		}
+	}
+}
+
+func handleGetTransfer(d *rest.HandlerDependency, c *rest.HandlerContext) http.HandlerFunc {
+	return rest.ParseTransactionId(d.Logger(), func(transactionId uuid.UUID) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rm, err := model.Map(Transform)(NewProcessor(d.Logger(), d.Context(), d.DB()).ByTransactionIdProvider(transactionId))()
+			if errors.Is(err, ErrIncompleteJournal) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				d.Logger().WithError(err).Errorf("Unable to rebuild transfer [%s].", transactionId)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			rest.MarshalResponse[RestModel](d.Logger())(w)(c.ServerInformation())(rm)
+		}
+	})
+}
+
+// handleGetTransferJournal returns the forensic timeline of a transfer, replaying the journal to show the state of the
+// transfer after each entry
+func handleGetTransferJournal(d *rest.HandlerDependency, c *rest.HandlerContext) http.HandlerFunc {
+	return rest.ParseTransactionId(d.Logger(), func(transactionId uuid.UUID) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			es, err := journal.NewProcessor(d.Logger(), d.Context(), d.DB()).ByTransactionIdProvider(transactionId)()
+			if err != nil {
+				d.Logger().WithError(err).Errorf("Unable to retrieve journal of transfer [%s].", transactionId)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			ms, err := Replay(es)
+			if errors.Is(err, ErrIncompleteJournal) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				d.Logger().WithError(err).Errorf("Unable to replay journal of transfer [%s].", transactionId)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			rms := make([]JournalEntryRestModel, 0, len(es))
+			for i, e := range es {
+				rm, _ := TransformJournalEntry(e, ms[i])
+				rms = append(rms, rm)
+			}
+			rest.MarshalResponse[[]JournalEntryRestModel](d.Logger())(w)(c.ServerInformation())(rms)
+		}
+	})
+}
+
+// handleAbortTransfer stops a transfer which is in progress, and returns the aborted transfer
+func handleAbortTransfer(d *rest.HandlerDependency, c *rest.HandlerContext) http.HandlerFunc {
+	return inProgressTransfer(d, c, func(p Processor, m Model) error {
+		return p.AbortAndEmit(m.TransactionId())
+	})
+}
+
+// handleReplayTransfer re-issues the pending command of a transfer which is in progress, and returns the transfer
+func handleReplayTransfer(d *rest.HandlerDependency, c *rest.HandlerContext) http.HandlerFunc {
+	return inProgressTransfer(d, c, func(p Processor, m Model) error {
+		return p.ResumeAndEmit(m)
+	})
+}
+
+// inProgressTransfer acts upon a transfer which has not finished, responding with the transfer as it is afterward. Transfers
+// which have finished, are scheduled and have not started, or which the action refuses, are a conflict.
+func inProgressTransfer(d *rest.HandlerDependency, c *rest.HandlerContext, action func(p Processor, m Model) error) http.HandlerFunc {
+	return rest.ParseTransactionId(d.Logger(), func(transactionId uuid.UUID) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			p := NewProcessor(d.Logger(), d.Context(), d.DB())
+			m, err := p.ByTransactionIdProvider(transactionId)()
+			if errors.Is(err, ErrIncompleteJournal) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				d.Logger().WithError(err).Errorf("Unable to rebuild transfer [%s].", transactionId)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if m.State().Terminal() || m.State() == StateScheduled {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+
+			err = action(p, m)
+			if errors.Is(err, ErrUnknownTransaction) || errors.Is(err, ErrNotAbortable) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			if err != nil {
+				d.Logger().WithError(err).Errorf("Unable to act upon transfer [%s].", transactionId)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			rm, err := model.Map(Transform)(p.ByTransactionIdProvider(transactionId))()
+			if err != nil {
+				d.Logger().WithError(err).Errorf("Unable to rebuild transfer [%s].", transactionId)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			rest.MarshalResponse[RestModel](d.Logger())(w)(c.ServerInformation())(rm)
+		}
+	})
+}
+
+// handleGetScheduledTransfers returns the scheduled transfers of an account which have not started, soonest due first
+func handleGetScheduledTransfers(d *rest.HandlerDependency, c *rest.HandlerContext) http.HandlerFunc {
+	return rest.ParseAccountId(d.Logger(), func(accountId uint32) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rms, err := model.SliceMap(Transform)(NewProcessor(d.Logger(), d.Context(), d.DB()).ScheduledByAccountIdProvider(accountId))()()
+			if err != nil {
+				d.Logger().WithError(err).Errorf("Unable to rebuild scheduled transfers of account [%d].", accountId)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			rest.MarshalResponse[[]RestModel](d.Logger())(w)(c.ServerInformation())(rms)
+		}
+	})
+}
+
+// handleCancelScheduledTransfer cancels a scheduled transfer of an account which has not started, and returns the
+// cancelled transfer. Transfers which have started or finished are a conflict.
+func handleCancelScheduledTransfer(d *rest.HandlerDependency, c *rest.HandlerContext) http.HandlerFunc {
+	return rest.ParseAccountId(d.Logger(), func(accountId uint32) http.HandlerFunc {
+		return rest.ParseTransactionId(d.Logger(), func(transactionId uuid.UUID) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				p := NewProcessor(d.Logger(), d.Context(), d.DB())
+				err := p.CancelAndEmit(accountId, transactionId)
+				if errors.Is(err, ErrUnknownTransaction) {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if errors.Is(err, ErrNotScheduled) {
+					w.WriteHeader(http.StatusConflict)
+					return
+				}
+				if err != nil {
+					d.Logger().WithError(err).Errorf("Unable to cancel transfer [%s].", transactionId)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				rm, err := model.Map(Transform)(p.ByTransactionIdProvider(transactionId))()
+				if err != nil {
+					d.Logger().WithError(err).Errorf("Unable to rebuild transfer [%s].", transactionId)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				rest.MarshalResponse[RestModel](d.Logger())(w)(c.ServerInformation())(rm)
+			}
+		})
+	})
+}
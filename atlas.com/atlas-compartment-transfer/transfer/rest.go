@@ -0,0 +1,123 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/journal"
+	"encoding/json"
+	"github.com/google/uuid"
+	"strconv"
+	"time"
+)
+
+// RestModel is the JSON:API resource for a transfer, as rebuilt from its journal
+type RestModel struct {
+	Id                  uuid.UUID     `json:"-"`
+	AccountId           uint32        `json:"accountId"`
+	CharacterId         uint32        `json:"characterId"`
+	AssetId             uint32        `json:"assetId"`
+	ReferenceId         uint32        `json:"referenceId"`
+	FromCompartmentId   uuid.UUID     `json:"fromCompartmentId"`
+	FromCompartmentType byte          `json:"fromCompartmentType"`
+	FromInventoryType   string        `json:"fromInventoryType"`
+	ToCompartmentId     uuid.UUID     `json:"toCompartmentId"`
+	ToCompartmentType   byte          `json:"toCompartmentType"`
+	ToInventoryType     string        `json:"toInventoryType"`
+	Fee                 *FeeRestModel `json:"fee,omitempty"`
+	DeliverAt           *time.Time    `json:"deliverAt,omitempty"`
+	State               string        `json:"state"`
+	Ordering            string        `json:"ordering"`
+	CreatedAt           time.Time     `json:"createdAt"`
+	UpdatedAt           time.Time     `json:"updatedAt"`
+}
+
+// FeeRestModel is the fee charged for a transfer, to a currency compartment
+type FeeRestModel struct {
+	Amount        uint32    `json:"amount"`
+	CompartmentId uuid.UUID `json:"compartmentId"`
+}
+
+func (r RestModel) GetName() string {
+	return "transfers"
+}
+
+func (r RestModel) GetID() string {
+	return r.Id.String()
+}
+
+func (r *RestModel) SetID(strId string) error {
+	id, err := uuid.Parse(strId)
+	if err != nil {
+		return err
+	}
+	r.Id = id
+	return nil
+}
+
+func Transform(m Model) (RestModel, error) {
+	var fee *FeeRestModel
+	if m.FeeAmount() > 0 {
+		fee = &FeeRestModel{Amount: m.FeeAmount(), CompartmentId: m.FeeCompartmentId()}
+	}
+	var deliverAt *time.Time
+	if !m.DeliverAt().IsZero() {
+		t := m.DeliverAt()
+		deliverAt = &t
+	}
+	return RestModel{
+		Id:                  m.TransactionId(),
+		AccountId:           m.AccountId(),
+		CharacterId:         m.CharacterId(),
+		AssetId:             m.AssetId(),
+		ReferenceId:         m.ReferenceId(),
+		FromCompartmentId:   m.FromCompartmentId(),
+		FromCompartmentType: m.FromCompartmentType(),
+		FromInventoryType:   m.FromInventoryType(),
+		ToCompartmentId:     m.ToCompartmentId(),
+		ToCompartmentType:   m.ToCompartmentType(),
+		ToInventoryType:     m.ToInventoryType(),
+		Fee:                 fee,
+		DeliverAt:           deliverAt,
+		State:               string(m.State()),
+		Ordering:            m.Ordering(),
+		CreatedAt:           m.CreatedAt(),
+		UpdatedAt:           m.UpdatedAt(),
+	}, nil
+}
+
+// JournalEntryRestModel is the JSON:API resource for a journal entry, with the state of the transfer once it was replayed
+type JournalEntryRestModel struct {
+	Id            uint64          `json:"-"`
+	TransactionId uuid.UUID       `json:"transactionId"`
+	Kind          string          `json:"kind"`
+	Payload       json.RawMessage `json:"payload"`
+	State         string          `json:"state"`
+	CreatedAt     time.Time       `json:"createdAt"`
+}
+
+func (r JournalEntryRestModel) GetName() string {
+	return "journal-entries"
+}
+
+func (r JournalEntryRestModel) GetID() string {
+	return strconv.FormatUint(r.Id, 10)
+}
+
+func (r *JournalEntryRestModel) SetID(strId string) error {
+	id, err := strconv.ParseUint(strId, 10, 64)
+	if err != nil {
+		return err
+	}
+	r.Id = id
+	return nil
+}
+
+// TransformJournalEntry converts a journal entry, and the transfer replayed through it, to a JournalEntryRestModel
+func TransformJournalEntry(e journal.Model, m Model) (JournalEntryRestModel, error) {
+	return JournalEntryRestModel{
+		Id:            e.Id(),
+		TransactionId: e.TransactionId(),
+		Kind:          string(e.Kind()),
+		Payload:       e.Payload(),
+		State:         string(m.State()),
+		CreatedAt:     e.CreatedAt(),
+	}, nil
+}
@@ -0,0 +1,1335 @@
+package transfer_test
+
+import (
+	"atlas-compartment-transfer/configuration"
+	"atlas-compartment-transfer/correlation"
+	"atlas-compartment-transfer/item"
+	"atlas-compartment-transfer/journal"
+	csCompartment "atlas-compartment-transfer/kafka/consumer/cashshop/compartment"
+	cCompartment "atlas-compartment-transfer/kafka/consumer/character/compartment"
+	tCompartment "atlas-compartment-transfer/kafka/consumer/compartment"
+	cuCompartment "atlas-compartment-transfer/kafka/consumer/currency/compartment"
+	compartment3 "atlas-compartment-transfer/kafka/message/cashshop/compartment"
+	compartment2 "atlas-compartment-transfer/kafka/message/character/compartment"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	compartment4 "atlas-compartment-transfer/kafka/message/currency/compartment"
+	"atlas-compartment-transfer/kafka/message/dlq"
+	"atlas-compartment-transfer/metrics"
+	"atlas-compartment-transfer/policy"
+	"atlas-compartment-transfer/ratelimit"
+	"atlas-compartment-transfer/test"
+	"atlas-compartment-transfer/tracing"
+	"atlas-compartment-transfer/transfer"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Chronicle20/atlas-kafka/consumer"
+	"github.com/Chronicle20/atlas-kafka/producer"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// harness runs the service's handlers against an in-memory bus and database
+type harness struct {
+	t   *testing.T
+	l   logrus.FieldLogger
+	ctx context.Context
+	db  *gorm.DB
+	bus *test.Bus
+	tf  transfer.ProcessorFactory
+}
+
+func newHarness(t *testing.T) *harness {
+	for _, token := range []string{
+		compartment.EnvCommandTopicCompartmentTransfer,
+		compartment.EnvEventTopicStatus,
+		compartment2.EnvCommandTopic,
+		compartment2.EnvEventTopicStatus,
+		compartment3.EnvCommandTopic,
+		compartment3.EnvEventTopicStatus,
+		compartment4.EnvCommandTopic,
+		compartment4.EnvEventTopicStatus,
+		dlq.EnvTopic,
+	} {
+		t.Setenv(token, token)
+	}
+
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	db := test.Database(t, journal.Migration, ratelimit.Migration)
+	bus := test.NewBus(l)
+	tf := transfer.NewProcessorFactory(db, bus.ProviderFactory, transfer.NewTransactionCache())
+	tCompartment.InitHandlers(l)(bus.ProviderFactory)(tf)(bus.RegisterHandler)
+	cCompartment.InitHandlers(l)(bus.ProviderFactory)(tf)(bus.RegisterHandler)
+	csCompartment.InitHandlers(l)(bus.ProviderFactory)(tf)(bus.RegisterHandler)
+	cuCompartment.InitHandlers(l)(bus.ProviderFactory)(tf)(bus.RegisterHandler)
+
+	te, err := tenant.Create(uuid.New(), "GMS", 83, 1)
+	if err != nil {
+		t.Fatalf("Unable to create tenant: %v", err)
+	}
+	return &harness{t: t, l: l, ctx: tenant.WithContext(context.Background(), te), db: db, bus: bus, tf: tf}
+}
+
+// publish puts a message onto the bus, and dispatches until every handler has run
+func (h *harness) publish(token string, value interface{}) {
+	h.t.Helper()
+	err := h.bus.Publish(h.ctx, token, producer.SingleMessageProvider(producer.CreateKey(0), value))
+	if err != nil {
+		h.t.Fatalf("Unable to publish to [%s]: %v", token, err)
+	}
+	err = h.bus.Dispatch()
+	if err != nil {
+		h.t.Fatalf("Unable to dispatch: %v", err)
+	}
+}
+
+// reply publishes the status event a compartment of the inventory type would emit for the transaction
+func (h *harness) reply(inventoryType string, eventType string, transactionId uuid.UUID) {
+	h.t.Helper()
+	if inventoryType == compartment.InventoryTypeCashShop {
+		h.publish(compartment3.EnvEventTopicStatus, compartment3.StatusEvent[compartment3.StatusEventReleasedBody]{
+			Type: eventType,
+			Body: compartment3.StatusEventReleasedBody{TransactionId: transactionId},
+		})
+		return
+	}
+	h.publish(compartment2.EnvEventTopicStatus, compartment2.StatusEvent[compartment2.ReleasedEventBody]{
+		CharacterId: 1,
+		Type:        eventType,
+		Body:        compartment2.ReleasedEventBody{TransactionId: transactionId},
+	})
+}
+
+// replyCurrency publishes the status event the currency compartment would emit for the transaction
+func (h *harness) replyCurrency(eventType string, transactionId uuid.UUID) {
+	h.t.Helper()
+	h.publish(compartment4.EnvEventTopicStatus, compartment4.StatusEvent[compartment4.StatusEventDebitedBody]{
+		Version:   compartment4.StatusEventVersions.Current(),
+		AccountId: 1000,
+		Type:      eventType,
+		Body:      compartment4.StatusEventDebitedBody{TransactionId: transactionId, Amount: 100},
+	})
+}
+
+// commandTypes decodes the types of the commands, or events, emitted to the topic
+func (h *harness) commandTypes(token string) []string {
+	h.t.Helper()
+	types := make([]string, 0)
+	for _, m := range h.bus.Messages(token) {
+		var c struct {
+			Type string `json:"type"`
+		}
+		decode(h.t, m, &c)
+		types = append(types, c.Type)
+	}
+	return types
+}
+
+// commandTransactionId decodes the transaction id of a command emitted to the topic
+func (h *harness) commandTransactionId(token string, i int) uuid.UUID {
+	h.t.Helper()
+	var c struct {
+		Body struct {
+			TransactionId uuid.UUID `json:"transactionId"`
+		} `json:"body"`
+	}
+	decode(h.t, h.bus.Messages(token)[i], &c)
+	return c.Body.TransactionId
+}
+
+// journal retrieves the journal of the transaction, oldest entry first
+func (h *harness) journal(transactionId uuid.UUID) []journal.Model {
+	h.t.Helper()
+	entries, err := journal.NewProcessor(h.l, h.ctx, h.db).ByTransactionIdProvider(transactionId)()
+	if err != nil {
+		h.t.Fatalf("Unable to retrieve journal: %v", err)
+	}
+	return entries
+}
+
+func (h *harness) deadLetterReasons() []string {
+	h.t.Helper()
+	reasons := make([]string, 0)
+	for _, m := range h.bus.Messages(dlq.EnvTopic) {
+		var e dlq.Entry
+		decode(h.t, m, &e)
+		reasons = append(reasons, e.Reason)
+	}
+	return reasons
+}
+
+func decode(t *testing.T, m kafka.Message, v interface{}) {
+	t.Helper()
+	err := json.Unmarshal(m.Value, v)
+	if err != nil {
+		t.Fatalf("Unable to decode message from [%s]: %v", m.Topic, err)
+	}
+}
+
+func commandTopic(inventoryType string) string {
+	if inventoryType == compartment.InventoryTypeCashShop {
+		return compartment3.EnvCommandTopic
+	}
+	return compartment2.EnvCommandTopic
+}
+
+func transferCommand(transactionId uuid.UUID, from string, to string) compartment.TransferCommand {
+	return compartment.TransferCommand{
+		TransactionId:       transactionId,
+		AccountId:           1000,
+		CharacterId:         1,
+		AssetId:             5,
+		FromCompartmentId:   uuid.New(),
+		FromCompartmentType: 1,
+		FromInventoryType:   from,
+		ToCompartmentId:     uuid.New(),
+		ToCompartmentType:   1,
+		ToInventoryType:     to,
+		ReferenceId:         2000,
+	}
+}
+
+func TestTransferSaga(t *testing.T) {
+	tests := []struct {
+		name         string
+		from         string
+		to           string
+		destination  string
+		source       string
+		fromCommands []string
+		toCommands   []string
+		events       []string
+		state        transfer.State
+		deadLetters  []string
+	}{
+		{
+			name:         "character to cash shop completes",
+			from:         compartment.InventoryTypeCharacter,
+			to:           compartment.InventoryTypeCashShop,
+			destination:  compartment3.StatusEventTypeAccepted,
+			source:       compartment2.StatusEventTypeReleased,
+			fromCommands: []string{compartment2.CommandRelease},
+			toCommands:   []string{compartment3.CommandAccept},
+			events:       []string{compartment.StatusEventTypeCompleted},
+			state:        transfer.StateCompleted,
+			deadLetters:  []string{},
+		},
+		{
+			name:         "cash shop to character completes",
+			from:         compartment.InventoryTypeCashShop,
+			to:           compartment.InventoryTypeCharacter,
+			destination:  compartment2.StatusEventTypeAccepted,
+			source:       compartment3.StatusEventTypeReleased,
+			fromCommands: []string{compartment3.CommandRelease},
+			toCommands:   []string{compartment2.CommandAccept},
+			events:       []string{compartment.StatusEventTypeCompleted},
+			state:        transfer.StateCompleted,
+			deadLetters:  []string{},
+		},
+		{
+			name:         "destination fails to accept",
+			from:         compartment.InventoryTypeCharacter,
+			to:           compartment.InventoryTypeCashShop,
+			destination:  compartment3.StatusEventTypeError,
+			fromCommands: []string{},
+			toCommands:   []string{compartment3.CommandAccept},
+			events:       []string{},
+			state:        transfer.StateFailed,
+			deadLetters:  []string{},
+		},
+		{
+			name:         "source fails to release",
+			from:         compartment.InventoryTypeCashShop,
+			to:           compartment.InventoryTypeCharacter,
+			destination:  compartment2.StatusEventTypeAccepted,
+			source:       compartment3.StatusEventTypeError,
+			fromCommands: []string{compartment3.CommandRelease},
+			toCommands:   []string{compartment2.CommandAccept},
+			events:       []string{},
+			state:        transfer.StateFailed,
+			deadLetters:  []string{},
+		},
+		{
+			name:         "source reports an error after completion",
+			from:         compartment.InventoryTypeCharacter,
+			to:           compartment.InventoryTypeCashShop,
+			destination:  compartment3.StatusEventTypeAccepted,
+			source:       compartment2.StatusEventTypeReleased,
+			fromCommands: []string{compartment2.CommandRelease},
+			toCommands:   []string{compartment3.CommandAccept},
+			events:       []string{compartment.StatusEventTypeCompleted},
+			state:        transfer.StateCompleted,
+			deadLetters:  []string{dlq.ReasonUnknownTransaction},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			transactionId := uuid.New()
+
+			h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, tt.from, tt.to))
+			if tt.destination != "" {
+				h.reply(tt.to, tt.destination, transactionId)
+			}
+			if tt.source != "" {
+				h.reply(tt.from, tt.source, transactionId)
+			}
+			if len(tt.deadLetters) > 0 {
+				h.reply(tt.from, compartment2.StatusEventTypeError, transactionId)
+			}
+
+			assertTypes(t, "source commands", tt.fromCommands, h.commandTypes(commandTopic(tt.from)))
+			assertTypes(t, "destination commands", tt.toCommands, h.commandTypes(commandTopic(tt.to)))
+			assertTypes(t, "transfer events", tt.events, h.commandTypes(compartment.EnvEventTopicStatus))
+			assertTypes(t, "dead letters", tt.deadLetters, h.deadLetterReasons())
+
+			m, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+			if err != nil {
+				t.Fatalf("Unable to retrieve transfer: %v", err)
+			}
+			if m.State() != tt.state {
+				t.Errorf("Expected state [%s], got [%s].", tt.state, m.State())
+			}
+		})
+	}
+}
+
+func TestTransferSagaRejectsInvalidCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  compartment.TransferCommand
+	}{
+		{name: "missing transaction id", cmd: transferCommand(uuid.Nil, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop)},
+		{name: "unsupported source", cmd: transferCommand(uuid.New(), "STORAGE", compartment.InventoryTypeCashShop)},
+		{name: "unsupported destination", cmd: transferCommand(uuid.New(), compartment.InventoryTypeCharacter, "STORAGE")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.publish(compartment.EnvCommandTopicCompartmentTransfer, tt.cmd)
+
+			assertTypes(t, "character commands", []string{}, h.commandTypes(compartment2.EnvCommandTopic))
+			assertTypes(t, "cash shop commands", []string{}, h.commandTypes(compartment3.EnvCommandTopic))
+			assertTypes(t, "dead letters", []string{dlq.ReasonInvalidCommand}, h.deadLetterReasons())
+
+			_, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(tt.cmd.TransactionId)()
+			if !errors.Is(err, transfer.ErrIncompleteJournal) {
+				t.Errorf("Expected no journal, got [%v].", err)
+			}
+		})
+	}
+}
+
+func TestTransferSagaPreflight(t *testing.T) {
+	tests := []struct {
+		name          string
+		accountId     uint32
+		heldAssetId   uint32
+		referenceId   uint32
+		capacity      uint32
+		held          int
+		templateId    uint32
+		noCharacter   bool
+		noDestination bool
+		failing       bool
+		reason        string
+	}{
+		{name: "transfer passes every check", capacity: 4, held: 3, templateId: 1302000},
+		{name: "character does not exist", noCharacter: true, reason: compartment.RejectedReasonCharacterNotOwned},
+		{name: "character belongs to another account", accountId: 1001, reason: compartment.RejectedReasonCharacterNotOwned},
+		{name: "source does not hold the asset", heldAssetId: 6, reason: compartment.RejectedReasonAssetNotHeld},
+		{name: "source holds the asset under another reference", referenceId: 2001, reason: compartment.RejectedReasonAssetNotHeld},
+		{name: "destination is full", capacity: 4, held: 4, templateId: 1302000, reason: compartment.RejectedReasonNoCapacity},
+		{name: "destination does not hold the asset's type", capacity: 4, templateId: 2000000, reason: compartment.RejectedReasonAssetNotAllowed},
+		{name: "destination does not exist", noDestination: true, reason: compartment.RejectedReasonUnknownDestination},
+		{name: "services are unavailable", failing: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			services := test.NewServices(t)
+			transactionId := uuid.New()
+			cmd := transferCommand(transactionId, compartment.InventoryTypeCashShop, compartment.InventoryTypeCharacter)
+
+			if !tt.noCharacter {
+				accountId := cmd.AccountId
+				if tt.accountId != 0 {
+					accountId = tt.accountId
+				}
+				services.SetCharacter(cmd.CharacterId, accountId)
+			}
+			asset := test.Asset{Id: cmd.AssetId, TemplateId: tt.templateId, ReferenceId: cmd.ReferenceId}
+			if tt.heldAssetId != 0 {
+				asset.Id = tt.heldAssetId
+			}
+			if tt.referenceId != 0 {
+				asset.ReferenceId = tt.referenceId
+			}
+			services.SetCompartment(compartment.InventoryTypeCashShop, cmd.AccountId, cmd.FromCompartmentId, 1, 10, asset)
+			if !tt.noDestination {
+				held := make([]test.Asset, 0)
+				for i := 0; i < tt.held; i++ {
+					held = append(held, test.Asset{Id: uint32(100 + i), TemplateId: 1302000})
+				}
+				services.SetCompartment(compartment.InventoryTypeCharacter, cmd.CharacterId, cmd.ToCompartmentId, 1, tt.capacity, held...)
+			}
+			services.SetFailing(tt.failing)
+			h.publish(compartment.EnvCommandTopicCompartmentTransfer, cmd)
+
+			if tt.failing {
+				// The transfer is not started unchecked
+				assertTypes(t, "destination commands", []string{}, h.commandTypes(compartment2.EnvCommandTopic))
+				assertTypes(t, "dead letters", []string{dlq.ReasonProcessingFailed}, h.deadLetterReasons())
+				_, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+				if !errors.Is(err, transfer.ErrIncompleteJournal) {
+					t.Errorf("Expected no journal, got [%v].", err)
+				}
+				return
+			}
+
+			state := transfer.StateAccepting
+			accepts := []string{compartment2.CommandAccept}
+			events := []string{}
+			if tt.reason != "" {
+				state = transfer.StateRejected
+				accepts = []string{}
+				events = []string{compartment.StatusEventTypeRejected}
+			}
+			assertTypes(t, "destination commands", accepts, h.commandTypes(compartment2.EnvCommandTopic))
+			assertTypes(t, "transfer events", events, h.commandTypes(compartment.EnvEventTopicStatus))
+			assertTypes(t, "dead letters", []string{}, h.deadLetterReasons())
+			if tt.reason != "" {
+				var e compartment.StatusEvent[compartment.StatusEventRejectedBody]
+				decode(t, h.bus.Messages(compartment.EnvEventTopicStatus)[0], &e)
+				if e.Body.Reason != tt.reason || e.Body.TransactionId != transactionId {
+					t.Errorf("Expected transfer [%s] to be rejected for [%s], got [%s] rejected for [%s].", transactionId, tt.reason, e.Body.TransactionId, e.Body.Reason)
+				}
+			}
+
+			m, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+			if err != nil {
+				t.Fatalf("Unable to retrieve transfer: %v", err)
+			}
+			if m.State() != state {
+				t.Errorf("Expected state [%s], got [%s].", state, m.State())
+			}
+		})
+	}
+}
+
+// usePolicy puts the transfer policy in place for the duration of the test
+func usePolicy(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("Unable to write policy: %v", err)
+	}
+	t.Setenv("TRANSFER_POLICY_FILE", path)
+	t.Cleanup(func() {
+		_ = os.Unsetenv("TRANSFER_POLICY_FILE")
+		_ = policy.GetRegistry().Load()
+	})
+	err = policy.GetRegistry().Load()
+	if err != nil {
+		t.Fatalf("Unable to load policy: %v", err)
+	}
+}
+
+func TestTransferSagaPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		flags  []string
+		noItem bool
+		ruleId string
+	}{
+		{name: "policy allows the item", flags: []string{item.FlagCash}},
+		{name: "policy denies the item", flags: []string{item.FlagCash, item.FlagAccountBound}, ruleId: "bound-to-cash-shop"},
+		{name: "item metadata is unavailable", noItem: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			services := test.NewServices(t)
+			tenantId := tenant.MustFromContext(h.ctx).Id()
+			usePolicy(t, `{"tenants":{"`+tenantId.String()+`":[{"id":"bound-to-cash-shop","effect":"DENY","flags":["ACCOUNT_BOUND"],"fromInventoryType":"CASH_SHOP"}]}}`)
+
+			transactionId := uuid.New()
+			cmd := transferCommand(transactionId, compartment.InventoryTypeCashShop, compartment.InventoryTypeCharacter)
+			services.SetCharacter(cmd.CharacterId, cmd.AccountId)
+			services.SetCompartment(compartment.InventoryTypeCashShop, cmd.AccountId, cmd.FromCompartmentId, 1, 10, test.Asset{Id: cmd.AssetId, TemplateId: 1302000, ReferenceId: cmd.ReferenceId})
+			services.SetCompartment(compartment.InventoryTypeCharacter, cmd.CharacterId, cmd.ToCompartmentId, 1, 4)
+			if !tt.noItem {
+				services.SetItem(1302000, tt.flags...)
+			}
+			h.publish(compartment.EnvCommandTopicCompartmentTransfer, cmd)
+
+			if tt.noItem {
+				// The transfer is not started when its policy cannot be evaluated
+				assertTypes(t, "destination commands", []string{}, h.commandTypes(compartment2.EnvCommandTopic))
+				assertTypes(t, "dead letters", []string{dlq.ReasonProcessingFailed}, h.deadLetterReasons())
+				_, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+				if !errors.Is(err, transfer.ErrIncompleteJournal) {
+					t.Errorf("Expected no journal, got [%v].", err)
+				}
+				return
+			}
+
+			if tt.ruleId == "" {
+				assertTypes(t, "destination commands", []string{compartment2.CommandAccept}, h.commandTypes(compartment2.EnvCommandTopic))
+				assertTypes(t, "transfer events", []string{}, h.commandTypes(compartment.EnvEventTopicStatus))
+				return
+			}
+
+			assertTypes(t, "destination commands", []string{}, h.commandTypes(compartment2.EnvCommandTopic))
+			assertTypes(t, "transfer events", []string{compartment.StatusEventTypeRejected}, h.commandTypes(compartment.EnvEventTopicStatus))
+			var e compartment.StatusEvent[compartment.StatusEventRejectedBody]
+			decode(t, h.bus.Messages(compartment.EnvEventTopicStatus)[0], &e)
+			if e.Body.Reason != compartment.RejectedReasonPolicyDenied || e.Body.RuleId != tt.ruleId {
+				t.Errorf("Expected transfer to be denied by rule [%s], got [%s] by rule [%s].", tt.ruleId, e.Body.Reason, e.Body.RuleId)
+			}
+
+			var body journal.StateChangedBody
+			entries := h.journal(transactionId)
+			err := json.Unmarshal(entries[len(entries)-1].Payload(), &body)
+			if err != nil || body.To != string(transfer.StateRejected) || body.RuleId != tt.ruleId {
+				t.Errorf("Expected the journal to record the denial by rule [%s], got %+v.", tt.ruleId, body)
+			}
+		})
+	}
+}
+
+// useConfiguration configures the tenant of the harness for the duration of the test
+func (h *harness) useConfiguration(content string) {
+	h.t.Helper()
+	path := filepath.Join(h.t.TempDir(), "tenants.json")
+	tenantId := tenant.MustFromContext(h.ctx).Id()
+	err := os.WriteFile(path, []byte(`{"tenants":{"`+tenantId.String()+`":`+content+`}}`), 0o600)
+	if err != nil {
+		h.t.Fatalf("Unable to write configuration: %v", err)
+	}
+	h.t.Setenv("TRANSFER_TENANT_CONFIG_FILE", path)
+	h.t.Cleanup(func() {
+		_ = os.Unsetenv("TRANSFER_TENANT_CONFIG_FILE")
+		_ = configuration.GetRegistry().Load()
+	})
+	err = configuration.GetRegistry().Load()
+	if err != nil {
+		h.t.Fatalf("Unable to load configuration: %v", err)
+	}
+}
+
+func TestTransferSagaReleaseFirst(t *testing.T) {
+	h := newHarness(t)
+	h.useConfiguration(`{"ordering":"RELEASE_FIRST"}`)
+	transactionId := uuid.New()
+
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+	assertTypes(t, "source commands", []string{compartment2.CommandRelease}, h.commandTypes(compartment2.EnvCommandTopic))
+	assertTypes(t, "destination commands", []string{}, h.commandTypes(compartment3.EnvCommandTopic))
+
+	// The transfer keeps the ordering it started with, as the journal records it
+	h.useConfiguration(`{"ordering":"ACCEPT_FIRST"}`)
+	m, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+	if err != nil {
+		t.Fatalf("Unable to retrieve transfer: %v", err)
+	}
+	if m.State() != transfer.StateReleasing || m.Ordering() != configuration.OrderingReleaseFirst {
+		t.Fatalf("Expected a release first transfer to be releasing, got [%s] [%s].", m.Ordering(), m.State())
+	}
+
+	h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, transactionId)
+	assertTypes(t, "destination commands", []string{compartment3.CommandAccept}, h.commandTypes(compartment3.EnvCommandTopic))
+	assertTypes(t, "transfer events", []string{}, h.commandTypes(compartment.EnvEventTopicStatus))
+
+	h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+	assertTypes(t, "source commands", []string{compartment2.CommandRelease}, h.commandTypes(compartment2.EnvCommandTopic))
+	assertTypes(t, "transfer events", []string{compartment.StatusEventTypeCompleted}, h.commandTypes(compartment.EnvEventTopicStatus))
+	assertTypes(t, "dead letters", []string{}, h.deadLetterReasons())
+
+	m, err = h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+	if err != nil {
+		t.Fatalf("Unable to retrieve transfer: %v", err)
+	}
+	if m.State() != transfer.StateCompleted {
+		t.Errorf("Expected state [%s], got [%s].", transfer.StateCompleted, m.State())
+	}
+}
+
+func TestTransferSagaRouteNotEnabled(t *testing.T) {
+	h := newHarness(t)
+	h.useConfiguration(`{"routes":[{"from":"CASH_SHOP","to":"CHARACTER"}]}`)
+	transactionId := uuid.New()
+
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+	assertTypes(t, "destination commands", []string{}, h.commandTypes(compartment3.EnvCommandTopic))
+	assertTypes(t, "transfer events", []string{compartment.StatusEventTypeRejected}, h.commandTypes(compartment.EnvEventTopicStatus))
+	var e compartment.StatusEvent[compartment.StatusEventRejectedBody]
+	decode(t, h.bus.Messages(compartment.EnvEventTopicStatus)[0], &e)
+	if e.Body.Reason != compartment.RejectedReasonRouteNotEnabled {
+		t.Errorf("Expected transfer to be rejected for [%s], got [%s].", compartment.RejectedReasonRouteNotEnabled, e.Body.Reason)
+	}
+
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(uuid.New(), compartment.InventoryTypeCashShop, compartment.InventoryTypeCharacter))
+	assertTypes(t, "destination commands", []string{compartment2.CommandAccept}, h.commandTypes(compartment2.EnvCommandTopic))
+}
+
+func TestTransferSagaUnwritableCommand(t *testing.T) {
+	t.Run("transfer is not started", func(t *testing.T) {
+		h := newHarness(t)
+		t.Setenv(compartment3.EnvCommandTopic+"_VERSION", "99")
+		transactionId := uuid.New()
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+
+		assertTypes(t, "destination commands", []string{}, h.commandTypes(compartment3.EnvCommandTopic))
+		assertTypes(t, "dead letters", []string{dlq.ReasonProcessingFailed}, h.deadLetterReasons())
+		_, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+		if !errors.Is(err, transfer.ErrIncompleteJournal) {
+			t.Errorf("Expected the transfer not to be journaled, got [%v].", err)
+		}
+	})
+
+	t.Run("transfer is not advanced", func(t *testing.T) {
+		h := newHarness(t)
+		transactionId := uuid.New()
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		t.Setenv(compartment2.EnvCommandTopic+"_VERSION", "99")
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+
+		assertTypes(t, "source commands", []string{}, h.commandTypes(compartment2.EnvCommandTopic))
+		assertTypes(t, "dead letters", []string{dlq.ReasonProcessingFailed}, h.deadLetterReasons())
+		m, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+		if err != nil || m.State() != transfer.StateAccepting {
+			t.Errorf("Expected the transfer to still be [%s], got [%s] [%v].", transfer.StateAccepting, m.State(), err)
+		}
+	})
+}
+
+func TestTransferSagaRateLimited(t *testing.T) {
+	rejections := func(h *harness) []string {
+		reasons := make([]string, 0)
+		for _, m := range h.bus.Messages(compartment.EnvEventTopicStatus) {
+			var e compartment.StatusEvent[compartment.StatusEventRejectedBody]
+			decode(t, m, &e)
+			reasons = append(reasons, e.Body.Reason)
+		}
+		return reasons
+	}
+
+	t.Run("character rate", func(t *testing.T) {
+		h := newHarness(t)
+		h.useConfiguration(`{"limits":{"characterRate":{"count":1,"per":"1h"}}}`)
+		first := transferCommand(uuid.New(), compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop)
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, first)
+		// A repeated command is ignored rather than counted against the limit
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, first)
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(uuid.New(), compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		assertTypes(t, "rejections", []string{compartment.RejectedReasonRateLimited}, rejections(h))
+
+		other := transferCommand(uuid.New(), compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop)
+		other.CharacterId = 2
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, other)
+		assertTypes(t, "destination commands", []string{compartment3.CommandAccept, compartment3.CommandAccept}, h.commandTypes(compartment3.EnvCommandTopic))
+	})
+
+	t.Run("transfer refused otherwise is not taken from the rate", func(t *testing.T) {
+		h := newHarness(t)
+		h.useConfiguration(`{"routes":[{"from":"CASH_SHOP","to":"CHARACTER"}],"limits":{"characterRate":{"count":1,"per":"1h"}}}`)
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(uuid.New(), compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(uuid.New(), compartment.InventoryTypeCashShop, compartment.InventoryTypeCharacter))
+		assertTypes(t, "rejections", []string{compartment.RejectedReasonRouteNotEnabled}, rejections(h))
+		assertTypes(t, "destination commands", []string{compartment2.CommandAccept}, h.commandTypes(compartment2.EnvCommandTopic))
+	})
+
+	t.Run("scheduled transfer is taken from the rate when accepted", func(t *testing.T) {
+		h := newHarness(t)
+		h.useConfiguration(`{"limits":{"characterRate":{"count":1,"per":"1h"}}}`)
+		scheduled := transferCommand(uuid.New(), compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop)
+		deliverAt := time.Now().Add(time.Hour)
+		scheduled.DeliverAt = &deliverAt
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, scheduled)
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(uuid.New(), compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		assertTypes(t, "rejections", []string{compartment.RejectedReasonRateLimited}, rejections(h))
+
+		m, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(scheduled.TransactionId)()
+		if err != nil || m.State() != transfer.StateScheduled {
+			t.Fatalf("Expected the transfer accepted first to be scheduled, got [%v].", err)
+		}
+	})
+
+	t.Run("account in flight", func(t *testing.T) {
+		h := newHarness(t)
+		h.useConfiguration(`{"limits":{"maxInFlight":1}}`)
+		first := uuid.New()
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(first, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(uuid.New(), compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		assertTypes(t, "rejections", []string{compartment.RejectedReasonRateLimited}, rejections(h))
+
+		// Once the first transfer finishes, the account may start another
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, first)
+		h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, first)
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(uuid.New(), compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		assertTypes(t, "destination commands", []string{compartment3.CommandAccept, compartment3.CommandAccept}, h.commandTypes(compartment3.EnvCommandTopic))
+	})
+}
+
+func TestTransferSagaStepTimeout(t *testing.T) {
+	h := newHarness(t)
+	h.useConfiguration(`{"timeouts":{"step":"1ns"},"limits":{"maxAttempts":2}}`)
+	transactionId := uuid.New()
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+
+	// The first timeout issues the command again, and the second fails the transfer
+	for _, expected := range []transfer.State{transfer.StateAccepting, transfer.StateFailed} {
+		n, err := transfer.TimeOutSteps(h.l, h.ctx, h.db)(h.tf)
+		if err != nil || n != 1 {
+			t.Fatalf("Expected one step to time out, got [%d] [%v].", n, err)
+		}
+		m, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+		if err != nil {
+			t.Fatalf("Unable to retrieve transfer: %v", err)
+		}
+		if m.State() != expected {
+			t.Fatalf("Expected state [%s], got [%s].", expected, m.State())
+		}
+	}
+	assertTypes(t, "destination commands", []string{compartment3.CommandAccept, compartment3.CommandAccept}, h.commandTypes(compartment3.EnvCommandTopic))
+
+	n, err := transfer.TimeOutSteps(h.l, h.ctx, h.db)(h.tf)
+	if err != nil || n != 0 {
+		t.Errorf("Expected a failed transfer not to time out, got [%d] [%v].", n, err)
+	}
+
+	// A late answer is for a transaction no longer in progress
+	h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+	assertTypes(t, "dead letters", []string{dlq.ReasonUnknownTransaction}, h.deadLetterReasons())
+}
+
+func TestTransferSagaStepDeadline(t *testing.T) {
+	h := newHarness(t)
+	h.useConfiguration(`{"timeouts":{"step":"1h"}}`)
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(uuid.New(), compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+	h.useConfiguration(`{}`)
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(uuid.New(), compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+
+	// Each step keeps the deadline of the step timeout it was taken under
+	h.useConfiguration(`{"timeouts":{"step":"1ns"}}`)
+	n, err := transfer.TimeOutSteps(h.l, h.ctx, h.db)(h.tf)
+	if err != nil || n != 0 {
+		t.Errorf("Expected steps before their deadline, or without one, not to time out, got [%d] [%v].", n, err)
+	}
+	assertTypes(t, "destination commands", []string{compartment3.CommandAccept, compartment3.CommandAccept}, h.commandTypes(compartment3.EnvCommandTopic))
+}
+
+func TestTransferSagaRecovery(t *testing.T) {
+	h := newHarness(t)
+	resumed := func() map[uuid.UUID]int {
+		accepts := make(map[uuid.UUID]int)
+		for i := range h.commandTypes(compartment3.EnvCommandTopic) {
+			accepts[h.commandTransactionId(compartment3.EnvCommandTopic, i)]++
+		}
+		return accepts
+	}
+
+	// Another instance is waiting on a step whose deadline has yet to pass, and on a step without one
+	h.useConfiguration(`{"timeouts":{"step":"1h"}}`)
+	live := uuid.New()
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(live, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+	h.useConfiguration(`{}`)
+	recent := uuid.New()
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(recent, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+	h.useConfiguration(`{"timeouts":{"step":"1ns"}}`)
+	due := uuid.New()
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(due, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+
+	n, err := transfer.Recover(h.l, h.ctx, h.db)(h.tf)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected only the transfer past its deadline to be recovered, got [%d] [%v].", n, err)
+	}
+	if accepts := resumed(); accepts[live] != 1 || accepts[recent] != 1 || accepts[due] != 2 {
+		t.Errorf("Expected ACCEPT to be issued again for [%s] only, got %v.", due, accepts)
+	}
+
+	// A step without a deadline is recovered once nothing has been journaled for it for a while
+	h.useConfiguration(`{}`)
+	t.Setenv("RECOVERY_STALE_AFTER", "0s")
+	n, err = transfer.Recover(h.l, h.ctx, h.db)(h.tf)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected the stale transfers to be recovered, got [%d] [%v].", n, err)
+	}
+	if accepts := resumed(); accepts[live] != 1 || accepts[recent] != 2 || accepts[due] != 3 {
+		t.Errorf("Expected ACCEPT to be issued again for [%s] and [%s] only, got %v.", recent, due, accepts)
+	}
+
+	// Every transfer in flight is gauged, whichever instance started it
+	transfer.GaugeInFlight(h.l, h.ctx, h.db)
+	var m dto.Metric
+	err = metrics.InFlightTransfers.WithLabelValues(tenant.MustFromContext(h.ctx).Id().String()).Write(&m)
+	if err != nil || m.GetGauge().GetValue() != 3 {
+		t.Errorf("Expected [3] transfers in flight, got [%v] [%v].", m.GetGauge().GetValue(), err)
+	}
+}
+
+func TestTransferSagaFee(t *testing.T) {
+	withFee := func(transactionId uuid.UUID) compartment.TransferCommand {
+		cmd := transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop)
+		cmd.Fee = &compartment.Fee{Amount: 100, CompartmentId: uuid.New()}
+		return cmd
+	}
+	state := func(h *harness, transactionId uuid.UUID) transfer.State {
+		m, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+		if err != nil {
+			h.t.Fatalf("Unable to retrieve transfer: %v", err)
+		}
+		return m.State()
+	}
+
+	t.Run("fee is charged before the asset is moved", func(t *testing.T) {
+		h := newHarness(t)
+		transactionId := uuid.New()
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, withFee(transactionId))
+		assertTypes(t, "currency commands", []string{compartment4.CommandDebit}, h.commandTypes(compartment4.EnvCommandTopic))
+		assertTypes(t, "destination commands", []string{}, h.commandTypes(compartment3.EnvCommandTopic))
+
+		h.replyCurrency(compartment4.StatusEventTypeDebited, transactionId)
+		assertTypes(t, "destination commands", []string{compartment3.CommandAccept}, h.commandTypes(compartment3.EnvCommandTopic))
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+		h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, transactionId)
+
+		assertTypes(t, "transfer events", []string{compartment.StatusEventTypeCompleted}, h.commandTypes(compartment.EnvEventTopicStatus))
+		var e compartment.StatusEvent[compartment.StatusEventCompletedBody]
+		decode(t, h.bus.Messages(compartment.EnvEventTopicStatus)[0], &e)
+		if e.Body.Fee == nil || e.Body.Fee.Amount != 100 {
+			t.Errorf("Expected the completed transfer to report its fee of [100], got %+v.", e.Body.Fee)
+		}
+		if s := state(h, transactionId); s != transfer.StateCompleted {
+			t.Errorf("Expected state [%s], got [%s].", transfer.StateCompleted, s)
+		}
+	})
+
+	t.Run("fee is refunded when the asset is not moved", func(t *testing.T) {
+		h := newHarness(t)
+		transactionId := uuid.New()
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, withFee(transactionId))
+		h.replyCurrency(compartment4.StatusEventTypeDebited, transactionId)
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeError, transactionId)
+		assertTypes(t, "currency commands", []string{compartment4.CommandDebit, compartment4.CommandCredit}, h.commandTypes(compartment4.EnvCommandTopic))
+		if s := state(h, transactionId); s != transfer.StateRefunding {
+			t.Fatalf("Expected state [%s], got [%s].", transfer.StateRefunding, s)
+		}
+
+		h.replyCurrency(compartment4.StatusEventTypeCredited, transactionId)
+		if s := state(h, transactionId); s != transfer.StateFailed {
+			t.Errorf("Expected state [%s], got [%s].", transfer.StateFailed, s)
+		}
+		assertTypes(t, "dead letters", []string{}, h.deadLetterReasons())
+	})
+
+	t.Run("fee is refunded when the transfer is aborted", func(t *testing.T) {
+		h := newHarness(t)
+		transactionId := uuid.New()
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, withFee(transactionId))
+		err := h.tf(h.l, h.ctx).AbortAndEmit(transactionId)
+		if !errors.Is(err, transfer.ErrNotAbortable) {
+			t.Fatalf("Expected a transfer being charged not to be aborted, got [%v].", err)
+		}
+
+		h.replyCurrency(compartment4.StatusEventTypeDebited, transactionId)
+		err = h.tf(h.l, h.ctx).AbortAndEmit(transactionId)
+		if err != nil {
+			t.Fatalf("Unable to abort transfer: %v", err)
+		}
+		assertTypes(t, "currency commands", []string{compartment4.CommandDebit, compartment4.CommandCredit}, h.commandTypes(compartment4.EnvCommandTopic))
+
+		var body journal.StateChangedBody
+		entries := h.journal(transactionId)
+		if err = json.Unmarshal(entries[len(entries)-2].Payload(), &body); err != nil || body.To != string(transfer.StateRefunding) || body.Reason != transfer.ReasonAborted {
+			t.Fatalf("Expected the journal to record the refund for [%s], got %+v.", transfer.ReasonAborted, body)
+		}
+		h.replyCurrency(compartment4.StatusEventTypeCredited, transactionId)
+		if s := state(h, transactionId); s != transfer.StateFailed {
+			t.Errorf("Expected state [%s], got [%s].", transfer.StateFailed, s)
+		}
+	})
+
+	t.Run("answers out of turn are ignored", func(t *testing.T) {
+		h := newHarness(t)
+		transactionId := uuid.New()
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, withFee(transactionId))
+
+		// Nothing is moved while the fee is being charged
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+		h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, transactionId)
+		assertTypes(t, "source commands", []string{}, h.commandTypes(compartment2.EnvCommandTopic))
+		if s := state(h, transactionId); s != transfer.StateCharging {
+			t.Fatalf("Expected state [%s], got [%s].", transfer.StateCharging, s)
+		}
+
+		// A repeated acceptance does not release the asset twice
+		h.replyCurrency(compartment4.StatusEventTypeDebited, transactionId)
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+		assertTypes(t, "source commands", []string{compartment2.CommandRelease}, h.commandTypes(compartment2.EnvCommandTopic))
+
+		// A refund is not completed by a late release
+		h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeError, transactionId)
+		h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, transactionId)
+		assertTypes(t, "transfer events", []string{}, h.commandTypes(compartment.EnvEventTopicStatus))
+		if s := state(h, transactionId); s != transfer.StateRefunding {
+			t.Errorf("Expected state [%s], got [%s].", transfer.StateRefunding, s)
+		}
+		assertTypes(t, "dead letters", []string{}, h.deadLetterReasons())
+	})
+
+	t.Run("fee which cannot be charged fails the transfer", func(t *testing.T) {
+		h := newHarness(t)
+		transactionId := uuid.New()
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, withFee(transactionId))
+		h.replyCurrency(compartment4.StatusEventTypeError, transactionId)
+
+		assertTypes(t, "currency commands", []string{compartment4.CommandDebit}, h.commandTypes(compartment4.EnvCommandTopic))
+		assertTypes(t, "destination commands", []string{}, h.commandTypes(compartment3.EnvCommandTopic))
+		if s := state(h, transactionId); s != transfer.StateFailed {
+			t.Errorf("Expected state [%s], got [%s].", transfer.StateFailed, s)
+		}
+	})
+
+	t.Run("fee is refunded when a step times out", func(t *testing.T) {
+		h := newHarness(t)
+		h.useConfiguration(`{"ordering":"RELEASE_FIRST","timeouts":{"step":"1ns"},"limits":{"maxAttempts":1}}`)
+		transactionId := uuid.New()
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, withFee(transactionId))
+		h.replyCurrency(compartment4.StatusEventTypeDebited, transactionId)
+		assertTypes(t, "source commands", []string{compartment2.CommandRelease}, h.commandTypes(compartment2.EnvCommandTopic))
+
+		n, err := transfer.TimeOutSteps(h.l, h.ctx, h.db)(h.tf)
+		if err != nil || n != 1 {
+			t.Fatalf("Expected one step to time out, got [%d] [%v].", n, err)
+		}
+		assertTypes(t, "currency commands", []string{compartment4.CommandDebit, compartment4.CommandCredit}, h.commandTypes(compartment4.EnvCommandTopic))
+
+		var body journal.StateChangedBody
+		entries := h.journal(transactionId)
+		m, err := transfer.Fold(entries)
+		if err != nil || m.State() != transfer.StateRefunding || m.Ordering() != configuration.OrderingReleaseFirst {
+			t.Fatalf("Expected a release first transfer to be refunding, got [%s] [%s] [%v].", m.Ordering(), m.State(), err)
+		}
+		err = json.Unmarshal(entries[len(entries)-2].Payload(), &body)
+		if err != nil || body.Reason != transfer.ReasonTimedOut {
+			t.Errorf("Expected the journal to record the refund for [%s], got %+v.", transfer.ReasonTimedOut, body)
+		}
+	})
+}
+
+func TestTransferSagaScheduled(t *testing.T) {
+	scheduled := func(transactionId uuid.UUID, deliverAt time.Time) compartment.TransferCommand {
+		cmd := transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop)
+		cmd.DeliverAt = &deliverAt
+		return cmd
+	}
+	state := func(h *harness, transactionId uuid.UUID) transfer.State {
+		m, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+		if err != nil {
+			h.t.Fatalf("Unable to retrieve transfer: %v", err)
+		}
+		return m.State()
+	}
+
+	t.Run("transfer starts once it is due", func(t *testing.T) {
+		h := newHarness(t)
+		h.useConfiguration(`{"timeouts":{"step":"1ns"},"limits":{"maxInFlight":1}}`)
+		transactionId := uuid.New()
+		deliverAt := time.Now().Add(250 * time.Millisecond)
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, scheduled(transactionId, deliverAt))
+		assertTypes(t, "destination commands", []string{}, h.commandTypes(compartment3.EnvCommandTopic))
+		if s := state(h, transactionId); s != transfer.StateScheduled {
+			t.Fatalf("Expected state [%s], got [%s].", transfer.StateScheduled, s)
+		}
+
+		// A scheduled transfer is neither in progress, nor timed out, nor started before it is due
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(uuid.New(), compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		assertTypes(t, "destination commands", []string{compartment3.CommandAccept}, h.commandTypes(compartment3.EnvCommandTopic))
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeError, h.commandTransactionId(compartment3.EnvCommandTopic, 0))
+		n, err := transfer.TimeOutSteps(h.l, h.ctx, h.db)(h.tf)
+		if err != nil || n != 0 {
+			t.Fatalf("Expected no step to time out, got [%d] [%v].", n, err)
+		}
+		n, err = transfer.StartDueTransfers(h.l, h.ctx, h.db)(h.tf)
+		if err != nil || n != 0 {
+			t.Fatalf("Expected no transfer to be due, got [%d] [%v].", n, err)
+		}
+
+		// The journal is all a transfer is started from, so an instance which did not receive it may start it
+		time.Sleep(time.Until(deliverAt))
+		other := transfer.NewProcessorFactory(h.db, h.bus.ProviderFactory, transfer.NewTransactionCache())
+		n, err = transfer.StartDueTransfers(h.l, h.ctx, h.db)(other)
+		if err != nil || n != 1 {
+			t.Fatalf("Expected one transfer to be started, got [%d] [%v].", n, err)
+		}
+		assertTypes(t, "destination commands", []string{compartment3.CommandAccept, compartment3.CommandAccept}, h.commandTypes(compartment3.EnvCommandTopic))
+		if s := state(h, transactionId); s != transfer.StateAccepting {
+			t.Fatalf("Expected state [%s], got [%s].", transfer.StateAccepting, s)
+		}
+
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+		h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, transactionId)
+		if s := state(h, transactionId); s != transfer.StateCompleted {
+			t.Errorf("Expected state [%s], got [%s].", transfer.StateCompleted, s)
+		}
+		assertTypes(t, "dead letters", []string{}, h.deadLetterReasons())
+	})
+
+	t.Run("transfer due on receipt starts at once", func(t *testing.T) {
+		h := newHarness(t)
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, scheduled(uuid.New(), time.Now().Add(-time.Minute)))
+		assertTypes(t, "destination commands", []string{compartment3.CommandAccept}, h.commandTypes(compartment3.EnvCommandTopic))
+	})
+
+	t.Run("transfer is listed and cancelled by its account", func(t *testing.T) {
+		h := newHarness(t)
+		later, sooner := uuid.New(), uuid.New()
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, scheduled(later, time.Now().Add(2*time.Hour)))
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, scheduled(sooner, time.Now().Add(time.Hour)))
+		p := h.tf(h.l, h.ctx)
+
+		ms, err := p.ScheduledByAccountIdProvider(1000)()
+		if err != nil || len(ms) != 2 || ms[0].TransactionId() != sooner || ms[1].TransactionId() != later {
+			t.Fatalf("Expected the account's transfers soonest due first, got [%d] [%v].", len(ms), err)
+		}
+		if ms, _ = p.ScheduledByAccountIdProvider(1001)(); len(ms) != 0 {
+			t.Fatalf("Expected another account to have no scheduled transfers, got [%d].", len(ms))
+		}
+
+		if err = p.CancelAndEmit(1001, sooner); !errors.Is(err, transfer.ErrUnknownTransaction) {
+			t.Fatalf("Expected the transfer to be unknown to another account, got [%v].", err)
+		}
+		if err = p.CancelAndEmit(1000, sooner); err != nil {
+			t.Fatalf("Unable to cancel transfer: %v", err)
+		}
+		if s := state(h, sooner); s != transfer.StateCancelled {
+			t.Fatalf("Expected state [%s], got [%s].", transfer.StateCancelled, s)
+		}
+		if err = p.CancelAndEmit(1000, sooner); !errors.Is(err, transfer.ErrNotScheduled) {
+			t.Errorf("Expected a cancelled transfer not to be scheduled, got [%v].", err)
+		}
+		ms, err = p.ScheduledByAccountIdProvider(1000)()
+		if err != nil || len(ms) != 1 || ms[0].TransactionId() != later {
+			t.Errorf("Expected only the transfer which was not cancelled to be listed, got [%d] [%v].", len(ms), err)
+		}
+	})
+}
+
+func TestTransferSagaUnknownTransactions(t *testing.T) {
+	t.Run("event of another saga is skipped", func(t *testing.T) {
+		h := newHarness(t)
+		h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeAccepted, uuid.New())
+
+		assertTypes(t, "character commands", []string{}, h.commandTypes(compartment2.EnvCommandTopic))
+		assertTypes(t, "dead letters", []string{}, h.deadLetterReasons())
+	})
+
+	t.Run("event of a finished transfer is dead-lettered", func(t *testing.T) {
+		h := newHarness(t)
+		transactionId := uuid.New()
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+		h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, transactionId)
+		h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, transactionId)
+
+		assertTypes(t, "dead letters", []string{dlq.ReasonUnknownTransaction}, h.deadLetterReasons())
+	})
+}
+
+func TestTransferSagaAbort(t *testing.T) {
+	state := func(h *harness, transactionId uuid.UUID) transfer.State {
+		m, err := h.tf(h.l, h.ctx).ByTransactionIdProvider(transactionId)()
+		if err != nil {
+			h.t.Fatalf("Unable to retrieve transfer: %v", err)
+		}
+		return m.State()
+	}
+
+	t.Run("transfer is aborted before the first compartment answers", func(t *testing.T) {
+		h := newHarness(t)
+		transactionId := uuid.New()
+
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		err := h.tf(h.l, h.ctx).AbortAndEmit(transactionId)
+		if err != nil {
+			t.Fatalf("Unable to abort transfer: %v", err)
+		}
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+
+		assertTypes(t, "source commands", []string{}, h.commandTypes(compartment2.EnvCommandTopic))
+		assertTypes(t, "dead letters", []string{dlq.ReasonUnknownTransaction}, h.deadLetterReasons())
+		if s := state(h, transactionId); s != transfer.StateAborted {
+			t.Errorf("Expected state [%s], got [%s].", transfer.StateAborted, s)
+		}
+
+		err = h.tf(h.l, h.ctx).AbortAndEmit(transactionId)
+		if !errors.Is(err, transfer.ErrUnknownTransaction) {
+			t.Errorf("Expected a finished transfer to be unknown, got [%v].", err)
+		}
+	})
+
+	t.Run("transfer is not aborted once the first compartment answered", func(t *testing.T) {
+		h := newHarness(t)
+		transactionId := uuid.New()
+
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+		err := h.tf(h.l, h.ctx).AbortAndEmit(transactionId)
+		if !errors.Is(err, transfer.ErrNotAbortable) {
+			t.Fatalf("Expected an accepted transfer not to be aborted, got [%v].", err)
+		}
+		if s := state(h, transactionId); s != transfer.StateReleasing {
+			t.Fatalf("Expected state [%s], got [%s].", transfer.StateReleasing, s)
+		}
+
+		// The saga carries on, so the asset does not stay in both compartments
+		h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, transactionId)
+		assertTypes(t, "transfer events", []string{compartment.StatusEventTypeCompleted}, h.commandTypes(compartment.EnvEventTopicStatus))
+		assertTypes(t, "dead letters", []string{}, h.deadLetterReasons())
+	})
+
+	t.Run("release first transfer is not aborted once released", func(t *testing.T) {
+		h := newHarness(t)
+		h.useConfiguration(`{"ordering":"RELEASE_FIRST"}`)
+		transactionId := uuid.New()
+
+		h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+		h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, transactionId)
+		err := h.tf(h.l, h.ctx).AbortAndEmit(transactionId)
+		if !errors.Is(err, transfer.ErrNotAbortable) {
+			t.Fatalf("Expected a released transfer not to be aborted, got [%v].", err)
+		}
+		if s := state(h, transactionId); s != transfer.StateAccepting {
+			t.Errorf("Expected state [%s], got [%s].", transfer.StateAccepting, s)
+		}
+	})
+}
+
+func TestTransferSagaUnreadableJournal(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(h *harness, transactionId uuid.UUID)
+	}{
+		{name: "database is unavailable", corrupt: func(h *harness, _ uuid.UUID) {
+			sqlDB, err := h.db.DB()
+			if err != nil {
+				h.t.Fatalf("Unable to retrieve database: %v", err)
+			}
+			_ = sqlDB.Close()
+		}},
+		{name: "journal cannot be folded", corrupt: func(h *harness, transactionId uuid.UUID) {
+			err := h.db.Exec("UPDATE transfer_journal SET payload = ? WHERE transaction_id = ? AND sequence = 1", "{", transactionId).Error
+			if err != nil {
+				h.t.Fatalf("Unable to corrupt journal: %v", err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			transactionId := uuid.New()
+			h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+			tt.corrupt(h, transactionId)
+
+			// Another instance, which has not cached the transfer, reads it from the journal
+			other := transfer.NewProcessorFactory(h.db, h.bus.ProviderFactory, transfer.NewTransactionCache())
+			err := other(h.l, h.ctx).HandleAcceptedAndEmit(transactionId)
+			if err == nil || errors.Is(err, transfer.ErrUnknownTransaction) {
+				t.Errorf("Expected the event to fail rather than be of an unknown transaction, got [%v].", err)
+			}
+			assertTypes(t, "source commands", []string{}, h.commandTypes(compartment2.EnvCommandTopic))
+		})
+	}
+}
+
+func TestTransferSagaSharedAcrossInstances(t *testing.T) {
+	h := newHarness(t)
+	transactionId := uuid.New()
+	cmd := transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop)
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, cmd)
+
+	// Another instance, with a cache of its own, handles ACCEPTED, leaving this instance's cache behind the journal
+	other := transfer.NewProcessorFactory(h.db, h.bus.ProviderFactory, transfer.NewTransactionCache())
+	err := other(h.l, h.ctx).HandleAcceptedAndEmit(transactionId)
+	if err != nil {
+		t.Fatalf("Unable to handle accepted transfer on another instance: %v", err)
+	}
+	h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, transactionId)
+
+	// A repeated command neither restarts the transfer nor is dead lettered
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, cmd)
+
+	assertTypes(t, "source commands", []string{compartment2.CommandRelease}, h.commandTypes(compartment2.EnvCommandTopic))
+	assertTypes(t, "destination commands", []string{compartment3.CommandAccept}, h.commandTypes(compartment3.EnvCommandTopic))
+	assertTypes(t, "transfer events", []string{compartment.StatusEventTypeCompleted}, h.commandTypes(compartment.EnvEventTopicStatus))
+	assertTypes(t, "dead letters", []string{}, h.deadLetterReasons())
+
+	entries := h.journal(transactionId)
+	for i, e := range entries {
+		if e.Sequence() != uint32(i+1) {
+			t.Fatalf("Expected journal entry [%d] to have sequence [%d], got [%d].", i, i+1, e.Sequence())
+		}
+	}
+	m, err := transfer.Fold(entries)
+	if err != nil {
+		t.Fatalf("Unable to fold journal: %v", err)
+	}
+	if m.State() != transfer.StateCompleted {
+		t.Errorf("Expected state [%s], got [%s].", transfer.StateCompleted, m.State())
+	}
+}
+
+func TestTransferSagaCorrelatesMessages(t *testing.T) {
+	h := newHarness(t)
+	transactionId := uuid.New()
+
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+	h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+
+	cmd := h.bus.Messages(compartment.EnvCommandTopicCompartmentTransfer)[0]
+	accept := h.bus.Messages(compartment3.EnvCommandTopic)[0]
+	accepted := h.bus.Messages(compartment3.EnvEventTopicStatus)[0]
+	release := h.bus.Messages(compartment2.EnvCommandTopic)[0]
+
+	for _, m := range []kafka.Message{accept, release} {
+		if id := header(m, correlation.CorrelationIdHeader); id != transactionId.String() {
+			t.Errorf("Expected message on [%s] to be correlated to [%s], got [%s].", m.Topic, transactionId, id)
+		}
+	}
+	if id := header(accept, correlation.CausationIdHeader); id == "" || id != header(cmd, correlation.MessageIdHeader) {
+		t.Errorf("Expected ACCEPT to be caused by the transfer command [%s], got [%s].", header(cmd, correlation.MessageIdHeader), id)
+	}
+	if id := header(release, correlation.CausationIdHeader); id == "" || id != header(accepted, correlation.MessageIdHeader) {
+		t.Errorf("Expected RELEASE to be caused by the ACCEPTED event [%s], got [%s].", header(accepted, correlation.MessageIdHeader), id)
+	}
+
+	causes := make(map[string]string)
+	for _, e := range h.journal(transactionId) {
+		if e.Kind() != journal.KindCommandEmitted {
+			continue
+		}
+		var body journal.CommandEmittedBody
+		if err := json.Unmarshal(e.Payload(), &body); err != nil {
+			t.Fatalf("Unable to decode journal entry: %v", err)
+		}
+		causes[body.Type] = body.CausationId
+	}
+	if causes[compartment3.CommandAccept] != header(cmd, correlation.MessageIdHeader) || causes[compartment2.CommandRelease] != header(accepted, correlation.MessageIdHeader) {
+		t.Errorf("Expected the journal to record the causes of the emitted commands, got %v.", causes)
+	}
+}
+
+// useRecorder traces through the recorder for the duration of the test, as the service traces through its exporter
+func useRecorder(t *testing.T, l logrus.FieldLogger) *tracetest.SpanRecorder {
+	t.Helper()
+	tracer, propagator, provider := opentracing.GlobalTracer(), otel.GetTextMapPropagator(), otel.GetTracerProvider()
+	t.Cleanup(func() {
+		opentracing.SetGlobalTracer(tracer)
+		otel.SetTextMapPropagator(propagator)
+		otel.SetTracerProvider(provider)
+	})
+	recorder := tracetest.NewSpanRecorder()
+	tracing.Install(l)("atlas-compartment-transfer")(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}
+
+func TestTransferSagaTracesAcrossMessages(t *testing.T) {
+	h := newHarness(t)
+	recorder := useRecorder(t, h.l)
+	transactionId := uuid.New()
+	ctx := h.ctx
+
+	// The command is sent within a span of its sender's
+	sender := opentracing.StartSpan("sender")
+	h.ctx = opentracing.ContextWithSpan(ctx, sender)
+	h.publish(compartment.EnvCommandTopicCompartmentTransfer, transferCommand(transactionId, compartment.InventoryTypeCharacter, compartment.InventoryTypeCashShop))
+	sender.Finish()
+
+	// The destination answers within the span ACCEPT carried to it, and the source within none
+	accept := h.bus.Messages(compartment3.EnvCommandTopic)[0]
+	h.ctx = consumer.SpanHeaderParser(ctx, accept.Headers)
+	h.reply(compartment.InventoryTypeCashShop, compartment3.StatusEventTypeAccepted, transactionId)
+	h.ctx = ctx
+	h.reply(compartment.InventoryTypeCharacter, compartment2.StatusEventTypeReleased, transactionId)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	saga, ok := spans[transfer.SpanSaga]
+	if !ok {
+		t.Fatalf("Expected the saga to be traced, got %v.", spans)
+	}
+	if links := saga.Links(); len(links) != 1 || links[0].SpanContext.TraceID() != spans["sender"].SpanContext().TraceID() {
+		t.Errorf("Expected the saga to follow from the trace of the command's sender, got %v.", links)
+	}
+
+	// Each step continues the saga's trace, whichever span its event was sent within
+	for _, name := range []string{transfer.SpanAccepted, transfer.SpanReleased} {
+		s, ok := spans[name]
+		if !ok {
+			t.Fatalf("Expected [%s] to be traced, got %v.", name, spans)
+		}
+		if s.Parent().SpanID() != saga.SpanContext().SpanID() || s.SpanContext().TraceID() != saga.SpanContext().TraceID() {
+			t.Errorf("Expected [%s] to be a child of the saga's span, got parent [%s] in trace [%s].", name, s.Parent().SpanID(), s.SpanContext().TraceID())
+		}
+	}
+
+	// The saga's span context survives the round trip through each message it emits
+	for _, m := range []kafka.Message{accept, h.bus.Messages(compartment2.EnvCommandTopic)[0], h.bus.Messages(compartment.EnvEventTopicStatus)[0]} {
+		carrier := propagation.MapCarrier{}
+		for _, hdr := range m.Headers {
+			carrier[hdr.Key] = string(hdr.Value)
+		}
+		sc := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), carrier))
+		if sc.TraceID() != saga.SpanContext().TraceID() {
+			t.Errorf("Expected message on [%s] to carry the saga's trace [%s], got [%s].", m.Topic, saga.SpanContext().TraceID(), sc.TraceID())
+		}
+	}
+}
+
+func header(m kafka.Message, key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func assertTypes(t *testing.T, what string, expected []string, actual []string) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Fatalf("Expected %s %v, got %v.", what, expected, actual)
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Fatalf("Expected %s %v, got %v.", what, expected, actual)
+		}
+	}
+}
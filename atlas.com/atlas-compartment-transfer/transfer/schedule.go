@@ -0,0 +1,229 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/configuration"
+	"atlas-compartment-transfer/journal"
+	"atlas-compartment-transfer/kafka/message"
+	"atlas-compartment-transfer/kafka/message/compartment"
+	"atlas-compartment-transfer/metrics"
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-model/model"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"sort"
+	"sync"
+	"time"
+)
+
+const scheduleInterval = 5 * time.Second
+
+// StartSchedules periodically starts the scheduled transfers which are due, until the context is done
+func StartSchedules(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup) func(db *gorm.DB, tf ProcessorFactory) {
+	return func(db *gorm.DB, tf ProcessorFactory) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(scheduleInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					// The transfers start detached, so stopping the loop does not cut them off partway through journaling or emitting
+					_, err := StartDueTransfers(l, context.WithoutCancel(ctx), db)(tf)
+					if err != nil {
+						l.WithError(err).Errorf("Unable to start the scheduled compartment transfers which are due.")
+					}
+				}
+			}
+		}()
+	}
+}
+
+// StartDueTransfers starts the scheduled transfers, across every tenant, whose delivery time has passed, returning how
+// many were started. Only the journals of due transfers are read. Every instance may start the same transfer, and the
+// journal decides which one does.
+func StartDueTransfers(l logrus.FieldLogger, ctx context.Context, db *gorm.DB) func(tf ProcessorFactory) (int, error) {
+	return func(tf ProcessorFactory) (int, error) {
+		entries, err := journal.DueProvider(ctx, db)(time.Now(), string(StateScheduled))()
+		if err != nil {
+			return 0, err
+		}
+
+		started := 0
+		for _, j := range groupByTransaction(entries) {
+			m, err := Fold(j)
+			if err != nil || m.State() != StateScheduled {
+				continue
+			}
+			tctx := tenant.WithContext(ctx, m.Tenant())
+			err = tf(l, tctx).StartScheduledAndEmit(m)
+			if err != nil {
+				ModelDecorator(m)(l).WithError(err).Errorf("Unable to start scheduled compartment transfer [%s] for tenant [%s].", m.TransactionId(), m.Tenant().Id())
+				continue
+			}
+			started++
+		}
+		return started, nil
+	}
+}
+
+// schedule journals a transfer which is to be delivered later, to be started once it is due. The transfer is taken from
+// the tenant's rates now, so scheduling many at once is refused, and it is rejected instead when over them. The entries
+// given are journaled ahead of the state change. It returns journal.ErrConflict when the transfer was already received.
+func (p *ProcessorImpl) schedule(mb *message.Buffer, cmd compartment.TransferCommand, cfg configuration.Model, entries ...journal.Model) error {
+	var rejection Rejection
+	err := p.throttle(cmd, cfg)
+	if errors.As(err, &rejection) {
+		return p.reject(mb, cmd, rejection, 0, "", entries...)
+	}
+	if err != nil {
+		p.l.WithError(err).Errorf("Unable to take compartment transfer [%s] from the rate limits. Not scheduling it.", cmd.TransactionId)
+		return err
+	}
+
+	entries = append(entries, stateChangedEntry(p.t, cmd.TransactionId, "", StateScheduled).Await(string(StateScheduled), *cmd.DeliverAt))
+	_, err = p.record(0, entries...)
+	if err != nil {
+		if !errors.Is(err, journal.ErrConflict) {
+			p.l.WithError(err).Errorf("Unable to journal compartment transfer [%s].", cmd.TransactionId)
+		}
+		return err
+	}
+	p.l.Infof("Scheduled compartment transfer [%s] for [%s].", cmd.TransactionId, cmd.DeliverAt.Format(time.RFC3339))
+	metrics.ScheduledTransfers.WithLabelValues(p.t.Id().String(), metrics.ActionScheduled).Inc()
+	return nil
+}
+
+// StartScheduled starts the saga of a scheduled transfer which is due. The transfer is checked, and runs in the ordering
+// of the tenant's configuration, as it is when the transfer starts.
+func (p *ProcessorImpl) StartScheduled(mb *message.Buffer) func(m Model) error {
+	return func(m Model) error {
+		tp := p.withLogger(ModelDecorator(m))
+		if m.State() != StateScheduled {
+			return ErrNotScheduled
+		}
+		cfg, err := configuration.NewProcessor(tp.l, p.ctx).TenantProvider()()
+		if err != nil {
+			return err
+		}
+
+		tp.l.Infof("Starting compartment transfer [%s] scheduled for [%s].", m.TransactionId(), m.DeliverAt().Format(time.RFC3339))
+		cmd := command(m)
+		err = tp.start(mb, cmd, tp.newBuilder(cmd, cfg).SetTraceContext(m.TraceContext()), cfg, m.Sequence(), StateScheduled)
+		if errors.Is(err, journal.ErrConflict) {
+			tp.l.Debugf("Compartment transfer [%s] was started or cancelled elsewhere. Not starting it.", m.TransactionId())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		metrics.ScheduledTransfers.WithLabelValues(p.t.Id().String(), metrics.ActionStarted).Inc()
+		return nil
+	}
+}
+
+// StartScheduledAndEmit starts the saga of a scheduled transfer which is due and emits messages
+func (p *ProcessorImpl) StartScheduledAndEmit(m Model) error {
+	sp, span := p.continueSaga(SpanStart, m.TransactionId(), p.createTransferInfo(m))
+	err := message.Emit(sp.producer)(func(mb *message.Buffer) error {
+		return sp.StartScheduled(mb)(m)
+	})
+	finishSpan(span, err)
+	return err
+}
+
+// Cancel stops a scheduled transfer of the account before it starts. Transfers of other accounts are unknown, and
+// transfers which have started or finished are not scheduled.
+func (p *ProcessorImpl) Cancel(mb *message.Buffer) func(accountId uint32, transactionId uuid.UUID) error {
+	return func(accountId uint32, transactionId uuid.UUID) error {
+		tp := p.withLogger(TransactionDecorator(transactionId))
+
+		m, err := tp.ByTransactionIdProvider(transactionId)()
+		if errors.Is(err, ErrIncompleteJournal) {
+			return ErrUnknownTransaction
+		}
+		if err != nil {
+			return err
+		}
+		if m.AccountId() != accountId {
+			return ErrUnknownTransaction
+		}
+		tp = tp.withLogger(ModelDecorator(m))
+		if m.State() != StateScheduled {
+			return ErrNotScheduled
+		}
+
+		// Only starting and cancelling advance a scheduled transfer, so one advanced elsewhere is no longer scheduled
+		_, err = tp.record(m.Sequence(), stateChangedEntry(p.t, transactionId, StateScheduled, StateCancelled))
+		if errors.Is(err, journal.ErrConflict) {
+			return ErrNotScheduled
+		}
+		if err != nil {
+			return tp.journalFailed(transactionId, err)
+		}
+		tp.l.Infof("Cancelled compartment transfer [%s] scheduled for [%s].", transactionId, m.DeliverAt().Format(time.RFC3339))
+
+		tenantId := p.t.Id().String()
+		metrics.Transfers.WithLabelValues(tenantId, metrics.OutcomeCancelled, m.FromInventoryType(), m.ToInventoryType()).Inc()
+		metrics.ScheduledTransfers.WithLabelValues(tenantId, metrics.ActionCancelled).Inc()
+		return nil
+	}
+}
+
+// CancelAndEmit cancels a scheduled transfer of the account and emits messages
+func (p *ProcessorImpl) CancelAndEmit(accountId uint32, transactionId uuid.UUID) error {
+	return message.Emit(p.producer)(func(mb *message.Buffer) error {
+		return p.Cancel(mb)(accountId, transactionId)
+	})
+}
+
+// ScheduledByAccountIdProvider rebuilds the scheduled transfers of the account which have not started, soonest due first
+func (p *ProcessorImpl) ScheduledByAccountIdProvider(accountId uint32) model.Provider[[]Model] {
+	entries, err := journal.NewProcessor(p.l, p.ctx, p.db).InFlightByAccountProvider(accountId)(terminalStates()...)()
+	if err != nil {
+		return model.ErrorProvider[[]Model](err)
+	}
+
+	results := make([]Model, 0)
+	for _, j := range groupByTransaction(entries) {
+		m, err := Fold(j)
+		if err != nil || m.State() != StateScheduled {
+			continue
+		}
+		results = append(results, m)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].DeliverAt().Before(results[j].DeliverAt())
+	})
+	return model.FixedProvider(results)
+}
+
+// command rebuilds the transfer command a transfer was requested with
+func command(m Model) compartment.TransferCommand {
+	cmd := compartment.TransferCommand{
+		TransactionId:       m.TransactionId(),
+		AccountId:           m.AccountId(),
+		CharacterId:         m.CharacterId(),
+		AssetId:             m.AssetId(),
+		FromCompartmentId:   m.FromCompartmentId(),
+		FromCompartmentType: m.FromCompartmentType(),
+		FromInventoryType:   m.FromInventoryType(),
+		ToCompartmentId:     m.ToCompartmentId(),
+		ToCompartmentType:   m.ToCompartmentType(),
+		ToInventoryType:     m.ToInventoryType(),
+		ReferenceId:         m.ReferenceId(),
+	}
+	if m.FeeAmount() > 0 {
+		cmd.Fee = &compartment.Fee{Amount: m.FeeAmount(), CompartmentId: m.FeeCompartmentId()}
+	}
+	if !m.DeliverAt().IsZero() {
+		deliverAt := m.DeliverAt()
+		cmd.DeliverAt = &deliverAt
+	}
+	return cmd
+}
@@ -0,0 +1,137 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/configuration"
+	"atlas-compartment-transfer/journal"
+	"atlas-compartment-transfer/kafka/message"
+	"atlas-compartment-transfer/metrics"
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// ReasonTimedOut is journaled for a transfer which failed as its pending step was not answered within MaxAttempts
+const ReasonTimedOut = "TIMED_OUT"
+
+const timeoutInterval = 10 * time.Second
+
+// StartTimeouts periodically times out the steps of in-flight transfers whose deadline has passed, until the context is
+// done
+func StartTimeouts(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup) func(db *gorm.DB, tf ProcessorFactory) {
+	return func(db *gorm.DB, tf ProcessorFactory) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(timeoutInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					// The steps run detached, so stopping the loop does not cut them off partway through journaling or emitting
+					_, err := TimeOutSteps(l, context.WithoutCancel(ctx), db)(tf)
+					if err != nil {
+						l.WithError(err).Errorf("Unable to time out the steps of in-flight compartment transfers.")
+					}
+				}
+			}
+		}()
+	}
+}
+
+// TimeOutSteps times out the steps, across every tenant, whose deadline has passed, returning how many were timed out.
+// A step's deadline is set by the tenant's step timeout as it was when the step was journaled, and only the journals of
+// transfers past their deadline are read. Every instance may time out the same step, and the journal decides which one
+// does.
+func TimeOutSteps(l logrus.FieldLogger, ctx context.Context, db *gorm.DB) func(tf ProcessorFactory) (int, error) {
+	return func(tf ProcessorFactory) (int, error) {
+		entries, err := journal.DueProvider(ctx, db)(time.Now(), startedStates()...)()
+		if err != nil {
+			return 0, err
+		}
+
+		timedOut := 0
+		for _, j := range groupByTransaction(entries) {
+			m, err := Fold(j)
+			if err != nil || m.State() == StateScheduled {
+				continue
+			}
+			tctx := tenant.WithContext(ctx, m.Tenant())
+			err = tf(l, tctx).TimeOutAndEmit(m)
+			if err != nil {
+				ModelDecorator(m)(l).WithError(err).Errorf("Unable to time out compartment transfer [%s] for tenant [%s].", m.TransactionId(), m.Tenant().Id())
+				continue
+			}
+			timedOut++
+		}
+		return timedOut, nil
+	}
+}
+
+// TimeOut issues the pending command of a transfer whose step has timed out again, or fails the transfer once the
+// command has been issued the tenant's MaxAttempts times. A fee charged for an asset which was not moved is refunded,
+// but other steps already taken are not compensated.
+func (p *ProcessorImpl) TimeOut(mb *message.Buffer) func(m Model) error {
+	return func(m Model) error {
+		tp := p.withLogger(ModelDecorator(m))
+		cfg, err := configuration.NewProcessor(tp.l, p.ctx).TenantProvider()()
+		if err != nil {
+			return err
+		}
+
+		if cfg.MaxAttempts() == 0 || m.Attempts() < cfg.MaxAttempts() {
+			tp.l.Warnf("Compartment transfer [%s] has waited [%s] in state [%s]. Issuing its command again.", m.TransactionId(), time.Since(m.UpdatedAt()).Round(time.Second), m.State())
+			err = tp.Resume(mb)(m)
+			if err != nil {
+				return err
+			}
+			metrics.StepTimeouts.WithLabelValues(p.t.Id().String(), string(m.State()), metrics.ActionReissued).Inc()
+			return nil
+		}
+
+		if m.FeeAmount() > 0 && (m.State() == StateAccepting || m.State() == StateReleasing) {
+			err = tp.refund(mb, m.TransactionId(), tp.createTransferInfo(m), ReasonTimedOut)
+			if errors.Is(err, journal.ErrConflict) {
+				tp.l.Debugf("Compartment transfer [%s] was advanced elsewhere. Not timing it out.", m.TransactionId())
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			metrics.StepTimeouts.WithLabelValues(p.t.Id().String(), string(m.State()), metrics.ActionRefunded).Inc()
+			return nil
+		}
+
+		_, err = tp.record(m.Sequence(), timedOutEntry(p.t, m.TransactionId(), m.State()))
+		if errors.Is(err, journal.ErrConflict) {
+			tp.l.Debugf("Compartment transfer [%s] was advanced elsewhere. Not timing it out.", m.TransactionId())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		tp.l.Errorf("Compartment transfer [%s] was not answered after [%d] attempts in state [%s]. Failing it.", m.TransactionId(), m.Attempts(), m.State())
+		if m.State() == StateRefunding {
+			tp.l.Errorf("Unable to refund the fee of [%d] charged for compartment transfer [%s].", m.FeeAmount(), m.TransactionId())
+		}
+		p.cache.Delete(m.TransactionId())
+		tp.finished(tp.createTransferInfo(m), metrics.OutcomeFailed)
+		metrics.StepTimeouts.WithLabelValues(p.t.Id().String(), string(m.State()), metrics.ActionFailed).Inc()
+		return nil
+	}
+}
+
+// TimeOutAndEmit times out the step of a transfer and emits messages
+func (p *ProcessorImpl) TimeOutAndEmit(m Model) error {
+	sp, span := p.continueSaga(SpanTimeout, m.TransactionId(), p.createTransferInfo(m))
+	err := message.Emit(sp.producer)(func(mb *message.Buffer) error {
+		return sp.TimeOut(mb)(m)
+	})
+	finishSpan(span, err)
+	return err
+}
@@ -0,0 +1,99 @@
+package transfer
+
+import (
+	"atlas-compartment-transfer/correlation"
+	"context"
+	"github.com/Chronicle20/atlas-tenant"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+const (
+	SpanSaga     = "compartment_transfer_saga"
+	SpanResume   = "compartment_transfer_resume"
+	SpanAccepted = "compartment_transfer_accepted"
+	SpanReleased = "compartment_transfer_released"
+	SpanError    = "compartment_transfer_error"
+	SpanAbort    = "compartment_transfer_abort"
+	SpanTimeout  = "compartment_transfer_timeout"
+	SpanDebited  = "compartment_transfer_debited"
+	SpanCredited = "compartment_transfer_credited"
+	SpanStart    = "compartment_transfer_start"
+)
+
+// startSaga starts the root span of a transfer's saga, linked to the span of the command which began it, and returns a
+// processor which emits within it
+func (p *ProcessorImpl) startSaga(transactionId uuid.UUID, fromInventoryType string, toInventoryType string) (*ProcessorImpl, opentracing.Span) {
+	opts := make([]opentracing.StartSpanOption, 0)
+	if parent := opentracing.SpanFromContext(p.ctx); parent != nil {
+		opts = append(opts, opentracing.FollowsFrom(parent.Context()))
+	}
+	span := opentracing.StartSpan(SpanSaga, opts...)
+	tagSpan(span, p.t, transactionId, fromInventoryType, toInventoryType)
+	return p.withSaga(transactionId, span), span
+}
+
+// continueSaga starts the span of a step of the saga as a child of its root span, linked to the span of the message which
+// triggered the step, and returns a processor which emits within it. Transfers without a root span are continued from the
+// message's span alone.
+func (p *ProcessorImpl) continueSaga(name string, transactionId uuid.UUID, info TransferInfo) (*ProcessorImpl, opentracing.Span) {
+	opts := make([]opentracing.StartSpanOption, 0)
+	parent := opentracing.SpanFromContext(p.ctx)
+	if sc, err := extractSpanContext(info.TraceContext); err == nil {
+		opts = append(opts, opentracing.ChildOf(sc))
+		if parent != nil {
+			opts = append(opts, opentracing.FollowsFrom(parent.Context()))
+		}
+	} else if parent != nil {
+		opts = append(opts, opentracing.ChildOf(parent.Context()))
+	}
+	span := opentracing.StartSpan(name, opts...)
+	tagSpan(span, p.t, transactionId, info.FromInventoryType, info.ToInventoryType)
+	return p.withSaga(transactionId, span), span
+}
+
+// withSaga creates a processor whose context, and so whose emitted messages, carry the span and the id of the saga
+func (p *ProcessorImpl) withSaga(transactionId uuid.UUID, span opentracing.Span) *ProcessorImpl {
+	c := *p
+	c.ctx = correlation.WithCorrelationId(opentracing.ContextWithSpan(p.ctx, span), transactionId.String())
+	c.producer = p.pf(p.l)(c.ctx)
+	return &c
+}
+
+func tagSpan(span opentracing.Span, t tenant.Model, transactionId uuid.UUID, fromInventoryType string, toInventoryType string) {
+	span.SetTag("transaction.id", transactionId.String())
+	span.SetTag("tenant.id", t.Id().String())
+	span.SetTag("transfer.from_inventory_type", fromInventoryType)
+	span.SetTag("transfer.to_inventory_type", toInventoryType)
+}
+
+// finishSpan finishes the span, marking it as failed when the step returned an error
+func finishSpan(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogKV("event", "error", "message", err.Error())
+	}
+	span.Finish()
+}
+
+// traceContext serializes the span of the context, so it can be stored with the state of the transfer
+func traceContext(ctx context.Context) map[string]string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return nil
+	}
+	carrier := opentracing.TextMapCarrier{}
+	err := opentracing.GlobalTracer().Inject(span.Context(), opentracing.TextMap, carrier)
+	if err != nil || len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+func extractSpanContext(traceContext map[string]string) (opentracing.SpanContext, error) {
+	if len(traceContext) == 0 {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+	return opentracing.GlobalTracer().Extract(opentracing.TextMap, opentracing.TextMapCarrier(traceContext))
+}